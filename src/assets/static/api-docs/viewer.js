@@ -0,0 +1,165 @@
+// Minimal OpenAPI 3 viewer for /api/v1, without external dependencies so it works offline.
+(function () {
+	"use strict";
+
+	const tokenInput = document.getElementById("token");
+	tokenInput.value = sessionStorage.getItem("alc_api_token") || "";
+	tokenInput.addEventListener("input", () => sessionStorage.setItem("alc_api_token", tokenInput.value));
+
+	function el(tag, attrs, ...children) {
+		const node = document.createElement(tag);
+		for (const [key, value] of Object.entries(attrs || {})) {
+			if (key === "class") node.className = value;
+			else node.setAttribute(key, value);
+		}
+		for (const child of children) {
+			if (child == null) continue;
+			node.append(child instanceof Node ? child : document.createTextNode(String(child)));
+		}
+		return node;
+	}
+
+	function resolve(spec, schema) {
+		while (schema && schema.$ref) {
+			schema = schema.$ref.replace("#/", "").split("/").reduce((obj, key) => obj[key], spec);
+		}
+		if (schema && schema.allOf && schema.allOf.length === 1) {
+			return Object.assign({}, resolve(spec, schema.allOf[0]), { nullable: schema.nullable });
+		}
+		return schema || {};
+	}
+
+	function refName(schema) {
+		const ref = schema.$ref || (schema.allOf && schema.allOf[0].$ref);
+		return ref ? ref.split("/").pop() : "";
+	}
+
+	function typeLabel(spec, schema) {
+		const name = refName(schema);
+		const s = resolve(spec, schema);
+		let label = name || s.type || "object";
+		if (s.type === "array") label = typeLabel(spec, s.items) + "[]";
+		if (s.format) label += " (" + s.format + ")";
+		if (s.enum) label += ": " + s.enum.join(" | ");
+		if (s.nullable) label += ", nullable";
+		return label;
+	}
+
+	// renderSchema shows the properties of an object schema, expanding nested objects once.
+	function renderSchema(spec, schema, seen) {
+		const s = resolve(spec, schema);
+		const target = s.type === "array" ? resolve(spec, s.items) : s;
+		if (!target.properties) return el("div", { class: "type" }, typeLabel(spec, schema));
+
+		const required = new Set(target.required || []);
+		const rows = Object.entries(target.properties).map(([name, prop]) => {
+			const nested = resolve(spec, prop);
+			const inner = nested.type === "array" ? resolve(spec, nested.items) : nested;
+			const ref = refName(prop) || (nested.items && refName(nested.items));
+			let detail = null;
+			if (inner.properties && !seen.has(ref)) {
+				detail = el("div", { class: "schema" }, renderSchema(spec, prop, new Set([...seen, ref])));
+			}
+			return el("tr", {},
+				el("td", {}, el("code", {}, name), required.has(name) ? el("span", { class: "required" }, " *") : null),
+				el("td", {}, el("span", { class: "type" }, typeLabel(spec, prop)), detail),
+			);
+		});
+		return el("table", {}, el("thead", {}, el("tr", {}, el("th", {}, "Field"), el("th", {}, "Type"))), el("tbody", {}, ...rows));
+	}
+
+	function renderOperation(spec, path, method, op) {
+		const inputs = {};
+		const paramRows = (op.parameters || []).map((p) => {
+			let input;
+			if (p.schema && p.schema.enum) {
+				input = el("select", {}, el("option", { value: "" }, ""), ...p.schema.enum.map((v) => el("option", { value: v }, v)));
+			} else {
+				input = el("input", { type: "text", placeholder: p.schema && p.schema.format ? p.schema.format : "" });
+			}
+			inputs[p.name] = { param: p, input: input };
+			return el("tr", {},
+				el("td", {}, el("code", {}, p.name), p.required ? el("span", { class: "required" }, " *") : null),
+				el("td", {}, p.in),
+				el("td", {}, p.description || ""),
+				el("td", {}, input),
+			);
+		});
+
+		const output = el("div");
+		const tryButton = el("button", { type: "button" }, "Send request");
+		tryButton.addEventListener("click", async () => {
+			let url = spec.servers[0].url + path;
+			const query = new URLSearchParams();
+			for (const { param, input } of Object.values(inputs)) {
+				if (!input.value) continue;
+				if (param.in === "path") url = url.replace("{" + param.name + "}", encodeURIComponent(input.value));
+				else query.set(param.name, input.value);
+			}
+			if (query.toString()) url += "?" + query.toString();
+
+			output.replaceChildren(el("p", {}, "GET " + url));
+			try {
+				const res = await fetch(url, { headers: { Authorization: "Bearer " + tokenInput.value } });
+				const text = await res.text();
+				let body = text;
+				try { body = JSON.stringify(JSON.parse(text), null, 2); } catch (e) { /* not JSON */ }
+				output.append(el("p", { class: "status" }, res.status + " " + res.statusText), el("pre", {}, body));
+			} catch (err) {
+				output.append(el("p", { class: "error" }, String(err)));
+			}
+		});
+
+		const responses = Object.entries(op.responses || {}).map(([status, res]) => {
+			const content = res.content && res.content["application/json"];
+			return el("div", {},
+				el("p", {}, el("span", { class: "status" }, status), " " + res.description),
+				status === "200" && content ? renderSchema(spec, content.schema, new Set()) : null,
+			);
+		});
+
+		return el("details", {},
+			el("summary", {}, el("span", { class: "method" }, method), el("span", { class: "path" }, path), el("span", { class: "summary" }, op.summary || "")),
+			el("div", { class: "body" },
+				el("p", {}, op.description || ""),
+				paramRows.length ? el("table", {},
+					el("thead", {}, el("tr", {}, el("th", {}, "Parameter"), el("th", {}, "In"), el("th", {}, "Description"), el("th", {}, "Value"))),
+					el("tbody", {}, ...paramRows)) : null,
+				tryButton,
+				output,
+				el("h4", {}, "Responses"),
+				...responses,
+			),
+		);
+	}
+
+	async function main() {
+		const container = document.getElementById("operations");
+		let spec;
+		try {
+			const res = await fetch("/api/v1/openapi.json");
+			spec = await res.json();
+		} catch (err) {
+			container.replaceChildren(el("p", { class: "error" }, "Could not load the API specification: " + err));
+			return;
+		}
+
+		document.getElementById("title").textContent = spec.info.title + " " + spec.info.version;
+		document.getElementById("description").textContent = spec.info.description || "";
+
+		const groups = {};
+		for (const path of Object.keys(spec.paths).sort()) {
+			for (const [method, op] of Object.entries(spec.paths[path])) {
+				const tag = (op.tags && op.tags[0]) || "default";
+				(groups[tag] = groups[tag] || []).push(renderOperation(spec, path, method, op));
+			}
+		}
+
+		container.replaceChildren();
+		for (const [tag, ops] of Object.entries(groups)) {
+			container.append(el("h2", {}, tag.replace("-", " ")), ...ops);
+		}
+	}
+
+	main();
+})();
@@ -4,6 +4,7 @@ import (
 	"alc/assets"
 	"alc/config"
 	"alc/handler"
+	"alc/model"
 	"alc/repository"
 	"alc/service"
 	"context"
@@ -51,14 +52,52 @@ func main() {
 	if err != nil {
 		log.Fatalf("could not create email service: %v", err)
 	}
-	certSvc := service.NewCertificateService(dbpool, repo, emailSvc)
+	ticketProvider, err := service.NewTicketProvider(cfg)
+	if err != nil {
+		log.Fatalf("could not create ticket provider: %v", err)
+	}
+	webhookSvc := service.NewWebhookService(repo)
+	certSvc := service.NewCertificateService(dbpool, repo, emailSvc, webhookSvc, ticketProvider)
+	accountSvc := service.NewAccountService(dbpool, repo, emailSvc)
+	apiTokenSvc := service.NewAPITokenService(repo)
+	manifestSvc := service.NewManifestService(dbpool, repo)
+	importSvc := service.NewImportService(dbpool, repo)
+	reportScheduleSvc := service.NewReportScheduleService(dbpool, repo, emailSvc)
+	appointmentSvc := service.NewAppointmentService(repo, emailSvc)
+	loanSvc := service.NewLoanService(dbpool, repo, emailSvc)
+	recoverySvc := service.NewRecoveryService(dbpool, repo)
+	stockSvc := service.NewStockService(repo)
+	machineSvc := service.NewMachineService(dbpool, repo)
+	masterDataSvc := service.NewMasterDataService(dbpool, repo)
+	machineUserSvc := service.NewMachineUserService(dbpool, repo)
+
+	authenticator, err := service.NewAuthenticator(cfg, repo)
+	if err != nil {
+		log.Fatalf("could not create authenticator: %v", err)
+	}
+
+	var oidcSvc *service.OIDCService
+	if cfg.OIDCEnabled() {
+		oidcSvc, err = service.NewOIDCService(context.Background(), cfg, repo)
+		if err != nil {
+			log.Fatalf("could not create OIDC service: %v", err)
+		}
+	}
 
 	// --- Handlers ---
-	authHandler := &handler.AuthHandler{Repo: repo}
-	adminHandler := &handler.AdminHandler{Repo: repo, DBPool: dbpool}
+	authHandler := &handler.AuthHandler{Repo: repo, Authenticator: authenticator, AccountSvc: accountSvc, OIDCSvc: oidcSvc}
+	accountHandler := &handler.AccountHandler{AccountSvc: accountSvc}
+	adminHandler := &handler.AdminHandler{Repo: repo, DBPool: dbpool, AccountSvc: accountSvc, APITokenSvc: apiTokenSvc, WebhookSvc: webhookSvc, ManifestSvc: manifestSvc, ImportSvc: importSvc, MachineSvc: machineSvc, MachineUserSvc: machineUserSvc, MasterDataSvc: masterDataSvc, ReportScheduleSvc: reportScheduleSvc}
 	certHandler := &handler.CertificateHandler{Repo: repo, CertSvc: certSvc}
-	apiHandler := &handler.ApiHandler{Repo: repo}
+	apiHandler := &handler.ApiHandler{Repo: repo, Tickets: ticketProvider}
+	apiV1Handler := &handler.ApiV1Handler{Repo: repo}
 	dashboardHandler := &handler.DashboardHandler{Repo: repo}
+	reportHandler := &handler.ReportHandler{Repo: repo, DBPool: dbpool}
+	deviceHandler := &handler.DeviceHandler{Repo: repo}
+	loanHandler := &handler.LoanHandler{Repo: repo, LoanSvc: loanSvc}
+	warehouseHandler := &handler.WarehouseHandler{Repo: repo, RecoverySvc: recoverySvc}
+	stockHandler := &handler.StockHandler{Repo: repo, StockSvc: stockSvc}
+	scheduleHandler := &handler.ScheduleHandler{Repo: repo, AppointmentSvc: appointmentSvc}
 
 	// Static files
 	e.StaticFS("/static", echo.MustSubFS(assets.Assets, "static"))
@@ -66,53 +105,205 @@ func main() {
 	// Public routes
 	e.GET("/login", authHandler.ShowLoginPage)
 	e.POST("/login", authHandler.HandleLogin)
+	e.GET("/login/oidc", authHandler.HandleOIDCLogin)
+	e.GET("/login/oidc/callback", authHandler.HandleOIDCCallback)
 	e.GET("/logout", authHandler.HandleLogout)
+	e.GET("/password/forgot", authHandler.ShowForgotPasswordPage)
+	e.POST("/password/forgot", authHandler.HandleForgotPassword)
+	e.GET("/password/reset/:token", authHandler.ShowResetPasswordPage)
+	e.POST("/password/reset/:token", authHandler.HandleResetPassword)
 
 	// Protected dashboard route
 	dashboardGroup := e.Group("/dashboard")
 	dashboardGroup.Use(handler.RequireAuth(repo))
 	dashboardGroup.GET("", dashboardHandler.ShowDashboard)
+	dashboardGroup.GET("/analytics", dashboardHandler.ShowAnalytics, handler.RequirePermission(model.PermViewStats))
+
+	// Protected account routes
+	accountGroup := e.Group("/account")
+	accountGroup.Use(handler.RequireAuth(repo))
+	accountGroup.GET("/password", accountHandler.ShowChangePasswordPage)
+	accountGroup.POST("/password", accountHandler.HandleChangePassword)
 
 	// Protected ADMIN routes
 	adminGroup := e.Group("/admin")
-	adminGroup.Use(handler.RequireAuth(repo), handler.RequireAdmin())
+	adminGroup.Use(handler.RequireAuth(repo), handler.RequirePermission(model.PermManageData))
 	adminGroup.GET("", adminHandler.ShowAdminDashboard)
-	adminGroup.POST("/users", adminHandler.HandleCreateUser)
-
 	adminGroup.POST("/software", adminHandler.HandleCreateSoftware)
 	adminGroup.POST("/peripherals", adminHandler.HandleCreatePeripheral)
 	adminGroup.POST("/config-items", adminHandler.HandleCreateConfigurationItem)
 	adminGroup.POST("/upload/machine-users", adminHandler.HandleBulkUploadMachineUsers)
 	adminGroup.POST("/upload/machines", adminHandler.HandleBulkUploadMachines)
-	adminGroup.GET("/report/download", adminHandler.HandleDownloadReport)
+	adminGroup.POST("/upload/manifest", adminHandler.HandleImportManifest)
+	adminGroup.GET("/imports", adminHandler.ShowImportJobs)
+	adminGroup.GET("/imports/:id", adminHandler.ShowImportJob)
+	adminGroup.POST("/imports/:id/mapping", adminHandler.HandleApplyImportMapping)
+	adminGroup.POST("/imports/:id/commit", adminHandler.HandleCommitImportJob)
+	adminGroup.POST("/imports/:id/discard", adminHandler.HandleDiscardImportJob)
+	adminGroup.GET("/imports/:id/rejected.csv", adminHandler.HandleDownloadRejectedRows)
+	adminGroup.POST("/imports/profiles/:id/delete", adminHandler.HandleDeleteImportMappingProfile)
+	adminGroup.GET("/reports", adminHandler.ShowReportSubscriptions)
+	adminGroup.POST("/reports", adminHandler.HandleCreateReportSubscription)
+	adminGroup.GET("/reports/:id", adminHandler.ShowReportSubscription)
+	adminGroup.POST("/reports/:id/active", adminHandler.HandleSetReportSubscriptionActive)
+	adminGroup.POST("/reports/:id/send", adminHandler.HandleSendReportSubscription)
+	adminGroup.POST("/reports/:id/delete", adminHandler.HandleDeleteReportSubscription)
+	adminGroup.GET("/waves", adminHandler.ShowRolloutWaves)
+	adminGroup.POST("/waves", adminHandler.HandleCreateRolloutWave)
+	adminGroup.GET("/waves/:id", adminHandler.ShowRolloutWave)
+	adminGroup.POST("/waves/:id", adminHandler.HandleUpdateRolloutWave)
+	adminGroup.POST("/waves/:id/delete", adminHandler.HandleDeleteRolloutWave)
+	adminGroup.POST("/waves/:id/members/location", adminHandler.HandleAddRolloutWaveLocation)
+	adminGroup.POST("/waves/:id/members/list", adminHandler.HandleAddRolloutWaveList)
+	adminGroup.POST("/waves/:id/members/:dni", adminHandler.HandleUpdateRolloutWaveMember)
+	adminGroup.POST("/waves/:id/members/:dni/remove", adminHandler.HandleRemoveRolloutWaveMember)
+	adminGroup.GET("/machine-users", adminHandler.ShowMachineUsers)
+	adminGroup.GET("/machine-users/:dni", adminHandler.ShowMachineUser)
+	adminGroup.POST("/machine-users/:dni", adminHandler.HandleUpdateMachineUser)
+	adminGroup.POST("/machine-users/:dni/active", adminHandler.HandleSetMachineUserActive)
+	adminGroup.POST("/machine-users/:dni/merge", adminHandler.HandleMergeMachineUser)
+	adminGroup.GET("/changes", adminHandler.ShowMasterDataChanges)
+	adminGroup.POST("/changes/:id/approve", adminHandler.HandleApproveMasterDataChange)
+	adminGroup.POST("/changes/:id/reject", adminHandler.HandleRejectMasterDataChange)
+	adminGroup.GET("/machines", adminHandler.ShowMachines)
+	adminGroup.POST("/machines", adminHandler.HandleCreateMachine)
+	adminGroup.GET("/machines/:serial", adminHandler.ShowMachine)
+	adminGroup.POST("/machines/:serial", adminHandler.HandleUpdateMachine)
+	adminGroup.POST("/machines/:serial/retire", adminHandler.HandleSetMachineRetired)
+	adminGroup.POST("/machines/:serial/delete", adminHandler.HandleDeleteMachine)
+	adminGroup.POST("/machines/:serial/device", adminHandler.HandleCreateDevice)
+	adminGroup.POST("/devices/:code", adminHandler.HandleUpdateDevice)
+	adminGroup.POST("/devices/:code/retire", adminHandler.HandleSetDeviceRetired)
+	adminGroup.POST("/devices/:code/delete", adminHandler.HandleDeleteDevice)
+	adminGroup.GET("/mtm-catalog", adminHandler.ShowMTMCatalog)
+	adminGroup.POST("/mtm-catalog", adminHandler.HandleUpsertMTMCatalogEntry)
+	adminGroup.POST("/mtm-catalog/:mtm/delete", adminHandler.HandleDeleteMTMCatalogEntry)
+
+	usersGroup := adminGroup.Group("/users", handler.RequirePermission(model.PermManageUsers))
+	usersGroup.POST("", adminHandler.HandleCreateUser)
+	usersGroup.GET("/:id", adminHandler.ShowUserDetail)
+	usersGroup.POST("/:id", adminHandler.HandleUpdateUser)
+	usersGroup.POST("/:id/active", adminHandler.HandleSetUserActive)
+	usersGroup.POST("/:id/delete", adminHandler.HandleDeleteUser)
+	usersGroup.POST("/:id/reset-password", adminHandler.HandleForcePasswordReset)
+	usersGroup.POST("/:id/scopes", adminHandler.HandleAddUserScope)
+	usersGroup.POST("/:id/scopes/:scopeID/delete", adminHandler.HandleDeleteUserScope)
+
+	tokensGroup := adminGroup.Group("/api-tokens", handler.RequirePermission(model.PermManageUsers))
+	tokensGroup.GET("", adminHandler.ShowAPITokens)
+	tokensGroup.POST("", adminHandler.HandleCreateAPIToken)
+	tokensGroup.POST("/:id/revoke", adminHandler.HandleRevokeAPIToken)
+
+	webhooksGroup := adminGroup.Group("/webhooks", handler.RequirePermission(model.PermManageUsers))
+	webhooksGroup.GET("", adminHandler.ShowWebhooks)
+	webhooksGroup.POST("", adminHandler.HandleCreateWebhook)
+	webhooksGroup.GET("/:id", adminHandler.ShowWebhookDetail)
+	webhooksGroup.POST("/:id/active", adminHandler.HandleSetWebhookActive)
+	webhooksGroup.POST("/:id/delete", adminHandler.HandleDeleteWebhook)
+	webhooksGroup.POST("/:id/ping", adminHandler.HandlePingWebhook)
+	webhooksGroup.POST("/:id/deliveries/:deliveryID/retry", adminHandler.HandleRetryWebhookDelivery)
+
+	// Protected report routes, filtered to the scopes of supervisors
+	reportGroup := e.Group("/reports")
+	reportGroup.Use(handler.RequireAuth(repo), handler.RequirePermission(model.PermViewReports))
+	reportGroup.GET("", reportHandler.ShowReportPage)
+	reportGroup.GET("/certificates", reportHandler.HandleDownloadReport)
+	reportGroup.GET("/loans", reportHandler.ShowOverdueLoans)
 
 	// Protected Certificate Routes
 	certGroup := e.Group("/certificates")
 	certGroup.Use(handler.RequireAuth(repo))
-	certGroup.GET("/new", certHandler.ShowCertificateForm)
-	certGroup.POST("/new", certHandler.HandleCreateCertificate)
+	certGroup.GET("", certHandler.ShowCertificateList, handler.RequirePermission(model.PermBrowseCertificates))
+	certGroup.GET("/new", certHandler.ShowCertificateForm, handler.RequirePermission(model.PermCreateCertificates))
+	certGroup.POST("/new", certHandler.HandleCreateCertificate, handler.RequirePermission(model.PermCreateCertificates))
+
+	// Device browser with the custody history of each device
+	deviceGroup := e.Group("/devices")
+	deviceGroup.Use(handler.RequireAuth(repo), handler.RequirePermission(model.PermBrowseCertificates))
+	deviceGroup.GET("", deviceHandler.ShowDeviceList)
+	deviceGroup.GET("/:code", deviceHandler.ShowDevice)
 
 	editGroup := e.Group("/certificate/edit")
-	editGroup.Use(handler.RequireAuth(repo))
+	editGroup.Use(handler.RequireAuth(repo), handler.RequirePermission(model.PermCreateCertificates))
 	editGroup.GET("/:id", certHandler.ShowEditCertificateForm)
 	editGroup.POST("/:id", certHandler.HandleUpdateCertificate)
+	editGroup.POST("/:id/void", certHandler.HandleVoidCertificate)
+
+	// Visits with machine users, booked by technicians or by coordinators for them
+	scheduleGroup := e.Group("/schedule")
+	scheduleGroup.Use(handler.RequireAuth(repo), handler.RequirePermission(model.PermCreateCertificates))
+	scheduleGroup.GET("", scheduleHandler.ShowSchedule)
+	scheduleGroup.POST("", scheduleHandler.HandleBookAppointment)
+	scheduleGroup.POST("/:id/done", scheduleHandler.HandleCompleteAppointment)
+	scheduleGroup.POST("/:id/cancel", scheduleHandler.HandleCancelAppointment)
+
+	// Devices lent with a certificate, returned through an acta the machine user confirms
+	loanGroup := e.Group("/loans")
+	loanGroup.Use(handler.RequireAuth(repo), handler.RequirePermission(model.PermCreateCertificates))
+	loanGroup.GET("", loanHandler.ShowLoans)
+	loanGroup.POST("/:id/return", loanHandler.HandleRequestLoanReturn)
+
+	// Recovered devices through the warehouse: intake, data wipe and disposition
+	warehouseGroup := e.Group("/warehouse")
+	warehouseGroup.Use(handler.RequireAuth(repo), handler.RequirePermission(model.PermCreateCertificates))
+	warehouseGroup.GET("", warehouseHandler.ShowWarehouse)
+	warehouseGroup.POST("/intake", warehouseHandler.HandleReceiveDevice)
+	warehouseGroup.POST("/recoveries/:id/wipe", warehouseHandler.HandleRecordWipe)
+	warehouseGroup.POST("/recoveries/:id/disposition", warehouseHandler.HandleRecordDisposition)
+	warehouseGroup.GET("/recoveries/:id/certificate/:step", warehouseHandler.ShowRecoveryCertificate)
+
+	// New machines in stock and their reservations; admins set the low-stock alerts
+	stockGroup := e.Group("/stock")
+	stockGroup.Use(handler.RequireAuth(repo), handler.RequirePermission(model.PermCreateCertificates))
+	stockGroup.GET("", stockHandler.ShowStock)
+	stockGroup.POST("/machines/:serial/reserve", stockHandler.HandleReserveMachine)
+	stockGroup.POST("/machines/:serial/release", stockHandler.HandleReleaseReservation)
+	stockGroup.POST("/alerts", stockHandler.HandleSaveAlertLevels, handler.RequirePermission(model.PermManageData))
+
+	// API contract and its viewer, public so integrators can read them without a token
+	e.GET("/api/v1/openapi.json", apiV1Handler.ServeOpenAPI)
+	e.GET("/api/v1/docs", func(c echo.Context) error {
+		return c.Redirect(http.StatusFound, "/static/api-docs/index.html")
+	})
+
+	// Versioned JSON API, authenticated with personal access tokens
+	v1Group := e.Group("/api/v1")
+	v1Group.Use(handler.RequireAPIToken(apiTokenSvc))
+	v1Group.GET("/certificates", apiV1Handler.ListCertificates, handler.RequireAPIScope(model.APIScopeCertificates))
+	v1Group.GET("/certificates/:id", apiV1Handler.GetCertificate, handler.RequireAPIScope(model.APIScopeCertificates))
+	v1Group.GET("/machine-users", apiV1Handler.ListMachineUsers, handler.RequireAPIScope(model.APIScopeMachineUsers))
+	v1Group.GET("/machine-users/:dni", apiV1Handler.GetMachineUser, handler.RequireAPIScope(model.APIScopeMachineUsers))
+	v1Group.GET("/machines", apiV1Handler.ListMachines, handler.RequireAPIScope(model.APIScopeInventory))
+	v1Group.GET("/machines/:serial", apiV1Handler.GetMachine, handler.RequireAPIScope(model.APIScopeInventory))
+	v1Group.GET("/devices", apiV1Handler.ListDevices, handler.RequireAPIScope(model.APIScopeInventory))
+	v1Group.GET("/devices/:code", apiV1Handler.GetDevice, handler.RequireAPIScope(model.APIScopeInventory))
+	v1Group.GET("/catalogs", apiV1Handler.GetCatalogs, handler.RequireAPIScope(model.APIScopeCatalogs))
 
 	apiGroup := e.Group("/api")
 	apiGroup.Use(handler.RequireAuth(repo))
 	apiGroup.GET("/machine-user", apiHandler.GetMachineUser)
 	apiGroup.GET("/machine", apiHandler.GetMachine)
+	apiGroup.GET("/ticket", apiHandler.GetTicket)
 
 	// Public Confirmation Routes
 	e.GET("/certificate/action/:token", certHandler.ShowConfirmationActionPage)
 	e.POST("/confirm/:token", certHandler.HandleCertificateConfirmation)
 	e.POST("/reject/:token", certHandler.HandleCertificateRejection)
 	e.GET("/certificate/view/:token", certHandler.ShowCertificate)
+	e.GET("/loan-return/:token", loanHandler.ShowLoanReturnPage)
+	e.POST("/loan-return/:token/confirm", loanHandler.HandleConfirmLoanReturn)
+	e.POST("/loan-return/:token/reject", loanHandler.HandleRejectLoanReturn)
 
 	// Add a root redirect for convenience
 	e.GET("/", func(c echo.Context) error {
 		return c.Redirect(http.StatusFound, "/dashboard")
 	})
 
+	// Deliver queued webhooks, scheduled reports and overdue loan reminders in the background
+	go webhookSvc.Run(context.Background())
+	go reportScheduleSvc.Run(context.Background())
+	go loanSvc.Run(context.Background())
+
 	// Start server
 	log.Fatalln(e.Start(":8080"))
 }
@@ -0,0 +1,51 @@
+// Command webhook-receiver is a local endpoint for trying out webhooks: it verifies
+// the signature of each delivery and prints the event.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"alc/service"
+)
+
+func main() {
+	addr := flag.String("addr", ":9090", "listen address")
+	status := flag.Int("status", http.StatusOK, "status code to answer with, to try out retries")
+	flag.Parse()
+
+	secret := os.Getenv("WEBHOOK_SECRET")
+	if secret == "" {
+		log.Fatalf("WEBHOOK_SECRET environment variable required")
+	}
+
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "could not read body", http.StatusBadRequest)
+			return
+		}
+
+		if err := service.VerifyWebhookSignature(secret, r.Header.Get(service.WebhookSignatureHeader), body, 5*time.Minute); err != nil {
+			log.Printf("Rejected delivery %s: %v", r.Header.Get(service.WebhookDeliveryHeader), err)
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		var pretty bytes.Buffer
+		if err := json.Indent(&pretty, body, "", "  "); err != nil {
+			pretty.Write(body)
+		}
+		log.Printf("%s (delivery %s)\n%s", r.Header.Get(service.WebhookEventHeader), r.Header.Get(service.WebhookDeliveryHeader), pretty.String())
+		w.WriteHeader(*status)
+	})
+
+	log.Printf("Listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
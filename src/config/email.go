@@ -14,6 +14,75 @@ type Config struct {
 	SmtpSender        string
 	SmtpBccRecipients []string
 	AppBaseURL        string
+
+	// Optional OpenID Connect login, enabled when OIDCIssuerURL is set
+	OIDCIssuerURL        string
+	OIDCClientID         string
+	OIDCClientSecret     string
+	OIDCRedirectURL      string
+	OIDCProviderName     string
+	OIDCGroupsClaim      string
+	OIDCDniClaim         string
+	OIDCAdminGroups      []string
+	OIDCSupervisorGroups []string
+	OIDCTecnicoGroups    []string
+	OIDCAllowJIT         bool
+	// Accept ID tokens without an email_verified claim, for providers that only
+	// return verified corporate emails and omit it
+	OIDCAssumeEmailVerified bool
+
+	// AuthChain lists the password authenticators tried in order: "local", "ldap"
+	AuthChain []string
+
+	// LDAP/Active Directory authentication, used when "ldap" is in AuthChain
+	LDAPURL              string
+	LDAPStartTLS         bool
+	LDAPBindDN           string
+	LDAPBindPassword     string
+	LDAPBaseDN           string
+	LDAPUserFilter       string
+	LDAPNameAttr         string
+	LDAPEmailAttr        string
+	LDAPDniAttr          string
+	LDAPGroupAttr        string
+	LDAPAdminGroups      []string
+	LDAPSupervisorGroups []string
+	LDAPTecnicoGroups    []string
+	LDAPAllowJIT         bool
+
+	// TicketProvider validates ticket numbers: "" (disabled), "servicenow" or "fake"
+	TicketProvider        string
+	ServiceNowURL         string
+	ServiceNowUser        string
+	ServiceNowPassword    string
+	ServiceNowTable       string
+	ServiceNowDniField    string
+	ServiceNowDeviceField string
+	TicketFakeFile        string
+}
+
+// OIDCEnabled reports whether single sign-on via OpenID Connect is configured.
+func (c *Config) OIDCEnabled() bool {
+	return c.OIDCIssuerURL != "" && c.OIDCClientID != ""
+}
+
+// splitList parses a separated environment variable, ignoring empty items.
+func splitList(s, sep string) []string {
+	var list []string
+	for _, item := range strings.Split(s, sep) {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getenvDefault returns the environment variable or def if it is empty.
+func getenvDefault(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
 }
 
 func Load() (*Config, error) {
@@ -26,6 +95,17 @@ func Load() (*Config, error) {
 		bccList = strings.Split(bccStr, ",")
 	}
 
+	appBaseURL := os.Getenv("APP_BASE_URL")
+	allowJIT, _ := strconv.ParseBool(os.Getenv("OIDC_ALLOW_JIT"))
+	assumeEmailVerified, _ := strconv.ParseBool(os.Getenv("OIDC_ASSUME_EMAIL_VERIFIED"))
+	startTLS, _ := strconv.ParseBool(os.Getenv("LDAP_START_TLS"))
+	ldapAllowJIT, _ := strconv.ParseBool(os.Getenv("LDAP_ALLOW_JIT"))
+
+	authChain := splitList(strings.ToLower(os.Getenv("AUTH_CHAIN")), ",")
+	if len(authChain) == 0 {
+		authChain = []string{"local"}
+	}
+
 	return &Config{
 		SmtpHost:          os.Getenv("SMTP_HOST"),
 		SmtpPort:          port,
@@ -33,6 +113,47 @@ func Load() (*Config, error) {
 		SmtpPass:          os.Getenv("SMTP_PASS"),
 		SmtpSender:        os.Getenv("SMTP_SENDER"),
 		SmtpBccRecipients: bccList,
-		AppBaseURL:        os.Getenv("APP_BASE_URL"),
+		AppBaseURL:        appBaseURL,
+
+		OIDCIssuerURL:           os.Getenv("OIDC_ISSUER_URL"),
+		OIDCClientID:            os.Getenv("OIDC_CLIENT_ID"),
+		OIDCClientSecret:        os.Getenv("OIDC_CLIENT_SECRET"),
+		OIDCRedirectURL:         getenvDefault("OIDC_REDIRECT_URL", appBaseURL+"/login/oidc/callback"),
+		OIDCProviderName:        getenvDefault("OIDC_PROVIDER_NAME", "SSO"),
+		OIDCGroupsClaim:         getenvDefault("OIDC_GROUPS_CLAIM", "groups"),
+		OIDCDniClaim:            os.Getenv("OIDC_DNI_CLAIM"),
+		OIDCAdminGroups:         splitList(os.Getenv("OIDC_ADMIN_GROUPS"), ","),
+		OIDCSupervisorGroups:    splitList(os.Getenv("OIDC_SUPERVISOR_GROUPS"), ","),
+		OIDCTecnicoGroups:       splitList(os.Getenv("OIDC_TECNICO_GROUPS"), ","),
+		OIDCAllowJIT:            allowJIT,
+		OIDCAssumeEmailVerified: assumeEmailVerified,
+
+		AuthChain: authChain,
+
+		LDAPURL:          os.Getenv("LDAP_URL"),
+		LDAPStartTLS:     startTLS,
+		LDAPBindDN:       os.Getenv("LDAP_BIND_DN"),
+		LDAPBindPassword: os.Getenv("LDAP_BIND_PASSWORD"),
+		LDAPBaseDN:       os.Getenv("LDAP_BASE_DN"),
+		LDAPUserFilter:   getenvDefault("LDAP_USER_FILTER", "(mail=%s)"),
+		LDAPNameAttr:     getenvDefault("LDAP_NAME_ATTR", "displayName"),
+		LDAPEmailAttr:    getenvDefault("LDAP_EMAIL_ATTR", "mail"),
+		LDAPDniAttr:      getenvDefault("LDAP_DNI_ATTR", "employeeID"),
+		LDAPGroupAttr:    getenvDefault("LDAP_GROUP_ATTR", "memberOf"),
+		// Group DNs contain commas, so the lists are separated by semicolons
+		LDAPAdminGroups:      splitList(os.Getenv("LDAP_ADMIN_GROUPS"), ";"),
+		LDAPSupervisorGroups: splitList(os.Getenv("LDAP_SUPERVISOR_GROUPS"), ";"),
+		LDAPTecnicoGroups:    splitList(os.Getenv("LDAP_TECNICO_GROUPS"), ";"),
+		LDAPAllowJIT:         ldapAllowJIT,
+
+		TicketProvider:     strings.ToLower(strings.TrimSpace(os.Getenv("TICKET_PROVIDER"))),
+		ServiceNowURL:      strings.TrimSuffix(os.Getenv("SERVICENOW_URL"), "/"),
+		ServiceNowUser:     os.Getenv("SERVICENOW_USER"),
+		ServiceNowPassword: os.Getenv("SERVICENOW_PASSWORD"),
+		ServiceNowTable:    getenvDefault("SERVICENOW_TABLE", "incident"),
+		// Dot-walked fields of the ticket holding the requester's DNI and the affected device code
+		ServiceNowDniField:    getenvDefault("SERVICENOW_DNI_FIELD", "caller_id.employee_number"),
+		ServiceNowDeviceField: getenvDefault("SERVICENOW_DEVICE_FIELD", "cmdb_ci.asset_tag"),
+		TicketFakeFile:        os.Getenv("TICKET_FAKE_FILE"),
 	}, nil
 }
@@ -0,0 +1,85 @@
+// Package dbtest gives tests a migrated PostgreSQL database of their own.
+package dbtest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"testing"
+
+	"alc/repository"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// New connects to TEST_POSTGRESQL_URL and applies every migration into a new schema,
+// dropped when the test ends. The test is skipped when the variable is not set.
+func New(t testing.TB) (*pgxpool.Pool, *repository.Queries) {
+	t.Helper()
+
+	connStr := os.Getenv("TEST_POSTGRESQL_URL")
+	if connStr == "" {
+		t.Skip("TEST_POSTGRESQL_URL is not set")
+	}
+	ctx := context.Background()
+
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	schema := "test_" + hex.EncodeToString(b)
+
+	admin, err := pgxpool.New(ctx, connStr)
+	if err != nil {
+		t.Fatalf("failed to connect to the test database: %v", err)
+	}
+	defer admin.Close()
+	// Extensions live in public, so that every test schema finds them
+	if _, err := admin.Exec(ctx, `CREATE EXTENSION IF NOT EXISTS "uuid-ossp" SCHEMA public; CREATE SCHEMA `+schema); err != nil {
+		t.Fatalf("failed to create schema %s: %v", schema, err)
+	}
+	t.Cleanup(func() {
+		admin, err := pgxpool.New(context.Background(), connStr)
+		if err != nil {
+			t.Errorf("failed to connect to drop schema %s: %v", schema, err)
+			return
+		}
+		defer admin.Close()
+		if _, err := admin.Exec(context.Background(), "DROP SCHEMA "+schema+" CASCADE"); err != nil {
+			t.Errorf("failed to drop schema %s: %v", schema, err)
+		}
+	})
+
+	cfg, err := pgxpool.ParseConfig(connStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ConnConfig.RuntimeParams["search_path"] = schema + ",public"
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+
+	_, file, _, _ := runtime.Caller(0)
+	migrations, err := filepath.Glob(filepath.Join(filepath.Dir(file), "..", "migrations", "*.up.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(migrations)
+	for _, migration := range migrations {
+		sql, err := os.ReadFile(migration)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pool.Exec(ctx, string(sql)); err != nil {
+			t.Fatalf("failed to apply %s: %v", filepath.Base(migration), err)
+		}
+	}
+
+	return pool, repository.New(pool)
+}
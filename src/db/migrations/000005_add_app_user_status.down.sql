@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS app_users_email_key;
+
+ALTER TABLE app_users
+ADD CONSTRAINT app_users_email_key UNIQUE (email);
+
+ALTER TABLE app_users
+DROP COLUMN deleted_at,
+DROP COLUMN is_active;
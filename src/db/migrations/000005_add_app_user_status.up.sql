@@ -0,0 +1,10 @@
+ALTER TABLE app_users
+ADD COLUMN is_active boolean NOT NULL DEFAULT true,
+ADD COLUMN deleted_at timestamptz;
+
+-- Deleted users keep their row (certificates reference them), so the email
+-- only needs to be unique among users that were not deleted.
+ALTER TABLE app_users
+DROP CONSTRAINT app_users_email_key;
+
+CREATE UNIQUE INDEX app_users_email_key ON app_users (email) WHERE deleted_at IS NULL;
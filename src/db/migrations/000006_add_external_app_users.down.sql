@@ -0,0 +1,4 @@
+-- The original letter case of the emails is not restored
+ALTER TABLE app_users DROP CONSTRAINT IF EXISTS app_users_email_lower;
+
+ALTER TABLE app_users DROP COLUMN IF EXISTS external_auth;
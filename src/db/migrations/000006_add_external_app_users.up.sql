@@ -0,0 +1,29 @@
+-- Users created on their first LDAP or OpenID Connect login sign in through their
+-- provider only: they cannot get a local password, which would skip the provider.
+ALTER TABLE app_users ADD COLUMN external_auth boolean NOT NULL DEFAULT false;
+
+-- Logins look emails up lower-cased, so users stored with capitals could no
+-- longer sign in. Two users whose emails differ only in case must be merged by
+-- hand first: lower-casing both would break the unique index.
+DO $$
+DECLARE
+    conflicts text;
+BEGIN
+    SELECT string_agg(email, ', ') INTO conflicts
+    FROM app_users
+    WHERE deleted_at IS NULL
+        AND lower(email) IN (
+            SELECT lower(email) FROM app_users
+            WHERE deleted_at IS NULL
+            GROUP BY lower(email)
+            HAVING COUNT(*) > 1
+        );
+    IF conflicts IS NOT NULL THEN
+        RAISE EXCEPTION 'app_users emails differ only in case: %', conflicts;
+    END IF;
+END $$;
+
+UPDATE app_users SET email = lower(email) WHERE email <> lower(email);
+
+ALTER TABLE app_users
+ADD CONSTRAINT app_users_email_lower CHECK (email = lower(email));
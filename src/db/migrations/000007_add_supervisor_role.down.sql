@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS app_user_scopes;
+
+-- PostgreSQL cannot drop an enum value, so the type is rebuilt without it.
+UPDATE app_users SET role = 'TECNICO' WHERE role = 'SUPERVISOR';
+
+ALTER TYPE user_role RENAME TO user_role_old;
+CREATE TYPE user_role AS ENUM ('ADMIN', 'TECNICO');
+ALTER TABLE app_users ALTER COLUMN role DROP DEFAULT;
+ALTER TABLE app_users ALTER COLUMN role TYPE user_role USING role::text::user_role;
+ALTER TABLE app_users ALTER COLUMN role SET DEFAULT 'TECNICO';
+DROP TYPE user_role_old;
@@ -0,0 +1,11 @@
+ALTER TYPE user_role ADD VALUE 'SUPERVISOR';
+
+-- Scopes limit what a SUPERVISOR can see to the machine users of a society,
+-- optionally narrowed to one site. An empty site means every site of the society.
+CREATE TABLE IF NOT EXISTS app_user_scopes (
+    scope_id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    user_id uuid NOT NULL REFERENCES app_users ON DELETE CASCADE,
+    society text NOT NULL,
+    site text NOT NULL DEFAULT '',
+    UNIQUE (user_id, society, site)
+);
@@ -0,0 +1,16 @@
+-- Personal access tokens for the JSON API. Only the SHA-256 of the token is stored;
+-- the prefix is kept to let admins recognize a token in the list.
+CREATE TABLE IF NOT EXISTS api_tokens (
+    token_id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id uuid NOT NULL REFERENCES app_users ON DELETE CASCADE,
+    name text NOT NULL,
+    token_prefix text NOT NULL,
+    token_hash text UNIQUE NOT NULL,
+    scopes text[] NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT NOW(),
+    expires_at timestamptz,
+    last_used_at timestamptz,
+    revoked_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS api_tokens_user_id_idx ON api_tokens (user_id);
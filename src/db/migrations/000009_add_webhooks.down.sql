@@ -0,0 +1,4 @@
+-- VOIDED stays in certificate_status: Postgres cannot drop a value from an enum.
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TYPE IF EXISTS webhook_delivery_status;
+DROP TABLE IF EXISTS webhook_endpoints;
@@ -0,0 +1,33 @@
+-- Outgoing webhooks. Deliveries work as an outbox: they are inserted in the same
+-- transaction as the change that triggers them and sent by a background worker.
+CREATE TABLE IF NOT EXISTS webhook_endpoints (
+    endpoint_id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    url text NOT NULL,
+    description text NOT NULL DEFAULT '',
+    secret text NOT NULL,
+    events text[] NOT NULL,
+    is_active boolean NOT NULL DEFAULT true,
+    created_at timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE TYPE webhook_delivery_status AS ENUM ('PENDING', 'SUCCEEDED', 'FAILED');
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    delivery_id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    endpoint_id int NOT NULL REFERENCES webhook_endpoints ON DELETE CASCADE,
+    event text NOT NULL,
+    payload jsonb NOT NULL,
+    status webhook_delivery_status NOT NULL DEFAULT 'PENDING',
+    attempts int NOT NULL DEFAULT 0,
+    next_attempt_at timestamptz NOT NULL DEFAULT NOW(),
+    last_status_code int,
+    last_error text NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL DEFAULT NOW(),
+    delivered_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'PENDING';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_endpoint_idx ON webhook_deliveries (endpoint_id, created_at DESC);
+
+-- Technicians void certificates issued by mistake, notified as certificate.voided
+ALTER TYPE certificate_status ADD VALUE IF NOT EXISTS 'VOIDED';
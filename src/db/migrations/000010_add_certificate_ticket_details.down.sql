@@ -0,0 +1,3 @@
+ALTER TABLE alicorp_2025_certificates
+DROP COLUMN ticket_url,
+DROP COLUMN ticket_state;
@@ -0,0 +1,5 @@
+-- State and link of the ITSM ticket as reported by the ticket provider when the
+-- certificate was last saved. Empty when no provider is configured.
+ALTER TABLE alicorp_2025_certificates
+ADD COLUMN ticket_state text NOT NULL DEFAULT '',
+ADD COLUMN ticket_url text NOT NULL DEFAULT '';
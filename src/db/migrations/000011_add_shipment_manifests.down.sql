@@ -0,0 +1,6 @@
+ALTER TABLE machines
+DROP COLUMN received_at,
+DROP COLUMN purchase_order,
+DROP COLUMN pallet;
+
+DROP TABLE IF EXISTS mtm_catalog;
@@ -0,0 +1,19 @@
+-- Specs of each Lenovo machine type model (MTM), maintained by admins and used to
+-- complete the machines of the vendor's shipment manifests.
+CREATE TABLE IF NOT EXISTS mtm_catalog (
+    mtm text PRIMARY KEY,
+    type machine_type NOT NULL,
+    model text NOT NULL,
+    processor text NOT NULL DEFAULT '',
+    memory_size text NOT NULL DEFAULT '',
+    disk_size text NOT NULL DEFAULT '',
+    profile machine_profile NOT NULL DEFAULT 'REGULAR',
+    updated_at timestamptz NOT NULL DEFAULT NOW()
+);
+
+-- Machines received in a shipment keep its pallet and purchase order. A received
+-- machine without a device is in stock, waiting to be assigned by a technician.
+ALTER TABLE machines
+ADD COLUMN pallet text NOT NULL DEFAULT '',
+ADD COLUMN purchase_order text NOT NULL DEFAULT '',
+ADD COLUMN received_at timestamptz;
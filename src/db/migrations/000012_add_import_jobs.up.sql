@@ -0,0 +1,21 @@
+CREATE TYPE import_job_status AS ENUM ('PREVIEW', 'COMMITTED', 'DISCARDED');
+
+-- Bulk uploads are validated into a job first. The job keeps every row of the file with
+-- its outcome, so the admin can review it before committing and download the rejected rows.
+CREATE TABLE IF NOT EXISTS import_jobs (
+    job_id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    kind text NOT NULL,
+    file_name text NOT NULL,
+    created_by uuid NOT NULL REFERENCES app_users ON DELETE RESTRICT,
+    status import_job_status NOT NULL DEFAULT 'PREVIEW',
+    total_rows int NOT NULL,
+    new_rows int NOT NULL,
+    updated_rows int NOT NULL,
+    unchanged_rows int NOT NULL,
+    invalid_rows int NOT NULL,
+    rows jsonb NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT NOW(),
+    finished_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS import_jobs_created_at_idx ON import_jobs (created_at DESC);
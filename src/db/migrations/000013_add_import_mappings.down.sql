@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS import_mapping_profiles;
+
+-- PostgreSQL cannot drop an enum value, so the type is rebuilt without it.
+UPDATE import_jobs SET status = 'DISCARDED', finished_at = NOW() WHERE status = 'MAPPING';
+
+ALTER TYPE import_job_status RENAME TO import_job_status_old;
+CREATE TYPE import_job_status AS ENUM ('PREVIEW', 'COMMITTED', 'DISCARDED');
+ALTER TABLE import_jobs ALTER COLUMN status DROP DEFAULT;
+ALTER TABLE import_jobs ALTER COLUMN status TYPE import_job_status USING status::text::import_job_status;
+ALTER TABLE import_jobs ALTER COLUMN status SET DEFAULT 'PREVIEW';
+DROP TYPE import_job_status_old;
@@ -0,0 +1,16 @@
+-- Uploads whose header cannot be matched automatically wait in MAPPING until an admin
+-- assigns each column to a field.
+ALTER TYPE import_job_status ADD VALUE 'MAPPING';
+
+-- A mapping profile remembers how the columns of a file were assigned, so the next upload
+-- with the same headers is matched without asking. columns maps a normalized header to a
+-- field name, or to '' for a column that is ignored.
+CREATE TABLE IF NOT EXISTS import_mapping_profiles (
+    profile_id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    kind text NOT NULL,
+    name text NOT NULL,
+    columns jsonb NOT NULL,
+    created_by uuid NOT NULL REFERENCES app_users ON DELETE RESTRICT,
+    updated_at timestamptz NOT NULL DEFAULT NOW(),
+    UNIQUE (kind, name)
+);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS report_runs;
+DROP TYPE IF EXISTS report_run_status;
+DROP TABLE IF EXISTS report_subscriptions;
+DROP TYPE IF EXISTS report_frequency;
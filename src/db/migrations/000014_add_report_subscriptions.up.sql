@@ -0,0 +1,44 @@
+CREATE TYPE report_frequency AS ENUM ('DAILY', 'WEEKLY', 'MONTHLY');
+
+-- Report subscriptions email the certificate report on a schedule. send_hour, weekday
+-- (0 = Sunday, for WEEKLY) and month_day (for MONTHLY) are in Lima time. period_days
+-- limits the report to the certificates created in the last days, 0 meaning all of them;
+-- the other filters and columns match the report form, empty meaning no filter.
+CREATE TABLE IF NOT EXISTS report_subscriptions (
+    subscription_id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    name text NOT NULL,
+    recipients text[] NOT NULL,
+    frequency report_frequency NOT NULL,
+    send_hour int NOT NULL CHECK (send_hour BETWEEN 0 AND 23),
+    weekday int NOT NULL DEFAULT 1 CHECK (weekday BETWEEN 0 AND 6),
+    month_day int NOT NULL DEFAULT 1 CHECK (month_day BETWEEN 1 AND 28),
+    format text NOT NULL DEFAULT 'xlsx',
+    period_days int NOT NULL DEFAULT 0 CHECK (period_days >= 0),
+    status certificate_status,
+    technician_id uuid REFERENCES app_users ON DELETE SET NULL,
+    society text NOT NULL DEFAULT '',
+    site text NOT NULL DEFAULT '',
+    columns text[] NOT NULL DEFAULT '{}',
+    is_active boolean NOT NULL DEFAULT true,
+    next_run_at timestamptz NOT NULL,
+    created_by uuid NOT NULL REFERENCES app_users ON DELETE RESTRICT,
+    created_at timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS report_subscriptions_due_idx ON report_subscriptions (next_run_at) WHERE is_active;
+
+CREATE TYPE report_run_status AS ENUM ('SUCCEEDED', 'FAILED');
+
+-- One row per attempt to send a subscription, scheduled or manual.
+CREATE TABLE IF NOT EXISTS report_runs (
+    run_id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    subscription_id int NOT NULL REFERENCES report_subscriptions ON DELETE CASCADE,
+    status report_run_status NOT NULL,
+    file_name text NOT NULL DEFAULT '',
+    size_bytes int NOT NULL DEFAULT 0,
+    error text NOT NULL DEFAULT '',
+    started_at timestamptz NOT NULL,
+    finished_at timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS report_runs_subscription_idx ON report_runs (subscription_id, started_at DESC);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS rollout_wave_members;
+DROP TABLE IF EXISTS rollout_waves;
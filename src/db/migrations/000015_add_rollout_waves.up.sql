@@ -0,0 +1,25 @@
+-- A rollout wave is a planned batch of replacements, usually a site or some floors of it,
+-- to be finished by target_date.
+CREATE TABLE IF NOT EXISTS rollout_waves (
+    wave_id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    name text UNIQUE NOT NULL,
+    description text NOT NULL DEFAULT '',
+    target_date date NOT NULL,
+    created_by uuid NOT NULL REFERENCES app_users ON DELETE RESTRICT,
+    created_at timestamptz NOT NULL DEFAULT NOW()
+);
+
+-- The machine users of a wave, each planned once across all waves. old_device_code is the
+-- device due for replacement as known when planning, '' when unknown; it is not a foreign
+-- key since old devices are registered with the certificate. The technician is optional
+-- until the work is assigned.
+CREATE TABLE IF NOT EXISTS rollout_wave_members (
+    wave_id int NOT NULL REFERENCES rollout_waves ON DELETE CASCADE,
+    machine_user_dni varchar(25) NOT NULL UNIQUE REFERENCES machine_users ON DELETE CASCADE,
+    old_device_code text NOT NULL DEFAULT '',
+    technician_id uuid REFERENCES app_users ON DELETE SET NULL,
+    added_at timestamptz NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (wave_id, machine_user_dni)
+);
+
+CREATE INDEX IF NOT EXISTS rollout_wave_members_technician_idx ON rollout_wave_members (technician_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS appointments;
+DROP TYPE IF EXISTS appointment_status;
@@ -0,0 +1,22 @@
+CREATE TYPE appointment_status AS ENUM ('SCHEDULED', 'DONE', 'CANCELLED');
+
+-- Visits booked by a technician, or by a coordinator for them, with a machine user.
+-- sequence is the revision of the calendar invitation, increased when it is cancelled so
+-- calendar clients replace the original event.
+CREATE TABLE IF NOT EXISTS appointments (
+    appointment_id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    machine_user_dni varchar(25) NOT NULL REFERENCES machine_users ON DELETE CASCADE,
+    technician_id uuid NOT NULL REFERENCES app_users ON DELETE RESTRICT,
+    starts_at timestamptz NOT NULL,
+    ends_at timestamptz NOT NULL,
+    location text NOT NULL,
+    notes text NOT NULL DEFAULT '',
+    status appointment_status NOT NULL DEFAULT 'SCHEDULED',
+    sequence int NOT NULL DEFAULT 0,
+    created_by uuid NOT NULL REFERENCES app_users ON DELETE RESTRICT,
+    created_at timestamptz NOT NULL DEFAULT NOW(),
+    updated_at timestamptz NOT NULL DEFAULT NOW(),
+    CHECK (ends_at > starts_at)
+);
+
+CREATE INDEX IF NOT EXISTS appointments_technician_idx ON appointments (technician_id, starts_at);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS device_custody;
+DROP TYPE IF EXISTS custodian_type;
+DROP TYPE IF EXISTS custody_event;
@@ -0,0 +1,63 @@
+CREATE TYPE custody_event AS ENUM ('ASIGNACION', 'RECUPERACION', 'PRESTAMO', 'DEVOLUCION', 'BACKUP');
+CREATE TYPE custodian_type AS ENUM ('MACHINE_USER', 'WAREHOUSE');
+
+-- Every change of hands of a device, the open one (ended_at NULL) being the current one.
+-- machine_user_dni is the machine user who holds the device, or for the warehouse the one
+-- it was recovered from or returned by. location is the site where it is kept.
+CREATE TABLE IF NOT EXISTS device_custody (
+    custody_id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    device_code text NOT NULL REFERENCES devices ON DELETE CASCADE,
+    event custody_event NOT NULL,
+    custodian custodian_type NOT NULL,
+    machine_user_dni varchar(25) REFERENCES machine_users ON DELETE SET NULL,
+    location text NOT NULL DEFAULT '',
+    certificate_id int REFERENCES alicorp_2025_certificates ON DELETE SET NULL,
+    recorded_by uuid REFERENCES app_users ON DELETE SET NULL,
+    notes text NOT NULL DEFAULT '',
+    started_at timestamptz NOT NULL DEFAULT NOW(),
+    ended_at timestamptz,
+    CHECK (custodian = 'WAREHOUSE' OR machine_user_dni IS NOT NULL)
+);
+
+CREATE INDEX IF NOT EXISTS device_custody_device_idx ON device_custody (device_code, started_at);
+
+-- Rebuild the history known so far from the certificates: the new device went to the
+-- machine user and the old one to the warehouse. The status of a device only tells how it
+-- was last handed over, so a new device that was later recovered counts as an assignment.
+INSERT INTO device_custody (device_code, event, custodian, machine_user_dni, location, certificate_id, recorded_by, started_at)
+SELECT
+    c.new_device_code,
+    (CASE WHEN d.status IN ('PRESTAMO', 'BACKUP') THEN d.status::text ELSE 'ASIGNACION' END)::custody_event,
+    'MACHINE_USER',
+    c.machine_user_dni,
+    mu.site,
+    c.certificate_id,
+    c.app_user_id,
+    c.created_at
+FROM alicorp_2025_certificates c
+JOIN devices d ON c.new_device_code = d.device_code
+JOIN machine_users mu ON c.machine_user_dni = mu.dni
+UNION ALL
+SELECT
+    c.old_device_code,
+    'RECUPERACION',
+    'WAREHOUSE',
+    c.machine_user_dni,
+    mu.site,
+    c.certificate_id,
+    c.app_user_id,
+    c.created_at
+FROM alicorp_2025_certificates c
+JOIN machine_users mu ON c.machine_user_dni = mu.dni;
+
+UPDATE device_custody dc
+SET ended_at = h.next_started_at
+FROM (
+    SELECT custody_id, LEAD(started_at) OVER (PARTITION BY device_code ORDER BY started_at, custody_id) AS next_started_at
+    FROM device_custody
+) h
+WHERE dc.custody_id = h.custody_id AND h.next_started_at IS NOT NULL;
+
+-- A device has one open custody at a time. The backfill opens several per device before
+-- closing them, so the index is only created afterwards.
+CREATE UNIQUE INDEX IF NOT EXISTS device_custody_open_idx ON device_custody (device_code) WHERE ended_at IS NULL;
@@ -0,0 +1,22 @@
+-- The loan of a certificate whose new device was handed over as PRESTAMO. The return is
+-- an acta the machine user confirms like the certificate: registering it sets return_token
+-- and a PENDING return_status, and the answer to the email settles it. A rejected return
+-- can be registered again with a new token.
+CREATE TABLE IF NOT EXISTS device_loans (
+    loan_id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    certificate_id int UNIQUE NOT NULL REFERENCES alicorp_2025_certificates ON DELETE CASCADE,
+    device_code text NOT NULL REFERENCES devices ON DELETE CASCADE,
+    machine_user_dni varchar(25) NOT NULL REFERENCES machine_users ON DELETE CASCADE,
+    technician_id uuid NOT NULL REFERENCES app_users ON DELETE RESTRICT,
+    due_date date NOT NULL,
+    return_status certificate_status,
+    return_token uuid UNIQUE,
+    return_comments text NOT NULL DEFAULT '',
+    return_received_by uuid REFERENCES app_users ON DELETE SET NULL,
+    return_requested_at timestamptz,
+    returned_at timestamptz,
+    overdue_notified_at timestamptz,
+    created_at timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS device_loans_open_idx ON device_loans (due_date) WHERE returned_at IS NULL;
@@ -0,0 +1,30 @@
+DROP TABLE IF EXISTS device_recoveries;
+DROP TYPE IF EXISTS recovery_disposition;
+
+-- PostgreSQL cannot drop an enum value, so the types are rebuilt without them. The custody
+-- of sold and scrapped devices ends with the warehouse again.
+DELETE FROM device_custody WHERE event IN ('REDESPLIEGUE', 'VENTA', 'BAJA');
+UPDATE device_custody dc
+SET ended_at = NULL
+WHERE dc.custody_id IN (
+    SELECT DISTINCT ON (h.device_code) h.custody_id
+    FROM device_custody h
+    ORDER BY h.device_code, h.started_at DESC, h.custody_id DESC
+) AND NOT EXISTS (
+    SELECT 1 FROM device_custody cur
+    WHERE cur.device_code = dc.device_code AND cur.ended_at IS NULL
+);
+
+ALTER TABLE device_custody DROP CONSTRAINT device_custody_check;
+
+ALTER TYPE custody_event RENAME TO custody_event_old;
+CREATE TYPE custody_event AS ENUM ('ASIGNACION', 'RECUPERACION', 'PRESTAMO', 'DEVOLUCION', 'BACKUP');
+ALTER TABLE device_custody ALTER COLUMN event TYPE custody_event USING event::text::custody_event;
+DROP TYPE custody_event_old;
+
+ALTER TYPE custodian_type RENAME TO custodian_type_old;
+CREATE TYPE custodian_type AS ENUM ('MACHINE_USER', 'WAREHOUSE');
+ALTER TABLE device_custody ALTER COLUMN custodian TYPE custodian_type USING custodian::text::custodian_type;
+DROP TYPE custodian_type_old;
+
+ALTER TABLE device_custody ADD CONSTRAINT device_custody_check CHECK (custodian = 'WAREHOUSE' OR machine_user_dni IS NOT NULL);
@@ -0,0 +1,42 @@
+-- Recovered devices leave the warehouse for good when they are sold or scrapped, held by
+-- someone outside the company.
+ALTER TYPE custody_event ADD VALUE 'REDESPLIEGUE';
+ALTER TYPE custody_event ADD VALUE 'VENTA';
+ALTER TYPE custody_event ADD VALUE 'BAJA';
+ALTER TYPE custodian_type ADD VALUE 'EXTERNAL';
+
+ALTER TABLE device_custody DROP CONSTRAINT device_custody_check;
+ALTER TABLE device_custody ADD CONSTRAINT device_custody_check CHECK (custodian <> 'MACHINE_USER' OR machine_user_dni IS NOT NULL);
+
+CREATE TYPE recovery_disposition AS ENUM ('REDESPLIEGUE', 'VENTA', 'BAJA');
+
+-- What happens to a device once it is back in the warehouse: its intake, the wipe of its
+-- data and its disposition, each step with its own certificate. A device goes through the
+-- pipeline again each time it is recovered, so it has one open recovery (disposition NULL)
+-- at a time. certificate_id and machine_user_dni tell where the device was recovered from.
+-- disposition_reference is the buyer, the scrap vendor or the reason of the
+-- redeployment.
+CREATE TABLE IF NOT EXISTS device_recoveries (
+    recovery_id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    device_code text NOT NULL REFERENCES devices ON DELETE CASCADE,
+    certificate_id int REFERENCES alicorp_2025_certificates ON DELETE SET NULL,
+    machine_user_dni varchar(25) REFERENCES machine_users ON DELETE SET NULL,
+    location text NOT NULL DEFAULT '',
+    intake_notes text NOT NULL DEFAULT '',
+    received_by uuid REFERENCES app_users ON DELETE SET NULL,
+    received_at timestamptz NOT NULL DEFAULT NOW(),
+    wipe_method text NOT NULL DEFAULT '',
+    wipe_tool text NOT NULL DEFAULT '',
+    wipe_operator text NOT NULL DEFAULT '',
+    wipe_notes text NOT NULL DEFAULT '',
+    wiped_by uuid REFERENCES app_users ON DELETE SET NULL,
+    wiped_at timestamptz,
+    disposition recovery_disposition,
+    disposition_reference text NOT NULL DEFAULT '',
+    disposition_notes text NOT NULL DEFAULT '',
+    disposed_by uuid REFERENCES app_users ON DELETE SET NULL,
+    disposed_at timestamptz,
+    CHECK (disposition IS NULL OR wiped_at IS NOT NULL)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS device_recoveries_open_idx ON device_recoveries (device_code) WHERE disposition IS NULL;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS stock_alert_levels;
+DROP TABLE IF EXISTS machine_reservations;
+ALTER TABLE machines DROP COLUMN IF EXISTS site;
@@ -0,0 +1,19 @@
+-- The site where a machine waiting to be assigned is stocked, '' when unknown.
+ALTER TABLE machines ADD COLUMN site text NOT NULL DEFAULT '';
+
+-- A machine in stock set aside for a machine user ahead of the visit. Each machine user
+-- has at most one, and the certificate that hands the machine over removes it.
+CREATE TABLE IF NOT EXISTS machine_reservations (
+    machine_serial_num text PRIMARY KEY REFERENCES machines ON DELETE CASCADE ON UPDATE CASCADE,
+    machine_user_dni varchar(25) UNIQUE NOT NULL REFERENCES machine_users ON DELETE CASCADE,
+    reserved_by uuid REFERENCES app_users ON DELETE SET NULL,
+    notes text NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL DEFAULT NOW()
+);
+
+-- The fewest available machines of a profile, across sites, before the stock page warns.
+CREATE TABLE IF NOT EXISTS stock_alert_levels (
+    profile machine_profile PRIMARY KEY,
+    min_available int NOT NULL CHECK (min_available >= 0),
+    updated_at timestamptz NOT NULL DEFAULT NOW()
+);
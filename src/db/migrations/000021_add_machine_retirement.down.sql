@@ -0,0 +1,6 @@
+ALTER TABLE devices DROP COLUMN IF EXISTS retired_at;
+ALTER TABLE machines DROP COLUMN IF EXISTS retired_at;
+
+ALTER TABLE devices DROP CONSTRAINT devices_machine_serial_num_fkey;
+ALTER TABLE devices ADD CONSTRAINT devices_machine_serial_num_fkey
+    FOREIGN KEY (machine_serial_num) REFERENCES machines ON DELETE RESTRICT;
@@ -0,0 +1,9 @@
+-- A serial number typed wrong is renamed from the admin panel, carrying its device along.
+ALTER TABLE devices DROP CONSTRAINT devices_machine_serial_num_fkey;
+ALTER TABLE devices ADD CONSTRAINT devices_machine_serial_num_fkey
+    FOREIGN KEY (machine_serial_num) REFERENCES machines ON DELETE RESTRICT ON UPDATE CASCADE;
+
+-- Retired machines and devices are kept for their history but are out of stock and
+-- cannot be handed over in a certificate.
+ALTER TABLE machines ADD COLUMN retired_at timestamptz;
+ALTER TABLE devices ADD COLUMN retired_at timestamptz;
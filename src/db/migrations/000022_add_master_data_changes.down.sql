@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS master_data_changes;
+DROP TYPE IF EXISTS master_data_change_status;
+DROP TYPE IF EXISTS master_data_entity;
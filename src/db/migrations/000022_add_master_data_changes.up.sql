@@ -0,0 +1,24 @@
+CREATE TYPE master_data_entity AS ENUM ('MACHINE_USER', 'MACHINE');
+CREATE TYPE master_data_change_status AS ENUM ('PENDING', 'APPROVED', 'REJECTED');
+
+-- Differences between what a technician typed in a certificate and the machine user or
+-- machine on record. They are applied only once an admin approves them.
+CREATE TABLE IF NOT EXISTS master_data_changes (
+    change_id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    entity master_data_entity NOT NULL,
+    -- The DNI of the machine user or the serial number of the machine.
+    entity_key text NOT NULL,
+    field text NOT NULL,
+    current_value text NOT NULL,
+    proposed_value text NOT NULL,
+    certificate_id int REFERENCES alicorp_2025_certificates ON DELETE SET NULL,
+    proposed_by uuid REFERENCES app_users ON DELETE SET NULL,
+    proposed_at timestamptz NOT NULL DEFAULT NOW(),
+    status master_data_change_status NOT NULL DEFAULT 'PENDING',
+    reviewed_by uuid REFERENCES app_users ON DELETE SET NULL,
+    reviewed_at timestamptz
+);
+
+-- A field has at most one pending change: a newer proposal replaces it.
+CREATE UNIQUE INDEX IF NOT EXISTS master_data_changes_pending_idx
+    ON master_data_changes (entity, entity_key, field) WHERE status = 'PENDING';
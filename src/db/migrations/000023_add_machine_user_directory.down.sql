@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS machine_user_history;
+DROP TYPE IF EXISTS machine_user_change_source;
+ALTER TABLE machine_users DROP COLUMN IF EXISTS active;
@@ -0,0 +1,20 @@
+-- Machine users who left the company are deactivated rather than deleted, keeping their
+-- certificates and custody history.
+ALTER TABLE machine_users ADD COLUMN active boolean NOT NULL DEFAULT true;
+
+CREATE TYPE machine_user_change_source AS ENUM ('DIRECTORY', 'REVIEW', 'MERGE');
+
+-- Every change made to a machine user after it was loaded: edits in the directory,
+-- approved changes from certificates and merges of duplicates.
+CREATE TABLE IF NOT EXISTS machine_user_history (
+    history_id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    machine_user_dni varchar(25) NOT NULL REFERENCES machine_users ON DELETE CASCADE,
+    field text NOT NULL,
+    old_value text NOT NULL,
+    new_value text NOT NULL,
+    source machine_user_change_source NOT NULL,
+    changed_by uuid REFERENCES app_users ON DELETE SET NULL,
+    changed_at timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS machine_user_history_dni_idx ON machine_user_history (machine_user_dni, changed_at);
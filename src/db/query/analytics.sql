@@ -0,0 +1,111 @@
+-- Aggregates of the analytics page. When scoped, certificates and machine users are limited
+-- to the viewer's app_user_scopes, and created_from/created_to filter certificates by their
+-- creation date like the report.
+
+-- name: GetCertificateTimeline :many
+-- Certificates created per day or week (bucket is 'day' or 'week'), in Lima time.
+SELECT
+    date_trunc(sqlc.arg(bucket)::text, c.created_at AT TIME ZONE 'America/Lima')::date AS period,
+    COUNT(*) FILTER (WHERE c.confirmation_status = 'CONFIRMED') AS confirmed,
+    COUNT(*) FILTER (WHERE c.confirmation_status = 'PENDING') AS pending,
+    COUNT(*) FILTER (WHERE c.confirmation_status = 'REJECTED') AS rejected
+FROM alicorp_2025_certificates c
+JOIN machine_users mu ON c.machine_user_dni = mu.dni
+WHERE (NOT sqlc.arg(scoped)::boolean OR EXISTS (
+        SELECT 1 FROM app_user_scopes sc
+        WHERE sc.user_id = sqlc.arg(viewer_id)::uuid
+            AND UPPER(sc.society) = UPPER(mu.society)
+            AND (sc.site = '' OR UPPER(sc.site) = UPPER(mu.site))
+    ))
+    AND (sqlc.narg(created_from)::timestamptz IS NULL OR c.created_at >= sqlc.narg(created_from)::timestamptz)
+    AND (sqlc.narg(created_to)::timestamptz IS NULL OR c.created_at < sqlc.narg(created_to)::timestamptz)
+GROUP BY 1
+ORDER BY 1;
+
+-- name: GetCertificateStatusCounts :many
+SELECT
+    c.confirmation_status,
+    COUNT(*) AS total
+FROM alicorp_2025_certificates c
+JOIN machine_users mu ON c.machine_user_dni = mu.dni
+WHERE (NOT sqlc.arg(scoped)::boolean OR EXISTS (
+        SELECT 1 FROM app_user_scopes sc
+        WHERE sc.user_id = sqlc.arg(viewer_id)::uuid
+            AND UPPER(sc.society) = UPPER(mu.society)
+            AND (sc.site = '' OR UPPER(sc.site) = UPPER(mu.site))
+    ))
+    AND (sqlc.narg(created_from)::timestamptz IS NULL OR c.created_at >= sqlc.narg(created_from)::timestamptz)
+    AND (sqlc.narg(created_to)::timestamptz IS NULL OR c.created_at < sqlc.narg(created_to)::timestamptz)
+GROUP BY c.confirmation_status
+ORDER BY c.confirmation_status;
+
+-- name: GetRolloutProgress :many
+-- Machine users per society, site or area (level is 'society', 'site' or 'area'), with
+-- how many have a certificate and how many have a confirmed one. The progress is over the
+-- whole rollout, so the date filters do not apply.
+SELECT
+    mu.society,
+    (CASE WHEN sqlc.arg(level)::text IN ('site', 'area') THEN mu.site ELSE '' END)::text AS site,
+    (CASE WHEN sqlc.arg(level)::text = 'area' THEN mu.area ELSE '' END)::text AS area,
+    COUNT(*) AS machine_users,
+    COUNT(*) FILTER (WHERE EXISTS (
+        SELECT 1 FROM alicorp_2025_certificates c WHERE c.machine_user_dni = mu.dni
+    )) AS certified,
+    COUNT(*) FILTER (WHERE EXISTS (
+        SELECT 1 FROM alicorp_2025_certificates c
+        WHERE c.machine_user_dni = mu.dni AND c.confirmation_status = 'CONFIRMED'
+    )) AS confirmed
+FROM machine_users mu
+WHERE (NOT sqlc.arg(scoped)::boolean OR EXISTS (
+        SELECT 1 FROM app_user_scopes sc
+        WHERE sc.user_id = sqlc.arg(viewer_id)::uuid
+            AND UPPER(sc.society) = UPPER(mu.society)
+            AND (sc.site = '' OR UPPER(sc.site) = UPPER(mu.site))
+    ))
+GROUP BY 1, 2, 3
+ORDER BY 1, 2, 3;
+
+-- name: GetTechnicianThroughput :many
+-- Certificates per technician, the days on which they created any, and the median time
+-- from creation to confirmation of their confirmed certificates.
+SELECT
+    au.user_id,
+    au.name,
+    COUNT(*) AS total,
+    COUNT(*) FILTER (WHERE c.confirmation_status = 'CONFIRMED') AS confirmed,
+    COUNT(*) FILTER (WHERE c.confirmation_status = 'REJECTED') AS rejected,
+    COUNT(DISTINCT (c.created_at AT TIME ZONE 'America/Lima')::date) AS active_days,
+    COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM c.confirmed_at - c.created_at))
+        FILTER (WHERE c.confirmation_status = 'CONFIRMED'), 0)::float8 AS median_confirmation_seconds
+FROM alicorp_2025_certificates c
+JOIN app_users au ON c.app_user_id = au.user_id
+JOIN machine_users mu ON c.machine_user_dni = mu.dni
+WHERE (NOT sqlc.arg(scoped)::boolean OR EXISTS (
+        SELECT 1 FROM app_user_scopes sc
+        WHERE sc.user_id = sqlc.arg(viewer_id)::uuid
+            AND UPPER(sc.society) = UPPER(mu.society)
+            AND (sc.site = '' OR UPPER(sc.site) = UPPER(mu.site))
+    ))
+    AND (sqlc.narg(created_from)::timestamptz IS NULL OR c.created_at >= sqlc.narg(created_from)::timestamptz)
+    AND (sqlc.narg(created_to)::timestamptz IS NULL OR c.created_at < sqlc.narg(created_to)::timestamptz)
+GROUP BY au.user_id, au.name
+ORDER BY total DESC, au.name;
+
+-- name: GetConfirmationTimeStats :one
+-- The median and 90th percentile of the time from creation to confirmation, in seconds,
+-- over the confirmed certificates.
+SELECT
+    COUNT(*) AS confirmed,
+    COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM c.confirmed_at - c.created_at)), 0)::float8 AS median_seconds,
+    COALESCE(percentile_cont(0.9) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM c.confirmed_at - c.created_at)), 0)::float8 AS p90_seconds
+FROM alicorp_2025_certificates c
+JOIN machine_users mu ON c.machine_user_dni = mu.dni
+WHERE c.confirmation_status = 'CONFIRMED'
+    AND (NOT sqlc.arg(scoped)::boolean OR EXISTS (
+        SELECT 1 FROM app_user_scopes sc
+        WHERE sc.user_id = sqlc.arg(viewer_id)::uuid
+            AND UPPER(sc.society) = UPPER(mu.society)
+            AND (sc.site = '' OR UPPER(sc.site) = UPPER(mu.site))
+    ))
+    AND (sqlc.narg(created_from)::timestamptz IS NULL OR c.created_at >= sqlc.narg(created_from)::timestamptz)
+    AND (sqlc.narg(created_to)::timestamptz IS NULL OR c.created_at < sqlc.narg(created_to)::timestamptz);
@@ -0,0 +1,42 @@
+-- name: CreateAPIToken :one
+INSERT INTO api_tokens (
+    user_id, name, token_prefix, token_hash, scopes, expires_at
+) VALUES (
+    $1, $2, $3, $4, $5, $6
+)
+RETURNING *;
+
+-- name: ListAPITokens :many
+SELECT
+    t.*,
+    u.name AS user_name,
+    u.email AS user_email
+FROM api_tokens t
+JOIN app_users u ON t.user_id = u.user_id
+ORDER BY t.created_at DESC;
+
+-- name: RevokeAPIToken :exec
+UPDATE api_tokens
+SET revoked_at = NOW()
+WHERE token_id = $1 AND revoked_at IS NULL;
+
+-- name: GetAPITokenUserByHash :one
+-- The token is only valid while it is not revoked or expired and its owner can still sign in.
+SELECT
+    t.token_id,
+    t.scopes,
+    u.user_id,
+    u.name,
+    u.email,
+    u.role
+FROM api_tokens t
+JOIN app_users u ON t.user_id = u.user_id
+WHERE t.token_hash = $1
+    AND t.revoked_at IS NULL
+    AND (t.expires_at IS NULL OR t.expires_at > NOW())
+    AND u.is_active AND u.deleted_at IS NULL;
+
+-- name: TouchAPIToken :exec
+UPDATE api_tokens
+SET last_used_at = NOW()
+WHERE token_id = $1;
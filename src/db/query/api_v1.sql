@@ -0,0 +1,166 @@
+-- name: APIListCertificates :many
+-- Lists of the JSON API use keyset pagination on the primary key: the handler
+-- asks for one row more than the page size to know if there is a next page.
+SELECT
+    c.certificate_id,
+    c.ticket_name,
+    c.ticket_state,
+    c.ticket_url,
+    c.confirmation_status,
+    c.confirmed_at,
+    c.created_at,
+    c.updated_at,
+    c.app_user_id,
+    au.name AS technician_name,
+    au.email AS technician_email,
+    c.machine_user_dni,
+    mu.name AS machine_user_name,
+    mu.society AS machine_user_society,
+    mu.site AS machine_user_site,
+    c.new_device_code,
+    c.old_device_code,
+    c.disk_c_size,
+    c.disk_d_size,
+    c.printer_name,
+    c.printer_ip,
+    c.printer_test,
+    c.comments
+FROM
+    alicorp_2025_certificates c
+JOIN app_users au ON c.app_user_id = au.user_id
+JOIN machine_users mu ON c.machine_user_dni = mu.dni
+WHERE
+    c.certificate_id > sqlc.arg(after_id)::int
+    AND (NOT sqlc.arg(scoped)::boolean OR EXISTS (
+        SELECT 1 FROM app_user_scopes sc
+        WHERE sc.user_id = sqlc.arg(viewer_id)::uuid
+            AND UPPER(sc.society) = UPPER(mu.society)
+            AND (sc.site = '' OR UPPER(sc.site) = UPPER(mu.site))
+    ))
+    AND (sqlc.narg(status)::certificate_status IS NULL OR c.confirmation_status = sqlc.narg(status)::certificate_status)
+    AND (sqlc.arg(ticket_name)::text = '' OR c.ticket_name = sqlc.arg(ticket_name)::text)
+    AND (sqlc.arg(machine_user_dni)::text = '' OR c.machine_user_dni = sqlc.arg(machine_user_dni)::text)
+    AND (sqlc.arg(device_code)::text = ''
+        OR c.new_device_code = sqlc.arg(device_code)::text
+        OR c.old_device_code = sqlc.arg(device_code)::text)
+    AND (sqlc.narg(created_from)::timestamptz IS NULL OR c.created_at >= sqlc.narg(created_from)::timestamptz)
+    AND (sqlc.narg(created_to)::timestamptz IS NULL OR c.created_at < sqlc.narg(created_to)::timestamptz)
+    AND (sqlc.narg(updated_since)::timestamptz IS NULL OR c.updated_at >= sqlc.narg(updated_since)::timestamptz)
+ORDER BY c.certificate_id
+LIMIT sqlc.arg(page_limit)::int;
+
+-- name: APIGetCertificate :one
+SELECT
+    c.certificate_id,
+    c.ticket_name,
+    c.ticket_state,
+    c.ticket_url,
+    c.confirmation_status,
+    c.confirmed_at,
+    c.created_at,
+    c.updated_at,
+    c.app_user_id,
+    au.name AS technician_name,
+    au.email AS technician_email,
+    c.machine_user_dni,
+    mu.name AS machine_user_name,
+    mu.society AS machine_user_society,
+    mu.site AS machine_user_site,
+    c.new_device_code,
+    c.old_device_code,
+    c.disk_c_size,
+    c.disk_d_size,
+    c.printer_name,
+    c.printer_ip,
+    c.printer_test,
+    c.comments
+FROM
+    alicorp_2025_certificates c
+JOIN app_users au ON c.app_user_id = au.user_id
+JOIN machine_users mu ON c.machine_user_dni = mu.dni
+WHERE
+    c.certificate_id = sqlc.arg(certificate_id)::int
+    AND (NOT sqlc.arg(scoped)::boolean OR EXISTS (
+        SELECT 1 FROM app_user_scopes sc
+        WHERE sc.user_id = sqlc.arg(viewer_id)::uuid
+            AND UPPER(sc.society) = UPPER(mu.society)
+            AND (sc.site = '' OR UPPER(sc.site) = UPPER(mu.site))
+    ));
+
+-- name: APIListMachineUsers :many
+SELECT mu.* FROM machine_users mu
+WHERE
+    mu.dni > sqlc.arg(after_dni)::text
+    AND (NOT sqlc.arg(scoped)::boolean OR EXISTS (
+        SELECT 1 FROM app_user_scopes sc
+        WHERE sc.user_id = sqlc.arg(viewer_id)::uuid
+            AND UPPER(sc.society) = UPPER(mu.society)
+            AND (sc.site = '' OR UPPER(sc.site) = UPPER(mu.site))
+    ))
+    AND (sqlc.arg(society)::text = '' OR UPPER(mu.society) = UPPER(sqlc.arg(society)::text))
+    AND (sqlc.arg(site)::text = '' OR UPPER(mu.site) = UPPER(sqlc.arg(site)::text))
+ORDER BY mu.dni
+LIMIT sqlc.arg(page_limit)::int;
+
+-- name: APIGetMachineUser :one
+SELECT mu.* FROM machine_users mu
+WHERE
+    mu.dni = sqlc.arg(dni)::text
+    AND (NOT sqlc.arg(scoped)::boolean OR EXISTS (
+        SELECT 1 FROM app_user_scopes sc
+        WHERE sc.user_id = sqlc.arg(viewer_id)::uuid
+            AND UPPER(sc.society) = UPPER(mu.society)
+            AND (sc.site = '' OR UPPER(sc.site) = UPPER(mu.site))
+    ));
+
+-- name: APIListMachines :many
+SELECT * FROM machines
+WHERE
+    serial_num > sqlc.arg(after_serial)::text
+    AND (sqlc.narg(type)::machine_type IS NULL OR type = sqlc.narg(type)::machine_type)
+    AND (sqlc.arg(model)::text = '' OR model ILIKE '%' || sqlc.arg(model)::text || '%')
+ORDER BY serial_num
+LIMIT sqlc.arg(page_limit)::int;
+
+-- name: APIListDevices :many
+SELECT
+    d.*,
+    m.type AS machine_type,
+    m.model AS machine_model,
+    m.plate_num AS machine_plate_num
+FROM devices d
+JOIN machines m ON d.machine_serial_num = m.serial_num
+WHERE
+    d.device_code > sqlc.arg(after_code)::text
+    AND (sqlc.narg(type)::device_type IS NULL OR d.type = sqlc.narg(type)::device_type)
+    AND (sqlc.narg(status)::device_status IS NULL OR d.status = sqlc.narg(status)::device_status)
+ORDER BY d.device_code
+LIMIT sqlc.arg(page_limit)::int;
+
+-- name: APIGetDevice :one
+SELECT
+    d.*,
+    m.type AS machine_type,
+    m.model AS machine_model,
+    m.plate_num AS machine_plate_num
+FROM devices d
+JOIN machines m ON d.machine_serial_num = m.serial_num
+WHERE d.device_code = $1;
+
+-- name: ListDeviceSoftwareNames :many
+SELECT s.name FROM device_software ds
+JOIN software s ON ds.software_id = s.software_id
+WHERE ds.device_code = $1
+ORDER BY s.name;
+
+-- name: ListDeviceConfigurationNames :many
+SELECT ci.name FROM device_configuration dc
+JOIN configuration_items ci ON dc.item_id = ci.item_id
+WHERE dc.device_code = $1
+ORDER BY ci.name;
+
+-- name: ListDevicePeripherals :many
+SELECT p.peripheral_id, p.name, dp.plate_num, dp.serial_num FROM device_peripherals dp
+JOIN peripherals p ON dp.peripheral_id = p.peripheral_id
+WHERE dp.device_code = $1
+ORDER BY p.name;
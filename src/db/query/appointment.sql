@@ -0,0 +1,54 @@
+-- name: CreateAppointment :one
+INSERT INTO appointments (
+    machine_user_dni, technician_id, starts_at, ends_at, location, notes, created_by
+) VALUES (
+    $1, $2, $3, $4, $5, $6, $7
+)
+RETURNING *;
+
+-- name: CountOverlappingAppointments :one
+-- The scheduled visits of a technician that overlap the given time window.
+SELECT COUNT(*) FROM appointments
+WHERE technician_id = $1
+    AND status = 'SCHEDULED'
+    AND starts_at < sqlc.arg(ends_at)::timestamptz
+    AND ends_at > sqlc.arg(starts_at)::timestamptz;
+
+-- name: GetAppointmentDetails :one
+-- An appointment with what its invitation and the certificate form need. old_device_code
+-- is the device planned for replacement in a rollout wave, '' when none.
+SELECT
+    a.*,
+    mu.name AS machine_user_name,
+    mu.email AS machine_user_email,
+    au.name AS technician_name,
+    au.email AS technician_email,
+    COALESCE(wm.old_device_code, '')::text AS old_device_code
+FROM appointments a
+JOIN machine_users mu ON a.machine_user_dni = mu.dni
+JOIN app_users au ON a.technician_id = au.user_id
+LEFT JOIN rollout_wave_members wm ON wm.machine_user_dni = a.machine_user_dni
+WHERE a.appointment_id = $1;
+
+-- name: ListTechnicianAppointments :many
+-- The appointments of a technician starting in [starts_from, starts_to), cancelled ones
+-- included.
+SELECT
+    a.*,
+    mu.name AS machine_user_name,
+    mu.site,
+    mu.floor_name
+FROM appointments a
+JOIN machine_users mu ON a.machine_user_dni = mu.dni
+WHERE a.technician_id = $1
+    AND a.starts_at >= sqlc.arg(starts_from)::timestamptz
+    AND a.starts_at < sqlc.arg(starts_to)::timestamptz
+ORDER BY a.starts_at;
+
+-- name: SetAppointmentStatus :one
+-- Closes a scheduled appointment as DONE or CANCELLED. No row is returned when it was
+-- already closed.
+UPDATE appointments
+SET status = $2, sequence = sequence + 1, updated_at = NOW()
+WHERE appointment_id = $1 AND status = 'SCHEDULED'
+RETURNING *;
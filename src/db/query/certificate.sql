@@ -10,9 +10,11 @@ INSERT INTO alicorp_2025_certificates (
     printer_name,
     printer_ip,
     printer_test,
-    comments
+    comments,
+    ticket_state,
+    ticket_url
 ) VALUES (
-    $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
+    $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13
 ) RETURNING *;
 
 -- name: AddSoftwareToDevice :exec
@@ -77,12 +79,49 @@ JOIN machines m ON d.machine_serial_num = m.serial_num
 WHERE
     c.confirmation_token = $1;
 
--- name: UpdateCertificateStatus :exec
+-- name: UpdateCertificateStatus :one
 UPDATE alicorp_2025_certificates
 SET
     confirmation_status = $1,
     confirmed_at = NOW(),
     updated_at = NOW()
 WHERE
-    confirmation_token = $2 AND confirmation_status = 'PENDING';
+    confirmation_token = $2 AND confirmation_status = 'PENDING'
+RETURNING *;
+
 
+-- name: ListCertificates :many
+-- Certificate browser. When scoped, only certificates of machine users in the viewer's scopes are listed.
+SELECT
+    c.certificate_id,
+    c.ticket_name,
+    c.confirmation_status,
+    c.confirmation_token,
+    c.ticket_state,
+    c.ticket_url,
+    c.created_at,
+    c.new_device_code,
+    mu.dni AS machine_user_dni,
+    mu.name AS machine_user_name,
+    mu.society AS machine_user_society,
+    mu.site AS machine_user_site,
+    au.name AS technician_name
+FROM
+    alicorp_2025_certificates c
+JOIN machine_users mu ON c.machine_user_dni = mu.dni
+JOIN app_users au ON c.app_user_id = au.user_id
+WHERE
+    (NOT sqlc.arg(scoped)::boolean OR EXISTS (
+            SELECT 1 FROM app_user_scopes sc
+            WHERE sc.user_id = sqlc.arg(viewer_id)::uuid
+                AND UPPER(sc.society) = UPPER(mu.society)
+                AND (sc.site = '' OR UPPER(sc.site) = UPPER(mu.site))
+        ))
+    AND (sqlc.arg(search)::text = ''
+        OR c.ticket_name ILIKE '%' || sqlc.arg(search)::text || '%'
+        OR mu.name ILIKE '%' || sqlc.arg(search)::text || '%'
+        OR mu.dni ILIKE '%' || sqlc.arg(search)::text || '%'
+        OR c.new_device_code ILIKE '%' || sqlc.arg(search)::text || '%')
+ORDER BY
+    c.created_at DESC
+LIMIT 200;
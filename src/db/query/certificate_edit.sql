@@ -4,7 +4,13 @@ SELECT
     au.name AS technician_name,
     mu.*,
     nd.hostname AS new_device_hostname,
-    nd.status AS new_device_status,
+    -- How the certificate handed the device over, even if it moved since
+    COALESCE((
+        SELECT cu.event::text FROM device_custody cu
+        WHERE cu.certificate_id = c.certificate_id AND cu.custodian = 'MACHINE_USER'
+        ORDER BY cu.custody_id DESC
+        LIMIT 1
+    ), nd.status::text)::device_status AS new_device_status,
     nd.additional_software,
     nm.serial_num AS new_machine_serial,
     nm.type AS new_machine_type,
@@ -21,7 +27,8 @@ SELECT
     om.memory_size AS old_machine_memory,
     ARRAY_TO_STRING(ARRAY_AGG(DISTINCT ds.software_id), ',') AS selected_software,
     ARRAY_TO_STRING(ARRAY_AGG(DISTINCT dc.item_id), ',') AS selected_config,
-    ARRAY_TO_STRING(ARRAY_AGG(DISTINCT p.peripheral_id || ':' || dp.plate_num || ':' || dp.serial_num), ';') AS selected_peripherals
+    ARRAY_TO_STRING(ARRAY_AGG(DISTINCT p.peripheral_id || ':' || dp.plate_num || ':' || dp.serial_num), ';') AS selected_peripherals,
+    COALESCE(MAX(l.due_date)::text, '')::text AS loan_due_date
 FROM
     alicorp_2025_certificates c
 JOIN app_users au ON c.app_user_id = au.user_id
@@ -34,10 +41,17 @@ LEFT JOIN device_software ds ON nd.device_code = ds.device_code
 LEFT JOIN device_configuration dc ON nd.device_code = dc.device_code
 LEFT JOIN device_peripherals dp ON nd.device_code = dp.device_code
 LEFT JOIN peripherals p ON dp.peripheral_id = p.peripheral_id
+LEFT JOIN device_loans l ON c.certificate_id = l.certificate_id
 WHERE c.certificate_id = $1 AND c.app_user_id = $2
 GROUP BY
     c.certificate_id, au.user_id, mu.dni, nd.device_code, nm.serial_num, od.device_code, om.serial_num;
 
+-- name: GetCertificateForUpdate :one
+-- Locks the certificate being edited, so the form can be compared with what it replaces.
+SELECT * FROM alicorp_2025_certificates
+WHERE certificate_id = $1 AND app_user_id = $2
+FOR UPDATE;
+
 -- name: UpdateCertificate :one
 UPDATE alicorp_2025_certificates
 SET
@@ -51,6 +65,8 @@ SET
     printer_ip = $9,
     printer_test = $10,
     comments = $11,
+    ticket_state = $13,
+    ticket_url = $14,
     confirmation_status = 'PENDING', -- Reset status to PENDING
     confirmation_token = uuid_generate_v4(), -- Generate a new token
     updated_at = NOW()
@@ -58,3 +74,13 @@ WHERE
     certificate_id = $1 AND app_user_id = $12
 RETURNING *;
 
+-- name: VoidCertificate :one
+-- Voids a certificate of the technician the machine user has not confirmed. Its links
+-- stop working, as only pending certificates can be answered.
+UPDATE alicorp_2025_certificates
+SET
+    confirmation_status = 'VOIDED',
+    updated_at = NOW()
+WHERE
+    certificate_id = $1 AND app_user_id = $2 AND confirmation_status IN ('PENDING', 'REJECTED')
+RETURNING *;
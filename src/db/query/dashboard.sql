@@ -1,8 +1,22 @@
 -- name: GetDashboardStats :one
+-- When scoped, certificates and machine users are limited to the viewer's app_user_scopes.
 SELECT
-    (SELECT COUNT(*) FROM alicorp_2025_certificates) AS total_certificates,
-    (SELECT COUNT(*) FROM app_users) AS total_app_users,
-    (SELECT COUNT(*) FROM machine_users) AS total_machine_users;
+    (SELECT COUNT(*) FROM alicorp_2025_certificates c
+        JOIN machine_users mu ON c.machine_user_dni = mu.dni
+        WHERE (NOT sqlc.arg(scoped)::boolean OR EXISTS (
+            SELECT 1 FROM app_user_scopes sc
+            WHERE sc.user_id = sqlc.arg(viewer_id)::uuid
+                AND UPPER(sc.society) = UPPER(mu.society)
+                AND (sc.site = '' OR UPPER(sc.site) = UPPER(mu.site))
+        ))) AS total_certificates,
+    (SELECT COUNT(*) FROM app_users WHERE deleted_at IS NULL) AS total_app_users,
+    (SELECT COUNT(*) FROM machine_users mu
+        WHERE (NOT sqlc.arg(scoped)::boolean OR EXISTS (
+            SELECT 1 FROM app_user_scopes sc
+            WHERE sc.user_id = sqlc.arg(viewer_id)::uuid
+                AND UPPER(sc.society) = UPPER(mu.society)
+                AND (sc.site = '' OR UPPER(sc.site) = UPPER(mu.site))
+        ))) AS total_machine_users;
 
 -- name: GetRecentCertificatesByTechnician :many
 SELECT
@@ -0,0 +1,123 @@
+-- name: CloseDeviceCustody :exec
+-- Ends the current custody of a device, if any, before a new one is recorded.
+UPDATE device_custody
+SET ended_at = NOW()
+WHERE device_code = $1 AND ended_at IS NULL;
+
+-- name: CreateDeviceCustody :one
+INSERT INTO device_custody (
+    device_code, event, custodian, machine_user_dni, location, certificate_id, recorded_by, notes
+) VALUES (
+    $1, $2, $3, $4, $5, $6, $7, $8
+)
+RETURNING *;
+
+-- name: DeleteOpenCertificateCustody :many
+-- Undoes the custodies a certificate opened that no later change superseded, so an
+-- edited certificate can record them again. Returns the devices to reopen.
+DELETE FROM device_custody
+WHERE certificate_id = $1 AND ended_at IS NULL
+RETURNING device_code;
+
+-- name: GetDeviceStatus :one
+SELECT status FROM devices
+WHERE device_code = $1;
+
+-- name: ListCertificateCustody :many
+-- The custodies a certificate opened, including those later changes ended.
+SELECT * FROM device_custody
+WHERE certificate_id = $1
+ORDER BY custody_id;
+
+-- name: ReopenLastDeviceCustody :exec
+-- Makes the latest custody of a device current again when it has no open one.
+UPDATE device_custody
+SET ended_at = NULL
+WHERE custody_id = (
+    SELECT dc.custody_id FROM device_custody dc
+    WHERE dc.device_code = $1
+    ORDER BY dc.started_at DESC, dc.custody_id DESC
+    LIMIT 1
+) AND NOT EXISTS (
+    SELECT 1 FROM device_custody cur
+    WHERE cur.device_code = $1 AND cur.ended_at IS NULL
+);
+
+-- name: SearchDevices :many
+-- Devices by code, plate, serial or hostname with their current custodian. When scoped,
+-- only devices held at some point by machine users in the viewer's scopes are listed.
+SELECT
+    d.device_code,
+    d.type,
+    d.hostname,
+    d.status,
+    m.serial_num,
+    m.plate_num,
+    m.model,
+    COALESCE(dc.custodian::text, '')::text AS custodian,
+    COALESCE(mu.name, '')::text AS machine_user_name,
+    COALESCE(dc.location, '')::text AS location
+FROM devices d
+JOIN machines m ON d.machine_serial_num = m.serial_num
+LEFT JOIN device_custody dc ON dc.device_code = d.device_code AND dc.ended_at IS NULL
+LEFT JOIN machine_users mu ON dc.machine_user_dni = mu.dni
+WHERE
+    (sqlc.arg(search)::text = ''
+        OR d.device_code ILIKE '%' || sqlc.arg(search)::text || '%'
+        OR m.plate_num ILIKE '%' || sqlc.arg(search)::text || '%'
+        OR m.serial_num ILIKE '%' || sqlc.arg(search)::text || '%'
+        OR d.hostname ILIKE '%' || sqlc.arg(search)::text || '%')
+    AND (NOT sqlc.arg(scoped)::boolean OR EXISTS (
+        SELECT 1 FROM device_custody h
+        JOIN machine_users hu ON h.machine_user_dni = hu.dni
+        JOIN app_user_scopes sc ON sc.user_id = sqlc.arg(viewer_id)::uuid
+            AND UPPER(sc.society) = UPPER(hu.society)
+            AND (sc.site = '' OR UPPER(sc.site) = UPPER(hu.site))
+        WHERE h.device_code = d.device_code
+    ))
+ORDER BY d.device_code
+LIMIT 200;
+
+-- name: GetDeviceDetails :one
+-- A device with its machine, subject to the same scope as SearchDevices.
+SELECT
+    d.*,
+    m.type AS machine_type,
+    m.mtm,
+    m.model,
+    m.plate_num,
+    m.disk_size,
+    m.memory_size,
+    m.processor,
+    m.profile
+FROM devices d
+JOIN machines m ON d.machine_serial_num = m.serial_num
+WHERE d.device_code = sqlc.arg(device_code)
+    AND (NOT sqlc.arg(scoped)::boolean OR EXISTS (
+        SELECT 1 FROM device_custody h
+        JOIN machine_users hu ON h.machine_user_dni = hu.dni
+        JOIN app_user_scopes sc ON sc.user_id = sqlc.arg(viewer_id)::uuid
+            AND UPPER(sc.society) = UPPER(hu.society)
+            AND (sc.site = '' OR UPPER(sc.site) = UPPER(hu.site))
+        WHERE h.device_code = d.device_code
+    ));
+
+-- name: ListDeviceCustody :many
+-- The custody timeline of a device, the latest first.
+SELECT
+    dc.*,
+    COALESCE(mu.name, '')::text AS machine_user_name,
+    COALESCE(c.ticket_name, '')::text AS ticket_name,
+    COALESCE(c.confirmation_token::text, '')::text AS confirmation_token,
+    COALESCE(au.name, '')::text AS recorded_by_name
+FROM device_custody dc
+LEFT JOIN machine_users mu ON dc.machine_user_dni = mu.dni
+LEFT JOIN alicorp_2025_certificates c ON dc.certificate_id = c.certificate_id
+LEFT JOIN app_users au ON dc.recorded_by = au.user_id
+WHERE dc.device_code = $1
+ORDER BY dc.started_at DESC, dc.custody_id DESC;
+
+-- name: UpdateDeviceStatus :exec
+UPDATE devices
+SET status = $2
+WHERE device_code = $1;
@@ -0,0 +1,95 @@
+-- name: CreateImportJob :one
+INSERT INTO import_jobs (
+    kind, file_name, created_by, status, total_rows, new_rows, updated_rows, unchanged_rows, invalid_rows, rows
+) VALUES (
+    $1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+)
+RETURNING *;
+
+-- name: SetImportJobPlan :one
+-- Replaces the rows of a job waiting for its column mapping with the validated plan.
+UPDATE import_jobs
+SET
+    status = 'PREVIEW',
+    total_rows = $2,
+    new_rows = $3,
+    updated_rows = $4,
+    unchanged_rows = $5,
+    invalid_rows = $6,
+    rows = $7
+WHERE job_id = $1 AND status = 'MAPPING'
+RETURNING *;
+
+-- name: GetImportJob :one
+SELECT
+    sqlc.embed(j),
+    u.name AS created_by_name
+FROM import_jobs j
+JOIN app_users u ON j.created_by = u.user_id
+WHERE j.job_id = $1;
+
+-- name: ListImportJobs :many
+SELECT
+    j.job_id,
+    j.kind,
+    j.file_name,
+    j.status,
+    j.total_rows,
+    j.new_rows,
+    j.updated_rows,
+    j.unchanged_rows,
+    j.invalid_rows,
+    j.created_at,
+    j.finished_at,
+    u.name AS created_by_name
+FROM import_jobs j
+JOIN app_users u ON j.created_by = u.user_id
+ORDER BY j.created_at DESC
+LIMIT 100;
+
+-- name: CommitImportJob :one
+-- Only a job in preview can be committed, and only once.
+UPDATE import_jobs
+SET
+    status = 'COMMITTED',
+    finished_at = NOW()
+WHERE job_id = $1 AND status = 'PREVIEW'
+RETURNING *;
+
+-- name: DiscardImportJob :one
+-- A job waiting for its column mapping can be discarded as well.
+UPDATE import_jobs
+SET
+    status = 'DISCARDED',
+    finished_at = NOW()
+WHERE job_id = $1 AND status IN ('PREVIEW', 'MAPPING')
+RETURNING *;
+
+-- name: ListMachineUsersByDNIs :many
+SELECT * FROM machine_users
+WHERE dni = ANY(sqlc.arg(dnis)::text[]);
+
+-- name: ListMachinesBySerials :many
+SELECT * FROM machines
+WHERE serial_num = ANY(sqlc.arg(serials)::text[]);
+
+-- name: ListImportMappingProfiles :many
+SELECT * FROM import_mapping_profiles
+WHERE kind = $1
+ORDER BY name;
+
+-- name: ListAllImportMappingProfiles :many
+SELECT * FROM import_mapping_profiles
+ORDER BY kind, name;
+
+-- name: SaveImportMappingProfile :one
+INSERT INTO import_mapping_profiles (kind, name, columns, created_by)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (kind, name) DO UPDATE SET
+    columns = EXCLUDED.columns,
+    updated_at = NOW()
+RETURNING *;
+
+-- name: DeleteImportMappingProfile :exec
+DELETE FROM import_mapping_profiles
+WHERE profile_id = $1;
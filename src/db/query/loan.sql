@@ -0,0 +1,108 @@
+-- name: UpsertCertificateLoan :exec
+-- Records the loan of a certificate, replacing it when the certificate is edited unless
+-- its return was already registered.
+INSERT INTO device_loans (
+    certificate_id, device_code, machine_user_dni, technician_id, due_date
+) VALUES (
+    $1, $2, $3, $4, $5
+) ON CONFLICT (certificate_id) DO UPDATE SET
+    device_code = EXCLUDED.device_code,
+    machine_user_dni = EXCLUDED.machine_user_dni,
+    technician_id = EXCLUDED.technician_id,
+    due_date = EXCLUDED.due_date,
+    overdue_notified_at = NULL
+WHERE device_loans.return_status IS NULL;
+
+-- name: GetCertificateLoan :one
+SELECT * FROM device_loans WHERE certificate_id = $1;
+
+-- name: DeleteCertificateLoan :exec
+-- Drops the loan of a certificate edited into another kind of handover, unless its
+-- return was already registered.
+DELETE FROM device_loans
+WHERE certificate_id = $1 AND return_status IS NULL;
+
+-- name: ListOpenLoans :many
+-- Loans not returned yet, the earliest due first. overdue_only keeps those due before
+-- today; when scoped, only machine users in the viewer's scopes are listed.
+SELECT
+    l.*,
+    mu.name AS machine_user_name,
+    mu.society,
+    mu.site,
+    au.name AS technician_name,
+    c.ticket_name
+FROM device_loans l
+JOIN machine_users mu ON l.machine_user_dni = mu.dni
+JOIN app_users au ON l.technician_id = au.user_id
+JOIN alicorp_2025_certificates c ON l.certificate_id = c.certificate_id
+WHERE l.returned_at IS NULL
+    AND (NOT sqlc.arg(overdue_only)::boolean OR l.due_date < sqlc.arg(today)::date)
+    AND (NOT sqlc.arg(scoped)::boolean OR EXISTS (
+        SELECT 1 FROM app_user_scopes sc
+        WHERE sc.user_id = sqlc.arg(viewer_id)::uuid
+            AND UPPER(sc.society) = UPPER(mu.society)
+            AND (sc.site = '' OR UPPER(sc.site) = UPPER(mu.site))
+    ))
+ORDER BY l.due_date, mu.name;
+
+-- name: GetLoanDetails :one
+SELECT
+    l.*,
+    mu.name AS machine_user_name,
+    mu.email AS machine_user_email,
+    mu.site,
+    au.name AS technician_name,
+    au.email AS technician_email,
+    COALESCE(rb.name, '')::text AS received_by_name,
+    m.model,
+    m.serial_num,
+    c.ticket_name
+FROM device_loans l
+JOIN machine_users mu ON l.machine_user_dni = mu.dni
+JOIN app_users au ON l.technician_id = au.user_id
+LEFT JOIN app_users rb ON l.return_received_by = rb.user_id
+JOIN devices d ON l.device_code = d.device_code
+JOIN machines m ON d.machine_serial_num = m.serial_num
+JOIN alicorp_2025_certificates c ON l.certificate_id = c.certificate_id
+WHERE l.loan_id = $1;
+
+-- name: GetLoanIDByReturnToken :one
+SELECT loan_id FROM device_loans
+WHERE return_token = $1;
+
+-- name: RequestLoanReturn :one
+-- Registers the return of a loan, issuing a new token for the machine user's answer. A
+-- pending return registered again invalidates the previous email.
+UPDATE device_loans
+SET
+    return_status = 'PENDING',
+    return_token = uuid_generate_v4(),
+    return_comments = $2,
+    return_received_by = $3,
+    return_requested_at = NOW()
+WHERE loan_id = $1 AND returned_at IS NULL
+RETURNING *;
+
+-- name: SetLoanReturnStatus :one
+-- Records the machine user's answer to a pending return. No row is returned when the
+-- return is no longer pending.
+UPDATE device_loans
+SET
+    return_status = sqlc.arg(return_status)::certificate_status,
+    returned_at = CASE WHEN sqlc.arg(return_status)::certificate_status = 'CONFIRMED' THEN NOW() END
+WHERE return_token = sqlc.arg(return_token) AND return_status = 'PENDING'
+RETURNING *;
+
+-- name: ListOverdueLoansToNotify :many
+-- Overdue loans whose reminder was never sent or was sent over a week ago.
+SELECT loan_id FROM device_loans
+WHERE returned_at IS NULL
+    AND due_date < sqlc.arg(today)::date
+    AND (overdue_notified_at IS NULL OR overdue_notified_at < NOW() - INTERVAL '7 days')
+ORDER BY due_date;
+
+-- name: MarkLoanOverdueNotified :exec
+UPDATE device_loans
+SET overdue_notified_at = NOW()
+WHERE loan_id = $1;
@@ -0,0 +1,112 @@
+-- name: SearchMachines :many
+-- Machines by serial, plate, MTM or model, or by the code or hostname of their device.
+SELECT
+    m.*,
+    COALESCE(d.device_code, '')::text AS device_code,
+    COALESCE(d.hostname, '')::text AS hostname,
+    COALESCE(d.type::text, '')::text AS device_type,
+    (r.machine_serial_num IS NOT NULL)::boolean AS reserved
+FROM machines m
+LEFT JOIN devices d ON d.machine_serial_num = m.serial_num
+LEFT JOIN machine_reservations r ON r.machine_serial_num = m.serial_num
+WHERE sqlc.arg(search)::text = ''
+    OR m.serial_num ILIKE '%' || sqlc.arg(search)::text || '%'
+    OR m.plate_num ILIKE '%' || sqlc.arg(search)::text || '%'
+    OR m.mtm ILIKE '%' || sqlc.arg(search)::text || '%'
+    OR m.model ILIKE '%' || sqlc.arg(search)::text || '%'
+    OR d.device_code ILIKE '%' || sqlc.arg(search)::text || '%'
+    OR d.hostname ILIKE '%' || sqlc.arg(search)::text || '%'
+ORDER BY m.serial_num
+LIMIT 200;
+
+-- name: GetDeviceByMachine :one
+SELECT * FROM devices
+WHERE machine_serial_num = $1;
+
+-- name: GetDevice :one
+SELECT * FROM devices
+WHERE device_code = $1;
+
+-- name: CreateMachine :one
+INSERT INTO machines (
+    serial_num, type, mtm, model, plate_num, disk_size, memory_size, processor, profile, site
+) VALUES (
+    $1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+)
+RETURNING *;
+
+-- name: UpdateMachine :one
+-- Updates a machine, renaming it when serial_num differs from old_serial_num. Its device
+-- and reservation follow the rename through their foreign keys.
+UPDATE machines
+SET
+    serial_num = sqlc.arg(serial_num),
+    type = sqlc.arg(type),
+    mtm = sqlc.arg(mtm),
+    model = sqlc.arg(model),
+    plate_num = sqlc.arg(plate_num),
+    disk_size = sqlc.arg(disk_size),
+    memory_size = sqlc.arg(memory_size),
+    processor = sqlc.arg(processor),
+    profile = sqlc.arg(profile),
+    site = sqlc.arg(site)
+WHERE serial_num = sqlc.arg(old_serial_num)
+RETURNING *;
+
+-- name: CountMachinesWithPlate :one
+-- Other machines already carrying a plate, to keep plates unique.
+SELECT COUNT(*) FROM machines
+WHERE plate_num = sqlc.arg(plate_num) AND serial_num <> sqlc.arg(serial_num);
+
+-- name: SetMachineRetired :exec
+UPDATE machines
+SET retired_at = CASE WHEN sqlc.arg(retired)::boolean THEN COALESCE(retired_at, NOW()) END
+WHERE serial_num = sqlc.arg(serial_num);
+
+-- name: DeleteMachine :execrows
+DELETE FROM machines
+WHERE serial_num = $1;
+
+-- name: ListDeviceCertificates :many
+-- The certificates that hand a device over or recover it.
+SELECT
+    certificate_id,
+    ticket_name,
+    confirmation_status,
+    (new_device_code = sqlc.arg(device_code))::boolean AS as_new_device
+FROM alicorp_2025_certificates
+WHERE new_device_code = sqlc.arg(device_code) OR old_device_code = sqlc.arg(device_code)
+ORDER BY certificate_id;
+
+-- name: CreateDevice :one
+INSERT INTO devices (
+    device_code, machine_serial_num, type, hostname, status, additional_software
+) VALUES (
+    $1, $2, $3, $4, $5, $6
+)
+RETURNING *;
+
+-- name: UpdateDevice :one
+UPDATE devices
+SET
+    type = sqlc.arg(type),
+    hostname = sqlc.arg(hostname),
+    status = sqlc.arg(status),
+    additional_software = sqlc.arg(additional_software)
+WHERE device_code = sqlc.arg(device_code)
+RETURNING *;
+
+-- name: SetDeviceRetired :exec
+UPDATE devices
+SET retired_at = CASE WHEN sqlc.arg(retired)::boolean THEN COALESCE(retired_at, NOW()) END
+WHERE device_code = sqlc.arg(device_code);
+
+-- name: DeleteDevice :execrows
+DELETE FROM devices
+WHERE device_code = $1;
+
+-- name: GetRetiredCertificateAssets :one
+-- Whether the machine or the device handed over in a certificate is retired.
+SELECT
+    EXISTS (SELECT 1 FROM machines m WHERE m.serial_num = sqlc.arg(serial_num) AND m.retired_at IS NOT NULL)::boolean AS machine_retired,
+    EXISTS (SELECT 1 FROM devices d WHERE d.device_code = sqlc.arg(device_code) AND d.retired_at IS NOT NULL)::boolean AS device_retired;
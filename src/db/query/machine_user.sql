@@ -0,0 +1,135 @@
+-- name: SearchMachineUsers :many
+-- Machine users by DNI, name, email or personal code, with their certificate count.
+SELECT
+    mu.*,
+    (SELECT COUNT(*) FROM alicorp_2025_certificates c WHERE c.machine_user_dni = mu.dni)::bigint AS certificate_count
+FROM machine_users mu
+WHERE (sqlc.arg(include_inactive)::boolean OR mu.active)
+    AND (sqlc.arg(search)::text = ''
+        OR mu.dni ILIKE '%' || sqlc.arg(search)::text || '%'
+        OR mu.name ILIKE '%' || sqlc.arg(search)::text || '%'
+        OR mu.email ILIKE '%' || sqlc.arg(search)::text || '%'
+        OR mu.personal_code ILIKE '%' || sqlc.arg(search)::text || '%')
+ORDER BY mu.name, mu.dni
+LIMIT 200;
+
+-- name: UpdateMachineUser :one
+UPDATE machine_users
+SET
+    personal_code = sqlc.arg(personal_code),
+    name = sqlc.arg(name),
+    email = sqlc.arg(email),
+    society = sqlc.arg(society),
+    site = sqlc.arg(site),
+    area = sqlc.arg(area),
+    floor_name = sqlc.arg(floor_name)
+WHERE dni = sqlc.arg(dni)
+RETURNING *;
+
+-- name: SetMachineUserActive :one
+UPDATE machine_users
+SET active = sqlc.arg(active)
+WHERE dni = sqlc.arg(dni)
+RETURNING *;
+
+-- name: CreateMachineUserHistory :exec
+INSERT INTO machine_user_history (
+    machine_user_dni, field, old_value, new_value, source, changed_by
+) VALUES (
+    $1, $2, $3, $4, $5, $6
+);
+
+-- name: ListMachineUserHistory :many
+SELECT
+    h.*,
+    COALESCE(u.name, '')::text AS changed_by_name
+FROM machine_user_history h
+LEFT JOIN app_users u ON u.user_id = h.changed_by
+WHERE h.machine_user_dni = $1
+ORDER BY h.changed_at DESC, h.history_id DESC;
+
+-- name: ListMachineUserCertificates :many
+SELECT
+    c.certificate_id,
+    c.ticket_name,
+    c.confirmation_status,
+    c.confirmation_token,
+    c.new_device_code,
+    c.old_device_code,
+    c.created_at,
+    u.name AS technician_name
+FROM alicorp_2025_certificates c
+JOIN app_users u ON u.user_id = c.app_user_id
+WHERE c.machine_user_dni = $1
+ORDER BY c.created_at DESC;
+
+-- name: ListMachineUserDevices :many
+-- Every device the machine user has held, the open custody being a device they hold now.
+SELECT
+    dc.custody_id,
+    dc.device_code,
+    dc.event,
+    dc.location,
+    dc.started_at,
+    dc.ended_at,
+    COALESCE(m.model, '')::text AS model,
+    COALESCE(m.serial_num, '')::text AS serial_num
+FROM device_custody dc
+LEFT JOIN devices d ON d.device_code = dc.device_code
+LEFT JOIN machines m ON m.serial_num = d.machine_serial_num
+WHERE dc.machine_user_dni = sqlc.arg(dni)::text AND dc.custodian = 'MACHINE_USER'
+ORDER BY dc.started_at DESC;
+
+-- Merging a duplicate machine user moves everything of it to the record kept. A wave
+-- membership or reservation of the duplicate is dropped when the kept record has its own.
+
+-- name: MergeMachineUserCertificates :exec
+UPDATE alicorp_2025_certificates SET machine_user_dni = sqlc.arg(into_dni)
+WHERE machine_user_dni = sqlc.arg(from_dni);
+
+-- name: DropMergedMachineUserWaveMembership :exec
+DELETE FROM rollout_wave_members d
+WHERE d.machine_user_dni = sqlc.arg(from_dni)
+    AND EXISTS (SELECT 1 FROM rollout_wave_members k WHERE k.machine_user_dni = sqlc.arg(into_dni));
+
+-- name: MergeMachineUserWaveMembership :exec
+UPDATE rollout_wave_members SET machine_user_dni = sqlc.arg(into_dni)
+WHERE machine_user_dni = sqlc.arg(from_dni);
+
+-- name: DropMergedMachineUserReservation :exec
+DELETE FROM machine_reservations d
+WHERE d.machine_user_dni = sqlc.arg(from_dni)
+    AND EXISTS (SELECT 1 FROM machine_reservations k WHERE k.machine_user_dni = sqlc.arg(into_dni));
+
+-- name: MergeMachineUserReservation :exec
+UPDATE machine_reservations SET machine_user_dni = sqlc.arg(into_dni)
+WHERE machine_user_dni = sqlc.arg(from_dni);
+
+-- name: MergeMachineUserAppointments :exec
+UPDATE appointments SET machine_user_dni = sqlc.arg(into_dni)
+WHERE machine_user_dni = sqlc.arg(from_dni);
+
+-- name: MergeMachineUserCustody :exec
+UPDATE device_custody SET machine_user_dni = sqlc.arg(into_dni)::text
+WHERE machine_user_dni = sqlc.arg(from_dni)::text;
+
+-- name: MergeMachineUserLoans :exec
+UPDATE device_loans SET machine_user_dni = sqlc.arg(into_dni)
+WHERE machine_user_dni = sqlc.arg(from_dni);
+
+-- name: MergeMachineUserRecoveries :exec
+UPDATE device_recoveries SET machine_user_dni = sqlc.arg(into_dni)::text
+WHERE machine_user_dni = sqlc.arg(from_dni)::text;
+
+-- name: MergeMachineUserHistory :exec
+UPDATE machine_user_history SET machine_user_dni = sqlc.arg(into_dni)
+WHERE machine_user_dni = sqlc.arg(from_dni);
+
+-- name: DiscardMachineUserPendingChanges :exec
+-- Drops the pending changes of a merged duplicate, which are about a record that is gone.
+DELETE FROM master_data_changes
+WHERE entity = 'MACHINE_USER' AND entity_key = sqlc.arg(dni) AND status = 'PENDING';
+
+-- name: DeleteMachineUser :exec
+DELETE FROM machine_users
+WHERE dni = $1;
@@ -0,0 +1,50 @@
+-- name: ListMTMCatalog :many
+SELECT * FROM mtm_catalog
+ORDER BY mtm;
+
+-- name: UpsertMTMCatalogEntry :one
+INSERT INTO mtm_catalog (
+    mtm, type, model, processor, memory_size, disk_size, profile
+) VALUES (
+    $1, $2, $3, $4, $5, $6, $7
+) ON CONFLICT (mtm) DO UPDATE SET
+    type = EXCLUDED.type,
+    model = EXCLUDED.model,
+    processor = EXCLUDED.processor,
+    memory_size = EXCLUDED.memory_size,
+    disk_size = EXCLUDED.disk_size,
+    profile = EXCLUDED.profile,
+    updated_at = NOW()
+RETURNING *;
+
+-- name: DeleteMTMCatalogEntry :exec
+DELETE FROM mtm_catalog
+WHERE mtm = $1;
+
+-- name: ReceiveMachine :one
+-- Registers a machine of a shipment manifest. A machine that already exists keeps its
+-- plate and profile, which are set when it is assigned, and its site when the manifest
+-- has none; inserted tells new machines apart.
+INSERT INTO machines (
+    serial_num, type, mtm, model, plate_num, disk_size, memory_size, processor, profile,
+    pallet, purchase_order, site, received_at
+) VALUES (
+    $1, $2, $3, $4, '', $5, $6, $7, $8, $9, $10, $11, NOW()
+) ON CONFLICT (serial_num) DO UPDATE SET
+    type = EXCLUDED.type,
+    mtm = EXCLUDED.mtm,
+    model = EXCLUDED.model,
+    disk_size = EXCLUDED.disk_size,
+    memory_size = EXCLUDED.memory_size,
+    processor = EXCLUDED.processor,
+    pallet = EXCLUDED.pallet,
+    purchase_order = EXCLUDED.purchase_order,
+    site = COALESCE(NULLIF(EXCLUDED.site, ''), machines.site),
+    received_at = COALESCE(machines.received_at, EXCLUDED.received_at)
+RETURNING (xmax = 0)::boolean AS inserted;
+
+-- name: CountUnassignedReceivedMachines :one
+SELECT COUNT(*) FROM machines m
+WHERE m.received_at IS NOT NULL
+    AND m.retired_at IS NULL
+    AND NOT EXISTS (SELECT 1 FROM devices d WHERE d.machine_serial_num = m.serial_num);
@@ -0,0 +1,108 @@
+-- name: ProposeMasterDataChange :exec
+-- Records a proposed change, replacing the pending one of the same field.
+INSERT INTO master_data_changes (
+    entity, entity_key, field, current_value, proposed_value, certificate_id, proposed_by
+) VALUES (
+    $1, $2, $3, $4, $5, $6, $7
+)
+ON CONFLICT (entity, entity_key, field) WHERE status = 'PENDING' DO UPDATE SET
+    current_value = EXCLUDED.current_value,
+    proposed_value = EXCLUDED.proposed_value,
+    certificate_id = EXCLUDED.certificate_id,
+    proposed_by = EXCLUDED.proposed_by,
+    proposed_at = NOW();
+
+-- name: WithdrawMasterDataChange :exec
+-- Drops the pending change of a field once a later certificate agrees with the record.
+DELETE FROM master_data_changes
+WHERE entity = $1 AND entity_key = $2 AND field = $3 AND status = 'PENDING';
+
+-- name: ListPendingMasterDataChanges :many
+SELECT
+    ch.*,
+    COALESCE(c.ticket_name, '')::text AS ticket_name,
+    COALESCE(u.name, '')::text AS proposed_by_name
+FROM master_data_changes ch
+LEFT JOIN alicorp_2025_certificates c ON c.certificate_id = ch.certificate_id
+LEFT JOIN app_users u ON u.user_id = ch.proposed_by
+WHERE ch.status = 'PENDING'
+ORDER BY ch.entity, ch.entity_key, ch.field;
+
+-- name: ListReviewedMasterDataChanges :many
+SELECT
+    ch.*,
+    COALESCE(c.ticket_name, '')::text AS ticket_name,
+    COALESCE(u.name, '')::text AS reviewed_by_name
+FROM master_data_changes ch
+LEFT JOIN alicorp_2025_certificates c ON c.certificate_id = ch.certificate_id
+LEFT JOIN app_users u ON u.user_id = ch.reviewed_by
+WHERE ch.status <> 'PENDING'
+ORDER BY ch.reviewed_at DESC
+LIMIT 50;
+
+-- name: CountPendingMasterDataChanges :one
+SELECT COUNT(*) FROM master_data_changes
+WHERE status = 'PENDING';
+
+-- name: ReviewMasterDataChange :one
+-- Approves or rejects a pending change.
+UPDATE master_data_changes
+SET status = sqlc.arg(status), reviewed_by = sqlc.arg(reviewed_by), reviewed_at = NOW()
+WHERE change_id = sqlc.arg(change_id) AND status = 'PENDING'
+RETURNING *;
+
+-- name: CountMachineUsersWithPersonalCode :one
+-- Other machine users already having a personal code, which is unique.
+SELECT COUNT(*) FROM machine_users
+WHERE personal_code = sqlc.arg(personal_code) AND dni <> sqlc.arg(dni);
+
+-- name: CountMachineUsersWithEmail :one
+-- Other machine users already having an email, which is unique.
+SELECT COUNT(*) FROM machine_users
+WHERE email = sqlc.arg(email) AND dni <> sqlc.arg(dni);
+
+-- name: ApplyMachineUserChange :execrows
+UPDATE machine_users
+SET
+    personal_code = CASE WHEN sqlc.arg(field)::text = 'personal_code' THEN sqlc.arg(value)::text ELSE personal_code END,
+    name = CASE WHEN sqlc.arg(field)::text = 'name' THEN sqlc.arg(value)::text ELSE name END,
+    email = CASE WHEN sqlc.arg(field)::text = 'email' THEN sqlc.arg(value)::text ELSE email END,
+    society = CASE WHEN sqlc.arg(field)::text = 'society' THEN sqlc.arg(value)::text ELSE society END,
+    site = CASE WHEN sqlc.arg(field)::text = 'site' THEN sqlc.arg(value)::text ELSE site END,
+    area = CASE WHEN sqlc.arg(field)::text = 'area' THEN sqlc.arg(value)::text ELSE area END,
+    floor_name = CASE WHEN sqlc.arg(field)::text = 'floor_name' THEN sqlc.arg(value)::text ELSE floor_name END
+WHERE dni = sqlc.arg(entity_key);
+
+-- name: ApplyMachineChange :execrows
+UPDATE machines
+SET
+    type = CASE WHEN sqlc.arg(field)::text = 'type' THEN sqlc.arg(value)::text::machine_type ELSE type END,
+    model = CASE WHEN sqlc.arg(field)::text = 'model' THEN sqlc.arg(value)::text ELSE model END,
+    plate_num = CASE WHEN sqlc.arg(field)::text = 'plate_num' THEN sqlc.arg(value)::text ELSE plate_num END,
+    disk_size = CASE WHEN sqlc.arg(field)::text = 'disk_size' THEN sqlc.arg(value)::text ELSE disk_size END,
+    memory_size = CASE WHEN sqlc.arg(field)::text = 'memory_size' THEN sqlc.arg(value)::text ELSE memory_size END,
+    profile = CASE WHEN sqlc.arg(field)::text = 'profile' THEN sqlc.arg(value)::text::machine_profile ELSE profile END
+WHERE serial_num = sqlc.arg(entity_key);
+
+-- name: FillMachineUserBlanks :one
+-- Fills in the empty fields of a machine user, which overwrites nothing and needs no review.
+UPDATE machine_users
+SET
+    name = CASE WHEN name = '' THEN sqlc.arg(name)::text ELSE name END,
+    society = CASE WHEN society = '' THEN sqlc.arg(society)::text ELSE society END,
+    site = CASE WHEN site = '' THEN sqlc.arg(site)::text ELSE site END,
+    area = CASE WHEN area = '' THEN sqlc.arg(area)::text ELSE area END,
+    floor_name = CASE WHEN floor_name = '' THEN sqlc.arg(floor_name)::text ELSE floor_name END
+WHERE dni = sqlc.arg(dni)
+RETURNING *;
+
+-- name: FillMachineBlanks :one
+-- Fills in the empty fields of a machine, which overwrites nothing and needs no review.
+UPDATE machines
+SET
+    model = CASE WHEN model = '' THEN sqlc.arg(model)::text ELSE model END,
+    plate_num = CASE WHEN plate_num = '' THEN sqlc.arg(plate_num)::text ELSE plate_num END,
+    disk_size = CASE WHEN disk_size = '' THEN sqlc.arg(disk_size)::text ELSE disk_size END,
+    memory_size = CASE WHEN memory_size = '' THEN sqlc.arg(memory_size)::text ELSE memory_size END
+WHERE serial_num = sqlc.arg(serial_num)
+RETURNING *;
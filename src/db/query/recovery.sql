@@ -0,0 +1,127 @@
+-- name: ListDevicesAwaitingIntake :many
+-- Devices recovered or returned to the warehouse whose intake was not registered yet.
+SELECT
+    dc.device_code,
+    dc.event,
+    dc.location,
+    dc.started_at,
+    d.hostname,
+    m.serial_num,
+    m.plate_num,
+    m.model,
+    COALESCE(mu.name, '')::text AS machine_user_name,
+    COALESCE(c.ticket_name, '')::text AS ticket_name
+FROM device_custody dc
+JOIN devices d ON dc.device_code = d.device_code
+JOIN machines m ON d.machine_serial_num = m.serial_num
+LEFT JOIN machine_users mu ON dc.machine_user_dni = mu.dni
+LEFT JOIN alicorp_2025_certificates c ON dc.certificate_id = c.certificate_id
+WHERE dc.ended_at IS NULL
+    AND dc.custodian = 'WAREHOUSE'
+    AND dc.event IN ('RECUPERACION', 'DEVOLUCION')
+    AND NOT EXISTS (
+        SELECT 1 FROM device_recoveries r
+        WHERE r.device_code = dc.device_code AND r.received_at >= dc.started_at
+    )
+ORDER BY dc.started_at;
+
+-- name: ListOpenRecoveries :many
+-- Devices received in the warehouse and not disposed of yet, the oldest first.
+SELECT
+    r.*,
+    d.hostname,
+    m.serial_num,
+    m.plate_num,
+    m.model,
+    COALESCE(mu.name, '')::text AS machine_user_name
+FROM device_recoveries r
+JOIN devices d ON r.device_code = d.device_code
+JOIN machines m ON d.machine_serial_num = m.serial_num
+LEFT JOIN machine_users mu ON r.machine_user_dni = mu.dni
+WHERE r.disposition IS NULL
+ORDER BY r.received_at;
+
+-- name: CreateDeviceRecovery :one
+-- Registers the intake of a device currently held by the warehouse. An empty location
+-- keeps the one of its custody.
+INSERT INTO device_recoveries (
+    device_code, certificate_id, machine_user_dni, location, intake_notes, received_by
+)
+SELECT
+    dc.device_code,
+    dc.certificate_id,
+    dc.machine_user_dni,
+    COALESCE(NULLIF(sqlc.arg(location)::text, ''), dc.location),
+    sqlc.arg(intake_notes),
+    sqlc.arg(received_by)
+FROM device_custody dc
+WHERE dc.device_code = sqlc.arg(device_code) AND dc.ended_at IS NULL AND dc.custodian = 'WAREHOUSE'
+RETURNING *;
+
+-- name: RecordRecoveryWipe :one
+UPDATE device_recoveries
+SET
+    wipe_method = sqlc.arg(wipe_method),
+    wipe_tool = sqlc.arg(wipe_tool),
+    wipe_operator = sqlc.arg(wipe_operator),
+    wipe_notes = sqlc.arg(wipe_notes),
+    wiped_by = sqlc.arg(wiped_by),
+    wiped_at = NOW()
+WHERE recovery_id = sqlc.arg(recovery_id) AND wiped_at IS NULL AND disposition IS NULL
+RETURNING *;
+
+-- name: RecordRecoveryDisposition :one
+UPDATE device_recoveries
+SET
+    disposition = sqlc.arg(disposition),
+    disposition_reference = sqlc.arg(disposition_reference),
+    disposition_notes = sqlc.arg(disposition_notes),
+    disposed_by = sqlc.arg(disposed_by),
+    disposed_at = NOW()
+WHERE recovery_id = sqlc.arg(recovery_id) AND wiped_at IS NOT NULL AND disposition IS NULL
+RETURNING *;
+
+-- name: GetRecoveryDetails :one
+-- A recovery with everything its certificates print.
+SELECT
+    r.*,
+    d.type AS device_type,
+    d.hostname,
+    m.serial_num,
+    m.type AS machine_type,
+    m.mtm,
+    m.model,
+    m.plate_num,
+    m.disk_size,
+    m.memory_size,
+    m.processor,
+    COALESCE(mu.name, '')::text AS machine_user_name,
+    COALESCE(c.ticket_name, '')::text AS ticket_name,
+    COALESCE(rb.name, '')::text AS received_by_name,
+    COALESCE(wb.name, '')::text AS wiped_by_name,
+    COALESCE(db.name, '')::text AS disposed_by_name
+FROM device_recoveries r
+JOIN devices d ON r.device_code = d.device_code
+JOIN machines m ON d.machine_serial_num = m.serial_num
+LEFT JOIN machine_users mu ON r.machine_user_dni = mu.dni
+LEFT JOIN alicorp_2025_certificates c ON r.certificate_id = c.certificate_id
+LEFT JOIN app_users rb ON r.received_by = rb.user_id
+LEFT JOIN app_users wb ON r.wiped_by = wb.user_id
+LEFT JOIN app_users db ON r.disposed_by = db.user_id
+WHERE r.recovery_id = $1;
+
+-- name: ListDeviceRecoveries :many
+-- The times a device went through the warehouse, the latest first.
+SELECT * FROM device_recoveries
+WHERE device_code = $1
+ORDER BY received_at DESC;
+
+-- name: CountRecoveriesByStage :one
+-- The devices in each step of the pipeline, and those disposed of in the last 30 days.
+SELECT
+    COUNT(*) FILTER (WHERE disposition IS NULL AND wiped_at IS NULL) AS awaiting_wipe,
+    COUNT(*) FILTER (WHERE disposition IS NULL AND wiped_at IS NOT NULL) AS awaiting_disposition,
+    COUNT(*) FILTER (WHERE disposition = 'REDESPLIEGUE' AND disposed_at > NOW() - INTERVAL '30 days') AS redeployed,
+    COUNT(*) FILTER (WHERE disposition = 'VENTA' AND disposed_at > NOW() - INTERVAL '30 days') AS sold,
+    COUNT(*) FILTER (WHERE disposition = 'BAJA' AND disposed_at > NOW() - INTERVAL '30 days') AS scrapped
+FROM device_recoveries;
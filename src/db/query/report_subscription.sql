@@ -0,0 +1,65 @@
+-- name: CreateReportSubscription :one
+INSERT INTO report_subscriptions (
+    name, recipients, frequency, send_hour, weekday, month_day, format, period_days,
+    status, technician_id, society, site, columns, next_run_at, created_by
+) VALUES (
+    $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15
+)
+RETURNING *;
+
+-- name: ListReportSubscriptions :many
+SELECT
+    rs.*,
+    COALESCE(lr.status::text, '')::text AS last_run_status,
+    lr.started_at AS last_run_at
+FROM report_subscriptions rs
+LEFT JOIN LATERAL (
+    SELECT r.status, r.started_at FROM report_runs r
+    WHERE r.subscription_id = rs.subscription_id
+    ORDER BY r.started_at DESC
+    LIMIT 1
+) lr ON true
+ORDER BY rs.name;
+
+-- name: GetReportSubscription :one
+SELECT * FROM report_subscriptions
+WHERE subscription_id = $1;
+
+-- name: SetReportSubscriptionActive :exec
+UPDATE report_subscriptions
+SET
+    is_active = $2,
+    next_run_at = $3
+WHERE subscription_id = $1;
+
+-- name: DeleteReportSubscription :exec
+DELETE FROM report_subscriptions
+WHERE subscription_id = $1;
+
+-- name: ListDueReportSubscriptions :many
+SELECT * FROM report_subscriptions
+WHERE is_active AND next_run_at <= NOW()
+ORDER BY next_run_at;
+
+-- name: ClaimReportSubscriptionRun :execrows
+-- Moves a due subscription to its next run. Only the app instance whose update matches the
+-- previous next_run_at sends the report.
+UPDATE report_subscriptions
+SET next_run_at = sqlc.arg(next_run_at)
+WHERE subscription_id = sqlc.arg(subscription_id)
+    AND is_active
+    AND next_run_at = sqlc.arg(due_at);
+
+-- name: CreateReportRun :one
+INSERT INTO report_runs (
+    subscription_id, status, file_name, size_bytes, error, started_at
+) VALUES (
+    $1, $2, $3, $4, $5, $6
+)
+RETURNING *;
+
+-- name: ListReportRuns :many
+SELECT * FROM report_runs
+WHERE subscription_id = $1
+ORDER BY started_at DESC
+LIMIT 50;
@@ -0,0 +1,151 @@
+-- The progress of a planned machine user follows their certificates: DONE once one is
+-- confirmed, PENDING while one awaits confirmation, REJECTED when the only ones were
+-- rejected, and PLANNED before any.
+
+-- name: CreateRolloutWave :one
+INSERT INTO rollout_waves (
+    name, description, target_date, created_by
+) VALUES (
+    $1, $2, $3, $4
+)
+RETURNING *;
+
+-- name: GetRolloutWave :one
+SELECT * FROM rollout_waves
+WHERE wave_id = $1;
+
+-- name: UpdateRolloutWave :exec
+UPDATE rollout_waves
+SET name = $2, description = $3, target_date = $4
+WHERE wave_id = $1;
+
+-- name: DeleteRolloutWave :exec
+DELETE FROM rollout_waves
+WHERE wave_id = $1;
+
+-- name: ListRolloutWaveProgress :many
+-- Every wave with its planned machine users by progress. When scoped, only the machine
+-- users in the viewer's app_user_scopes are counted and waves without any are left out.
+SELECT
+    w.*,
+    COUNT(m.machine_user_dni) AS planned,
+    COUNT(m.machine_user_dni) FILTER (WHERE EXISTS (
+        SELECT 1 FROM alicorp_2025_certificates c
+        WHERE c.machine_user_dni = m.machine_user_dni AND c.confirmation_status = 'CONFIRMED'
+    )) AS done,
+    COUNT(m.machine_user_dni) FILTER (WHERE NOT EXISTS (
+        SELECT 1 FROM alicorp_2025_certificates c
+        WHERE c.machine_user_dni = m.machine_user_dni AND c.confirmation_status = 'CONFIRMED'
+    ) AND EXISTS (
+        SELECT 1 FROM alicorp_2025_certificates c
+        WHERE c.machine_user_dni = m.machine_user_dni AND c.confirmation_status = 'PENDING'
+    )) AS pending,
+    COUNT(m.machine_user_dni) FILTER (WHERE NOT EXISTS (
+        SELECT 1 FROM alicorp_2025_certificates c
+        WHERE c.machine_user_dni = m.machine_user_dni AND c.confirmation_status IN ('CONFIRMED', 'PENDING')
+    ) AND EXISTS (
+        SELECT 1 FROM alicorp_2025_certificates c
+        WHERE c.machine_user_dni = m.machine_user_dni AND c.confirmation_status = 'REJECTED'
+    )) AS rejected
+FROM rollout_waves w
+LEFT JOIN (
+    SELECT wm.wave_id, wm.machine_user_dni
+    FROM rollout_wave_members wm
+    JOIN machine_users mu ON wm.machine_user_dni = mu.dni
+    WHERE (NOT sqlc.arg(scoped)::boolean OR EXISTS (
+        SELECT 1 FROM app_user_scopes sc
+        WHERE sc.user_id = sqlc.arg(viewer_id)::uuid
+            AND UPPER(sc.society) = UPPER(mu.society)
+            AND (sc.site = '' OR UPPER(sc.site) = UPPER(mu.site))
+    ))
+) m ON m.wave_id = w.wave_id
+GROUP BY w.wave_id
+HAVING NOT sqlc.arg(scoped)::boolean OR COUNT(m.machine_user_dni) > 0
+ORDER BY w.target_date, w.name;
+
+-- name: AddRolloutWaveMembersByLocation :execrows
+-- Plans the machine users of a society, optionally narrowed to a site, area and floor ('' for
+-- any). Machine users already planned in a wave, or inactive, are skipped.
+INSERT INTO rollout_wave_members (wave_id, machine_user_dni, technician_id)
+SELECT sqlc.arg(wave_id)::int, mu.dni, sqlc.narg(technician_id)::uuid
+FROM machine_users mu
+WHERE mu.active
+    AND UPPER(mu.society) = UPPER(sqlc.arg(society)::text)
+    AND (sqlc.arg(site)::text = '' OR UPPER(mu.site) = UPPER(sqlc.arg(site)::text))
+    AND (sqlc.arg(area)::text = '' OR UPPER(mu.area) = UPPER(sqlc.arg(area)::text))
+    AND (sqlc.arg(floor_name)::text = '' OR UPPER(mu.floor_name) = UPPER(sqlc.arg(floor_name)::text))
+ON CONFLICT DO NOTHING;
+
+-- name: AddRolloutWaveMember :execrows
+-- Plans a machine user given by DNI or personal code. No row is added when the machine
+-- user does not exist, is inactive or is already planned in a wave.
+INSERT INTO rollout_wave_members (wave_id, machine_user_dni, old_device_code, technician_id)
+SELECT sqlc.arg(wave_id)::int, mu.dni, sqlc.arg(old_device_code)::text, sqlc.narg(technician_id)::uuid
+FROM machine_users mu
+WHERE mu.active AND (mu.dni = sqlc.arg(code)::text OR UPPER(mu.personal_code) = UPPER(sqlc.arg(code)::text))
+LIMIT 1
+ON CONFLICT DO NOTHING;
+
+-- name: UpdateRolloutWaveMember :exec
+UPDATE rollout_wave_members
+SET old_device_code = $3, technician_id = $4
+WHERE wave_id = $1 AND machine_user_dni = $2;
+
+-- name: RemoveRolloutWaveMember :exec
+DELETE FROM rollout_wave_members
+WHERE wave_id = $1 AND machine_user_dni = $2;
+
+-- name: ListRolloutWaveMembers :many
+SELECT
+    m.*,
+    mu.name AS machine_user_name,
+    mu.personal_code,
+    mu.society,
+    mu.site,
+    mu.area,
+    mu.floor_name,
+    au.name AS technician_name,
+    (CASE
+        WHEN EXISTS (SELECT 1 FROM alicorp_2025_certificates c
+            WHERE c.machine_user_dni = m.machine_user_dni AND c.confirmation_status = 'CONFIRMED') THEN 'DONE'
+        WHEN EXISTS (SELECT 1 FROM alicorp_2025_certificates c
+            WHERE c.machine_user_dni = m.machine_user_dni AND c.confirmation_status = 'PENDING') THEN 'PENDING'
+        WHEN EXISTS (SELECT 1 FROM alicorp_2025_certificates c
+            WHERE c.machine_user_dni = m.machine_user_dni AND c.confirmation_status = 'REJECTED') THEN 'REJECTED'
+        ELSE 'PLANNED'
+    END)::text AS progress
+FROM rollout_wave_members m
+JOIN machine_users mu ON m.machine_user_dni = mu.dni
+LEFT JOIN app_users au ON m.technician_id = au.user_id
+WHERE m.wave_id = $1
+ORDER BY mu.site, mu.floor_name, mu.area, mu.name;
+
+-- name: ListTechnicianWorklist :many
+-- The planned machine users assigned to a technician that are not done yet, the most
+-- urgent first.
+SELECT
+    m.machine_user_dni,
+    m.old_device_code,
+    w.name AS wave_name,
+    w.target_date,
+    mu.name AS machine_user_name,
+    mu.society,
+    mu.site,
+    mu.area,
+    mu.floor_name,
+    (CASE
+        WHEN EXISTS (SELECT 1 FROM alicorp_2025_certificates c
+            WHERE c.machine_user_dni = m.machine_user_dni AND c.confirmation_status = 'PENDING') THEN 'PENDING'
+        WHEN EXISTS (SELECT 1 FROM alicorp_2025_certificates c
+            WHERE c.machine_user_dni = m.machine_user_dni AND c.confirmation_status = 'REJECTED') THEN 'REJECTED'
+        ELSE 'PLANNED'
+    END)::text AS progress
+FROM rollout_wave_members m
+JOIN rollout_waves w ON m.wave_id = w.wave_id
+JOIN machine_users mu ON m.machine_user_dni = mu.dni
+WHERE m.technician_id = $1
+    AND NOT EXISTS (
+        SELECT 1 FROM alicorp_2025_certificates c
+        WHERE c.machine_user_dni = m.machine_user_dni AND c.confirmation_status = 'CONFIRMED'
+    )
+ORDER BY w.target_date, mu.site, mu.floor_name, mu.area, mu.name;
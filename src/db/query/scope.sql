@@ -0,0 +1,20 @@
+-- name: ListAppUserScopes :many
+SELECT * FROM app_user_scopes
+WHERE user_id = $1
+ORDER BY society, site;
+
+-- name: AddAppUserScope :one
+INSERT INTO app_user_scopes (
+    user_id, society, site
+) VALUES (
+    $1, $2, $3
+)
+RETURNING *;
+
+-- name: DeleteAppUserScope :exec
+DELETE FROM app_user_scopes
+WHERE scope_id = $1 AND user_id = $2;
+
+-- name: ListSocietySites :many
+SELECT DISTINCT society, site FROM machine_users
+ORDER BY society, site;
@@ -0,0 +1,93 @@
+-- name: SummarizeMachineStock :many
+-- Machines per site, profile and model: available ones have no device and no
+-- reservation, reserved ones only a reservation, assigned ones a NEW device. Machines
+-- known only as old devices, and retired ones, are not stock.
+SELECT
+    m.site,
+    m.profile,
+    m.model,
+    COUNT(*) FILTER (WHERE d.device_code IS NULL AND r.machine_serial_num IS NULL) AS available,
+    COUNT(*) FILTER (WHERE d.device_code IS NULL AND r.machine_serial_num IS NOT NULL) AS reserved,
+    COUNT(*) FILTER (WHERE d.type = 'NEW') AS assigned
+FROM machines m
+LEFT JOIN devices d ON d.machine_serial_num = m.serial_num
+LEFT JOIN machine_reservations r ON r.machine_serial_num = m.serial_num
+WHERE (d.device_code IS NULL OR d.type = 'NEW') AND m.retired_at IS NULL
+GROUP BY m.site, m.profile, m.model
+ORDER BY m.site, m.profile, m.model;
+
+-- name: ListStockMachines :many
+-- The machines without a device and not retired, available or reserved, optionally of a
+-- site and a profile.
+SELECT
+    m.serial_num,
+    m.type,
+    m.mtm,
+    m.model,
+    m.profile,
+    m.site,
+    m.pallet,
+    m.received_at,
+    COALESCE(r.machine_user_dni, '')::text AS reserved_for_dni,
+    COALESCE(mu.name, '')::text AS reserved_for_name,
+    COALESCE(au.name, '')::text AS reserved_by_name,
+    COALESCE(r.notes, '')::text AS reservation_notes,
+    r.created_at AS reserved_at
+FROM machines m
+LEFT JOIN machine_reservations r ON r.machine_serial_num = m.serial_num
+LEFT JOIN machine_users mu ON r.machine_user_dni = mu.dni
+LEFT JOIN app_users au ON r.reserved_by = au.user_id
+WHERE NOT EXISTS (SELECT 1 FROM devices d WHERE d.machine_serial_num = m.serial_num)
+    AND m.retired_at IS NULL
+    AND (sqlc.arg(site)::text = '' OR m.site = sqlc.arg(site)::text)
+    AND (sqlc.narg(profile)::machine_profile IS NULL OR m.profile = sqlc.narg(profile)::machine_profile)
+ORDER BY r.created_at NULLS FIRST, m.received_at, m.serial_num
+LIMIT 500;
+
+-- name: ListStockAlertLevels :many
+SELECT * FROM stock_alert_levels
+ORDER BY profile;
+
+-- name: UpsertStockAlertLevel :exec
+INSERT INTO stock_alert_levels (profile, min_available)
+VALUES ($1, $2)
+ON CONFLICT (profile) DO UPDATE SET
+    min_available = EXCLUDED.min_available,
+    updated_at = NOW();
+
+-- name: DeleteStockAlertLevel :exec
+DELETE FROM stock_alert_levels
+WHERE profile = $1;
+
+-- name: ReserveMachine :one
+-- Sets a machine in stock aside for a machine user.
+INSERT INTO machine_reservations (machine_serial_num, machine_user_dni, reserved_by, notes)
+SELECT m.serial_num, sqlc.arg(machine_user_dni), sqlc.arg(reserved_by), sqlc.arg(notes)
+FROM machines m
+WHERE m.serial_num = sqlc.arg(machine_serial_num)
+    AND m.retired_at IS NULL
+    AND NOT EXISTS (SELECT 1 FROM devices d WHERE d.machine_serial_num = m.serial_num)
+RETURNING *;
+
+-- name: ReleaseMachineReservation :execrows
+DELETE FROM machine_reservations
+WHERE machine_serial_num = $1;
+
+-- name: ClaimMachineReservation :one
+-- Removes the reservation of a machine being handed over, returning whom it was for.
+DELETE FROM machine_reservations
+WHERE machine_serial_num = $1
+RETURNING machine_user_dni;
+
+-- name: GetMachineReservationByUser :one
+SELECT r.*, m.model, m.profile
+FROM machine_reservations r
+JOIN machines m ON r.machine_serial_num = m.serial_num
+WHERE r.machine_user_dni = $1;
+
+-- name: ListMachineSites :many
+-- The sites to stock machines at: those of the machine users and of the machines.
+SELECT site FROM machine_users WHERE site <> ''
+UNION
+SELECT site FROM machines WHERE site <> ''
+ORDER BY site;
@@ -1,6 +1,12 @@
 -- name: GetAppUserByEmail :one
 SELECT * FROM app_users
-WHERE email = $1 LIMIT 1;
+WHERE email = $1 AND deleted_at IS NULL LIMIT 1;
+
+-- name: IsAppUserEmailDeleted :one
+SELECT EXISTS (
+    SELECT 1 FROM app_users
+    WHERE email = $1 AND deleted_at IS NOT NULL
+);
 
 -- name: CreateAppSession :one
 INSERT INTO app_sessions (
@@ -13,7 +19,8 @@ RETURNING *;
 -- name: GetAppUserBySessionID :one
 SELECT u.* FROM app_users u
 JOIN app_sessions s ON u.user_id = s.user_id
-WHERE s.session_id = $1 AND s.expires_at > NOW();
+WHERE s.session_id = $1 AND s.expires_at > NOW()
+    AND u.is_active AND u.deleted_at IS NULL;
 
 -- name: CreateAppUser :one
 INSERT INTO app_users (
@@ -27,7 +34,98 @@ INSERT INTO app_users (
 )
 RETURNING *;
 
+-- name: CreateExternalAppUser :one
+INSERT INTO app_users (
+    name,
+    email,
+    hashed_password,
+    role,
+    dni,
+    external_auth
+) VALUES (
+    $1, $2, $3, $4, $5, true
+)
+RETURNING *;
+
 -- name: ListAppUsers :many
 SELECT * FROM app_users
+WHERE deleted_at IS NULL
 ORDER BY created_at DESC;
 
+
+-- name: GetAppUserByID :one
+SELECT * FROM app_users
+WHERE user_id = $1 LIMIT 1;
+
+-- name: UpdateAppUserPassword :exec
+UPDATE app_users
+SET
+    hashed_password = $2,
+    updated_at = NOW()
+WHERE user_id = $1;
+
+-- name: DeleteAppSessionsByUser :exec
+DELETE FROM app_sessions
+WHERE user_id = $1;
+
+-- name: CreatePasswordResetToken :one
+INSERT INTO password_reset_tokens (
+    user_id
+) VALUES (
+    $1
+)
+RETURNING *;
+
+-- name: InvalidatePasswordResetTokens :exec
+UPDATE password_reset_tokens
+SET used_at = NOW()
+WHERE user_id = $1 AND used_at IS NULL;
+
+-- name: GetValidPasswordResetToken :one
+SELECT * FROM password_reset_tokens
+WHERE token = $1 AND used_at IS NULL AND expires_at > NOW();
+
+-- name: ConsumePasswordResetToken :one
+UPDATE password_reset_tokens
+SET used_at = NOW()
+WHERE token = $1 AND used_at IS NULL AND expires_at > NOW()
+RETURNING *;
+
+-- name: UpdateAppUser :one
+UPDATE app_users
+SET
+    name = $2,
+    email = $3,
+    dni = $4,
+    role = $5,
+    updated_at = NOW()
+WHERE user_id = $1 AND deleted_at IS NULL
+RETURNING *;
+
+-- name: SetAppUserActive :exec
+UPDATE app_users
+SET
+    is_active = $2,
+    updated_at = NOW()
+WHERE user_id = $1 AND deleted_at IS NULL;
+
+-- name: SoftDeleteAppUser :exec
+UPDATE app_users
+SET
+    is_active = false,
+    deleted_at = NOW(),
+    updated_at = NOW()
+WHERE user_id = $1 AND deleted_at IS NULL;
+
+-- name: CountActiveAdmins :one
+SELECT COUNT(*) FROM app_users
+WHERE role = 'ADMIN' AND is_active AND deleted_at IS NULL;
+
+-- name: GetAppUserCertificateCounts :one
+SELECT
+    COUNT(*) AS total,
+    COUNT(*) FILTER (WHERE confirmation_status = 'PENDING') AS pending,
+    COUNT(*) FILTER (WHERE confirmation_status = 'CONFIRMED') AS confirmed,
+    COUNT(*) FILTER (WHERE confirmation_status = 'REJECTED') AS rejected
+FROM alicorp_2025_certificates
+WHERE app_user_id = $1;
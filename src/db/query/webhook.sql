@@ -0,0 +1,96 @@
+-- name: CreateWebhookEndpoint :one
+INSERT INTO webhook_endpoints (
+    url, description, secret, events
+) VALUES (
+    $1, $2, $3, $4
+)
+RETURNING *;
+
+-- name: ListWebhookEndpoints :many
+SELECT * FROM webhook_endpoints
+ORDER BY created_at DESC;
+
+-- name: GetWebhookEndpoint :one
+SELECT * FROM webhook_endpoints
+WHERE endpoint_id = $1;
+
+-- name: SetWebhookEndpointActive :exec
+UPDATE webhook_endpoints
+SET is_active = $2
+WHERE endpoint_id = $1;
+
+-- name: DeleteWebhookEndpoint :exec
+DELETE FROM webhook_endpoints
+WHERE endpoint_id = $1;
+
+-- name: EnqueueWebhookEvent :execrows
+-- One delivery per active endpoint subscribed to the event.
+INSERT INTO webhook_deliveries (endpoint_id, event, payload)
+SELECT endpoint_id, sqlc.arg(event)::text, sqlc.arg(payload)::jsonb
+FROM webhook_endpoints
+WHERE is_active AND sqlc.arg(event)::text = ANY(events);
+
+-- name: EnqueueWebhookDelivery :one
+-- Delivery to a single endpoint, used for test pings.
+INSERT INTO webhook_deliveries (endpoint_id, event, payload)
+VALUES ($1, $2, $3)
+RETURNING *;
+
+-- name: ClaimDueWebhookDeliveries :many
+-- Claimed deliveries are leased for two minutes, so other app instances skip them while they are sent.
+UPDATE webhook_deliveries d
+SET next_attempt_at = NOW() + INTERVAL '2 minutes'
+FROM webhook_endpoints e
+WHERE d.endpoint_id = e.endpoint_id
+    AND d.delivery_id IN (
+        SELECT delivery_id FROM webhook_deliveries
+        WHERE status = 'PENDING' AND next_attempt_at <= NOW()
+        ORDER BY next_attempt_at
+        LIMIT $1
+        FOR UPDATE SKIP LOCKED
+    )
+RETURNING d.delivery_id, d.event, d.payload, d.attempts, e.url, e.secret;
+
+-- name: MarkWebhookDeliverySucceeded :exec
+UPDATE webhook_deliveries
+SET
+    status = 'SUCCEEDED',
+    attempts = attempts + 1,
+    last_status_code = $2,
+    last_error = '',
+    delivered_at = NOW()
+WHERE delivery_id = $1;
+
+-- name: MarkWebhookDeliveryAttemptFailed :exec
+-- status is PENDING while retries remain, FAILED after the last attempt.
+UPDATE webhook_deliveries
+SET
+    status = $2,
+    attempts = attempts + 1,
+    last_status_code = $3,
+    last_error = $4,
+    next_attempt_at = $5
+WHERE delivery_id = $1;
+
+-- name: ListWebhookDeliveries :many
+SELECT
+    delivery_id,
+    event,
+    status,
+    attempts,
+    last_status_code,
+    last_error,
+    created_at,
+    next_attempt_at,
+    delivered_at
+FROM webhook_deliveries
+WHERE endpoint_id = $1
+ORDER BY created_at DESC
+LIMIT 100;
+
+-- name: RetryWebhookDelivery :exec
+UPDATE webhook_deliveries
+SET
+    status = 'PENDING',
+    next_attempt_at = NOW()
+WHERE delivery_id = $1 AND endpoint_id = $2 AND status = 'FAILED';
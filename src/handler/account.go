@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"alc/model"
+	"alc/service"
+	"alc/view"
+
+	"github.com/labstack/echo/v4"
+)
+
+type AccountHandler struct {
+	AccountSvc *service.AccountService
+}
+
+func (h *AccountHandler) ShowChangePasswordPage(c echo.Context) error {
+	return render(c, http.StatusOK, view.ChangePasswordPage("", ""))
+}
+
+// HandleChangePassword updates the password of the logged-in user. Every other
+// session of the user is closed and this browser gets a new one.
+func (h *AccountHandler) HandleChangePassword(c echo.Context) error {
+	user, ok := c.Get("user").(model.AuthenticatedUser)
+	if !ok {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+
+	password := c.FormValue("password")
+	if password != c.FormValue("password_confirm") {
+		return render(c, http.StatusBadRequest, view.ChangePasswordPage("Las contraseñas no coinciden.", ""))
+	}
+
+	session, err := h.AccountSvc.ChangePassword(c.Request().Context(), user.ID, c.FormValue("current_password"), password)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidCurrentPassword) {
+			return render(c, http.StatusUnauthorized, view.ChangePasswordPage(err.Error(), ""))
+		}
+		log.Printf("Error changing password for user %s: %v", user.ID, err)
+		return render(c, http.StatusBadRequest, view.ChangePasswordPage(err.Error(), ""))
+	}
+
+	setSessionCookie(c, session)
+	return render(c, http.StatusOK, view.ChangePasswordPage("", "Tu contraseña fue actualizada."))
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"alc/model"
+	"alc/view"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+)
+
+// renderAPITokens renders the token list, optionally with a just-created token or an error.
+func (h *AdminHandler) renderAPITokens(c echo.Context, statusCode int, newToken, errorMsg string) error {
+	tokens, err := h.Repo.ListAPITokens(c.Request().Context())
+	if err != nil {
+		log.Printf("Error listing API tokens: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to load API tokens.")
+	}
+
+	return render(c, statusCode, view.APITokensPage(view.APITokensPageProps{
+		Tokens:   tokens,
+		NewToken: newToken,
+		ErrorMsg: errorMsg,
+	}))
+}
+
+func (h *AdminHandler) ShowAPITokens(c echo.Context) error {
+	return h.renderAPITokens(c, http.StatusOK, "", "")
+}
+
+// HandleCreateAPIToken issues a personal access token for the current admin. The page is
+// rendered directly instead of redirecting because the plain token is only available now.
+func (h *AdminHandler) HandleCreateAPIToken(c echo.Context) error {
+	user, ok := c.Get("user").(model.AuthenticatedUser)
+	if !ok {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+
+	days, err := strconv.Atoi(c.FormValue("expires_in_days"))
+	if err != nil || days < 0 {
+		return h.renderAPITokens(c, http.StatusBadRequest, "", "Invalid expiration.")
+	}
+
+	form, err := c.FormParams()
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid form data.")
+	}
+
+	plain, token, err := h.APITokenSvc.Create(c.Request().Context(), user.ID, c.FormValue("name"), form["scopes"], time.Duration(days)*24*time.Hour)
+	if err != nil {
+		log.Printf("Error creating API token for %s: %v", user.Email, err)
+		return h.renderAPITokens(c, http.StatusBadRequest, "", "Could not create the token: "+err.Error()+".")
+	}
+
+	log.Printf("API token %s (%s) created by %s", token.TokenPrefix, token.Name, user.Email)
+	return h.renderAPITokens(c, http.StatusCreated, plain, "")
+}
+
+func (h *AdminHandler) HandleRevokeAPIToken(c echo.Context) error {
+	tokenID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid token ID.")
+	}
+
+	if err := h.Repo.RevokeAPIToken(c.Request().Context(), pgtype.UUID{Bytes: tokenID, Valid: true}); err != nil {
+		log.Printf("Error revoking API token %s: %v", tokenID, err)
+		return c.String(http.StatusInternalServerError, "Failed to revoke token.")
+	}
+
+	return c.Redirect(http.StatusFound, "/admin/api-tokens")
+}
@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"alc/model"
+	"alc/service"
+	"alc/view"
+
+	"github.com/labstack/echo/v4"
+)
+
+// renderMasterDataChanges renders the review queue, optionally with the error of a review.
+func (h *AdminHandler) renderMasterDataChanges(c echo.Context, statusCode int, errorMsg string) error {
+	ctx := c.Request().Context()
+	pending, err := h.Repo.ListPendingMasterDataChanges(ctx)
+	if err != nil {
+		log.Printf("Error listing pending master data changes: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to load changes.")
+	}
+	reviewed, err := h.Repo.ListReviewedMasterDataChanges(ctx)
+	if err != nil {
+		log.Printf("Error listing reviewed master data changes: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to load changes.")
+	}
+
+	return render(c, statusCode, view.MasterDataChangesPage(view.MasterDataChangesPageProps{
+		Pending:  pending,
+		Reviewed: reviewed,
+		ErrorMsg: errorMsg,
+	}))
+}
+
+// ShowMasterDataChanges lists the changes to machine users and machines proposed by
+// certificates, and the latest reviewed ones.
+func (h *AdminHandler) ShowMasterDataChanges(c echo.Context) error {
+	return h.renderMasterDataChanges(c, http.StatusOK, "")
+}
+
+func (h *AdminHandler) HandleApproveMasterDataChange(c echo.Context) error {
+	user, ok := c.Get("user").(model.AuthenticatedUser)
+	if !ok {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid change ID.")
+	}
+
+	change, err := h.MasterDataSvc.Approve(c.Request().Context(), int32(id), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrChangeNotPending):
+			return h.renderMasterDataChanges(c, http.StatusConflict, "The change was already reviewed.")
+		case errors.Is(err, service.ErrMasterRecordGone):
+			return h.renderMasterDataChanges(c, http.StatusConflict, fmt.Sprintf("%s no longer exists, it may have been renamed or merged. Reject the change.", change.EntityKey))
+		case errors.Is(err, service.ErrPlateInUse):
+			return h.renderMasterDataChanges(c, http.StatusConflict, fmt.Sprintf("The plate %s is already used by another machine.", change.ProposedValue))
+		case errors.Is(err, service.ErrPersonalCodeInUse):
+			return h.renderMasterDataChanges(c, http.StatusConflict, fmt.Sprintf("The personal code %s is already used by another machine user.", change.ProposedValue))
+		case errors.Is(err, service.ErrEmailInUse):
+			return h.renderMasterDataChanges(c, http.StatusConflict, fmt.Sprintf("The email %s is already used by another machine user.", change.ProposedValue))
+		}
+		log.Printf("Error approving master data change %d: %v", id, err)
+		return h.renderMasterDataChanges(c, http.StatusInternalServerError, "Could not apply the change.")
+	}
+	return c.Redirect(http.StatusFound, "/admin/changes")
+}
+
+func (h *AdminHandler) HandleRejectMasterDataChange(c echo.Context) error {
+	user, ok := c.Get("user").(model.AuthenticatedUser)
+	if !ok {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid change ID.")
+	}
+
+	if _, err := h.MasterDataSvc.Reject(c.Request().Context(), int32(id), user.ID); err != nil {
+		if errors.Is(err, service.ErrChangeNotPending) {
+			return h.renderMasterDataChanges(c, http.StatusConflict, "The change was already reviewed.")
+		}
+		log.Printf("Error rejecting master data change %d: %v", id, err)
+		return h.renderMasterDataChanges(c, http.StatusInternalServerError, "Could not reject the change.")
+	}
+	return c.Redirect(http.StatusFound, "/admin/changes")
+}
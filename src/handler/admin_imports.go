@@ -0,0 +1,260 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"alc/model"
+	"alc/repository"
+	"alc/service"
+	"alc/view"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+)
+
+// previewUpload validates an uploaded file into an import job and shows its preview.
+// Nothing is saved until the admin commits the job.
+func (h *AdminHandler) previewUpload(c echo.Context, kind service.ImportKind) error {
+	user, ok := c.Get("user").(model.AuthenticatedUser)
+	if !ok {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+
+	file, err := c.FormFile("csvfile")
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Failed to get the file.")
+	}
+	src, err := file.Open()
+	if err != nil {
+		return c.String(http.StatusInternalServerError, "Failed to open the file.")
+	}
+	defer src.Close()
+
+	records, err := service.ReadSpreadsheet(file.Filename, src)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	job, err := h.ImportSvc.Preview(c.Request().Context(), kind, file.Filename, user.ID, records)
+	if err != nil {
+		log.Printf("Error previewing %s upload %s: %v", kind, file.Filename, err)
+		return c.String(http.StatusBadRequest, "Could not read the file: "+err.Error())
+	}
+
+	return c.Redirect(http.StatusFound, "/admin/imports/"+job.JobID.String())
+}
+
+// HandleBulkUploadMachineUsers previews an upload of machine users.
+func (h *AdminHandler) HandleBulkUploadMachineUsers(c echo.Context) error {
+	return h.previewUpload(c, service.ImportKindMachineUsers)
+}
+
+// HandleBulkUploadMachines previews an upload of machines.
+func (h *AdminHandler) HandleBulkUploadMachines(c echo.Context) error {
+	return h.previewUpload(c, service.ImportKindMachines)
+}
+
+func (h *AdminHandler) ShowImportJobs(c echo.Context) error {
+	ctx := c.Request().Context()
+	jobs, err := h.Repo.ListImportJobs(ctx)
+	if err != nil {
+		log.Printf("Error listing import jobs: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to load imports.")
+	}
+	profiles, err := h.Repo.ListAllImportMappingProfiles(ctx)
+	if err != nil {
+		log.Printf("Error listing import mapping profiles: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to load imports.")
+	}
+	return render(c, http.StatusOK, view.ImportJobsPage(jobs, profiles))
+}
+
+// parseJobID reads the :id route parameter of the import routes.
+func parseJobID(c echo.Context) (pgtype.UUID, error) {
+	id, err := uuid.Parse(c.Param("id"))
+	return pgtype.UUID{Bytes: id, Valid: err == nil}, err
+}
+
+// ShowImportJob shows the rows of a job with their outcome. Unchanged rows are only
+// counted unless ?all=1 is given, since most rows of a repeated upload are unchanged.
+func (h *AdminHandler) ShowImportJob(c echo.Context) error {
+	jobID, err := parseJobID(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid import ID.")
+	}
+
+	job, err := h.Repo.GetImportJob(c.Request().Context(), jobID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return c.String(http.StatusNotFound, "Import not found.")
+		}
+		log.Printf("Error fetching import job %s: %v", jobID.String(), err)
+		return c.String(http.StatusInternalServerError, "Failed to load import.")
+	}
+
+	plan, err := service.DecodePlan(job.ImportJob)
+	if err != nil {
+		log.Printf("Error decoding import job %s: %v", jobID.String(), err)
+		return c.String(http.StatusInternalServerError, "Failed to load import.")
+	}
+
+	if job.ImportJob.Status == repository.ImportJobStatusMAPPING {
+		return renderImportMapping(c, http.StatusOK, job, plan, plan.Mapping.Fields(len(plan.Header)), "")
+	}
+
+	showAll := c.QueryParam("all") == "1"
+	var rows []view.ImportPreviewRow
+	for _, row := range plan.Rows {
+		if !showAll && row.Status == service.ImportRowUnchanged {
+			continue
+		}
+		preview := view.ImportPreviewRow{Line: row.Line, Key: row.Key, Status: string(row.Status), Errors: row.Errors}
+		for _, diff := range row.Diffs {
+			preview.Diffs = append(preview.Diffs, view.ImportFieldDiff(diff))
+		}
+		rows = append(rows, preview)
+	}
+
+	return render(c, http.StatusOK, view.ImportJobPage(view.ImportJobPageProps{
+		Job:     job,
+		Rows:    rows,
+		ShowAll: showAll,
+		Error:   c.QueryParam("error"),
+	}))
+}
+
+// renderImportMapping shows the mapping screen of a job with the given field selected for
+// each column of the file.
+func renderImportMapping(c echo.Context, status int, job repository.GetImportJobRow, plan service.ImportPlan, fields []string, errorMsg string) error {
+	samples := plan.Records[:min(len(plan.Records), 3)]
+	kind := service.ImportKind(job.ImportJob.Kind)
+	var columns []view.ImportField
+	for _, col := range kind.Columns() {
+		columns = append(columns, view.ImportField{Field: col.Field, Label: col.Label})
+	}
+	var missing []string
+	for _, col := range plan.Mapping.Missing(kind) {
+		missing = append(missing, col.Label)
+	}
+	return render(c, status, view.ImportMappingPage(view.ImportMappingPageProps{
+		Job:     job,
+		Columns: columns,
+		Header:  plan.Header,
+		Fields:  fields,
+		Samples: samples,
+		Missing: missing,
+		Error:   errorMsg,
+	}))
+}
+
+// HandleApplyImportMapping validates the rows of a job with the columns chosen in the
+// mapping screen, sent as column_<index> form values.
+func (h *AdminHandler) HandleApplyImportMapping(c echo.Context) error {
+	user, ok := c.Get("user").(model.AuthenticatedUser)
+	if !ok {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+	jobID, err := parseJobID(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid import ID.")
+	}
+
+	ctx := c.Request().Context()
+	job, err := h.Repo.GetImportJob(ctx, jobID)
+	if err != nil {
+		return c.String(http.StatusNotFound, "Import not found.")
+	}
+	plan, err := service.DecodePlan(job.ImportJob)
+	if err != nil {
+		log.Printf("Error decoding import job %s: %v", jobID.String(), err)
+		return c.String(http.StatusInternalServerError, "Failed to load import.")
+	}
+
+	fields := make([]string, len(plan.Header))
+	for i := range fields {
+		fields[i] = c.FormValue(fmt.Sprintf("column_%d", i))
+	}
+
+	_, err = h.ImportSvc.ApplyMapping(ctx, jobID, user.ID, fields, c.FormValue("profile_name"))
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidMapping) {
+			return renderImportMapping(c, http.StatusUnprocessableEntity, job, plan, fields, err.Error())
+		}
+		log.Printf("Error applying mapping to import job %s: %v", jobID.String(), err)
+		return renderImportMapping(c, http.StatusInternalServerError, job, plan, fields, "The rows could not be validated. The import may have been discarded already.")
+	}
+
+	return c.Redirect(http.StatusFound, "/admin/imports/"+jobID.String())
+}
+
+func (h *AdminHandler) HandleDeleteImportMappingProfile(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid profile ID.")
+	}
+	if err := h.Repo.DeleteImportMappingProfile(c.Request().Context(), int32(id)); err != nil {
+		log.Printf("Error deleting import mapping profile %d: %v", id, err)
+		return c.String(http.StatusInternalServerError, "Failed to delete profile.")
+	}
+	return c.Redirect(http.StatusFound, "/admin/imports")
+}
+
+func (h *AdminHandler) HandleCommitImportJob(c echo.Context) error {
+	jobID, err := parseJobID(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid import ID.")
+	}
+
+	job, err := h.ImportSvc.Commit(c.Request().Context(), jobID)
+	if err != nil {
+		log.Printf("Error committing import job %s: %v", jobID.String(), err)
+		return c.Redirect(http.StatusFound, fmt.Sprintf("/admin/imports/%s?error=%s", jobID.String(), "commit"))
+	}
+
+	log.Printf("Import %s of %s committed: %d new, %d updated", job.FileName, job.Kind, job.NewRows, job.UpdatedRows)
+	return c.Redirect(http.StatusFound, "/admin/imports/"+jobID.String())
+}
+
+func (h *AdminHandler) HandleDiscardImportJob(c echo.Context) error {
+	jobID, err := parseJobID(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid import ID.")
+	}
+
+	if err := h.ImportSvc.Discard(c.Request().Context(), jobID); err != nil {
+		log.Printf("Error discarding import job %s: %v", jobID.String(), err)
+	}
+	return c.Redirect(http.StatusFound, "/admin/imports")
+}
+
+// HandleDownloadRejectedRows serves the invalid rows of a job as CSV, so they can be
+// fixed and uploaded again.
+func (h *AdminHandler) HandleDownloadRejectedRows(c echo.Context) error {
+	jobID, err := parseJobID(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid import ID.")
+	}
+
+	job, err := h.Repo.GetImportJob(c.Request().Context(), jobID)
+	if err != nil {
+		return c.String(http.StatusNotFound, "Import not found.")
+	}
+	plan, err := service.DecodePlan(job.ImportJob)
+	if err != nil {
+		log.Printf("Error decoding import job %s: %v", jobID.String(), err)
+		return c.String(http.StatusInternalServerError, "Failed to load import.")
+	}
+
+	fileName := "rejected_" + strings.TrimSuffix(job.ImportJob.FileName, filepath.Ext(job.ImportJob.FileName)) + ".csv"
+	c.Response().Header().Set("Content-Type", "text/csv")
+	c.Response().Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+	return service.WriteRejectedRows(c.Response().Writer, plan)
+}
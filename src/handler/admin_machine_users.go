@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+
+	"alc/model"
+	"alc/repository"
+	"alc/service"
+	"alc/view"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// machineUserURL is the directory page of a machine user.
+func machineUserURL(dni string) string {
+	return "/admin/machine-users/" + url.PathEscape(dni)
+}
+
+// ShowMachineUsers searches the machine user directory by DNI, name, email or personal
+// code with the q query parameter. Inactive machine users are listed when inactive is set.
+func (h *AdminHandler) ShowMachineUsers(c echo.Context) error {
+	search := strings.TrimSpace(c.QueryParam("q"))
+	includeInactive := c.QueryParam("inactive") != ""
+	machineUsers, err := h.Repo.SearchMachineUsers(c.Request().Context(), repository.SearchMachineUsersParams{
+		Search:          search,
+		IncludeInactive: includeInactive,
+	})
+	if err != nil {
+		log.Printf("Error searching machine users for %q: %v", search, err)
+		return c.String(http.StatusInternalServerError, "Failed to load machine users.")
+	}
+
+	return render(c, http.StatusOK, view.MachineUsersPage(view.MachineUsersPageProps{
+		MachineUsers:    machineUsers,
+		Search:          search,
+		IncludeInactive: includeInactive,
+	}))
+}
+
+// getMachineUser loads the machine user of the :dni route parameter, answering the request
+// itself when it cannot.
+func (h *AdminHandler) getMachineUser(c echo.Context) (repository.MachineUser, bool, error) {
+	dni, err := url.PathUnescape(c.Param("dni"))
+	if err != nil {
+		return repository.MachineUser{}, false, c.String(http.StatusBadRequest, "Invalid DNI.")
+	}
+	machineUser, err := h.Repo.GetMachineUserByDNI(c.Request().Context(), dni)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return machineUser, false, c.String(http.StatusNotFound, "Machine user not found.")
+		}
+		log.Printf("Error fetching machine user %s: %v", dni, err)
+		return machineUser, false, c.String(http.StatusInternalServerError, "Failed to load machine user.")
+	}
+	return machineUser, true, nil
+}
+
+// renderMachineUser renders the page of a machine user with their certificates, devices
+// and history, optionally with an error from one of its forms.
+func (h *AdminHandler) renderMachineUser(c echo.Context, statusCode int, machineUser repository.MachineUser, errorMsg string) error {
+	ctx := c.Request().Context()
+	certificates, err := h.Repo.ListMachineUserCertificates(ctx, machineUser.Dni)
+	if err != nil {
+		log.Printf("Error listing certificates of machine user %s: %v", machineUser.Dni, err)
+		return c.String(http.StatusInternalServerError, "Failed to load machine user.")
+	}
+	devices, err := h.Repo.ListMachineUserDevices(ctx, machineUser.Dni)
+	if err != nil {
+		log.Printf("Error listing devices of machine user %s: %v", machineUser.Dni, err)
+		return c.String(http.StatusInternalServerError, "Failed to load machine user.")
+	}
+	history, err := h.Repo.ListMachineUserHistory(ctx, machineUser.Dni)
+	if err != nil {
+		log.Printf("Error listing history of machine user %s: %v", machineUser.Dni, err)
+		return c.String(http.StatusInternalServerError, "Failed to load machine user.")
+	}
+
+	return render(c, statusCode, view.MachineUserPage(view.MachineUserPageProps{
+		MachineUser:  machineUser,
+		Certificates: certificates,
+		Devices:      devices,
+		History:      history,
+		ErrorMsg:     errorMsg,
+	}))
+}
+
+func (h *AdminHandler) ShowMachineUser(c echo.Context) error {
+	machineUser, ok, err := h.getMachineUser(c)
+	if !ok {
+		return err
+	}
+	return h.renderMachineUser(c, http.StatusOK, machineUser, "")
+}
+
+func (h *AdminHandler) HandleUpdateMachineUser(c echo.Context) error {
+	user, ok := c.Get("user").(model.AuthenticatedUser)
+	if !ok {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+	machineUser, ok, err := h.getMachineUser(c)
+	if !ok {
+		return err
+	}
+
+	p := repository.UpdateMachineUserParams{
+		Dni:          machineUser.Dni,
+		PersonalCode: strings.ToUpper(strings.TrimSpace(c.FormValue("personal_code"))),
+		Name:         strings.ToUpper(strings.TrimSpace(c.FormValue("name"))),
+		Email:        strings.ToLower(strings.TrimSpace(c.FormValue("email"))),
+		Society:      strings.ToUpper(strings.TrimSpace(c.FormValue("society"))),
+		Site:         strings.ToUpper(strings.TrimSpace(c.FormValue("site"))),
+		Area:         strings.ToUpper(strings.TrimSpace(c.FormValue("area"))),
+		FloorName:    strings.ToUpper(strings.TrimSpace(c.FormValue("floor_name"))),
+	}
+	if p.PersonalCode == "" || p.Name == "" || p.Email == "" {
+		return h.renderMachineUser(c, http.StatusBadRequest, machineUser, "Personal code, name and email are required.")
+	}
+	if _, err := mail.ParseAddress(p.Email); err != nil {
+		return h.renderMachineUser(c, http.StatusBadRequest, machineUser, fmt.Sprintf("The email %s is not valid.", p.Email))
+	}
+
+	if _, err := h.MachineUserSvc.Update(c.Request().Context(), p, user.ID); err != nil {
+		if isUniqueViolation(err) {
+			return h.renderMachineUser(c, http.StatusConflict, machineUser, "Another machine user already has that email or personal code. Merge the duplicate instead.")
+		}
+		log.Printf("Error updating machine user %s: %v", machineUser.Dni, err)
+		return c.String(http.StatusInternalServerError, "Failed to update machine user.")
+	}
+	return c.Redirect(http.StatusFound, machineUserURL(machineUser.Dni))
+}
+
+// HandleSetMachineUserActive deactivates the machine user, or reactivates them, with the
+// active form value.
+func (h *AdminHandler) HandleSetMachineUserActive(c echo.Context) error {
+	user, ok := c.Get("user").(model.AuthenticatedUser)
+	if !ok {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+	machineUser, ok, err := h.getMachineUser(c)
+	if !ok {
+		return err
+	}
+
+	if _, err := h.MachineUserSvc.SetActive(c.Request().Context(), machineUser.Dni, c.FormValue("active") == "true", user.ID); err != nil {
+		log.Printf("Error setting machine user %s active: %v", machineUser.Dni, err)
+		return c.String(http.StatusInternalServerError, "Failed to update machine user.")
+	}
+	return c.Redirect(http.StatusFound, machineUserURL(machineUser.Dni))
+}
+
+// HandleMergeMachineUser merges the machine user, a duplicate, into the one of the
+// into_dni form value, and shows the machine user kept.
+func (h *AdminHandler) HandleMergeMachineUser(c echo.Context) error {
+	user, ok := c.Get("user").(model.AuthenticatedUser)
+	if !ok {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+	machineUser, ok, err := h.getMachineUser(c)
+	if !ok {
+		return err
+	}
+
+	intoDNI := strings.ReplaceAll(c.FormValue("into_dni"), " ", "")
+	if err := h.MachineUserSvc.Merge(c.Request().Context(), machineUser.Dni, intoDNI, user.ID); err != nil {
+		switch {
+		case errors.Is(err, service.ErrMergeSameMachineUser):
+			return h.renderMachineUser(c, http.StatusBadRequest, machineUser, "Choose another machine user to merge into.")
+		case errors.Is(err, pgx.ErrNoRows):
+			return h.renderMachineUser(c, http.StatusBadRequest, machineUser, fmt.Sprintf("There is no machine user with DNI %q.", intoDNI))
+		}
+		log.Printf("Error merging machine user %s into %s: %v", machineUser.Dni, intoDNI, err)
+		return h.renderMachineUser(c, http.StatusInternalServerError, machineUser, "Could not merge the machine users.")
+	}
+	return c.Redirect(http.StatusFound, machineUserURL(intoDNI))
+}
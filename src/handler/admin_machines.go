@@ -0,0 +1,357 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"alc/repository"
+	"alc/service"
+	"alc/view"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// machineURL is the admin page of a machine.
+func machineURL(serial string) string {
+	return "/admin/machines/" + url.PathEscape(serial)
+}
+
+// parseMachineForm reads the fields shared by the create and edit forms of a machine,
+// returning a validation message when they are not valid.
+func parseMachineForm(c echo.Context) (repository.UpdateMachineParams, string) {
+	p := repository.UpdateMachineParams{
+		SerialNum:  strings.ToUpper(strings.TrimSpace(c.FormValue("serial_num"))),
+		Type:       repository.MachineType(c.FormValue("type")),
+		Mtm:        strings.ToUpper(strings.TrimSpace(c.FormValue("mtm"))),
+		Model:      strings.TrimSpace(c.FormValue("model")),
+		PlateNum:   strings.ToUpper(strings.TrimSpace(c.FormValue("plate_num"))),
+		DiskSize:   strings.TrimSpace(c.FormValue("disk_size")),
+		MemorySize: strings.TrimSpace(c.FormValue("memory_size")),
+		Processor:  strings.TrimSpace(c.FormValue("processor")),
+		Profile:    repository.MachineProfile(c.FormValue("profile")),
+		Site:       strings.ToUpper(strings.TrimSpace(c.FormValue("site"))),
+	}
+	switch {
+	case p.SerialNum == "":
+		return p, "The serial number is required."
+	case strings.ContainsAny(p.SerialNum, " \t/"):
+		return p, "The serial number cannot contain spaces or slashes."
+	case strings.ContainsAny(p.PlateNum, " \t"):
+		return p, "The plate cannot contain spaces."
+	case !slices.Contains(repository.AllMachineTypeValues(), p.Type):
+		return p, "Invalid machine type specified."
+	case !slices.Contains(repository.AllMachineProfileValues(), p.Profile):
+		return p, "Invalid profile specified."
+	}
+	return p, ""
+}
+
+// parseDeviceForm reads the fields shared by the register and edit forms of a device.
+func parseDeviceForm(c echo.Context) (repository.UpdateDeviceParams, string) {
+	p := repository.UpdateDeviceParams{
+		Type:               repository.DeviceType(c.FormValue("type")),
+		Hostname:           strings.ToUpper(strings.TrimSpace(c.FormValue("hostname"))),
+		Status:             repository.DeviceStatus(c.FormValue("status")),
+		AdditionalSoftware: strings.TrimSpace(c.FormValue("additional_software")),
+	}
+	switch {
+	case !slices.Contains(repository.AllDeviceTypeValues(), p.Type):
+		return p, "Invalid device type specified."
+	case !slices.Contains(repository.AllDeviceStatusValues(), p.Status):
+		return p, "Invalid device status specified."
+	}
+	return p, ""
+}
+
+// renderMachines renders the machine search, optionally with an error from the create form.
+func (h *AdminHandler) renderMachines(c echo.Context, statusCode int, errorMsg string) error {
+	search := strings.TrimSpace(c.QueryParam("q"))
+	machines, err := h.Repo.SearchMachines(c.Request().Context(), search)
+	if err != nil {
+		log.Printf("Error searching machines for %q: %v", search, err)
+		return c.String(http.StatusInternalServerError, "Failed to load machines.")
+	}
+
+	return render(c, statusCode, view.MachinesPage(view.MachinesPageProps{
+		Machines: machines,
+		Search:   search,
+		ErrorMsg: errorMsg,
+	}))
+}
+
+// ShowMachines searches the machines by serial number, plate, MTM or model, or by the
+// code or hostname of their device, with the q query parameter.
+func (h *AdminHandler) ShowMachines(c echo.Context) error {
+	return h.renderMachines(c, http.StatusOK, "")
+}
+
+func (h *AdminHandler) HandleCreateMachine(c echo.Context) error {
+	p, errorMsg := parseMachineForm(c)
+	if errorMsg != "" {
+		return h.renderMachines(c, http.StatusBadRequest, errorMsg)
+	}
+
+	ctx := c.Request().Context()
+	if p.PlateNum != "" {
+		others, err := h.Repo.CountMachinesWithPlate(ctx, repository.CountMachinesWithPlateParams{
+			PlateNum:  p.PlateNum,
+			SerialNum: p.SerialNum,
+		})
+		if err != nil {
+			log.Printf("Error checking plate %s: %v", p.PlateNum, err)
+			return c.String(http.StatusInternalServerError, "Failed to create machine.")
+		}
+		if others > 0 {
+			return h.renderMachines(c, http.StatusConflict, fmt.Sprintf("The plate %s is already used by another machine.", p.PlateNum))
+		}
+	}
+
+	machine, err := h.Repo.CreateMachine(ctx, repository.CreateMachineParams{
+		SerialNum:  p.SerialNum,
+		Type:       p.Type,
+		Mtm:        p.Mtm,
+		Model:      p.Model,
+		PlateNum:   p.PlateNum,
+		DiskSize:   p.DiskSize,
+		MemorySize: p.MemorySize,
+		Processor:  p.Processor,
+		Profile:    p.Profile,
+		Site:       p.Site,
+	})
+	if err != nil {
+		if isUniqueViolation(err) {
+			return h.renderMachines(c, http.StatusConflict, fmt.Sprintf("A machine with serial number %s already exists.", p.SerialNum))
+		}
+		log.Printf("Error creating machine %s: %v", p.SerialNum, err)
+		return c.String(http.StatusInternalServerError, "Failed to create machine.")
+	}
+
+	return c.Redirect(http.StatusFound, machineURL(machine.SerialNum))
+}
+
+// getMachine loads the machine of the :serial route parameter, answering the request
+// itself when it cannot.
+func (h *AdminHandler) getMachine(c echo.Context) (repository.Machine, bool, error) {
+	serial, err := machineSerialParam(c)
+	if err != nil {
+		return repository.Machine{}, false, c.String(http.StatusBadRequest, "Invalid serial number.")
+	}
+	machine, err := h.Repo.GetMachineBySerial(c.Request().Context(), serial)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return machine, false, c.String(http.StatusNotFound, "Machine not found.")
+		}
+		log.Printf("Error fetching machine %s: %v", serial, err)
+		return machine, false, c.String(http.StatusInternalServerError, "Failed to load machine.")
+	}
+	return machine, true, nil
+}
+
+// renderMachine renders the page of a machine with its device and the certificates of
+// the device, optionally with an error from one of its forms.
+func (h *AdminHandler) renderMachine(c echo.Context, statusCode int, machine repository.Machine, errorMsg string) error {
+	ctx := c.Request().Context()
+	props := view.MachinePageProps{Machine: machine, ErrorMsg: errorMsg}
+
+	device, err := h.Repo.GetDeviceByMachine(ctx, machine.SerialNum)
+	switch {
+	case err == nil:
+		props.Device = &device
+		props.Certificates, err = h.Repo.ListDeviceCertificates(ctx, device.DeviceCode)
+		if err != nil {
+			log.Printf("Error listing certificates of device %s: %v", device.DeviceCode, err)
+			return c.String(http.StatusInternalServerError, "Failed to load machine.")
+		}
+	case !errors.Is(err, pgx.ErrNoRows):
+		log.Printf("Error fetching device of machine %s: %v", machine.SerialNum, err)
+		return c.String(http.StatusInternalServerError, "Failed to load machine.")
+	}
+
+	return render(c, statusCode, view.MachinePage(props))
+}
+
+func (h *AdminHandler) ShowMachine(c echo.Context) error {
+	machine, ok, err := h.getMachine(c)
+	if !ok {
+		return err
+	}
+	return h.renderMachine(c, http.StatusOK, machine, "")
+}
+
+// HandleUpdateMachine saves a machine. A new serial number renames it, carrying its
+// device and reservation along.
+func (h *AdminHandler) HandleUpdateMachine(c echo.Context) error {
+	machine, ok, err := h.getMachine(c)
+	if !ok {
+		return err
+	}
+
+	p, errorMsg := parseMachineForm(c)
+	if errorMsg != "" {
+		return h.renderMachine(c, http.StatusBadRequest, machine, errorMsg)
+	}
+	p.OldSerialNum = machine.SerialNum
+
+	updated, err := h.MachineSvc.UpdateMachine(c.Request().Context(), p)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrPlateInUse):
+			return h.renderMachine(c, http.StatusConflict, machine, fmt.Sprintf("The plate %s is already used by another machine.", p.PlateNum))
+		case isUniqueViolation(err):
+			return h.renderMachine(c, http.StatusConflict, machine, fmt.Sprintf("A machine with serial number %s already exists.", p.SerialNum))
+		}
+		log.Printf("Error updating machine %s: %v", machine.SerialNum, err)
+		return c.String(http.StatusInternalServerError, "Failed to update machine.")
+	}
+
+	return c.Redirect(http.StatusFound, machineURL(updated.SerialNum))
+}
+
+// HandleSetMachineRetired retires the machine, or brings it back, with the retired form
+// value.
+func (h *AdminHandler) HandleSetMachineRetired(c echo.Context) error {
+	machine, ok, err := h.getMachine(c)
+	if !ok {
+		return err
+	}
+
+	if err := h.MachineSvc.SetMachineRetired(c.Request().Context(), machine.SerialNum, c.FormValue("retired") == "true"); err != nil {
+		log.Printf("Error retiring machine %s: %v", machine.SerialNum, err)
+		return c.String(http.StatusInternalServerError, "Failed to retire machine.")
+	}
+	return c.Redirect(http.StatusFound, machineURL(machine.SerialNum))
+}
+
+func (h *AdminHandler) HandleDeleteMachine(c echo.Context) error {
+	machine, ok, err := h.getMachine(c)
+	if !ok {
+		return err
+	}
+
+	if err := h.MachineSvc.DeleteMachine(c.Request().Context(), machine.SerialNum); err != nil {
+		var inUse *service.AssetInUseError
+		if errors.As(err, &inUse) {
+			return h.renderMachine(c, http.StatusConflict, machine, inUse.Reason)
+		}
+		log.Printf("Error deleting machine %s: %v", machine.SerialNum, err)
+		return c.String(http.StatusInternalServerError, "Failed to delete machine.")
+	}
+	return c.Redirect(http.StatusFound, "/admin/machines")
+}
+
+// HandleCreateDevice registers the device of a machine that has none.
+func (h *AdminHandler) HandleCreateDevice(c echo.Context) error {
+	machine, ok, err := h.getMachine(c)
+	if !ok {
+		return err
+	}
+
+	p, errorMsg := parseDeviceForm(c)
+	code := strings.ToUpper(strings.TrimSpace(c.FormValue("device_code")))
+	if errorMsg == "" && code == "" {
+		errorMsg = "The device code is required."
+	}
+	if errorMsg != "" {
+		return h.renderMachine(c, http.StatusBadRequest, machine, errorMsg)
+	}
+
+	if _, err := h.Repo.CreateDevice(c.Request().Context(), repository.CreateDeviceParams{
+		DeviceCode:         code,
+		MachineSerialNum:   machine.SerialNum,
+		Type:               p.Type,
+		Hostname:           p.Hostname,
+		Status:             p.Status,
+		AdditionalSoftware: p.AdditionalSoftware,
+	}); err != nil {
+		if isUniqueViolation(err) {
+			return h.renderMachine(c, http.StatusConflict, machine, fmt.Sprintf("The device code %s is already in use, or the machine already has a device.", code))
+		}
+		log.Printf("Error creating device %s for machine %s: %v", code, machine.SerialNum, err)
+		return c.String(http.StatusInternalServerError, "Failed to create device.")
+	}
+	return c.Redirect(http.StatusFound, machineURL(machine.SerialNum))
+}
+
+// getDevice loads the device of the :code route parameter and its machine, answering the
+// request itself when it cannot.
+func (h *AdminHandler) getDevice(c echo.Context) (repository.Device, repository.Machine, bool, error) {
+	code, err := url.PathUnescape(c.Param("code"))
+	if err != nil {
+		return repository.Device{}, repository.Machine{}, false, c.String(http.StatusBadRequest, "Invalid device code.")
+	}
+	ctx := c.Request().Context()
+	device, err := h.Repo.GetDevice(ctx, code)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return device, repository.Machine{}, false, c.String(http.StatusNotFound, "Device not found.")
+		}
+		log.Printf("Error fetching device %s: %v", code, err)
+		return device, repository.Machine{}, false, c.String(http.StatusInternalServerError, "Failed to load device.")
+	}
+	machine, err := h.Repo.GetMachineBySerial(ctx, device.MachineSerialNum)
+	if err != nil {
+		log.Printf("Error fetching machine %s of device %s: %v", device.MachineSerialNum, code, err)
+		return device, machine, false, c.String(http.StatusInternalServerError, "Failed to load device.")
+	}
+	return device, machine, true, nil
+}
+
+func (h *AdminHandler) HandleUpdateDevice(c echo.Context) error {
+	device, machine, ok, err := h.getDevice(c)
+	if !ok {
+		return err
+	}
+
+	p, errorMsg := parseDeviceForm(c)
+	if errorMsg != "" {
+		return h.renderMachine(c, http.StatusBadRequest, machine, errorMsg)
+	}
+	p.DeviceCode = device.DeviceCode
+
+	if _, err := h.Repo.UpdateDevice(c.Request().Context(), p); err != nil {
+		log.Printf("Error updating device %s: %v", device.DeviceCode, err)
+		return c.String(http.StatusInternalServerError, "Failed to update device.")
+	}
+	return c.Redirect(http.StatusFound, machineURL(machine.SerialNum))
+}
+
+// HandleSetDeviceRetired retires the device, or brings it back, with the retired form
+// value.
+func (h *AdminHandler) HandleSetDeviceRetired(c echo.Context) error {
+	device, machine, ok, err := h.getDevice(c)
+	if !ok {
+		return err
+	}
+
+	if err := h.Repo.SetDeviceRetired(c.Request().Context(), repository.SetDeviceRetiredParams{
+		DeviceCode: device.DeviceCode,
+		Retired:    c.FormValue("retired") == "true",
+	}); err != nil {
+		log.Printf("Error retiring device %s: %v", device.DeviceCode, err)
+		return c.String(http.StatusInternalServerError, "Failed to retire device.")
+	}
+	return c.Redirect(http.StatusFound, machineURL(machine.SerialNum))
+}
+
+func (h *AdminHandler) HandleDeleteDevice(c echo.Context) error {
+	device, machine, ok, err := h.getDevice(c)
+	if !ok {
+		return err
+	}
+
+	if err := h.MachineSvc.DeleteDevice(c.Request().Context(), device.DeviceCode); err != nil {
+		var inUse *service.AssetInUseError
+		if errors.As(err, &inUse) {
+			return h.renderMachine(c, http.StatusConflict, machine, inUse.Reason)
+		}
+		log.Printf("Error deleting device %s: %v", device.DeviceCode, err)
+		return c.String(http.StatusInternalServerError, "Failed to delete device.")
+	}
+	return c.Redirect(http.StatusFound, machineURL(machine.SerialNum))
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"alc/repository"
+	"alc/service"
+	"alc/view"
+
+	"github.com/labstack/echo/v4"
+)
+
+// renderMTMCatalog renders the catalog page, optionally with an error from the entry form.
+func (h *AdminHandler) renderMTMCatalog(c echo.Context, statusCode int, errorMsg string) error {
+	ctx := c.Request().Context()
+	entries, err := h.Repo.ListMTMCatalog(ctx)
+	if err != nil {
+		log.Printf("Error listing MTM catalog: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to load the MTM catalog.")
+	}
+	inStock, err := h.Repo.CountUnassignedReceivedMachines(ctx)
+	if err != nil {
+		log.Printf("Error counting received machines: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to load the MTM catalog.")
+	}
+
+	return render(c, statusCode, view.MTMCatalogPage(view.MTMCatalogPageProps{
+		Entries:  entries,
+		InStock:  inStock,
+		ErrorMsg: errorMsg,
+	}))
+}
+
+func (h *AdminHandler) ShowMTMCatalog(c echo.Context) error {
+	return h.renderMTMCatalog(c, http.StatusOK, "")
+}
+
+// HandleUpsertMTMCatalogEntry adds an MTM or replaces the specs of an existing one.
+func (h *AdminHandler) HandleUpsertMTMCatalogEntry(c echo.Context) error {
+	params := repository.UpsertMTMCatalogEntryParams{
+		Mtm:        strings.ToUpper(strings.ReplaceAll(c.FormValue("mtm"), " ", "")),
+		Type:       repository.MachineType(c.FormValue("type")),
+		Model:      strings.TrimSpace(c.FormValue("model")),
+		Processor:  strings.TrimSpace(c.FormValue("processor")),
+		MemorySize: strings.TrimSpace(c.FormValue("memory_size")),
+		DiskSize:   strings.TrimSpace(c.FormValue("disk_size")),
+		Profile:    repository.MachineProfile(c.FormValue("profile")),
+	}
+	if params.Mtm == "" || params.Model == "" {
+		return h.renderMTMCatalog(c, http.StatusBadRequest, "MTM and model are required.")
+	}
+	if !slices.Contains(repository.AllMachineTypeValues(), params.Type) {
+		return h.renderMTMCatalog(c, http.StatusBadRequest, "Invalid machine type.")
+	}
+	if !slices.Contains(repository.AllMachineProfileValues(), params.Profile) {
+		return h.renderMTMCatalog(c, http.StatusBadRequest, "Invalid profile.")
+	}
+
+	if _, err := h.Repo.UpsertMTMCatalogEntry(c.Request().Context(), params); err != nil {
+		log.Printf("Error saving MTM %s: %v", params.Mtm, err)
+		return h.renderMTMCatalog(c, http.StatusInternalServerError, "Could not save the MTM.")
+	}
+
+	return c.Redirect(http.StatusFound, "/admin/mtm-catalog")
+}
+
+func (h *AdminHandler) HandleDeleteMTMCatalogEntry(c echo.Context) error {
+	mtm, err := url.PathUnescape(c.Param("mtm"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid MTM.")
+	}
+
+	if err := h.Repo.DeleteMTMCatalogEntry(c.Request().Context(), mtm); err != nil {
+		log.Printf("Error deleting MTM %s: %v", mtm, err)
+		return c.String(http.StatusInternalServerError, "Failed to delete the MTM.")
+	}
+
+	return c.Redirect(http.StatusFound, "/admin/mtm-catalog")
+}
+
+// HandleImportManifest registers the machines of a vendor shipment manifest (CSV or XLSX)
+// and shows which rows were imported and which need attention.
+func (h *AdminHandler) HandleImportManifest(c echo.Context) error {
+	file, err := c.FormFile("manifest")
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Failed to get the file.")
+	}
+	src, err := file.Open()
+	if err != nil {
+		return c.String(http.StatusInternalServerError, "Failed to open the file.")
+	}
+	defer src.Close()
+
+	props := view.ManifestImportPageProps{FileName: file.Filename}
+
+	rows, err := service.ReadSpreadsheet(file.Filename, src)
+	if err != nil {
+		props.ErrorMsg = err.Error()
+		return render(c, http.StatusBadRequest, view.ManifestImportPage(props))
+	}
+
+	result, err := h.ManifestSvc.Import(c.Request().Context(), rows)
+	if err != nil {
+		log.Printf("Error importing manifest %s: %v", file.Filename, err)
+		props.ErrorMsg = err.Error()
+		return render(c, http.StatusBadRequest, view.ManifestImportPage(props))
+	}
+	props.Received, props.Updated, props.UnknownMTMs = result.Received, result.Updated, result.UnknownMTMs
+	for _, rowErr := range result.Errors {
+		props.Errors = append(props.Errors, view.ManifestImportError(rowErr))
+	}
+
+	log.Printf("Imported manifest %s: %d received, %d updated, %d rows with errors", file.Filename, result.Received, result.Updated, len(result.Errors))
+	return render(c, http.StatusOK, view.ManifestImportPage(props))
+}
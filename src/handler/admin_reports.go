@@ -0,0 +1,228 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"alc/model"
+	"alc/repository"
+	"alc/service"
+	"alc/view"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+)
+
+// renderReportSubscriptions renders the subscription list, optionally with an error from
+// the create form.
+func (h *AdminHandler) renderReportSubscriptions(c echo.Context, statusCode int, errorMsg string) error {
+	ctx := c.Request().Context()
+	subs, err := h.Repo.ListReportSubscriptions(ctx)
+	if err != nil {
+		log.Printf("Error listing report subscriptions: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to load report subscriptions.")
+	}
+	technicians, err := h.Repo.ListAppUsers(ctx)
+	if err != nil {
+		log.Printf("Error listing technicians for report subscriptions: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to load report subscriptions.")
+	}
+	societySites, err := h.Repo.ListSocietySites(ctx)
+	if err != nil {
+		log.Printf("Error listing society sites for report subscriptions: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to load report subscriptions.")
+	}
+
+	return render(c, statusCode, view.ReportSubscriptionsPage(view.ReportSubscriptionsPageProps{
+		Subscriptions: subs,
+		Filters:       reportFilters(technicians, societySites),
+		ErrorMsg:      errorMsg,
+	}))
+}
+
+func (h *AdminHandler) ShowReportSubscriptions(c echo.Context) error {
+	return h.renderReportSubscriptions(c, http.StatusOK, "")
+}
+
+// formInt reads an integer form value within [min, max].
+func formInt(c echo.Context, name string, min, max int) (int, bool) {
+	v, err := strconv.Atoi(c.FormValue(name))
+	return v, err == nil && v >= min && v <= max
+}
+
+func (h *AdminHandler) HandleCreateReportSubscription(c echo.Context) error {
+	user, ok := c.Get("user").(model.AuthenticatedUser)
+	if !ok {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+
+	name := strings.TrimSpace(c.FormValue("name"))
+	if name == "" {
+		return h.renderReportSubscriptions(c, http.StatusBadRequest, "The name is required.")
+	}
+
+	var recipients []string
+	for _, r := range strings.FieldsFunc(c.FormValue("recipients"), func(r rune) bool { return r == ',' || r == ';' || r == '\n' || r == ' ' }) {
+		addr, err := mail.ParseAddress(r)
+		if err != nil {
+			return h.renderReportSubscriptions(c, http.StatusBadRequest, fmt.Sprintf("Invalid recipient %q.", r))
+		}
+		recipients = append(recipients, strings.ToLower(addr.Address))
+	}
+	if len(recipients) == 0 {
+		return h.renderReportSubscriptions(c, http.StatusBadRequest, "Add at least one recipient.")
+	}
+
+	frequency := repository.ReportFrequency(c.FormValue("frequency"))
+	if !slices.Contains(repository.AllReportFrequencyValues(), frequency) {
+		return h.renderReportSubscriptions(c, http.StatusBadRequest, "Invalid frequency.")
+	}
+	hour, ok := formInt(c, "send_hour", 0, 23)
+	if !ok {
+		return h.renderReportSubscriptions(c, http.StatusBadRequest, "The hour must be between 0 and 23.")
+	}
+	weekday, ok := formInt(c, "weekday", 0, 6)
+	if !ok {
+		weekday = 1
+	}
+	monthDay, ok := formInt(c, "month_day", 1, 28)
+	if !ok {
+		monthDay = 1
+	}
+	periodDays, ok := formInt(c, "period_days", 0, 3660)
+	if !ok {
+		return h.renderReportSubscriptions(c, http.StatusBadRequest, "Invalid period.")
+	}
+	format := c.FormValue("format")
+	if format != service.ReportFormatCSV {
+		format = service.ReportFormatXLSX
+	}
+
+	filters, err := parseReportFilters(c)
+	if err != nil {
+		return h.renderReportSubscriptions(c, http.StatusBadRequest, "Invalid report filter: "+err.Error())
+	}
+	form, _ := c.FormParams()
+	var columns []string
+	if selected := service.SelectReportColumns(form["col"]); len(selected) < len(service.CertificateReportColumns) {
+		for _, i := range selected {
+			columns = append(columns, service.CertificateReportColumns[i].Key)
+		}
+	}
+
+	sub, err := h.Repo.CreateReportSubscription(c.Request().Context(), repository.CreateReportSubscriptionParams{
+		Name:         name,
+		Recipients:   recipients,
+		Frequency:    frequency,
+		SendHour:     int32(hour),
+		Weekday:      int32(weekday),
+		MonthDay:     int32(monthDay),
+		Format:       format,
+		PeriodDays:   int32(periodDays),
+		Status:       filters.Status,
+		TechnicianID: filters.TechnicianID,
+		Society:      filters.Society,
+		Site:         filters.Site,
+		Columns:      columns,
+		NextRunAt:    pgtype.Timestamptz{Time: service.NextReportRun(frequency, hour, weekday, monthDay, time.Now()), Valid: true},
+		CreatedBy:    pgtype.UUID{Bytes: user.ID, Valid: true},
+	})
+	if err != nil {
+		log.Printf("Error creating report subscription %s: %v", name, err)
+		return h.renderReportSubscriptions(c, http.StatusInternalServerError, "Could not create the subscription.")
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/admin/reports/%d", sub.SubscriptionID))
+}
+
+// getReportSubscription loads the subscription of the :id route parameter, answering the
+// request itself when it cannot.
+func (h *AdminHandler) getReportSubscription(c echo.Context) (repository.ReportSubscription, bool, error) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 32)
+	if err != nil {
+		return repository.ReportSubscription{}, false, c.String(http.StatusBadRequest, "Invalid subscription ID.")
+	}
+	sub, err := h.Repo.GetReportSubscription(c.Request().Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return sub, false, c.String(http.StatusNotFound, "Subscription not found.")
+		}
+		log.Printf("Error fetching report subscription %d: %v", id, err)
+		return sub, false, c.String(http.StatusInternalServerError, "Failed to load subscription.")
+	}
+	return sub, true, nil
+}
+
+// ShowReportSubscription shows the settings of a subscription and its latest runs.
+func (h *AdminHandler) ShowReportSubscription(c echo.Context) error {
+	sub, ok, err := h.getReportSubscription(c)
+	if !ok {
+		return err
+	}
+
+	runs, err := h.Repo.ListReportRuns(c.Request().Context(), sub.SubscriptionID)
+	if err != nil {
+		log.Printf("Error listing runs of report subscription %d: %v", sub.SubscriptionID, err)
+		return c.String(http.StatusInternalServerError, "Failed to load subscription runs.")
+	}
+
+	return render(c, http.StatusOK, view.ReportSubscriptionPage(view.ReportSubscriptionPageProps{
+		Subscription: sub,
+		Runs:         runs,
+	}))
+}
+
+// HandleSetReportSubscriptionActive pauses or resumes a subscription. A resumed
+// subscription is next sent at its following scheduled time, not for the runs it missed.
+func (h *AdminHandler) HandleSetReportSubscriptionActive(c echo.Context) error {
+	sub, ok, err := h.getReportSubscription(c)
+	if !ok {
+		return err
+	}
+
+	if err := h.Repo.SetReportSubscriptionActive(c.Request().Context(), repository.SetReportSubscriptionActiveParams{
+		SubscriptionID: sub.SubscriptionID,
+		IsActive:       c.FormValue("active") == "true",
+		NextRunAt:      pgtype.Timestamptz{Time: service.SubscriptionNextRun(sub, time.Now()), Valid: true},
+	}); err != nil {
+		log.Printf("Error updating report subscription %d: %v", sub.SubscriptionID, err)
+		return c.String(http.StatusInternalServerError, "Failed to update subscription.")
+	}
+
+	return c.Redirect(http.StatusFound, "/admin/reports")
+}
+
+// HandleSendReportSubscription sends the report right away, without changing the schedule.
+// The outcome appears in the run log.
+func (h *AdminHandler) HandleSendReportSubscription(c echo.Context) error {
+	sub, ok, err := h.getReportSubscription(c)
+	if !ok {
+		return err
+	}
+
+	if _, err := h.ReportScheduleSvc.Send(c.Request().Context(), sub); err != nil {
+		log.Printf("Error sending report subscription %d: %v", sub.SubscriptionID, err)
+	}
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/admin/reports/%d", sub.SubscriptionID))
+}
+
+func (h *AdminHandler) HandleDeleteReportSubscription(c echo.Context) error {
+	sub, ok, err := h.getReportSubscription(c)
+	if !ok {
+		return err
+	}
+
+	if err := h.Repo.DeleteReportSubscription(c.Request().Context(), sub.SubscriptionID); err != nil {
+		log.Printf("Error deleting report subscription %d: %v", sub.SubscriptionID, err)
+		return c.String(http.StatusInternalServerError, "Failed to delete subscription.")
+	}
+	return c.Redirect(http.StatusFound, "/admin/reports")
+}
@@ -0,0 +1,309 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"alc/model"
+	"alc/repository"
+	"alc/service"
+	"alc/view"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+)
+
+// validateAppUserFields returns a validation message, or an empty string if the fields are valid.
+func validateAppUserFields(name, email, dni string, role repository.UserRole) string {
+	if name == "" || email == "" || dni == "" {
+		return "Name, email and DNI are required."
+	}
+	if _, err := mail.ParseAddress(email); err != nil {
+		return fmt.Sprintf("The email %s is not valid.", email)
+	}
+	switch role {
+	case repository.UserRoleADMIN, repository.UserRoleSUPERVISOR, repository.UserRoleTECNICO:
+	default:
+		return "Invalid role specified."
+	}
+	return ""
+}
+
+// renderUserDetail loads the user with their certificate counts and renders the detail page.
+func (h *AdminHandler) renderUserDetail(c echo.Context, statusCode int, userID uuid.UUID, errorMsg string) error {
+	ctx := c.Request().Context()
+	pgxUserID := pgtype.UUID{Bytes: userID, Valid: true}
+
+	user, err := h.Repo.GetAppUserByID(ctx, pgxUserID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return c.String(http.StatusNotFound, "User not found.")
+		}
+		return c.String(http.StatusInternalServerError, "Database error.")
+	}
+	// A deleted user only remains for the certificates they signed
+	if user.DeletedAt.Valid {
+		return c.String(http.StatusNotFound, "User not found.")
+	}
+
+	counts, err := h.Repo.GetAppUserCertificateCounts(ctx, pgxUserID)
+	if err != nil {
+		log.Printf("Error getting certificate counts for user %s: %v", userID, err)
+		// Non-critical error, can still render the page
+	}
+
+	props := view.UserDetailPageProps{
+		User:     user,
+		Counts:   counts,
+		ErrorMsg: errorMsg,
+	}
+
+	if user.Role == repository.UserRoleSUPERVISOR {
+		if props.Scopes, err = h.Repo.ListAppUserScopes(ctx, pgxUserID); err != nil {
+			log.Printf("Error getting scopes for user %s: %v", userID, err)
+			return c.String(http.StatusInternalServerError, "Database error.")
+		}
+		if props.SocietySites, err = h.Repo.ListSocietySites(ctx); err != nil {
+			log.Printf("Error getting societies and sites: %v", err)
+			return c.String(http.StatusInternalServerError, "Database error.")
+		}
+	}
+
+	return render(c, statusCode, view.UserDetailPage(props))
+}
+
+// ShowUserDetail displays the edit form, status actions and certificate counts of an app user.
+func (h *AdminHandler) ShowUserDetail(c echo.Context) error {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid user ID.")
+	}
+	return h.renderUserDetail(c, http.StatusOK, userID, "")
+}
+
+// canRemoveAdmin reports whether the target can stop being an active admin
+// without leaving the application without any.
+func (h *AdminHandler) canRemoveAdmin(c echo.Context, target repository.AppUser) (bool, error) {
+	if target.Role != repository.UserRoleADMIN || !target.IsActive {
+		return true, nil
+	}
+	count, err := h.Repo.CountActiveAdmins(c.Request().Context())
+	if err != nil {
+		return false, err
+	}
+	return count > 1, nil
+}
+
+// HandleUpdateUser edits name, email, DNI and role of an app user.
+func (h *AdminHandler) HandleUpdateUser(c echo.Context) error {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid user ID.")
+	}
+
+	ctx := c.Request().Context()
+	pgxUserID := pgtype.UUID{Bytes: userID, Valid: true}
+
+	target, err := h.Repo.GetAppUserByID(ctx, pgxUserID)
+	if err != nil || target.DeletedAt.Valid {
+		return c.String(http.StatusNotFound, "User not found.")
+	}
+
+	params := repository.UpdateAppUserParams{
+		UserID: pgxUserID,
+		Name:   strings.TrimSpace(c.FormValue("name")),
+		Email:  strings.ToLower(strings.TrimSpace(c.FormValue("email"))),
+		Dni:    strings.TrimSpace(c.FormValue("dni")),
+		Role:   repository.UserRole(c.FormValue("role")),
+	}
+
+	if msg := validateAppUserFields(params.Name, params.Email, params.Dni, params.Role); msg != "" {
+		return h.renderUserDetail(c, http.StatusBadRequest, userID, msg)
+	}
+
+	if params.Role != target.Role {
+		ok, err := h.canRemoveAdmin(c, target)
+		if err != nil {
+			return c.String(http.StatusInternalServerError, "Database error.")
+		}
+		if !ok {
+			return h.renderUserDetail(c, http.StatusBadRequest, userID, "The last active admin cannot change role.")
+		}
+	}
+
+	if _, err := h.Repo.UpdateAppUser(ctx, params); err != nil {
+		if isUniqueViolation(err) {
+			return h.renderUserDetail(c, http.StatusConflict, userID, fmt.Sprintf("A user with email %s already exists.", params.Email))
+		}
+		log.Printf("Error updating user %s: %v", userID, err)
+		return c.String(http.StatusInternalServerError, "Failed to update user.")
+	}
+
+	return c.Redirect(http.StatusFound, "/admin/users/"+userID.String())
+}
+
+// HandleSetUserActive enables or disables an app user. Disabled users cannot log in
+// and their open sessions are closed.
+func (h *AdminHandler) HandleSetUserActive(c echo.Context) error {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid user ID.")
+	}
+
+	current, _ := c.Get("user").(model.AuthenticatedUser)
+	active := c.FormValue("active") == "true"
+	if !active && current.ID == userID {
+		return h.renderUserDetail(c, http.StatusBadRequest, userID, "You cannot disable your own account.")
+	}
+
+	ctx := c.Request().Context()
+	pgxUserID := pgtype.UUID{Bytes: userID, Valid: true}
+
+	target, err := h.Repo.GetAppUserByID(ctx, pgxUserID)
+	if err != nil || target.DeletedAt.Valid {
+		return c.String(http.StatusNotFound, "User not found.")
+	}
+
+	if !active {
+		ok, err := h.canRemoveAdmin(c, target)
+		if err != nil {
+			return c.String(http.StatusInternalServerError, "Database error.")
+		}
+		if !ok {
+			return h.renderUserDetail(c, http.StatusBadRequest, userID, "The last active admin cannot be disabled.")
+		}
+	}
+
+	if err := h.Repo.SetAppUserActive(ctx, repository.SetAppUserActiveParams{UserID: pgxUserID, IsActive: active}); err != nil {
+		log.Printf("Error setting active=%t for user %s: %v", active, userID, err)
+		return c.String(http.StatusInternalServerError, "Failed to update user.")
+	}
+	if !active {
+		if err := h.Repo.DeleteAppSessionsByUser(ctx, pgxUserID); err != nil {
+			log.Printf("Error deleting sessions of disabled user %s: %v", userID, err)
+		}
+	}
+
+	return c.Redirect(http.StatusFound, "/admin/users/"+userID.String())
+}
+
+// HandleDeleteUser soft deletes an app user. The row is kept so the certificates
+// created by the user still reference it.
+func (h *AdminHandler) HandleDeleteUser(c echo.Context) error {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid user ID.")
+	}
+
+	current, _ := c.Get("user").(model.AuthenticatedUser)
+	if current.ID == userID {
+		return h.renderUserDetail(c, http.StatusBadRequest, userID, "You cannot delete your own account.")
+	}
+
+	ctx := c.Request().Context()
+	pgxUserID := pgtype.UUID{Bytes: userID, Valid: true}
+
+	target, err := h.Repo.GetAppUserByID(ctx, pgxUserID)
+	if err != nil || target.DeletedAt.Valid {
+		return c.String(http.StatusNotFound, "User not found.")
+	}
+
+	ok, err := h.canRemoveAdmin(c, target)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, "Database error.")
+	}
+	if !ok {
+		return h.renderUserDetail(c, http.StatusBadRequest, userID, "The last active admin cannot be deleted.")
+	}
+
+	if err := h.Repo.SoftDeleteAppUser(ctx, pgxUserID); err != nil {
+		log.Printf("Error deleting user %s: %v", userID, err)
+		return c.String(http.StatusInternalServerError, "Failed to delete user.")
+	}
+	if err := h.Repo.DeleteAppSessionsByUser(ctx, pgxUserID); err != nil {
+		log.Printf("Error deleting sessions of deleted user %s: %v", userID, err)
+	}
+
+	return c.Redirect(http.StatusFound, "/admin")
+}
+
+// HandleForcePasswordReset invalidates the user's password and sessions and emails a reset link.
+func (h *AdminHandler) HandleForcePasswordReset(c echo.Context) error {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid user ID.")
+	}
+
+	if err := h.AccountSvc.ForcePasswordReset(c.Request().Context(), userID); err != nil {
+		if errors.Is(err, service.ErrExternalAccount) {
+			return h.renderUserDetail(c, http.StatusConflict, userID, "This user signs in through the directory or single sign-on. Reset the password there.")
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			return c.String(http.StatusNotFound, "User not found.")
+		}
+		log.Printf("Error forcing password reset for user %s: %v", userID, err)
+		return c.String(http.StatusInternalServerError, "Failed to reset password.")
+	}
+
+	return c.Redirect(http.StatusFound, "/admin/users/"+userID.String())
+}
+
+// HandleAddUserScope gives a supervisor visibility over a society, or a single site of it.
+// The form value is "society|site", with an empty site meaning the whole society.
+func (h *AdminHandler) HandleAddUserScope(c echo.Context) error {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid user ID.")
+	}
+
+	society, site, _ := strings.Cut(c.FormValue("scope"), "|")
+	society, site = strings.TrimSpace(society), strings.TrimSpace(site)
+	if society == "" {
+		return h.renderUserDetail(c, http.StatusBadRequest, userID, "A society is required.")
+	}
+
+	_, err = h.Repo.AddAppUserScope(c.Request().Context(), repository.AddAppUserScopeParams{
+		UserID:  pgtype.UUID{Bytes: userID, Valid: true},
+		Society: society,
+		Site:    site,
+	})
+	if err != nil {
+		if isUniqueViolation(err) {
+			return h.renderUserDetail(c, http.StatusConflict, userID, "The user already has this scope.")
+		}
+		log.Printf("Error adding scope %s/%s to user %s: %v", society, site, userID, err)
+		return c.String(http.StatusInternalServerError, "Failed to add scope.")
+	}
+
+	return c.Redirect(http.StatusFound, "/admin/users/"+userID.String())
+}
+
+// HandleDeleteUserScope removes a scope from a supervisor.
+func (h *AdminHandler) HandleDeleteUserScope(c echo.Context) error {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid user ID.")
+	}
+	scopeID, err := strconv.Atoi(c.Param("scopeID"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid scope ID.")
+	}
+
+	err = h.Repo.DeleteAppUserScope(c.Request().Context(), repository.DeleteAppUserScopeParams{
+		ScopeID: int32(scopeID),
+		UserID:  pgtype.UUID{Bytes: userID, Valid: true},
+	})
+	if err != nil {
+		log.Printf("Error deleting scope %d of user %s: %v", scopeID, userID, err)
+		return c.String(http.StatusInternalServerError, "Failed to remove scope.")
+	}
+
+	return c.Redirect(http.StatusFound, "/admin/users/"+userID.String())
+}
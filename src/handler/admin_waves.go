@@ -0,0 +1,336 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"alc/model"
+	"alc/repository"
+	"alc/view"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+)
+
+// renderRolloutWaves renders the wave list, optionally with an error from the create form.
+func (h *AdminHandler) renderRolloutWaves(c echo.Context, statusCode int, errorMsg string) error {
+	waves, err := h.Repo.ListRolloutWaveProgress(c.Request().Context(), repository.ListRolloutWaveProgressParams{})
+	if err != nil {
+		log.Printf("Error listing rollout waves: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to load rollout waves.")
+	}
+
+	return render(c, statusCode, view.RolloutWavesPage(view.RolloutWavesPageProps{
+		Waves:    waves,
+		ErrorMsg: errorMsg,
+	}))
+}
+
+func (h *AdminHandler) ShowRolloutWaves(c echo.Context) error {
+	return h.renderRolloutWaves(c, http.StatusOK, "")
+}
+
+// parseRolloutWaveForm reads the fields shared by the create and edit forms of a wave.
+func parseRolloutWaveForm(c echo.Context) (name, description string, targetDate pgtype.Date, errorMsg string) {
+	name = strings.TrimSpace(c.FormValue("name"))
+	description = strings.TrimSpace(c.FormValue("description"))
+	if name == "" {
+		return name, description, targetDate, "The name is required."
+	}
+	t, err := time.Parse("2006-01-02", c.FormValue("target_date"))
+	if err != nil {
+		return name, description, targetDate, "The target date is required."
+	}
+	return name, description, pgtype.Date{Time: t, Valid: true}, ""
+}
+
+func (h *AdminHandler) HandleCreateRolloutWave(c echo.Context) error {
+	user, ok := c.Get("user").(model.AuthenticatedUser)
+	if !ok {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+
+	name, description, targetDate, errorMsg := parseRolloutWaveForm(c)
+	if errorMsg != "" {
+		return h.renderRolloutWaves(c, http.StatusBadRequest, errorMsg)
+	}
+
+	wave, err := h.Repo.CreateRolloutWave(c.Request().Context(), repository.CreateRolloutWaveParams{
+		Name:        name,
+		Description: description,
+		TargetDate:  targetDate,
+		CreatedBy:   pgtype.UUID{Bytes: user.ID, Valid: true},
+	})
+	if err != nil {
+		if isUniqueViolation(err) {
+			return h.renderRolloutWaves(c, http.StatusConflict, fmt.Sprintf("A wave named %q already exists.", name))
+		}
+		log.Printf("Error creating rollout wave %s: %v", name, err)
+		return h.renderRolloutWaves(c, http.StatusInternalServerError, "Could not create the wave.")
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/admin/waves/%d", wave.WaveID))
+}
+
+// getRolloutWave loads the wave of the :id route parameter, answering the request itself
+// when it cannot.
+func (h *AdminHandler) getRolloutWave(c echo.Context) (repository.RolloutWave, bool, error) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 32)
+	if err != nil {
+		return repository.RolloutWave{}, false, c.String(http.StatusBadRequest, "Invalid wave ID.")
+	}
+	wave, err := h.Repo.GetRolloutWave(c.Request().Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return wave, false, c.String(http.StatusNotFound, "Wave not found.")
+		}
+		log.Printf("Error fetching rollout wave %d: %v", id, err)
+		return wave, false, c.String(http.StatusInternalServerError, "Failed to load wave.")
+	}
+	return wave, true, nil
+}
+
+// renderRolloutWave renders the page of a wave with its members. notice reports the
+// outcome of adding members and errorMsg the error of a form.
+func (h *AdminHandler) renderRolloutWave(c echo.Context, statusCode int, wave repository.RolloutWave, notice, errorMsg string) error {
+	ctx := c.Request().Context()
+	members, err := h.Repo.ListRolloutWaveMembers(ctx, wave.WaveID)
+	if err != nil {
+		log.Printf("Error listing members of rollout wave %d: %v", wave.WaveID, err)
+		return c.String(http.StatusInternalServerError, "Failed to load wave.")
+	}
+	users, err := h.Repo.ListAppUsers(ctx)
+	if err != nil {
+		log.Printf("Error listing technicians for rollout wave %d: %v", wave.WaveID, err)
+		return c.String(http.StatusInternalServerError, "Failed to load wave.")
+	}
+	societySites, err := h.Repo.ListSocietySites(ctx)
+	if err != nil {
+		log.Printf("Error listing society sites for rollout wave %d: %v", wave.WaveID, err)
+		return c.String(http.StatusInternalServerError, "Failed to load wave.")
+	}
+
+	// Only active users who can create certificates can be assigned work
+	var technicians []repository.AppUser
+	for _, u := range users {
+		if u.IsActive && model.RoleCan(u.Role, model.PermCreateCertificates) {
+			technicians = append(technicians, u)
+		}
+	}
+
+	return render(c, statusCode, view.RolloutWavePage(view.RolloutWavePageProps{
+		Wave:         wave,
+		Members:      members,
+		Technicians:  technicians,
+		SocietySites: societySites,
+		Notice:       notice,
+		ErrorMsg:     errorMsg,
+	}))
+}
+
+// ShowRolloutWave shows a wave with its members. After adding members, the added and
+// skipped query parameters report the outcome.
+func (h *AdminHandler) ShowRolloutWave(c echo.Context) error {
+	wave, ok, err := h.getRolloutWave(c)
+	if !ok {
+		return err
+	}
+
+	var notice string
+	if added := c.QueryParam("added"); added != "" {
+		notice = fmt.Sprintf("%s machine users added to the wave.", added)
+		if skipped := c.QueryParam("skipped"); skipped != "" {
+			notice += " Not found, inactive or already planned in a wave: " + skipped + "."
+		}
+	}
+	return h.renderRolloutWave(c, http.StatusOK, wave, notice, "")
+}
+
+func (h *AdminHandler) HandleUpdateRolloutWave(c echo.Context) error {
+	wave, ok, err := h.getRolloutWave(c)
+	if !ok {
+		return err
+	}
+
+	name, description, targetDate, errorMsg := parseRolloutWaveForm(c)
+	if errorMsg != "" {
+		return h.renderRolloutWave(c, http.StatusBadRequest, wave, "", errorMsg)
+	}
+	if err := h.Repo.UpdateRolloutWave(c.Request().Context(), repository.UpdateRolloutWaveParams{
+		WaveID:      wave.WaveID,
+		Name:        name,
+		Description: description,
+		TargetDate:  targetDate,
+	}); err != nil {
+		if isUniqueViolation(err) {
+			return h.renderRolloutWave(c, http.StatusConflict, wave, "", fmt.Sprintf("A wave named %q already exists.", name))
+		}
+		log.Printf("Error updating rollout wave %d: %v", wave.WaveID, err)
+		return c.String(http.StatusInternalServerError, "Failed to update wave.")
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/admin/waves/%d", wave.WaveID))
+}
+
+func (h *AdminHandler) HandleDeleteRolloutWave(c echo.Context) error {
+	wave, ok, err := h.getRolloutWave(c)
+	if !ok {
+		return err
+	}
+
+	if err := h.Repo.DeleteRolloutWave(c.Request().Context(), wave.WaveID); err != nil {
+		log.Printf("Error deleting rollout wave %d: %v", wave.WaveID, err)
+		return c.String(http.StatusInternalServerError, "Failed to delete wave.")
+	}
+	return c.Redirect(http.StatusFound, "/admin/waves")
+}
+
+// formTechnician reads the technician select of the member forms, where "" means
+// unassigned.
+func formTechnician(c echo.Context) (pgtype.UUID, error) {
+	v := c.FormValue("technician")
+	if v == "" {
+		return pgtype.UUID{}, nil
+	}
+	id, err := uuid.Parse(v)
+	if err != nil {
+		return pgtype.UUID{}, err
+	}
+	return pgtype.UUID{Bytes: id, Valid: true}, nil
+}
+
+// HandleAddRolloutWaveLocation plans every machine user of a society, site, area and floor
+// that is not planned yet.
+func (h *AdminHandler) HandleAddRolloutWaveLocation(c echo.Context) error {
+	wave, ok, err := h.getRolloutWave(c)
+	if !ok {
+		return err
+	}
+
+	technician, err := formTechnician(c)
+	if err != nil {
+		return h.renderRolloutWave(c, http.StatusBadRequest, wave, "", "Invalid technician.")
+	}
+	// The society and site come from a single select of the known pairs
+	society, site, _ := strings.Cut(c.FormValue("location"), "|")
+	if society == "" {
+		return h.renderRolloutWave(c, http.StatusBadRequest, wave, "", "Select a society or site.")
+	}
+
+	added, err := h.Repo.AddRolloutWaveMembersByLocation(c.Request().Context(), repository.AddRolloutWaveMembersByLocationParams{
+		WaveID:       wave.WaveID,
+		TechnicianID: technician,
+		Society:      society,
+		Site:         site,
+		Area:         strings.TrimSpace(c.FormValue("area")),
+		FloorName:    strings.TrimSpace(c.FormValue("floor")),
+	})
+	if err != nil {
+		log.Printf("Error adding machine users of %s %s to rollout wave %d: %v", society, site, wave.WaveID, err)
+		return h.renderRolloutWave(c, http.StatusInternalServerError, wave, "", "Could not add the machine users.")
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/admin/waves/%d?added=%d", wave.WaveID, added))
+}
+
+// HandleAddRolloutWaveList plans the machine users of a pasted list, one per line, given by
+// DNI or personal code and optionally followed by the code of the device to replace.
+func (h *AdminHandler) HandleAddRolloutWaveList(c echo.Context) error {
+	wave, ok, err := h.getRolloutWave(c)
+	if !ok {
+		return err
+	}
+
+	technician, err := formTechnician(c)
+	if err != nil {
+		return h.renderRolloutWave(c, http.StatusBadRequest, wave, "", "Invalid technician.")
+	}
+
+	ctx := c.Request().Context()
+	var added int64
+	var skipped []string
+	for _, line := range strings.Split(c.FormValue("members"), "\n") {
+		fields := strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ';' || r == '\t' })
+		if len(fields) == 0 || strings.TrimSpace(fields[0]) == "" {
+			continue
+		}
+		code := strings.ReplaceAll(fields[0], " ", "")
+		var oldDevice string
+		if len(fields) > 1 {
+			oldDevice = strings.TrimSpace(fields[1])
+		}
+
+		n, err := h.Repo.AddRolloutWaveMember(ctx, repository.AddRolloutWaveMemberParams{
+			WaveID:        wave.WaveID,
+			OldDeviceCode: oldDevice,
+			TechnicianID:  technician,
+			Code:          code,
+		})
+		if err != nil {
+			log.Printf("Error adding machine user %s to rollout wave %d: %v", code, wave.WaveID, err)
+			return h.renderRolloutWave(c, http.StatusInternalServerError, wave, "", "Could not add the machine users.")
+		}
+		if n == 0 {
+			skipped = append(skipped, code)
+		}
+		added += n
+	}
+
+	target := fmt.Sprintf("/admin/waves/%d?added=%d", wave.WaveID, added)
+	if len(skipped) > 0 {
+		target += "&skipped=" + url.QueryEscape(strings.Join(skipped, ", "))
+	}
+	return c.Redirect(http.StatusFound, target)
+}
+
+// HandleUpdateRolloutWaveMember changes the device to replace and the technician of a
+// planned machine user.
+func (h *AdminHandler) HandleUpdateRolloutWaveMember(c echo.Context) error {
+	wave, ok, err := h.getRolloutWave(c)
+	if !ok {
+		return err
+	}
+
+	technician, err := formTechnician(c)
+	if err != nil {
+		return h.renderRolloutWave(c, http.StatusBadRequest, wave, "", "Invalid technician.")
+	}
+	dni := c.Param("dni")
+	if err := h.Repo.UpdateRolloutWaveMember(c.Request().Context(), repository.UpdateRolloutWaveMemberParams{
+		WaveID:         wave.WaveID,
+		MachineUserDni: dni,
+		OldDeviceCode:  strings.TrimSpace(c.FormValue("old_device_code")),
+		TechnicianID:   technician,
+	}); err != nil {
+		log.Printf("Error updating machine user %s of rollout wave %d: %v", dni, wave.WaveID, err)
+		return c.String(http.StatusInternalServerError, "Failed to update the machine user.")
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/admin/waves/%d", wave.WaveID))
+}
+
+func (h *AdminHandler) HandleRemoveRolloutWaveMember(c echo.Context) error {
+	wave, ok, err := h.getRolloutWave(c)
+	if !ok {
+		return err
+	}
+
+	dni := c.Param("dni")
+	if err := h.Repo.RemoveRolloutWaveMember(c.Request().Context(), repository.RemoveRolloutWaveMemberParams{
+		WaveID:         wave.WaveID,
+		MachineUserDni: dni,
+	}); err != nil {
+		log.Printf("Error removing machine user %s from rollout wave %d: %v", dni, wave.WaveID, err)
+		return c.String(http.StatusInternalServerError, "Failed to remove the machine user.")
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/admin/waves/%d", wave.WaveID))
+}
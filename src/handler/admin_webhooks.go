@@ -0,0 +1,189 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"alc/repository"
+	"alc/service"
+	"alc/view"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+)
+
+// renderWebhooks renders the endpoint list, optionally with an error from the create form.
+func (h *AdminHandler) renderWebhooks(c echo.Context, statusCode int, errorMsg string) error {
+	endpoints, err := h.Repo.ListWebhookEndpoints(c.Request().Context())
+	if err != nil {
+		log.Printf("Error listing webhook endpoints: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to load webhooks.")
+	}
+
+	events := make([]string, len(service.WebhookEvents))
+	for i, event := range service.WebhookEvents {
+		events[i] = string(event)
+	}
+	return render(c, statusCode, view.WebhooksPage(view.WebhooksPageProps{
+		Endpoints: endpoints,
+		Events:    events,
+		ErrorMsg:  errorMsg,
+	}))
+}
+
+func (h *AdminHandler) ShowWebhooks(c echo.Context) error {
+	return h.renderWebhooks(c, http.StatusOK, "")
+}
+
+func (h *AdminHandler) HandleCreateWebhook(c echo.Context) error {
+	form, err := c.FormParams()
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid form data.")
+	}
+
+	endpointURL := strings.TrimSpace(form.Get("url"))
+	if u, err := url.Parse(endpointURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return h.renderWebhooks(c, http.StatusBadRequest, "The URL must be an absolute http or https URL.")
+	}
+
+	var events []string
+	for _, event := range form["events"] {
+		if !slices.Contains(service.WebhookEvents, service.WebhookEvent(event)) {
+			return h.renderWebhooks(c, http.StatusBadRequest, fmt.Sprintf("Unknown event %q.", event))
+		}
+		events = append(events, event)
+	}
+	if len(events) == 0 {
+		return h.renderWebhooks(c, http.StatusBadRequest, "Select at least one event.")
+	}
+
+	secret, err := service.NewWebhookSecret()
+	if err != nil {
+		log.Printf("Error generating webhook secret: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to create webhook.")
+	}
+
+	endpoint, err := h.Repo.CreateWebhookEndpoint(c.Request().Context(), repository.CreateWebhookEndpointParams{
+		Url:         endpointURL,
+		Description: strings.TrimSpace(form.Get("description")),
+		Secret:      secret,
+		Events:      events,
+	})
+	if err != nil {
+		log.Printf("Error creating webhook endpoint %s: %v", endpointURL, err)
+		return h.renderWebhooks(c, http.StatusInternalServerError, "Could not create the webhook.")
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/admin/webhooks/%d", endpoint.EndpointID))
+}
+
+// parseEndpointID reads the :id route parameter of the webhook routes.
+func parseEndpointID(c echo.Context) (int32, error) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 32)
+	return int32(id), err
+}
+
+// ShowWebhookDetail shows the signing secret and the latest deliveries of an endpoint.
+func (h *AdminHandler) ShowWebhookDetail(c echo.Context) error {
+	id, err := parseEndpointID(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid webhook ID.")
+	}
+
+	ctx := c.Request().Context()
+	endpoint, err := h.Repo.GetWebhookEndpoint(ctx, id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return c.String(http.StatusNotFound, "Webhook not found.")
+		}
+		log.Printf("Error fetching webhook endpoint %d: %v", id, err)
+		return c.String(http.StatusInternalServerError, "Failed to load webhook.")
+	}
+
+	deliveries, err := h.Repo.ListWebhookDeliveries(ctx, id)
+	if err != nil {
+		log.Printf("Error listing deliveries of webhook endpoint %d: %v", id, err)
+		return c.String(http.StatusInternalServerError, "Failed to load webhook deliveries.")
+	}
+
+	return render(c, http.StatusOK, view.WebhookDetailPage(view.WebhookDetailPageProps{
+		Endpoint:   endpoint,
+		Deliveries: deliveries,
+	}))
+}
+
+func (h *AdminHandler) HandleSetWebhookActive(c echo.Context) error {
+	id, err := parseEndpointID(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid webhook ID.")
+	}
+
+	if err := h.Repo.SetWebhookEndpointActive(c.Request().Context(), repository.SetWebhookEndpointActiveParams{
+		EndpointID: id,
+		IsActive:   c.FormValue("active") == "true",
+	}); err != nil {
+		log.Printf("Error updating webhook endpoint %d: %v", id, err)
+		return c.String(http.StatusInternalServerError, "Failed to update webhook.")
+	}
+
+	return c.Redirect(http.StatusFound, "/admin/webhooks")
+}
+
+func (h *AdminHandler) HandleDeleteWebhook(c echo.Context) error {
+	id, err := parseEndpointID(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid webhook ID.")
+	}
+
+	if err := h.Repo.DeleteWebhookEndpoint(c.Request().Context(), id); err != nil {
+		log.Printf("Error deleting webhook endpoint %d: %v", id, err)
+		return c.String(http.StatusInternalServerError, "Failed to delete webhook.")
+	}
+
+	return c.Redirect(http.StatusFound, "/admin/webhooks")
+}
+
+// HandlePingWebhook queues a test delivery; its outcome appears in the delivery log.
+func (h *AdminHandler) HandlePingWebhook(c echo.Context) error {
+	id, err := parseEndpointID(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid webhook ID.")
+	}
+
+	if err := h.WebhookSvc.Ping(c.Request().Context(), id); err != nil {
+		log.Printf("Error queueing ping for webhook endpoint %d: %v", id, err)
+		return c.String(http.StatusInternalServerError, "Failed to send test delivery.")
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/admin/webhooks/%d", id))
+}
+
+// HandleRetryWebhookDelivery queues a FAILED delivery again with a fresh attempt.
+func (h *AdminHandler) HandleRetryWebhookDelivery(c echo.Context) error {
+	id, err := parseEndpointID(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid webhook ID.")
+	}
+	deliveryID, err := uuid.Parse(c.Param("deliveryID"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid delivery ID.")
+	}
+
+	if err := h.Repo.RetryWebhookDelivery(c.Request().Context(), repository.RetryWebhookDeliveryParams{
+		DeliveryID: pgtype.UUID{Bytes: deliveryID, Valid: true},
+		EndpointID: id,
+	}); err != nil {
+		log.Printf("Error retrying webhook delivery %s: %v", deliveryID, err)
+		return c.String(http.StatusInternalServerError, "Failed to retry delivery.")
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/admin/webhooks/%d", id))
+}
@@ -2,10 +2,13 @@ package handler
 
 import (
 	"context"
+	"errors"
+	"log"
 	"net/http"
 	"strings"
 
 	"alc/repository"
+	"alc/service"
 	"alc/view"
 
 	"github.com/jackc/pgx/v5"
@@ -14,27 +17,35 @@ import (
 
 type ApiHandler struct {
 	Repo *repository.Queries
+	// Tickets is nil when ticket validation is disabled
+	Tickets service.TicketProvider
 }
 
 func (h *ApiHandler) GetMachineUser(c echo.Context) error {
 	// NORMALIZE: Trim spaces from the DNI
 	dni := strings.ReplaceAll(c.QueryParam("machine_user_dni"), " ", "")
+	// The ticket field is part of the replaced fragment, so its value is carried over
+	ticketName := c.QueryParam("ticket_name")
 
 	if dni == "" {
 		// If the input is cleared, return the NotFound fragment to clear the form
-		return render(c, http.StatusOK, view.MachineUserNotFound())
+		return render(c, http.StatusOK, view.MachineUserNotFound(ticketName))
 	}
 
 	user, err := h.Repo.GetMachineUserByDNI(context.Background(), dni)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			// Return the NotFound fragment to clear fields and show a message
-			return render(c, http.StatusOK, view.MachineUserNotFound())
+			return render(c, http.StatusOK, view.MachineUserNotFound(ticketName))
 		}
 		return c.String(http.StatusInternalServerError, "Database error.")
 	}
+	if !user.Active {
+		// Machine users who left the company cannot receive devices
+		return render(c, http.StatusOK, view.MachineUserInactive(ticketName))
+	}
 
-	return render(c, http.StatusOK, view.MachineUserDetails(user))
+	return render(c, http.StatusOK, view.MachineUserDetails(user, ticketName))
 }
 
 func (h *ApiHandler) GetMachine(c echo.Context) error {
@@ -55,3 +66,37 @@ func (h *ApiHandler) GetMachine(c echo.Context) error {
 
 	return render(c, http.StatusOK, view.NewDeviceDetails(machine))
 }
+
+// GetTicket validates the ticket number against the ITSM system. The requester's DNI
+// and the device of the ticket are filled in only when those fields are still empty.
+func (h *ApiHandler) GetTicket(c echo.Context) error {
+	if h.Tickets == nil {
+		return render(c, http.StatusOK, view.TicketFeedback(nil, ""))
+	}
+
+	number := service.NormalizeTicketNumber(c.QueryParam("ticket_name"))
+	if number == "" {
+		return render(c, http.StatusOK, view.TicketFeedback(nil, ""))
+	}
+
+	ticket, err := h.Tickets.LookupTicket(c.Request().Context(), number)
+	if err != nil {
+		if errors.Is(err, service.ErrTicketNotFound) {
+			return render(c, http.StatusOK, view.TicketFeedback(nil, "Ticket no encontrado en "+h.Tickets.Name()+"."))
+		}
+		if errors.Is(err, service.ErrInvalidTicketNumber) {
+			return render(c, http.StatusOK, view.TicketFeedback(nil, "El número de ticket no es válido."))
+		}
+		log.Printf("Error looking up ticket %s: %v", number, err)
+		return render(c, http.StatusOK, view.TicketFeedback(nil, "No se pudo validar el ticket."))
+	}
+
+	props := view.TicketPrefill{State: ticket.State, URL: ticket.URL, Summary: ticket.Summary}
+	if strings.TrimSpace(c.QueryParam("machine_user_dni")) == "" {
+		props.Dni = ticket.RequesterDNI
+	}
+	if strings.TrimSpace(c.QueryParam("old_device_code")) == "" {
+		props.OldDeviceCode = ticket.DeviceCode
+	}
+	return render(c, http.StatusOK, view.TicketDetails(props))
+}
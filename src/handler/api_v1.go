@@ -0,0 +1,577 @@
+package handler
+
+import (
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"alc/model"
+	"alc/repository"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultAPIPageSize = 50
+	maxAPIPageSize     = 200
+)
+
+// ApiV1Handler serves the versioned JSON API used by integrations. Requests are
+// authenticated with personal access tokens and certificates and machine users
+// follow the same scopes as the token owner in the web app.
+type ApiV1Handler struct {
+	Repo *repository.Queries
+}
+
+// apiError writes the JSON error body used by every /api/v1 endpoint.
+func apiError(c echo.Context, status int, msg string) error {
+	return c.JSON(status, map[string]string{"error": msg})
+}
+
+// apiList is the envelope of the paginated list endpoints. NextCursor is empty on the last page.
+type apiList[T any] struct {
+	Data       []T    `json:"data"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// apiPage holds the decoded pagination parameters of a list request.
+type apiPage struct {
+	size  int
+	after string
+}
+
+// parseAPIPage reads "limit" and the opaque "cursor", which encodes the key of the
+// last item of the previous page.
+func parseAPIPage(c echo.Context) (apiPage, error) {
+	page := apiPage{size: defaultAPIPageSize}
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAPIPageSize {
+			return page, errors.New("limit must be between 1 and " + strconv.Itoa(maxAPIPageSize))
+		}
+		page.size = n
+	}
+	if v := c.QueryParam("cursor"); v != "" {
+		after, err := base64.RawURLEncoding.DecodeString(v)
+		if err != nil {
+			return page, errors.New("invalid cursor")
+		}
+		page.after = string(after)
+	}
+	return page, nil
+}
+
+// newAPIList trims the extra row fetched to detect a next page and builds the cursor from it.
+func newAPIList[R, T any](rows []R, page apiPage, key func(R) string, convert func(R) T) apiList[T] {
+	list := apiList[T]{Data: make([]T, 0, len(rows))}
+	if len(rows) > page.size {
+		rows = rows[:page.size]
+		list.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(key(rows[len(rows)-1])))
+	}
+	for _, row := range rows {
+		list.Data = append(list.Data, convert(row))
+	}
+	return list
+}
+
+// parseAPITime reads an optional RFC 3339 timestamp query parameter.
+func parseAPITime(c echo.Context, name string) (pgtype.Timestamptz, error) {
+	v := c.QueryParam(name)
+	if v == "" {
+		return pgtype.Timestamptz{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return pgtype.Timestamptz{}, errors.New(name + " must be an RFC 3339 timestamp")
+	}
+	return pgtype.Timestamptz{Time: t, Valid: true}, nil
+}
+
+func apiTime(t pgtype.Timestamptz) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// --- Resources ---
+
+type apiCertificate struct {
+	ID            int32                        `json:"id"`
+	TicketName    string                       `json:"ticket_name"`
+	TicketState   string                       `json:"ticket_state"`
+	TicketURL     string                       `json:"ticket_url"`
+	Status        repository.CertificateStatus `json:"status"`
+	ConfirmedAt   *time.Time                   `json:"confirmed_at"`
+	CreatedAt     time.Time                    `json:"created_at"`
+	UpdatedAt     time.Time                    `json:"updated_at"`
+	Technician    apiTechnician                `json:"technician"`
+	MachineUser   apiMachineUserRef            `json:"machine_user"`
+	NewDeviceCode string                       `json:"new_device_code"`
+	OldDeviceCode string                       `json:"old_device_code"`
+	DiskCSize     string                       `json:"disk_c_size"`
+	DiskDSize     string                       `json:"disk_d_size"`
+	PrinterName   string                       `json:"printer_name"`
+	PrinterIP     string                       `json:"printer_ip"`
+	PrinterTest   bool                         `json:"printer_test"`
+	Comments      string                       `json:"comments"`
+	NewDevice     *apiDevice                   `json:"new_device,omitempty"`
+}
+
+type apiTechnician struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type apiMachineUserRef struct {
+	Dni     string `json:"dni"`
+	Name    string `json:"name"`
+	Society string `json:"society"`
+	Site    string `json:"site"`
+}
+
+type apiMachineUser struct {
+	Dni          string `json:"dni"`
+	PersonalCode string `json:"personal_code"`
+	Name         string `json:"name"`
+	Email        string `json:"email"`
+	Society      string `json:"society"`
+	Site         string `json:"site"`
+	Area         string `json:"area"`
+	Floor        string `json:"floor"`
+}
+
+type apiMachine struct {
+	SerialNum  string                    `json:"serial_num"`
+	Type       repository.MachineType    `json:"type"`
+	Mtm        string                    `json:"mtm"`
+	Model      string                    `json:"model"`
+	PlateNum   string                    `json:"plate_num"`
+	DiskSize   string                    `json:"disk_size"`
+	MemorySize string                    `json:"memory_size"`
+	Processor  string                    `json:"processor"`
+	Profile    repository.MachineProfile `json:"profile"`
+	// Shipment details, set for machines registered from a vendor manifest
+	Pallet        string     `json:"pallet"`
+	PurchaseOrder string     `json:"purchase_order"`
+	ReceivedAt    *time.Time `json:"received_at"`
+}
+
+type apiDevice struct {
+	Code               string                  `json:"code"`
+	MachineSerialNum   string                  `json:"machine_serial_num"`
+	MachineType        repository.MachineType  `json:"machine_type"`
+	MachineModel       string                  `json:"machine_model"`
+	MachinePlateNum    string                  `json:"machine_plate_num"`
+	Type               repository.DeviceType   `json:"type"`
+	Hostname           string                  `json:"hostname"`
+	Status             repository.DeviceStatus `json:"status"`
+	AdditionalSoftware string                  `json:"additional_software"`
+	// The lists are only loaded when fetching a single device or certificate
+	Software      []string              `json:"software,omitempty"`
+	Configuration []string              `json:"configuration,omitempty"`
+	Peripherals   []apiDevicePeripheral `json:"peripherals,omitempty"`
+}
+
+type apiDevicePeripheral struct {
+	ID        int32  `json:"id"`
+	Name      string `json:"name"`
+	PlateNum  string `json:"plate_num"`
+	SerialNum string `json:"serial_num"`
+}
+
+type apiCatalogItem struct {
+	ID   int32  `json:"id"`
+	Name string `json:"name"`
+}
+
+type apiCatalogs struct {
+	Software           []apiCatalogItem `json:"software"`
+	Peripherals        []apiCatalogItem `json:"peripherals"`
+	ConfigurationItems []apiCatalogItem `json:"configuration_items"`
+}
+
+// toAPICertificate converts both the list and the single certificate rows, which share their columns.
+func toAPICertificate(row repository.APIListCertificatesRow) apiCertificate {
+	return apiCertificate{
+		ID:          row.CertificateID,
+		TicketName:  row.TicketName,
+		TicketState: row.TicketState,
+		TicketURL:   row.TicketUrl,
+		Status:      row.ConfirmationStatus,
+		ConfirmedAt: apiTime(row.ConfirmedAt),
+		CreatedAt:   row.CreatedAt.Time,
+		UpdatedAt:   row.UpdatedAt.Time,
+		Technician: apiTechnician{
+			ID:    row.AppUserID.String(),
+			Name:  row.TechnicianName,
+			Email: row.TechnicianEmail,
+		},
+		MachineUser: apiMachineUserRef{
+			Dni:     row.MachineUserDni,
+			Name:    row.MachineUserName,
+			Society: row.MachineUserSociety,
+			Site:    row.MachineUserSite,
+		},
+		NewDeviceCode: row.NewDeviceCode,
+		OldDeviceCode: row.OldDeviceCode,
+		DiskCSize:     row.DiskCSize,
+		DiskDSize:     row.DiskDSize,
+		PrinterName:   row.PrinterName,
+		PrinterIP:     row.PrinterIp,
+		PrinterTest:   row.PrinterTest,
+		Comments:      row.Comments,
+	}
+}
+
+func toAPIMachineUser(mu repository.MachineUser) apiMachineUser {
+	return apiMachineUser{
+		Dni:          mu.Dni,
+		PersonalCode: mu.PersonalCode,
+		Name:         mu.Name,
+		Email:        mu.Email,
+		Society:      mu.Society,
+		Site:         mu.Site,
+		Area:         mu.Area,
+		Floor:        mu.FloorName,
+	}
+}
+
+func toAPIMachine(m repository.Machine) apiMachine {
+	return apiMachine{
+		SerialNum:  m.SerialNum,
+		Type:       m.Type,
+		Mtm:        m.Mtm,
+		Model:      m.Model,
+		PlateNum:   m.PlateNum,
+		DiskSize:   m.DiskSize,
+		MemorySize: m.MemorySize,
+		Processor:  m.Processor,
+		Profile:    m.Profile,
+
+		Pallet:        m.Pallet,
+		PurchaseOrder: m.PurchaseOrder,
+		ReceivedAt:    apiTime(m.ReceivedAt),
+	}
+}
+
+// toAPIDevice converts both the list and the single device rows, which share their columns.
+func toAPIDevice(d repository.APIListDevicesRow) apiDevice {
+	return apiDevice{
+		Code:               d.DeviceCode,
+		MachineSerialNum:   d.MachineSerialNum,
+		MachineType:        d.MachineType,
+		MachineModel:       d.MachineModel,
+		MachinePlateNum:    d.MachinePlateNum,
+		Type:               d.Type,
+		Hostname:           d.Hostname,
+		Status:             d.Status,
+		AdditionalSoftware: d.AdditionalSoftware,
+	}
+}
+
+// loadDevice fetches a device with its software, configuration and peripherals.
+func (h *ApiV1Handler) loadDevice(c echo.Context, code string) (apiDevice, error) {
+	ctx := c.Request().Context()
+	row, err := h.Repo.APIGetDevice(ctx, code)
+	if err != nil {
+		return apiDevice{}, err
+	}
+	device := toAPIDevice(repository.APIListDevicesRow(row))
+
+	if device.Software, err = h.Repo.ListDeviceSoftwareNames(ctx, code); err != nil {
+		return apiDevice{}, err
+	}
+	if device.Configuration, err = h.Repo.ListDeviceConfigurationNames(ctx, code); err != nil {
+		return apiDevice{}, err
+	}
+	peripherals, err := h.Repo.ListDevicePeripherals(ctx, code)
+	if err != nil {
+		return apiDevice{}, err
+	}
+	for _, p := range peripherals {
+		device.Peripherals = append(device.Peripherals, apiDevicePeripheral{
+			ID:        p.PeripheralID,
+			Name:      p.Name,
+			PlateNum:  p.PlateNum,
+			SerialNum: p.SerialNum,
+		})
+	}
+	return device, nil
+}
+
+// --- Endpoints ---
+
+// ListCertificates returns certificates ordered by ID. Filters: status, ticket_name,
+// machine_user_dni, device_code, created_from, created_to and updated_since.
+func (h *ApiV1Handler) ListCertificates(c echo.Context) error {
+	user, _ := c.Get("user").(model.AuthenticatedUser)
+	page, err := parseAPIPage(c)
+	if err != nil {
+		return apiError(c, http.StatusBadRequest, err.Error())
+	}
+
+	scoped, viewerID := viewerScope(user)
+	params := repository.APIListCertificatesParams{
+		Scoped:         scoped,
+		ViewerID:       viewerID,
+		TicketName:     strings.TrimSpace(c.QueryParam("ticket_name")),
+		MachineUserDni: strings.TrimSpace(c.QueryParam("machine_user_dni")),
+		DeviceCode:     strings.TrimSpace(c.QueryParam("device_code")),
+		PageLimit:      int32(page.size + 1),
+	}
+	if page.after != "" {
+		after, err := strconv.Atoi(page.after)
+		if err != nil {
+			return apiError(c, http.StatusBadRequest, "invalid cursor")
+		}
+		params.AfterID = int32(after)
+	}
+	if v := c.QueryParam("status"); v != "" {
+		status := repository.CertificateStatus(strings.ToUpper(v))
+		switch status {
+		case repository.CertificateStatusPENDING, repository.CertificateStatusCONFIRMED, repository.CertificateStatusREJECTED, repository.CertificateStatusVOIDED:
+			params.Status = repository.NullCertificateStatus{CertificateStatus: status, Valid: true}
+		default:
+			return apiError(c, http.StatusBadRequest, "status must be PENDING, CONFIRMED, REJECTED or VOIDED")
+		}
+	}
+	if params.CreatedFrom, err = parseAPITime(c, "created_from"); err != nil {
+		return apiError(c, http.StatusBadRequest, err.Error())
+	}
+	if params.CreatedTo, err = parseAPITime(c, "created_to"); err != nil {
+		return apiError(c, http.StatusBadRequest, err.Error())
+	}
+	if params.UpdatedSince, err = parseAPITime(c, "updated_since"); err != nil {
+		return apiError(c, http.StatusBadRequest, err.Error())
+	}
+
+	rows, err := h.Repo.APIListCertificates(c.Request().Context(), params)
+	if err != nil {
+		log.Printf("API: error listing certificates: %v", err)
+		return apiError(c, http.StatusInternalServerError, "database error")
+	}
+
+	return c.JSON(http.StatusOK, newAPIList(rows, page,
+		func(r repository.APIListCertificatesRow) string { return strconv.Itoa(int(r.CertificateID)) },
+		toAPICertificate,
+	))
+}
+
+// GetCertificate returns a certificate with the details of its new device.
+func (h *ApiV1Handler) GetCertificate(c echo.Context) error {
+	user, _ := c.Get("user").(model.AuthenticatedUser)
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return apiError(c, http.StatusBadRequest, "invalid certificate id")
+	}
+
+	scoped, viewerID := viewerScope(user)
+	row, err := h.Repo.APIGetCertificate(c.Request().Context(), repository.APIGetCertificateParams{
+		CertificateID: int32(id),
+		Scoped:        scoped,
+		ViewerID:      viewerID,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return apiError(c, http.StatusNotFound, "certificate not found")
+		}
+		log.Printf("API: error getting certificate %d: %v", id, err)
+		return apiError(c, http.StatusInternalServerError, "database error")
+	}
+
+	cert := toAPICertificate(repository.APIListCertificatesRow(row))
+	device, err := h.loadDevice(c, row.NewDeviceCode)
+	if err != nil {
+		log.Printf("API: error getting device %s of certificate %d: %v", row.NewDeviceCode, id, err)
+		return apiError(c, http.StatusInternalServerError, "database error")
+	}
+	cert.NewDevice = &device
+
+	return c.JSON(http.StatusOK, cert)
+}
+
+// ListMachineUsers returns machine users ordered by DNI. Filters: society and site.
+func (h *ApiV1Handler) ListMachineUsers(c echo.Context) error {
+	user, _ := c.Get("user").(model.AuthenticatedUser)
+	page, err := parseAPIPage(c)
+	if err != nil {
+		return apiError(c, http.StatusBadRequest, err.Error())
+	}
+
+	scoped, viewerID := viewerScope(user)
+	rows, err := h.Repo.APIListMachineUsers(c.Request().Context(), repository.APIListMachineUsersParams{
+		AfterDni:  page.after,
+		Scoped:    scoped,
+		ViewerID:  viewerID,
+		Society:   strings.TrimSpace(c.QueryParam("society")),
+		Site:      strings.TrimSpace(c.QueryParam("site")),
+		PageLimit: int32(page.size + 1),
+	})
+	if err != nil {
+		log.Printf("API: error listing machine users: %v", err)
+		return apiError(c, http.StatusInternalServerError, "database error")
+	}
+
+	return c.JSON(http.StatusOK, newAPIList(rows, page,
+		func(mu repository.MachineUser) string { return mu.Dni },
+		toAPIMachineUser,
+	))
+}
+
+func (h *ApiV1Handler) GetMachineUser(c echo.Context) error {
+	user, _ := c.Get("user").(model.AuthenticatedUser)
+	scoped, viewerID := viewerScope(user)
+	mu, err := h.Repo.APIGetMachineUser(c.Request().Context(), repository.APIGetMachineUserParams{
+		Dni:      c.Param("dni"),
+		Scoped:   scoped,
+		ViewerID: viewerID,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return apiError(c, http.StatusNotFound, "machine user not found")
+		}
+		log.Printf("API: error getting machine user %s: %v", c.Param("dni"), err)
+		return apiError(c, http.StatusInternalServerError, "database error")
+	}
+	return c.JSON(http.StatusOK, toAPIMachineUser(mu))
+}
+
+// ListMachines returns machines ordered by serial number. Filters: type and model.
+func (h *ApiV1Handler) ListMachines(c echo.Context) error {
+	page, err := parseAPIPage(c)
+	if err != nil {
+		return apiError(c, http.StatusBadRequest, err.Error())
+	}
+
+	params := repository.APIListMachinesParams{
+		AfterSerial: page.after,
+		Model:       strings.TrimSpace(c.QueryParam("model")),
+		PageLimit:   int32(page.size + 1),
+	}
+	if v := c.QueryParam("type"); v != "" {
+		t := repository.MachineType(strings.ToUpper(v))
+		if t != repository.MachineTypePC && t != repository.MachineTypeLAPTOP {
+			return apiError(c, http.StatusBadRequest, "type must be PC or LAPTOP")
+		}
+		params.Type = repository.NullMachineType{MachineType: t, Valid: true}
+	}
+
+	rows, err := h.Repo.APIListMachines(c.Request().Context(), params)
+	if err != nil {
+		log.Printf("API: error listing machines: %v", err)
+		return apiError(c, http.StatusInternalServerError, "database error")
+	}
+
+	return c.JSON(http.StatusOK, newAPIList(rows, page,
+		func(m repository.Machine) string { return m.SerialNum },
+		toAPIMachine,
+	))
+}
+
+func (h *ApiV1Handler) GetMachine(c echo.Context) error {
+	m, err := h.Repo.GetMachineBySerial(c.Request().Context(), c.Param("serial"))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return apiError(c, http.StatusNotFound, "machine not found")
+		}
+		log.Printf("API: error getting machine %s: %v", c.Param("serial"), err)
+		return apiError(c, http.StatusInternalServerError, "database error")
+	}
+	return c.JSON(http.StatusOK, toAPIMachine(m))
+}
+
+// ListDevices returns devices ordered by code. Filters: type (NEW, OLD) and status.
+func (h *ApiV1Handler) ListDevices(c echo.Context) error {
+	page, err := parseAPIPage(c)
+	if err != nil {
+		return apiError(c, http.StatusBadRequest, err.Error())
+	}
+
+	params := repository.APIListDevicesParams{
+		AfterCode: page.after,
+		PageLimit: int32(page.size + 1),
+	}
+	if v := c.QueryParam("type"); v != "" {
+		t := repository.DeviceType(strings.ToUpper(v))
+		if t != repository.DeviceTypeNEW && t != repository.DeviceTypeOLD {
+			return apiError(c, http.StatusBadRequest, "type must be NEW or OLD")
+		}
+		params.Type = repository.NullDeviceType{DeviceType: t, Valid: true}
+	}
+	if v := c.QueryParam("status"); v != "" {
+		status := repository.DeviceStatus(strings.ToUpper(v))
+		switch status {
+		case repository.DeviceStatusASIGNACION, repository.DeviceStatusRECUPERACION, repository.DeviceStatusPRESTAMO, repository.DeviceStatusBACKUP:
+			params.Status = repository.NullDeviceStatus{DeviceStatus: status, Valid: true}
+		default:
+			return apiError(c, http.StatusBadRequest, "status must be ASIGNACION, RECUPERACION, PRESTAMO or BACKUP")
+		}
+	}
+
+	rows, err := h.Repo.APIListDevices(c.Request().Context(), params)
+	if err != nil {
+		log.Printf("API: error listing devices: %v", err)
+		return apiError(c, http.StatusInternalServerError, "database error")
+	}
+
+	return c.JSON(http.StatusOK, newAPIList(rows, page,
+		func(d repository.APIListDevicesRow) string { return d.DeviceCode },
+		toAPIDevice,
+	))
+}
+
+func (h *ApiV1Handler) GetDevice(c echo.Context) error {
+	device, err := h.loadDevice(c, c.Param("code"))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return apiError(c, http.StatusNotFound, "device not found")
+		}
+		log.Printf("API: error getting device %s: %v", c.Param("code"), err)
+		return apiError(c, http.StatusInternalServerError, "database error")
+	}
+	return c.JSON(http.StatusOK, device)
+}
+
+// GetCatalogs returns the software, peripheral and configuration item catalogs.
+func (h *ApiV1Handler) GetCatalogs(c echo.Context) error {
+	ctx := c.Request().Context()
+	catalogs := apiCatalogs{
+		Software:           []apiCatalogItem{},
+		Peripherals:        []apiCatalogItem{},
+		ConfigurationItems: []apiCatalogItem{},
+	}
+
+	software, err := h.Repo.ListSoftware(ctx)
+	if err != nil {
+		return apiError(c, http.StatusInternalServerError, "database error")
+	}
+	for _, s := range software {
+		catalogs.Software = append(catalogs.Software, apiCatalogItem{ID: s.SoftwareID, Name: s.Name})
+	}
+
+	peripherals, err := h.Repo.ListPeripherals(ctx)
+	if err != nil {
+		return apiError(c, http.StatusInternalServerError, "database error")
+	}
+	for _, p := range peripherals {
+		catalogs.Peripherals = append(catalogs.Peripherals, apiCatalogItem{ID: p.PeripheralID, Name: p.Name})
+	}
+
+	items, err := h.Repo.ListConfigurationItems(ctx)
+	if err != nil {
+		return apiError(c, http.StatusInternalServerError, "database error")
+	}
+	for _, i := range items {
+		catalogs.ConfigurationItems = append(catalogs.ConfigurationItems, apiCatalogItem{ID: i.ItemID, Name: i.Name})
+	}
+
+	return c.JSON(http.StatusOK, catalogs)
+}
@@ -2,25 +2,58 @@ package handler
 
 import (
 	"context"
+	"errors"
+	"log"
 	"net/http"
 	"time"
 
 	"alc/repository"
+	"alc/service"
 	"alc/view"
 
-	"github.com/jackc/pgx/v5"
+	"github.com/a-h/templ"
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
-	"golang.org/x/crypto/bcrypt"
 )
 
 const AppSessionCookie = "app_session_id"
 
 type AuthHandler struct {
-	Repo *repository.Queries
+	Repo          *repository.Queries
+	Authenticator service.Authenticator
+	AccountSvc    *service.AccountService
+	// OIDCSvc is nil when single sign-on is not configured
+	OIDCSvc *service.OIDCService
+}
+
+// loginPage builds the login page, adding the SSO button when it is configured.
+func (h *AuthHandler) loginPage(errorMsg, notice string) templ.Component {
+	props := view.LoginPageProps{
+		FormURL:  "/login",
+		ErrorMsg: errorMsg,
+		Notice:   notice,
+	}
+	if h.OIDCSvc != nil {
+		props.SSOLabel = h.OIDCSvc.ProviderName()
+	}
+	return view.LoginPage(props)
+}
+
+func setSessionCookie(c echo.Context, session repository.AppSession) {
+	cookie := new(http.Cookie)
+	cookie.Name = AppSessionCookie
+	cookie.Value = session.SessionID.String()
+	cookie.Expires = time.Now().Add(30 * 24 * time.Hour) // 1 month
+	cookie.Path = "/"
+	cookie.HttpOnly = true
+	c.SetCookie(cookie)
 }
 
 func (h *AuthHandler) ShowLoginPage(c echo.Context) error {
-	return render(c, http.StatusOK, view.LoginPage("/login", ""))
+	if c.QueryParam("notice") == "password-reset" {
+		return render(c, http.StatusOK, h.loginPage("", "Tu contraseña fue actualizada. Inicia sesión con la nueva contraseña."))
+	}
+	return render(c, http.StatusOK, h.loginPage("", ""))
 }
 
 func (h *AuthHandler) HandleLogin(c echo.Context) error {
@@ -28,38 +61,29 @@ func (h *AuthHandler) HandleLogin(c echo.Context) error {
 	email := c.FormValue("email")
 	password := c.FormValue("password")
 
-	// 1. Find user by email
-	user, err := h.Repo.GetAppUserByEmail(ctx, email)
+	// 1. Check the credentials against the configured authenticators
+	user, err := h.Authenticator.Authenticate(ctx, email, password)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return render(c, http.StatusUnauthorized, view.LoginPage("/login", "Invalid email or password."))
+		switch {
+		case errors.Is(err, service.ErrInvalidCredentials):
+			return render(c, http.StatusUnauthorized, h.loginPage("Invalid email or password.", ""))
+		case errors.Is(err, service.ErrUserDisabled):
+			return render(c, http.StatusForbidden, h.loginPage("Your account is disabled. Contact an administrator.", ""))
 		}
-		return c.String(http.StatusInternalServerError, "Database error")
-	}
-
-	// 2. Compare password with hashed password
-	err = bcrypt.CompareHashAndPassword([]byte(user.HashedPassword), []byte(password))
-	if err != nil {
-		// Password does not match
-		return render(c, http.StatusUnauthorized, view.LoginPage("/login", "Invalid email or password."))
+		log.Printf("Error authenticating %s: %v", email, err)
+		return c.String(http.StatusInternalServerError, "Authentication error")
 	}
 
-	// 3. Create a session
+	// 2. Create a session
 	session, err := h.Repo.CreateAppSession(ctx, user.UserID)
 	if err != nil {
 		return c.String(http.StatusInternalServerError, "Could not create session")
 	}
 
-	// 4. Set a cookie
-	cookie := new(http.Cookie)
-	cookie.Name = AppSessionCookie
-	cookie.Value = session.SessionID.String()
-	cookie.Expires = time.Now().Add(30 * 24 * time.Hour) // 1 month
-	cookie.Path = "/"
-	cookie.HttpOnly = true
-	c.SetCookie(cookie)
+	// 3. Set a cookie
+	setSessionCookie(c, session)
 
-	// 5. Redirect to a protected page
+	// 4. Redirect to a protected page
 	return c.Redirect(http.StatusFound, "/dashboard")
 }
 
@@ -74,3 +98,63 @@ func (h *AuthHandler) HandleLogout(c echo.Context) error {
 
 	return c.Redirect(http.StatusFound, "/login")
 }
+
+func (h *AuthHandler) ShowForgotPasswordPage(c echo.Context) error {
+	return render(c, http.StatusOK, view.ForgotPasswordPage("", ""))
+}
+
+// HandleForgotPassword always answers with the same message so it cannot be used to discover accounts.
+func (h *AuthHandler) HandleForgotPassword(c echo.Context) error {
+	email := c.FormValue("email")
+	if email == "" {
+		return render(c, http.StatusBadRequest, view.ForgotPasswordPage("", "Ingrese su correo."))
+	}
+
+	if err := h.AccountSvc.RequestPasswordReset(c.Request().Context(), email); err != nil {
+		log.Printf("Error requesting password reset for %s: %v", email, err)
+		return render(c, http.StatusInternalServerError, view.ForgotPasswordPage("", "No se pudo procesar la solicitud."))
+	}
+
+	return render(c, http.StatusOK, view.ForgotPasswordPage("Si el correo está registrado, recibirás un enlace para restablecer tu contraseña.", ""))
+}
+
+func (h *AuthHandler) ShowResetPasswordPage(c echo.Context) error {
+	tokenStr := c.Param("token")
+	token, err := uuid.Parse(tokenStr)
+	if err != nil {
+		return render(c, http.StatusBadRequest, view.ConfirmationResultPage("Error", service.ErrInvalidResetToken.Error()))
+	}
+
+	if err := h.AccountSvc.CheckResetToken(c.Request().Context(), token); err != nil {
+		if errors.Is(err, service.ErrInvalidResetToken) {
+			return render(c, http.StatusNotFound, view.ConfirmationResultPage("Error", err.Error()))
+		}
+		log.Printf("Error checking reset token: %v", err)
+		return c.String(http.StatusInternalServerError, "Database error")
+	}
+
+	return render(c, http.StatusOK, view.ResetPasswordPage(tokenStr, ""))
+}
+
+func (h *AuthHandler) HandleResetPassword(c echo.Context) error {
+	tokenStr := c.Param("token")
+	token, err := uuid.Parse(tokenStr)
+	if err != nil {
+		return render(c, http.StatusBadRequest, view.ConfirmationResultPage("Error", service.ErrInvalidResetToken.Error()))
+	}
+
+	password := c.FormValue("password")
+	if password != c.FormValue("password_confirm") {
+		return render(c, http.StatusBadRequest, view.ResetPasswordPage(tokenStr, "Las contraseñas no coinciden."))
+	}
+
+	if err := h.AccountSvc.ResetPassword(c.Request().Context(), token, password); err != nil {
+		if errors.Is(err, service.ErrInvalidResetToken) {
+			return render(c, http.StatusNotFound, view.ConfirmationResultPage("Error", err.Error()))
+		}
+		log.Printf("Error resetting password: %v", err)
+		return render(c, http.StatusBadRequest, view.ResetPasswordPage(tokenStr, err.Error()))
+	}
+
+	return c.Redirect(http.StatusFound, "/login?notice=password-reset")
+}
@@ -6,8 +6,10 @@ import (
 	"alc/service"
 	"alc/view"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/labstack/echo/v4"
 	"log"
@@ -45,6 +47,9 @@ func (h *CertificateHandler) ShowCertificateForm(c echo.Context) error {
 		StandardSoftware: standardSoftware,
 		StandardConfig:   standardConfig,
 		Peripherals:      peripherals,
+		// Opened from the worklist of a rollout wave
+		MachineUserDni: strings.TrimSpace(c.QueryParam("dni")),
+		OldDeviceCode:  strings.TrimSpace(c.QueryParam("old_device")),
 	}
 
 	return render(c, http.StatusOK, view.CertificateForm(props))
@@ -120,10 +125,7 @@ func (h *CertificateHandler) HandleCertificateConfirmation(c echo.Context) error
 	}
 
 	// If pending, update the status
-	err = h.Repo.UpdateCertificateStatus(ctx, repository.UpdateCertificateStatusParams{
-		ConfirmationStatus: repository.CertificateStatusCONFIRMED,
-		ConfirmationToken:  pgxToken,
-	})
+	_, err = h.CertSvc.SetCertificateStatus(ctx, pgxToken, repository.CertificateStatusCONFIRMED)
 	if err != nil {
 		log.Printf("Error confirming certificate with token %s: %v", tokenStr, err)
 		return render(c, http.StatusInternalServerError, view.ConfirmationResultPage("Error", "No se pudo procesar la confirmación."))
@@ -165,10 +167,7 @@ func (h *CertificateHandler) HandleCertificateRejection(c echo.Context) error {
 		return render(c, http.StatusOK, view.ConfirmationResultPage("Aviso", fmt.Sprintf("Esta solicitud ya fue marcada como %s.", cert.ConfirmationStatus)))
 	}
 
-	err = h.Repo.UpdateCertificateStatus(ctx, repository.UpdateCertificateStatusParams{
-		ConfirmationStatus: repository.CertificateStatusREJECTED,
-		ConfirmationToken:  pgxToken,
-	})
+	_, err = h.CertSvc.SetCertificateStatus(ctx, pgxToken, repository.CertificateStatusREJECTED)
 	if err != nil {
 		log.Printf("Error rejecting certificate with token %s: %v", tokenStr, err)
 		return render(c, http.StatusInternalServerError, view.ConfirmationResultPage("Error", "No se pudo procesar la observación."))
@@ -271,6 +270,9 @@ func (h *CertificateHandler) ShowEditCertificateForm(c echo.Context) error {
 	if err != nil {
 		return c.String(http.StatusBadRequest, "No se encuentra el certificado para editar.")
 	}
+	if certData.ConfirmationStatus == repository.CertificateStatusVOIDED {
+		return c.String(http.StatusConflict, "El certificado fue anulado y ya no puede editarse.")
+	}
 
 	allSoftware, _ := h.Repo.ListSoftware(ctx)
 	allConfigItems, _ := h.Repo.ListConfigurationItems(ctx)
@@ -321,6 +323,28 @@ func (h *CertificateHandler) HandleUpdateCertificate(c echo.Context) error {
 	return c.NoContent(http.StatusOK)
 }
 
+// HandleVoidCertificate voids a certificate of the technician issued by mistake.
+func (h *CertificateHandler) HandleVoidCertificate(c echo.Context) error {
+	user, ok := c.Get("user").(model.AuthenticatedUser)
+	if !ok {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+
+	certID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, "ID de certificado inválido.")
+	}
+
+	if _, err := h.CertSvc.VoidCertificate(c.Request().Context(), user, int32(certID)); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return c.String(http.StatusConflict, "El certificado no existe o ya fue confirmado o anulado.")
+		}
+		log.Printf("Error voiding certificate %d: %v", certID, err)
+		return c.String(http.StatusInternalServerError, "No se pudo anular el certificado.")
+	}
+	return c.Redirect(http.StatusFound, "/dashboard")
+}
+
 func (h *CertificateHandler) ShowConfirmationActionPage(c echo.Context) error {
 	tokenStr := c.Param("token")
 	choice := c.QueryParam("choice")
@@ -342,3 +366,30 @@ func (h *CertificateHandler) ShowConfirmationActionPage(c echo.Context) error {
 
 	return render(c, http.StatusOK, view.ConfirmationActionPage(props))
 }
+
+// ShowCertificateList renders the certificate browser. Supervisors only see the
+// certificates of machine users inside their scopes.
+func (h *CertificateHandler) ShowCertificateList(c echo.Context) error {
+	user, ok := c.Get("user").(model.AuthenticatedUser)
+	if !ok {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+
+	search := strings.TrimSpace(c.QueryParam("q"))
+	scoped, viewerID := viewerScope(user)
+	certs, err := h.Repo.ListCertificates(c.Request().Context(), repository.ListCertificatesParams{
+		Scoped:   scoped,
+		ViewerID: viewerID,
+		Search:   search,
+	})
+	if err != nil {
+		log.Printf("Error listing certificates for user %s: %v", user.ID, err)
+		return c.String(http.StatusInternalServerError, "Error al obtener los certificados")
+	}
+
+	return render(c, http.StatusOK, view.CertificateListPage(view.CertificateListPageProps{
+		User:   user,
+		Certs:  certs,
+		Search: search,
+	}))
+}
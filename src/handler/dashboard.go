@@ -3,6 +3,7 @@ package handler
 import (
 	"log"
 	"net/http"
+	"time"
 
 	"alc/model"
 	"alc/repository"
@@ -28,22 +29,127 @@ func (h *DashboardHandler) ShowDashboard(c echo.Context) error {
 	}
 
 	// Recent certificates
-	pgxUserID := pgtype.UUID{Bytes: user.ID, Valid: true}
-	certs, err := h.Repo.GetRecentCertificatesByTechnician(ctx, pgxUserID)
-	if err != nil {
-		log.Printf("Error getting recent certs for user %s: %v", user.ID, err)
-		// Non-critical error, can still render the page
+	if user.Can(model.PermCreateCertificates) {
+		pgxUserID := pgtype.UUID{Bytes: user.ID, Valid: true}
+		certs, err := h.Repo.GetRecentCertificatesByTechnician(ctx, pgxUserID)
+		if err != nil {
+			log.Printf("Error getting recent certs for user %s: %v", user.ID, err)
+			// Non-critical error, can still render the page
+		}
+		props.RecentCerts = certs
+
+		worklist, err := h.Repo.ListTechnicianWorklist(ctx, pgxUserID)
+		if err != nil {
+			log.Printf("Error getting worklist for user %s: %v", user.ID, err)
+			// Non-critical error, can still render the page
+		}
+		props.Worklist = worklist
 	}
-	props.RecentCerts = certs
 
-	if user.Role == repository.UserRoleADMIN {
-		stats, err := h.Repo.GetDashboardStats(ctx)
+	if user.Can(model.PermViewStats) {
+		scoped, viewerID := viewerScope(user)
+		stats, err := h.Repo.GetDashboardStats(ctx, repository.GetDashboardStatsParams{
+			Scoped:   scoped,
+			ViewerID: viewerID,
+		})
 		if err != nil {
-			log.Printf("Error getting admin stats: %v", err)
+			log.Printf("Error getting dashboard stats: %v", err)
 			// Non-critical error, can still render the page without stats
 		}
 		props.AdminStats = stats
+
+		waves, err := h.Repo.ListRolloutWaveProgress(ctx, repository.ListRolloutWaveProgressParams{
+			Scoped:   scoped,
+			ViewerID: viewerID,
+		})
+		if err != nil {
+			log.Printf("Error getting rollout wave progress: %v", err)
+			// Non-critical error, can still render the page without waves
+		}
+		props.Waves = waves
 	}
 
 	return render(c, http.StatusOK, view.DashboardPage(props))
 }
+
+// ShowAnalytics shows the progress of the rollout: certificates per day or week, per status
+// and per technician, the time to confirmation, and the machine users with a certificate
+// per society, site or area. Supervisors only see their scopes.
+func (h *DashboardHandler) ShowAnalytics(c echo.Context) error {
+	user, ok := c.Get("user").(model.AuthenticatedUser)
+	if !ok {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+
+	from, to, err := parseDateRange(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Filtro inválido: "+err.Error())
+	}
+	props := view.AnalyticsPageProps{
+		From:   c.QueryParam("from"),
+		To:     c.QueryParam("to"),
+		Bucket: c.QueryParam("bucket"),
+		Level:  c.QueryParam("level"),
+	}
+	if props.Bucket != "day" && props.Bucket != "week" {
+		// Daily columns stay readable for about two months
+		props.Bucket = "week"
+		if from.Valid && (!to.Valid || to.Time.Sub(from.Time) <= 62*24*time.Hour) {
+			props.Bucket = "day"
+		}
+	}
+	if props.Level != "site" && props.Level != "area" {
+		props.Level = "society"
+	}
+
+	ctx := c.Request().Context()
+	scoped, viewerID := viewerScope(user)
+	props.Scoped = scoped
+
+	// Fetch all aggregates in parallel
+	errs := make(chan error, 5)
+	go func() {
+		var err error
+		props.Timeline, err = h.Repo.GetCertificateTimeline(ctx, repository.GetCertificateTimelineParams{
+			Bucket: props.Bucket, Scoped: scoped, ViewerID: viewerID, CreatedFrom: from, CreatedTo: to,
+		})
+		errs <- err
+	}()
+	go func() {
+		var err error
+		props.StatusCounts, err = h.Repo.GetCertificateStatusCounts(ctx, repository.GetCertificateStatusCountsParams{
+			Scoped: scoped, ViewerID: viewerID, CreatedFrom: from, CreatedTo: to,
+		})
+		errs <- err
+	}()
+	go func() {
+		var err error
+		props.Progress, err = h.Repo.GetRolloutProgress(ctx, repository.GetRolloutProgressParams{
+			Level: props.Level, Scoped: scoped, ViewerID: viewerID,
+		})
+		errs <- err
+	}()
+	go func() {
+		var err error
+		props.Technicians, err = h.Repo.GetTechnicianThroughput(ctx, repository.GetTechnicianThroughputParams{
+			Scoped: scoped, ViewerID: viewerID, CreatedFrom: from, CreatedTo: to,
+		})
+		errs <- err
+	}()
+	go func() {
+		var err error
+		props.ConfirmationTime, err = h.Repo.GetConfirmationTimeStats(ctx, repository.GetConfirmationTimeStatsParams{
+			Scoped: scoped, ViewerID: viewerID, CreatedFrom: from, CreatedTo: to,
+		})
+		errs <- err
+	}()
+
+	for i := 0; i < 5; i++ {
+		if err := <-errs; err != nil {
+			log.Printf("Error fetching analytics: %v", err)
+			return c.String(http.StatusInternalServerError, "No se pudo cargar la analítica.")
+		}
+	}
+
+	return render(c, http.StatusOK, view.AnalyticsPage(props))
+}
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"alc/model"
+	"alc/repository"
+	"alc/view"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// DeviceHandler serves the device browser and the custody timeline of each device.
+// Supervisors only see devices held by machine users inside their scopes.
+type DeviceHandler struct {
+	Repo *repository.Queries
+}
+
+// ShowDeviceList searches devices by code, plate, serial or hostname.
+func (h *DeviceHandler) ShowDeviceList(c echo.Context) error {
+	user, ok := c.Get("user").(model.AuthenticatedUser)
+	if !ok {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+
+	search := strings.TrimSpace(c.QueryParam("q"))
+	scoped, viewerID := viewerScope(user)
+	devices, err := h.Repo.SearchDevices(c.Request().Context(), repository.SearchDevicesParams{
+		Search:   search,
+		Scoped:   scoped,
+		ViewerID: viewerID,
+	})
+	if err != nil {
+		log.Printf("Error searching devices for user %s: %v", user.ID, err)
+		return c.String(http.StatusInternalServerError, "Error al obtener los equipos")
+	}
+
+	return render(c, http.StatusOK, view.DeviceListPage(view.DeviceListPageProps{
+		User:    user,
+		Devices: devices,
+		Search:  search,
+	}))
+}
+
+// ShowDevice renders a device with the full history of who held it.
+func (h *DeviceHandler) ShowDevice(c echo.Context) error {
+	user, ok := c.Get("user").(model.AuthenticatedUser)
+	if !ok {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+	code, err := url.PathUnescape(c.Param("code"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Código de equipo inválido.")
+	}
+
+	ctx := c.Request().Context()
+	scoped, viewerID := viewerScope(user)
+	device, err := h.Repo.GetDeviceDetails(ctx, repository.GetDeviceDetailsParams{
+		DeviceCode: code,
+		Scoped:     scoped,
+		ViewerID:   viewerID,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return c.String(http.StatusNotFound, "Equipo no encontrado.")
+		}
+		log.Printf("Error fetching device %s: %v", code, err)
+		return c.String(http.StatusInternalServerError, "Error al obtener el equipo")
+	}
+
+	custody, err := h.Repo.ListDeviceCustody(ctx, device.DeviceCode)
+	if err != nil {
+		log.Printf("Error listing custody of device %s: %v", code, err)
+		return c.String(http.StatusInternalServerError, "Error al obtener el historial del equipo")
+	}
+
+	recoveries, err := h.Repo.ListDeviceRecoveries(ctx, device.DeviceCode)
+	if err != nil {
+		log.Printf("Error listing recoveries of device %s: %v", code, err)
+		return c.String(http.StatusInternalServerError, "Error al obtener el historial del equipo")
+	}
+
+	return render(c, http.StatusOK, view.DevicePage(view.DevicePageProps{
+		Device:     device,
+		Custody:    custody,
+		Recoveries: recoveries,
+		// The certificates are served with the warehouse, to those who operate it
+		RecoveryCertificates: user.Can(model.PermCreateCertificates),
+	}))
+}
@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"alc/model"
+	"alc/repository"
+	"alc/service"
+	"alc/view"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+)
+
+// LoanHandler serves the open loans to technicians and the return acta to machine users.
+type LoanHandler struct {
+	Repo    *repository.Queries
+	LoanSvc *service.LoanService
+}
+
+// ShowLoans lists the devices lent and not returned yet, the earliest due first.
+func (h *LoanHandler) ShowLoans(c echo.Context) error {
+	loans, err := h.Repo.ListOpenLoans(c.Request().Context(), repository.ListOpenLoansParams{
+		Today: service.LimaToday(),
+	})
+	if err != nil {
+		log.Printf("Error listing open loans: %v", err)
+		return c.String(http.StatusInternalServerError, "Error al obtener los préstamos")
+	}
+	requested, _ := strconv.Atoi(c.QueryParam("requested"))
+	return render(c, http.StatusOK, view.LoansPage(view.LoansPageProps{
+		Loans:     loans,
+		Requested: int32(requested),
+	}))
+}
+
+// HandleRequestLoanReturn registers that the device of a loan was handed back and emails
+// the return acta to the machine user.
+func (h *LoanHandler) HandleRequestLoanReturn(c echo.Context) error {
+	user, ok := c.Get("user").(model.AuthenticatedUser)
+	if !ok {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "ID de préstamo inválido.")
+	}
+
+	loan, err := h.LoanSvc.RequestReturn(c.Request().Context(), int32(id), user.ID, c.FormValue("comments"))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return c.String(http.StatusConflict, "El préstamo no existe o ya fue devuelto.")
+		}
+		log.Printf("Error registering return of loan %d: %v", id, err)
+		return c.String(http.StatusInternalServerError, "No se pudo registrar la devolución.")
+	}
+	return c.Redirect(http.StatusFound, "/loans?requested="+strconv.Itoa(int(loan.LoanID)))
+}
+
+// getLoanByReturnToken loads the loan of the :token route parameter, answering the request
+// itself when it is invalid or unknown.
+func (h *LoanHandler) getLoanByReturnToken(c echo.Context) (repository.GetLoanDetailsRow, bool, error) {
+	token, err := uuid.Parse(c.Param("token"))
+	if err != nil {
+		return repository.GetLoanDetailsRow{}, false, render(c, http.StatusBadRequest, view.ConfirmationResultPage("Error", "El enlace utilizado es inválido."))
+	}
+	ctx := c.Request().Context()
+	id, err := h.Repo.GetLoanIDByReturnToken(ctx, pgtype.UUID{Bytes: token, Valid: true})
+	if err == nil {
+		var loan repository.GetLoanDetailsRow
+		loan, err = h.Repo.GetLoanDetails(ctx, id)
+		if err == nil {
+			return loan, true, nil
+		}
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return repository.GetLoanDetailsRow{}, false, render(c, http.StatusNotFound, view.ConfirmationResultPage("Error", "El acta de devolución no fue encontrada o fue reemplazada por una más reciente."))
+	}
+	log.Printf("Error fetching loan return %s: %v", token, err)
+	return repository.GetLoanDetailsRow{}, false, render(c, http.StatusInternalServerError, view.ConfirmationResultPage("Error", "No se pudo cargar el acta de devolución."))
+}
+
+// ShowLoanReturnPage shows the return acta to the machine user, with the buttons to answer
+// it while it is pending.
+func (h *LoanHandler) ShowLoanReturnPage(c echo.Context) error {
+	loan, ok, err := h.getLoanByReturnToken(c)
+	if !ok {
+		return err
+	}
+	return render(c, http.StatusOK, view.LoanReturnPage(view.LoanReturnPageProps{
+		Loan:   loan,
+		Choice: c.QueryParam("choice"),
+	}))
+}
+
+func (h *LoanHandler) HandleConfirmLoanReturn(c echo.Context) error {
+	return h.answerLoanReturn(c, repository.CertificateStatusCONFIRMED, "¡Gracias!", "La devolución del equipo ha sido registrada con éxito.")
+}
+
+func (h *LoanHandler) HandleRejectLoanReturn(c echo.Context) error {
+	return h.answerLoanReturn(c, repository.CertificateStatusREJECTED, "Procesado", "Tu observación ha sido registrada.")
+}
+
+func (h *LoanHandler) answerLoanReturn(c echo.Context, status repository.CertificateStatus, title, message string) error {
+	loan, ok, err := h.getLoanByReturnToken(c)
+	if !ok {
+		return err
+	}
+
+	if _, err := h.LoanSvc.SetReturnStatus(c.Request().Context(), loan.ReturnToken, status); err != nil {
+		if errors.Is(err, service.ErrLoanReturnNotPending) {
+			return render(c, http.StatusOK, view.ConfirmationResultPage("Aviso", "Esta devolución ya fue respondida."))
+		}
+		log.Printf("Error answering return of loan %d: %v", loan.LoanID, err)
+		return render(c, http.StatusInternalServerError, view.ConfirmationResultPage("Error", "No se pudo procesar tu respuesta."))
+	}
+	return render(c, http.StatusOK, view.ConfirmationResultPage(title, message))
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"alc/service"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/oauth2"
+)
+
+const oidcFlowCookie = "oidc_flow"
+
+// randomString returns a URL-safe random value for the state and nonce parameters.
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func setOIDCFlowCookie(c echo.Context, value string, expires time.Time) {
+	cookie := new(http.Cookie)
+	cookie.Name = oidcFlowCookie
+	cookie.Value = value
+	cookie.Expires = expires
+	cookie.Path = "/login/oidc"
+	cookie.HttpOnly = true
+	cookie.Secure = c.Scheme() == "https"
+	cookie.SameSite = http.SameSiteLaxMode
+	c.SetCookie(cookie)
+}
+
+// HandleOIDCLogin starts the authorization code flow with PKCE. The state, nonce and
+// code verifier are kept in a short-lived cookie until the provider redirects back.
+func (h *AuthHandler) HandleOIDCLogin(c echo.Context) error {
+	if h.OIDCSvc == nil {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+
+	state, err := randomString()
+	if err != nil {
+		return c.String(http.StatusInternalServerError, "Could not start login")
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return c.String(http.StatusInternalServerError, "Could not start login")
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	setOIDCFlowCookie(c, strings.Join([]string{state, nonce, verifier}, "."), time.Now().Add(10*time.Minute))
+
+	return c.Redirect(http.StatusFound, h.OIDCSvc.AuthCodeURL(state, nonce, verifier))
+}
+
+// HandleOIDCCallback completes the flow and opens a regular app session.
+func (h *AuthHandler) HandleOIDCCallback(c echo.Context) error {
+	if h.OIDCSvc == nil {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+
+	cookie, err := c.Cookie(oidcFlowCookie)
+	// The flow cookie is single use
+	setOIDCFlowCookie(c, "", time.Unix(0, 0))
+	if err != nil {
+		return render(c, http.StatusBadRequest, h.loginPage("Your sign-in session expired, please try again.", ""))
+	}
+
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 || parts[0] != c.QueryParam("state") {
+		return render(c, http.StatusBadRequest, h.loginPage("Invalid sign-in state, please try again.", ""))
+	}
+	nonce, verifier := parts[1], parts[2]
+
+	if providerErr := c.QueryParam("error"); providerErr != "" {
+		log.Printf("OIDC provider returned an error: %s: %s", providerErr, c.QueryParam("error_description"))
+		return render(c, http.StatusUnauthorized, h.loginPage("The identity provider rejected the sign-in.", ""))
+	}
+
+	ctx := c.Request().Context()
+	claims, err := h.OIDCSvc.Exchange(ctx, c.QueryParam("code"), nonce, verifier)
+	if err != nil {
+		log.Printf("OIDC exchange failed: %v", err)
+		return render(c, http.StatusUnauthorized, h.loginPage("Could not verify your identity.", ""))
+	}
+
+	user, err := h.OIDCSvc.ResolveUser(ctx, claims)
+	if err != nil {
+		if errors.Is(err, service.ErrOIDCUserNotAllowed) || errors.Is(err, service.ErrUserDisabled) {
+			log.Printf("OIDC login denied for %s: %v", claims.Email, err)
+			return render(c, http.StatusForbidden, h.loginPage(err.Error(), ""))
+		}
+		log.Printf("OIDC user resolution failed for %s: %v", claims.Email, err)
+		return c.String(http.StatusInternalServerError, "Database error")
+	}
+
+	session, err := h.Repo.CreateAppSession(ctx, user.UserID)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, "Could not create session")
+	}
+	setSessionCookie(c, session)
+
+	return c.Redirect(http.StatusFound, "/dashboard")
+}
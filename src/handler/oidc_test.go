@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"alc/config"
+	"alc/service"
+
+	"github.com/labstack/echo/v4"
+)
+
+// newTestOIDCHandler returns an AuthHandler with single sign-on against a provider that
+// only serves its discovery document, enough for the steps before the code exchange.
+func newTestOIDCHandler(t *testing.T) *AuthHandler {
+	t.Helper()
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                 srv.URL,
+			"authorization_endpoint": srv.URL + "/authorize",
+			"token_endpoint":         srv.URL + "/token",
+			"jwks_uri":               srv.URL + "/keys",
+		})
+	}))
+	t.Cleanup(srv.Close)
+
+	svc, err := service.NewOIDCService(context.Background(), &config.Config{
+		OIDCIssuerURL:   srv.URL,
+		OIDCClientID:    "alc-test",
+		OIDCRedirectURL: "https://alc.example.com/login/oidc/callback",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &AuthHandler{OIDCSvc: svc}
+}
+
+func TestHandleOIDCLoginCookie(t *testing.T) {
+	h := newTestOIDCHandler(t)
+
+	for _, proto := range []string{"http", "https"} {
+		t.Run(proto, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/login/oidc", nil)
+			req.Header.Set(echo.HeaderXForwardedProto, proto)
+			rec := httptest.NewRecorder()
+			if err := h.HandleOIDCLogin(echo.New().NewContext(req, rec)); err != nil {
+				t.Fatal(err)
+			}
+
+			cookies := rec.Result().Cookies()
+			if len(cookies) != 1 || cookies[0].Name != oidcFlowCookie {
+				t.Fatalf("cookies = %v, want the flow cookie", cookies)
+			}
+			if cookies[0].Secure != (proto == "https") || !cookies[0].HttpOnly {
+				t.Errorf("flow cookie Secure = %v, HttpOnly = %v over %s", cookies[0].Secure, cookies[0].HttpOnly, proto)
+			}
+
+			state := strings.SplitN(cookies[0].Value, ".", 2)[0]
+			location, err := url.Parse(rec.Header().Get(echo.HeaderLocation))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if q := location.Query(); q.Get("state") != state || q.Get("code_challenge_method") != "S256" {
+				t.Errorf("redirect to %s does not carry the state and PKCE challenge", location)
+			}
+		})
+	}
+}
+
+func TestHandleOIDCCallbackState(t *testing.T) {
+	h := newTestOIDCHandler(t)
+
+	tests := []struct {
+		name   string
+		cookie string
+		state  string
+	}{
+		{name: "no flow cookie", state: "s1"},
+		{name: "state mismatch", cookie: "s1.nonce.verifier", state: "s2"},
+		{name: "malformed cookie", cookie: "s1", state: "s1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/login/oidc/callback?code=c&state="+tt.state, nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: oidcFlowCookie, Value: tt.cookie})
+			}
+			rec := httptest.NewRecorder()
+			if err := h.HandleOIDCCallback(echo.New().NewContext(req, rec)); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
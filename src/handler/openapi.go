@@ -0,0 +1,330 @@
+package handler
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"alc/model"
+	"alc/repository"
+
+	"github.com/labstack/echo/v4"
+)
+
+// The OpenAPI document of /api/v1 is generated from the resource types the handlers
+// serialize, so the schemas follow any change to them. Only the operations are listed by hand.
+
+// openAPISchemas names the resource types published in components.schemas.
+var openAPISchemas = []struct {
+	name string
+	typ  reflect.Type
+}{
+	{"Certificate", reflect.TypeOf(apiCertificate{})},
+	{"Technician", reflect.TypeOf(apiTechnician{})},
+	{"MachineUserRef", reflect.TypeOf(apiMachineUserRef{})},
+	{"MachineUser", reflect.TypeOf(apiMachineUser{})},
+	{"Machine", reflect.TypeOf(apiMachine{})},
+	{"Device", reflect.TypeOf(apiDevice{})},
+	{"DevicePeripheral", reflect.TypeOf(apiDevicePeripheral{})},
+	{"CatalogItem", reflect.TypeOf(apiCatalogItem{})},
+	{"Catalogs", reflect.TypeOf(apiCatalogs{})},
+}
+
+// openAPIEnums holds the values of the database enums used by the resources.
+var openAPIEnums = map[reflect.Type][]string{
+	reflect.TypeOf(repository.CertificateStatus("")): enumStrings(repository.AllCertificateStatusValues()),
+	reflect.TypeOf(repository.MachineType("")):       enumStrings(repository.AllMachineTypeValues()),
+	reflect.TypeOf(repository.MachineProfile("")):    enumStrings(repository.AllMachineProfileValues()),
+	reflect.TypeOf(repository.DeviceType("")):        enumStrings(repository.AllDeviceTypeValues()),
+	reflect.TypeOf(repository.DeviceStatus("")):      enumStrings(repository.AllDeviceStatusValues()),
+}
+
+func enumStrings[T ~string](values []T) []string {
+	list := make([]string, len(values))
+	for i, v := range values {
+		list[i] = string(v)
+	}
+	return list
+}
+
+type openAPIParam struct {
+	name        string
+	in          string
+	description string
+	// enum is set for parameters restricted to the values of a database enum
+	enum reflect.Type
+	// format is "date-time" for timestamps
+	format string
+}
+
+type openAPIOperation struct {
+	path    string
+	id      string
+	summary string
+	scope   model.APIScope
+	params  []openAPIParam
+	// schema is the name of the returned resource; list operations wrap it in the list envelope
+	schema string
+	list   bool
+}
+
+var openAPIOperations = []openAPIOperation{
+	{
+		path: "/certificates", id: "listCertificates", summary: "List certificates ordered by ID",
+		scope: model.APIScopeCertificates, schema: "Certificate", list: true,
+		params: []openAPIParam{
+			{name: "status", in: "query", enum: reflect.TypeOf(repository.CertificateStatus(""))},
+			{name: "ticket_name", in: "query", description: "Exact ticket name"},
+			{name: "machine_user_dni", in: "query"},
+			{name: "device_code", in: "query", description: "Matches the new or the old device"},
+			{name: "created_from", in: "query", format: "date-time", description: "Inclusive lower bound of created_at"},
+			{name: "created_to", in: "query", format: "date-time", description: "Exclusive upper bound of created_at"},
+			{name: "updated_since", in: "query", format: "date-time", description: "Inclusive lower bound of updated_at"},
+		},
+	},
+	{
+		path: "/certificates/{id}", id: "getCertificate", summary: "Get a certificate with its new device",
+		scope: model.APIScopeCertificates, schema: "Certificate",
+		params: []openAPIParam{{name: "id", in: "path"}},
+	},
+	{
+		path: "/machine-users", id: "listMachineUsers", summary: "List machine users ordered by DNI",
+		scope: model.APIScopeMachineUsers, schema: "MachineUser", list: true,
+		params: []openAPIParam{
+			{name: "society", in: "query", description: "Case-insensitive"},
+			{name: "site", in: "query", description: "Case-insensitive"},
+		},
+	},
+	{
+		path: "/machine-users/{dni}", id: "getMachineUser", summary: "Get a machine user",
+		scope: model.APIScopeMachineUsers, schema: "MachineUser",
+		params: []openAPIParam{{name: "dni", in: "path"}},
+	},
+	{
+		path: "/machines", id: "listMachines", summary: "List machines ordered by serial number",
+		scope: model.APIScopeInventory, schema: "Machine", list: true,
+		params: []openAPIParam{
+			{name: "type", in: "query", enum: reflect.TypeOf(repository.MachineType(""))},
+			{name: "model", in: "query", description: "Substring of the model, case-insensitive"},
+		},
+	},
+	{
+		path: "/machines/{serial}", id: "getMachine", summary: "Get a machine",
+		scope: model.APIScopeInventory, schema: "Machine",
+		params: []openAPIParam{{name: "serial", in: "path"}},
+	},
+	{
+		path: "/devices", id: "listDevices", summary: "List devices ordered by code",
+		scope: model.APIScopeInventory, schema: "Device", list: true,
+		params: []openAPIParam{
+			{name: "type", in: "query", enum: reflect.TypeOf(repository.DeviceType(""))},
+			{name: "status", in: "query", enum: reflect.TypeOf(repository.DeviceStatus(""))},
+		},
+	},
+	{
+		path: "/devices/{code}", id: "getDevice", summary: "Get a device with its software, configuration and peripherals",
+		scope: model.APIScopeInventory, schema: "Device",
+		params: []openAPIParam{{name: "code", in: "path"}},
+	},
+	{
+		path: "/catalogs", id: "getCatalogs", summary: "Get the software, peripheral and configuration item catalogs",
+		scope: model.APIScopeCatalogs, schema: "Catalogs",
+	},
+}
+
+// openAPISchema describes a Go type the way encoding/json serializes it.
+func openAPISchema(t reflect.Type) map[string]any {
+	for _, s := range openAPISchemas {
+		if s.typ == t {
+			return map[string]any{"$ref": "#/components/schemas/" + s.name}
+		}
+	}
+	if values, ok := openAPIEnums[t]; ok {
+		return map[string]any{"type": "string", "enum": values}
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := openAPISchema(t.Elem())
+		if _, isRef := schema["$ref"]; isRef {
+			// Siblings of $ref are ignored in OpenAPI 3.0
+			return map[string]any{"allOf": []any{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": openAPISchema(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int32:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case reflect.Int, reflect.Int64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Struct:
+		return openAPIObject(t)
+	}
+	panic("openapi: unsupported type " + t.String())
+}
+
+// openAPIObject describes the exported fields of a struct. Fields without omitempty are required.
+func openAPIObject(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if !f.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		omitempty := strings.Contains(opts, "omitempty")
+		if omitempty && f.Type.Kind() == reflect.Pointer {
+			// An omitted pointer is absent rather than null
+			properties[name] = openAPISchema(f.Type.Elem())
+		} else {
+			properties[name] = openAPISchema(f.Type)
+		}
+		if !omitempty {
+			required = append(required, name)
+		}
+	}
+	return map[string]any{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+func openAPIErrorResponse(description string) map[string]any {
+	return map[string]any{
+		"description": description,
+		"content": map[string]any{
+			"application/json": map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/Error"}},
+		},
+	}
+}
+
+// buildOpenAPI assembles the OpenAPI 3.0 document of /api/v1.
+func buildOpenAPI() map[string]any {
+	schemas := map[string]any{
+		"Error": map[string]any{
+			"type":       "object",
+			"properties": map[string]any{"error": map[string]any{"type": "string"}},
+			"required":   []string{"error"},
+		},
+	}
+	for _, s := range openAPISchemas {
+		schemas[s.name] = openAPIObject(s.typ)
+	}
+
+	paths := map[string]any{}
+	for _, op := range openAPIOperations {
+		params := []any{}
+		for _, p := range op.params {
+			schema := map[string]any{"type": "string"}
+			if p.enum != nil {
+				schema = openAPISchema(p.enum)
+			}
+			if p.format != "" {
+				schema["format"] = p.format
+			}
+			param := map[string]any{
+				"name":     p.name,
+				"in":       p.in,
+				"required": p.in == "path",
+				"schema":   schema,
+			}
+			if p.description != "" {
+				param["description"] = p.description
+			}
+			params = append(params, param)
+		}
+
+		var body map[string]any
+		if op.list {
+			params = append(params,
+				map[string]any{
+					"name": "limit", "in": "query", "required": false,
+					"schema": map[string]any{"type": "integer", "minimum": 1, "maximum": maxAPIPageSize, "default": defaultAPIPageSize},
+				},
+				map[string]any{
+					"name": "cursor", "in": "query", "required": false,
+					"description": "next_cursor of the previous page",
+					"schema":      map[string]any{"type": "string"},
+				},
+			)
+			body = map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"data":        map[string]any{"type": "array", "items": map[string]any{"$ref": "#/components/schemas/" + op.schema}},
+					"next_cursor": map[string]any{"type": "string", "description": "Absent on the last page"},
+				},
+				"required": []string{"data"},
+			}
+		} else {
+			body = map[string]any{"$ref": "#/components/schemas/" + op.schema}
+		}
+
+		responses := map[string]any{
+			"200": map[string]any{
+				"description": "OK",
+				"content":     map[string]any{"application/json": map[string]any{"schema": body}},
+			},
+			"400": openAPIErrorResponse("Invalid parameters"),
+			"401": openAPIErrorResponse("Missing, invalid or expired token"),
+			"403": openAPIErrorResponse("The token lacks the required scope"),
+		}
+		if !op.list && len(op.params) > 0 {
+			responses["404"] = openAPIErrorResponse("Not found")
+		}
+
+		paths[op.path] = map[string]any{
+			"get": map[string]any{
+				"operationId": op.id,
+				"summary":     op.summary,
+				"description": "Requires the `" + string(op.scope) + "` scope.",
+				"tags":        []string{strings.Split(strings.TrimPrefix(op.path, "/"), "/")[0]},
+				"parameters":  params,
+				"security":    []any{map[string]any{"bearerToken": []string{string(op.scope)}}},
+				"responses":   responses,
+			},
+		}
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "ALC Certificates API",
+			"version":     "1.0.0",
+			"description": "Read-only access to certificates, machine users, machines, devices and catalogs. Certificates and machine users are limited to the scopes of the token owner.",
+		},
+		"servers": []any{map[string]any{"url": "/api/v1"}},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"bearerToken": map[string]any{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "Personal access token created in the admin panel",
+				},
+			},
+		},
+	}
+}
+
+var openAPIDocument = sync.OnceValue(buildOpenAPI)
+
+// ServeOpenAPI serves the OpenAPI document. It is public so tools can fetch it without a token.
+func (h *ApiV1Handler) ServeOpenAPI(c echo.Context) error {
+	return c.JSON(http.StatusOK, openAPIDocument())
+}
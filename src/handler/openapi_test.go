@@ -0,0 +1,285 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"alc/config"
+	"alc/db/dbtest"
+	"alc/model"
+	"alc/repository"
+	"alc/service"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// openAPIJSON returns the OpenAPI document as served, decoded into plain maps and slices.
+func openAPIJSON(t *testing.T) map[string]any {
+	t.Helper()
+
+	b, err := json.Marshal(buildOpenAPI())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// validateSchema checks a decoded JSON value against the subset of OpenAPI 3.0 schemas
+// buildOpenAPI produces. Properties the schema does not describe are reported too, so
+// the document cannot fall behind the responses.
+func validateSchema(doc, schema map[string]any, value any, at string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		target, ok := doc["components"].(map[string]any)["schemas"].(map[string]any)[name].(map[string]any)
+		if !ok {
+			return []string{at + ": unresolved " + ref}
+		}
+		return validateSchema(doc, target, value, at)
+	}
+	if value == nil {
+		if schema["nullable"] == true {
+			return nil
+		}
+		return []string{at + ": null is not nullable"}
+	}
+	if allOf, ok := schema["allOf"].([]any); ok {
+		var errs []string
+		for _, s := range allOf {
+			errs = append(errs, validateSchema(doc, s.(map[string]any), value, at)...)
+		}
+		return errs
+	}
+
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: %T is not an object", at, value)}
+		}
+		var errs []string
+		properties, _ := schema["properties"].(map[string]any)
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				errs = append(errs, fmt.Sprintf("%s: missing required %s", at, name))
+			}
+		}
+		for name, v := range obj {
+			prop, ok := properties[name].(map[string]any)
+			if !ok {
+				errs = append(errs, fmt.Sprintf("%s: undocumented property %s", at, name))
+				continue
+			}
+			errs = append(errs, validateSchema(doc, prop, v, at+"."+name)...)
+		}
+		return errs
+	case "array":
+		list, ok := value.([]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: %T is not an array", at, value)}
+		}
+		var errs []string
+		for i, v := range list {
+			errs = append(errs, validateSchema(doc, schema["items"].(map[string]any), v, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+		return errs
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return []string{fmt.Sprintf("%s: %T is not a string", at, value)}
+		}
+		if enum, ok := schema["enum"].([]any); ok && !slices.Contains(enum, any(s)) {
+			return []string{fmt.Sprintf("%s: %q is not one of %v", at, s, enum)}
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				return []string{fmt.Sprintf("%s: %q is not a date-time", at, s)}
+			}
+		}
+	case "integer":
+		n, ok := value.(float64)
+		if !ok || n != float64(int64(n)) {
+			return []string{fmt.Sprintf("%s: %v is not an integer", at, value)}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{fmt.Sprintf("%s: %T is not a boolean", at, value)}
+		}
+	default:
+		return []string{fmt.Sprintf("%s: unsupported schema %v", at, schema)}
+	}
+	return nil
+}
+
+// TestOpenAPIDocument checks that every schema reference of the document resolves.
+func TestOpenAPIDocument(t *testing.T) {
+	doc := openAPIJSON(t)
+
+	var walk func(v any, at string)
+	walk = func(v any, at string) {
+		switch v := v.(type) {
+		case map[string]any:
+			if ref, ok := v["$ref"].(string); ok {
+				name := strings.TrimPrefix(ref, "#/components/schemas/")
+				if _, ok := doc["components"].(map[string]any)["schemas"].(map[string]any)[name]; !ok {
+					t.Errorf("%s: unresolved %s", at, ref)
+				}
+			}
+			for k, child := range v {
+				walk(child, at+"/"+k)
+			}
+		case []any:
+			for i, child := range v {
+				walk(child, fmt.Sprintf("%s/%d", at, i))
+			}
+		}
+	}
+	walk(doc, "#")
+
+	if paths := doc["paths"].(map[string]any); len(paths) != len(openAPIOperations) {
+		t.Errorf("document has %d paths, want %d", len(paths), len(openAPIOperations))
+	}
+}
+
+// TestOpenAPIConformance calls every /api/v1 operation of the document and validates the
+// responses against it.
+func TestOpenAPIConformance(t *testing.T) {
+	pool, repo := dbtest.New(t)
+	ctx := context.Background()
+	doc := openAPIJSON(t)
+
+	admin, err := repo.CreateAppUser(ctx, repository.CreateAppUserParams{
+		Name:           "Admin",
+		Email:          "admin@example.com",
+		HashedPassword: "x",
+		Role:           repository.UserRoleADMIN,
+		Dni:            "00000000",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := model.AuthenticatedUser{ID: uuid.UUID(admin.UserID.Bytes), Name: admin.Name, Email: admin.Email, Role: admin.Role}
+
+	emailSvc, err := service.NewEmailService(&config.Config{SmtpHost: "localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	certSvc := service.NewCertificateService(pool, repo, emailSvc, service.NewWebhookService(repo), nil)
+	var certIDs []int32
+	for i := range 2 {
+		cert, err := certSvc.CreateCertificateFromForm(ctx, user, url.Values{
+			"ticket_name":        {fmt.Sprintf("INC000%d", i)},
+			"machine_user_dni":   {fmt.Sprintf("1000000%d", i)},
+			"machine_user_code":  {fmt.Sprintf("P%d", i)},
+			"machine_user_name":  {"Usuario"},
+			"machine_user_email": {fmt.Sprintf("usuario%d@example.com", i)},
+			"new_device_code":    {fmt.Sprintf("EQ-NEW-%d", i)},
+			"new_device_serial":  {fmt.Sprintf("SN-NEW-%d", i)},
+			"new_device_type":    {"LAPTOP"},
+			"new_device_status":  {"ASIGNACION"},
+			"old_device_code":    {fmt.Sprintf("EQ-OLD-%d", i)},
+			"old_device_serial":  {fmt.Sprintf("SN-OLD-%d", i)},
+			"old_device_type":    {"PC"},
+			"old_device_status":  {"RECUPERACION"},
+		})
+		if err != nil {
+			t.Fatalf("failed to create certificate %d: %v", i, err)
+		}
+		certIDs = append(certIDs, cert.CertificateID)
+	}
+
+	h := &ApiV1Handler{Repo: repo}
+	handlers := map[string]echo.HandlerFunc{
+		"listCertificates": h.ListCertificates,
+		"getCertificate":   h.GetCertificate,
+		"listMachineUsers": h.ListMachineUsers,
+		"getMachineUser":   h.GetMachineUser,
+		"listMachines":     h.ListMachines,
+		"getMachine":       h.GetMachine,
+		"listDevices":      h.ListDevices,
+		"getDevice":        h.GetDevice,
+		"getCatalogs":      h.GetCatalogs,
+	}
+	// Keys of the seeded records for the path parameters
+	found := map[string]string{"id": strconv.Itoa(int(certIDs[0])), "dni": "10000000", "serial": "SN-NEW-0", "code": "EQ-NEW-0"}
+	missing := map[string]string{"id": "999999", "dni": "NOPE", "serial": "NOPE", "code": "NOPE"}
+
+	e := echo.New()
+	api := e.Group("/api/v1", func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("user", user)
+			return next(c)
+		}
+	})
+	for _, op := range openAPIOperations {
+		handler, ok := handlers[op.id]
+		if !ok {
+			t.Fatalf("no handler for operation %s", op.id)
+		}
+		api.GET(strings.NewReplacer("{", ":", "}", "").Replace(op.path), handler)
+	}
+
+	// call requests a path and validates the response against the document.
+	call := func(t *testing.T, op openAPIOperation, path string, wantStatus int) map[string]any {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1"+path, nil))
+		if rec.Code != wantStatus {
+			t.Fatalf("GET %s = %d, want %d: %s", path, rec.Code, wantStatus, rec.Body)
+		}
+
+		responses := doc["paths"].(map[string]any)[op.path].(map[string]any)["get"].(map[string]any)["responses"].(map[string]any)
+		response, ok := responses[strconv.Itoa(rec.Code)].(map[string]any)
+		if !ok {
+			t.Fatalf("GET %s answered %d, which %s does not document", path, rec.Code, op.id)
+		}
+		schema := response["content"].(map[string]any)["application/json"].(map[string]any)["schema"].(map[string]any)
+
+		var body any
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		for _, msg := range validateSchema(doc, schema, body, op.id) {
+			t.Error(msg)
+		}
+		obj, _ := body.(map[string]any)
+		return obj
+	}
+
+	for _, op := range openAPIOperations {
+		t.Run(op.id, func(t *testing.T) {
+			if op.list {
+				page := call(t, op, op.path+"?limit=1", http.StatusOK)
+				if len(page["data"].([]any)) == 0 {
+					t.Fatalf("%s returned no data", op.id)
+				}
+				if cursor, ok := page["next_cursor"].(string); ok {
+					call(t, op, op.path+"?limit=1&cursor="+url.QueryEscape(cursor), http.StatusOK)
+				}
+				call(t, op, op.path+"?limit=0", http.StatusBadRequest)
+				return
+			}
+			if len(op.params) == 0 {
+				call(t, op, op.path, http.StatusOK)
+				return
+			}
+			param := op.params[0].name
+			call(t, op, strings.Replace(op.path, "{"+param+"}", found[param], 1), http.StatusOK)
+			call(t, op, strings.Replace(op.path, "{"+param+"}", missing[param], 1), http.StatusNotFound)
+		})
+	}
+}
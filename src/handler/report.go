@@ -0,0 +1,203 @@
+package handler
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"alc/model"
+	"alc/repository"
+	"alc/service"
+	"alc/view"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/labstack/echo/v4"
+)
+
+// ReportHandler serves the certificate reports. Supervisors only get the
+// certificates inside their scopes.
+type ReportHandler struct {
+	Repo   *repository.Queries
+	DBPool *pgxpool.Pool
+}
+
+// ShowReportPage shows the filters of the certificate report.
+func (h *ReportHandler) ShowReportPage(c echo.Context) error {
+	ctx := c.Request().Context()
+	technicians, err := h.Repo.ListAppUsers(ctx)
+	if err != nil {
+		log.Printf("Error listing technicians for report filters: %v", err)
+		return c.String(http.StatusInternalServerError, "Could not load the report filters.")
+	}
+	societySites, err := h.Repo.ListSocietySites(ctx)
+	if err != nil {
+		log.Printf("Error listing society sites for report filters: %v", err)
+		return c.String(http.StatusInternalServerError, "Could not load the report filters.")
+	}
+	return render(c, http.StatusOK, view.ReportPage(reportFilters(technicians, societySites)))
+}
+
+// reportFilters builds the options of the report form.
+func reportFilters(technicians []repository.AppUser, societySites []repository.ListSocietySitesRow) view.ReportFilterProps {
+	columns := make([]view.ReportColumn, len(service.CertificateReportColumns))
+	for i, col := range service.CertificateReportColumns {
+		columns[i] = view.ReportColumn(col)
+	}
+	return view.ReportFilterProps{
+		Technicians:  technicians,
+		SocietySites: societySites,
+		Columns:      columns,
+	}
+}
+
+// parseDateRange reads the from and to dates of a filter form. They are dates in Lima time,
+// both inclusive, and an empty value means no limit. The returned to is exclusive.
+func parseDateRange(c echo.Context) (from, to pgtype.Timestamptz, err error) {
+	if v := c.FormValue("from"); v != "" {
+		t, err := time.ParseInLocation("2006-01-02", v, view.LimaLocation)
+		if err != nil {
+			return from, to, errors.New("invalid from date")
+		}
+		from = pgtype.Timestamptz{Time: t, Valid: true}
+	}
+	if v := c.FormValue("to"); v != "" {
+		t, err := time.ParseInLocation("2006-01-02", v, view.LimaLocation)
+		if err != nil {
+			return from, to, errors.New("invalid to date")
+		}
+		to = pgtype.Timestamptz{Time: t.AddDate(0, 0, 1), Valid: true}
+	}
+	return from, to, nil
+}
+
+// parseReportFilters reads the filters of the report form, with the dates of
+// parseDateRange. The report subscription form posts the same fields, without the dates.
+func parseReportFilters(c echo.Context) (service.CertificateReportParams, error) {
+	var params service.CertificateReportParams
+
+	var err error
+	params.CreatedFrom, params.CreatedTo, err = parseDateRange(c)
+	if err != nil {
+		return params, err
+	}
+	if v := c.FormValue("status"); v != "" {
+		status := repository.CertificateStatus(v)
+		if !slices.Contains(repository.AllCertificateStatusValues(), status) {
+			return params, errors.New("invalid status")
+		}
+		params.Status = repository.NullCertificateStatus{CertificateStatus: status, Valid: true}
+	}
+	if v := c.FormValue("technician"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			return params, errors.New("invalid technician")
+		}
+		params.TechnicianID = pgtype.UUID{Bytes: id, Valid: true}
+	}
+	params.Society = strings.TrimSpace(c.FormValue("society"))
+	params.Site = strings.TrimSpace(c.FormValue("site"))
+	return params, nil
+}
+
+// HandleDownloadReport streams the certificate report, filtered by the query parameters of
+// parseReportFilters, as CSV or as an Excel workbook with format=xlsx. The columns can be
+// chosen with repeated col parameters; every column is included by default.
+func (h *ReportHandler) HandleDownloadReport(c echo.Context) error {
+	user, ok := c.Get("user").(model.AuthenticatedUser)
+	if !ok {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+
+	params, err := parseReportFilters(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid report filter: "+err.Error())
+	}
+	params.Scoped, params.ViewerID = viewerScope(user)
+
+	report := service.CertificateReport{
+		DBPool:  h.DBPool,
+		Params:  params,
+		Columns: service.SelectReportColumns(c.QueryParams()["col"]),
+	}
+
+	baseName := fmt.Sprintf("reporte_certificados_%s", time.Now().Format("20060102"))
+	res := c.Response()
+	if c.QueryParam("format") == "xlsx" {
+		res.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		res.Header().Set("Content-Disposition", "attachment; filename="+baseName+".xlsx")
+		err = report.WriteXLSX(c.Request().Context(), res)
+	} else {
+		res.Header().Set("Content-Type", "text/csv")
+		res.Header().Set("Content-Disposition", "attachment; filename="+baseName+".csv")
+		err = report.WriteCSV(c.Request().Context(), res)
+	}
+	if err != nil {
+		log.Printf("Error writing certificate report: %v", err)
+		// Once rows were sent the download can only be cut short
+		if !res.Committed {
+			res.Header().Del("Content-Disposition")
+			return c.String(http.StatusInternalServerError, "Could not generate report.")
+		}
+	}
+	return nil
+}
+
+// ShowOverdueLoans lists the loans past their due date, or downloads them with
+// format=csv.
+func (h *ReportHandler) ShowOverdueLoans(c echo.Context) error {
+	user, ok := c.Get("user").(model.AuthenticatedUser)
+	if !ok {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+
+	scoped, viewerID := viewerScope(user)
+	loans, err := h.Repo.ListOpenLoans(c.Request().Context(), repository.ListOpenLoansParams{
+		OverdueOnly: true,
+		Today:       service.LimaToday(),
+		Scoped:      scoped,
+		ViewerID:    viewerID,
+	})
+	if err != nil {
+		log.Printf("Error listing overdue loans: %v", err)
+		return c.String(http.StatusInternalServerError, "Could not load the overdue loans.")
+	}
+
+	if c.QueryParam("format") != "csv" {
+		return render(c, http.StatusOK, view.OverdueLoansPage(view.OverdueLoansPageProps{
+			Scoped: scoped,
+			Loans:  loans,
+		}))
+	}
+
+	res := c.Response()
+	res.Header().Set("Content-Type", "text/csv")
+	res.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=prestamos_vencidos_%s.csv", time.Now().Format("20060102")))
+	writer := csv.NewWriter(res)
+	writer.Write([]string{"Código Equipo", "DNI", "Usuario", "Sociedad", "Sede", "Ticket", "Técnico", "Fecha de Devolución", "Acta de Devolución"})
+	for _, loan := range loans {
+		returnStatus := ""
+		if loan.ReturnStatus.Valid {
+			returnStatus = string(loan.ReturnStatus.CertificateStatus)
+		}
+		writer.Write([]string{
+			loan.DeviceCode,
+			loan.MachineUserDni,
+			loan.MachineUserName,
+			loan.Society,
+			loan.Site,
+			loan.TicketName,
+			loan.TechnicianName,
+			loan.DueDate.Time.Format("2006-01-02"),
+			returnStatus,
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,237 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"alc/model"
+	"alc/repository"
+	"alc/service"
+	"alc/view"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+)
+
+// ScheduleHandler serves the visit calendar. Technicians book and manage their own visits;
+// coordinators, who can manage data, do it for any technician.
+type ScheduleHandler struct {
+	Repo           *repository.Queries
+	AppointmentSvc *service.AppointmentService
+}
+
+func isCoordinator(user model.AuthenticatedUser) bool {
+	return user.Can(model.PermManageData)
+}
+
+// weekStart returns the Monday, in Lima, of the week of the given date, or of the current
+// week when the date is empty or invalid.
+func weekStart(date string) time.Time {
+	day, err := time.ParseInLocation("2006-01-02", date, view.LimaLocation)
+	if err != nil {
+		now := time.Now().In(view.LimaLocation)
+		day = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, view.LimaLocation)
+	}
+	return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+}
+
+// scheduleTechnician returns the technician whose calendar is shown: the user, or for
+// coordinators the one chosen with the technician field.
+func scheduleTechnician(c echo.Context, user model.AuthenticatedUser) (uuid.UUID, error) {
+	if v := c.FormValue("technician"); v != "" && isCoordinator(user) {
+		return uuid.Parse(v)
+	}
+	return user.ID, nil
+}
+
+// renderSchedule renders the week of the technician's calendar with the booking form,
+// optionally with an error from it.
+func (h *ScheduleHandler) renderSchedule(c echo.Context, statusCode int, errorMsg string) error {
+	user, ok := c.Get("user").(model.AuthenticatedUser)
+	if !ok {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+	technicianID, err := scheduleTechnician(c, user)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Técnico inválido.")
+	}
+
+	ctx := c.Request().Context()
+	start := weekStart(c.FormValue("week"))
+	appointments, err := h.Repo.ListTechnicianAppointments(ctx, repository.ListTechnicianAppointmentsParams{
+		TechnicianID: pgtype.UUID{Bytes: technicianID, Valid: true},
+		StartsFrom:   pgtype.Timestamptz{Time: start, Valid: true},
+		StartsTo:     pgtype.Timestamptz{Time: start.AddDate(0, 0, 7), Valid: true},
+	})
+	if err != nil {
+		log.Printf("Error listing appointments of technician %s: %v", technicianID, err)
+		return c.String(http.StatusInternalServerError, "No se pudo cargar la agenda.")
+	}
+
+	dni := c.FormValue("dni")
+	if dni == "" {
+		dni = c.FormValue("machine_user_dni")
+	}
+	props := view.SchedulePageProps{
+		Coordinator:  isCoordinator(user),
+		TechnicianID: pgtype.UUID{Bytes: technicianID, Valid: true},
+		WeekStart:    start,
+		Appointments: appointments,
+		Dni:          dni,
+		ErrorMsg:     errorMsg,
+	}
+	if props.Coordinator {
+		users, err := h.Repo.ListAppUsers(ctx)
+		if err != nil {
+			log.Printf("Error listing technicians for the schedule: %v", err)
+			return c.String(http.StatusInternalServerError, "No se pudo cargar la agenda.")
+		}
+		for _, u := range users {
+			if u.IsActive && model.RoleCan(u.Role, model.PermCreateCertificates) {
+				props.Technicians = append(props.Technicians, u)
+			}
+		}
+	}
+
+	return render(c, statusCode, view.SchedulePage(props))
+}
+
+// ShowSchedule shows a week of visits. The week, technician and dni query parameters choose
+// the week, the technician for coordinators, and the machine user to book.
+func (h *ScheduleHandler) ShowSchedule(c echo.Context) error {
+	return h.renderSchedule(c, http.StatusOK, "")
+}
+
+// scheduleURL is the calendar of the week of t for the technician.
+func scheduleURL(t time.Time, technicianID pgtype.UUID) string {
+	return fmt.Sprintf("/schedule?week=%s&technician=%s", t.In(view.LimaLocation).Format("2006-01-02"), technicianID.String())
+}
+
+func (h *ScheduleHandler) HandleBookAppointment(c echo.Context) error {
+	user, ok := c.Get("user").(model.AuthenticatedUser)
+	if !ok {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+	technicianID, err := scheduleTechnician(c, user)
+	if err != nil {
+		return h.renderSchedule(c, http.StatusBadRequest, "Técnico inválido.")
+	}
+
+	ctx := c.Request().Context()
+	dni := strings.ReplaceAll(c.FormValue("machine_user_dni"), " ", "")
+	machineUser, err := h.Repo.GetMachineUserByDNI(ctx, dni)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return h.renderSchedule(c, http.StatusBadRequest, fmt.Sprintf("No existe un usuario de máquina con DNI %q.", dni))
+		}
+		log.Printf("Error fetching machine user %s for an appointment: %v", dni, err)
+		return h.renderSchedule(c, http.StatusInternalServerError, "No se pudo agendar la visita.")
+	}
+	if !machineUser.Active {
+		return h.renderSchedule(c, http.StatusConflict, fmt.Sprintf("El usuario con DNI %q está dado de baja.", dni))
+	}
+
+	date := c.FormValue("date")
+	startsAt, err := time.ParseInLocation("2006-01-02 15:04", date+" "+c.FormValue("start_time"), view.LimaLocation)
+	if err != nil {
+		return h.renderSchedule(c, http.StatusBadRequest, "Fecha u hora de inicio inválida.")
+	}
+	endsAt, err := time.ParseInLocation("2006-01-02 15:04", date+" "+c.FormValue("end_time"), view.LimaLocation)
+	if err != nil || !endsAt.After(startsAt) {
+		return h.renderSchedule(c, http.StatusBadRequest, "La hora de fin debe ser posterior a la de inicio.")
+	}
+
+	appt, err := h.AppointmentSvc.Book(ctx, service.BookAppointmentParams{
+		MachineUser:  machineUser,
+		TechnicianID: technicianID,
+		StartsAt:     startsAt,
+		EndsAt:       endsAt,
+		Location:     c.FormValue("location"),
+		Notes:        c.FormValue("notes"),
+		CreatedBy:    user.ID,
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrAppointmentOverlap) {
+			return h.renderSchedule(c, http.StatusConflict, "El técnico ya tiene una visita en ese horario.")
+		}
+		log.Printf("Error booking appointment with %s: %v", dni, err)
+		return h.renderSchedule(c, http.StatusInternalServerError, "No se pudo agendar la visita.")
+	}
+
+	return c.Redirect(http.StatusFound, scheduleURL(appt.StartsAt.Time, appt.TechnicianID))
+}
+
+// getAppointment loads the appointment of the :id route parameter if the user may manage
+// it, answering the request itself when not.
+func (h *ScheduleHandler) getAppointment(c echo.Context, user model.AuthenticatedUser) (repository.GetAppointmentDetailsRow, bool, error) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 32)
+	if err != nil {
+		return repository.GetAppointmentDetailsRow{}, false, c.String(http.StatusBadRequest, "ID de visita inválido.")
+	}
+	appt, err := h.Repo.GetAppointmentDetails(c.Request().Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return appt, false, c.String(http.StatusNotFound, "Visita no encontrada.")
+		}
+		log.Printf("Error fetching appointment %d: %v", id, err)
+		return appt, false, c.String(http.StatusInternalServerError, "No se pudo cargar la visita.")
+	}
+	if appt.TechnicianID.Bytes != user.ID && !isCoordinator(user) {
+		return appt, false, c.String(http.StatusForbidden, "La visita está asignada a otro técnico.")
+	}
+	return appt, true, nil
+}
+
+// HandleCompleteAppointment marks a visit as done and opens the certificate form filled in
+// with the machine user and the device planned for replacement.
+func (h *ScheduleHandler) HandleCompleteAppointment(c echo.Context) error {
+	user, ok := c.Get("user").(model.AuthenticatedUser)
+	if !ok {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+	appt, ok, err := h.getAppointment(c, user)
+	if !ok {
+		return err
+	}
+
+	if _, err := h.AppointmentSvc.Close(c.Request().Context(), appt.AppointmentID, repository.AppointmentStatusDONE); err != nil {
+		if errors.Is(err, service.ErrAppointmentClosed) {
+			return c.String(http.StatusConflict, "La visita ya fue cerrada.")
+		}
+		log.Printf("Error completing appointment %d: %v", appt.AppointmentID, err)
+		return c.String(http.StatusInternalServerError, "No se pudo actualizar la visita.")
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/certificates/new?dni=%s&old_device=%s",
+		url.QueryEscape(appt.MachineUserDni), url.QueryEscape(appt.OldDeviceCode)))
+}
+
+// HandleCancelAppointment cancels a visit, which sends the cancellation to the calendars.
+func (h *ScheduleHandler) HandleCancelAppointment(c echo.Context) error {
+	user, ok := c.Get("user").(model.AuthenticatedUser)
+	if !ok {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+	appt, ok, err := h.getAppointment(c, user)
+	if !ok {
+		return err
+	}
+
+	if _, err := h.AppointmentSvc.Close(c.Request().Context(), appt.AppointmentID, repository.AppointmentStatusCANCELLED); err != nil {
+		if errors.Is(err, service.ErrAppointmentClosed) {
+			return c.String(http.StatusConflict, "La visita ya fue cerrada.")
+		}
+		log.Printf("Error cancelling appointment %d: %v", appt.AppointmentID, err)
+		return c.String(http.StatusInternalServerError, "No se pudo cancelar la visita.")
+	}
+
+	return c.Redirect(http.StatusFound, scheduleURL(appt.StartsAt.Time, appt.TechnicianID))
+}
@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"alc/model"
+	"alc/repository"
+	"alc/service"
+	"alc/view"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// StockHandler serves the machines in stock, waiting to be assigned, and their
+// reservations. Admins also set the alert level of each profile.
+type StockHandler struct {
+	Repo     *repository.Queries
+	StockSvc *service.StockService
+}
+
+// stockFilter reads the site and profile the machine list is narrowed to.
+func stockFilter(c echo.Context) (string, repository.NullMachineProfile) {
+	site := strings.TrimSpace(c.FormValue("site"))
+	profile := repository.MachineProfile(c.FormValue("profile"))
+	if slices.Contains(repository.AllMachineProfileValues(), profile) {
+		return site, repository.NullMachineProfile{MachineProfile: profile, Valid: true}
+	}
+	return site, repository.NullMachineProfile{}
+}
+
+// renderStock renders the stock page, optionally with an error from one of its forms.
+func (h *StockHandler) renderStock(c echo.Context, statusCode int, errorMsg string) error {
+	user, ok := c.Get("user").(model.AuthenticatedUser)
+	if !ok {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+
+	ctx := c.Request().Context()
+	site, profile := stockFilter(c)
+	summary, err := h.Repo.SummarizeMachineStock(ctx)
+	if err != nil {
+		log.Printf("Error summarizing machine stock: %v", err)
+		return c.String(http.StatusInternalServerError, "No se pudo cargar el stock.")
+	}
+	machines, err := h.Repo.ListStockMachines(ctx, repository.ListStockMachinesParams{
+		Site:    site,
+		Profile: profile,
+	})
+	if err != nil {
+		log.Printf("Error listing machines in stock: %v", err)
+		return c.String(http.StatusInternalServerError, "No se pudo cargar el stock.")
+	}
+	levels, err := h.Repo.ListStockAlertLevels(ctx)
+	if err != nil {
+		log.Printf("Error listing stock alert levels: %v", err)
+		return c.String(http.StatusInternalServerError, "No se pudo cargar el stock.")
+	}
+	sites, err := h.Repo.ListMachineSites(ctx)
+	if err != nil {
+		log.Printf("Error listing sites for the stock: %v", err)
+		return c.String(http.StatusInternalServerError, "No se pudo cargar el stock.")
+	}
+
+	var alerts []view.StockAlert
+	for _, alert := range service.LowStockAlerts(summary, levels) {
+		alerts = append(alerts, view.StockAlert(alert))
+	}
+	return render(c, statusCode, view.StockPage(view.StockPageProps{
+		CanManage: user.Can(model.PermManageData),
+		Summary:   summary,
+		Machines:  machines,
+		Levels:    levels,
+		Alerts:    alerts,
+		Sites:     sites,
+		Site:      site,
+		Profile:   profile,
+		Dni:       c.FormValue("dni"),
+		ErrorMsg:  errorMsg,
+	}))
+}
+
+// ShowStock shows the stock levels per site, profile and model, and the machines without
+// a device. The site and profile query parameters filter the machines, and dni fills in
+// the machine user to reserve for.
+func (h *StockHandler) ShowStock(c echo.Context) error {
+	return h.renderStock(c, http.StatusOK, "")
+}
+
+// stockURL is the stock page keeping the filters of the form that was submitted.
+func stockURL(c echo.Context) string {
+	q := url.Values{}
+	for _, key := range []string{"site", "profile"} {
+		if v := c.FormValue(key); v != "" {
+			q.Set(key, v)
+		}
+	}
+	if len(q) == 0 {
+		return "/stock"
+	}
+	return "/stock?" + q.Encode()
+}
+
+// machineSerialParam decodes the :serial route parameter.
+func machineSerialParam(c echo.Context) (string, error) {
+	return url.PathUnescape(c.Param("serial"))
+}
+
+func (h *StockHandler) HandleReserveMachine(c echo.Context) error {
+	user, ok := c.Get("user").(model.AuthenticatedUser)
+	if !ok {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+	serial, err := machineSerialParam(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Número de serie inválido.")
+	}
+
+	dni := c.FormValue("machine_user_dni")
+	_, err = h.StockSvc.Reserve(c.Request().Context(), serial, dni, c.FormValue("notes"), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return h.renderStock(c, http.StatusBadRequest, fmt.Sprintf("No existe un usuario de máquina con DNI %q.", dni))
+		case errors.Is(err, service.ErrMachineUserInactive):
+			return h.renderStock(c, http.StatusConflict, fmt.Sprintf("El usuario con DNI %q está dado de baja.", dni))
+		case errors.Is(err, service.ErrUserHasReservation):
+			return h.renderStock(c, http.StatusConflict, fmt.Sprintf("El usuario con DNI %q ya tiene un equipo reservado.", dni))
+		case errors.Is(err, service.ErrMachineNotInStock):
+			return h.renderStock(c, http.StatusConflict, fmt.Sprintf("El equipo %q ya no está en stock.", serial))
+		case isUniqueViolation(err):
+			return h.renderStock(c, http.StatusConflict, fmt.Sprintf("El equipo %q ya está reservado.", serial))
+		}
+		log.Printf("Error reserving machine %s for %s: %v", serial, dni, err)
+		return h.renderStock(c, http.StatusInternalServerError, "No se pudo reservar el equipo.")
+	}
+	return c.Redirect(http.StatusFound, stockURL(c))
+}
+
+func (h *StockHandler) HandleReleaseReservation(c echo.Context) error {
+	serial, err := machineSerialParam(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Número de serie inválido.")
+	}
+	if _, err := h.Repo.ReleaseMachineReservation(c.Request().Context(), serial); err != nil {
+		log.Printf("Error releasing reservation of machine %s: %v", serial, err)
+		return h.renderStock(c, http.StatusInternalServerError, "No se pudo liberar la reserva.")
+	}
+	return c.Redirect(http.StatusFound, stockURL(c))
+}
+
+// HandleSaveAlertLevels sets the alert level of every profile from the min_<PROFILE>
+// fields. An empty field removes the alert of its profile.
+func (h *StockHandler) HandleSaveAlertLevels(c echo.Context) error {
+	levels := map[repository.MachineProfile]int{}
+	for _, profile := range repository.AllMachineProfileValues() {
+		value := strings.TrimSpace(c.FormValue("min_" + string(profile)))
+		if value == "" {
+			continue
+		}
+		minAvailable, err := strconv.Atoi(value)
+		if err != nil || minAvailable < 0 {
+			return h.renderStock(c, http.StatusBadRequest, fmt.Sprintf("El mínimo de %s debe ser un número entero no negativo.", profile))
+		}
+		levels[profile] = minAvailable
+	}
+
+	ctx := c.Request().Context()
+	for _, profile := range repository.AllMachineProfileValues() {
+		var err error
+		if minAvailable, ok := levels[profile]; ok {
+			err = h.Repo.UpsertStockAlertLevel(ctx, repository.UpsertStockAlertLevelParams{
+				Profile:      profile,
+				MinAvailable: int32(minAvailable),
+			})
+		} else {
+			err = h.Repo.DeleteStockAlertLevel(ctx, profile)
+		}
+		if err != nil {
+			log.Printf("Error saving stock alert of %s: %v", profile, err)
+			return h.renderStock(c, http.StatusInternalServerError, "No se pudieron guardar las alertas.")
+		}
+	}
+	return c.Redirect(http.StatusFound, stockURL(c))
+}
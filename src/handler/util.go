@@ -1,9 +1,12 @@
 package handler
 
 import (
+	"errors"
+	"net/http"
+
 	"github.com/a-h/templ"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/labstack/echo/v4"
-	"net/http"
 )
 
 func render(ctx echo.Context, statusCode int, t templ.Component) error {
@@ -20,3 +23,9 @@ func render(ctx echo.Context, statusCode int, t templ.Component) error {
 func renderOK(ctx echo.Context, t templ.Component) error {
 	return render(ctx, http.StatusOK, t)
 }
+
+// isUniqueViolation reports whether err comes from a UNIQUE constraint in PostgreSQL.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"alc/model"
+	"alc/repository"
+	"alc/service"
+	"alc/view"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// WarehouseHandler serves the pipeline of recovered devices: their intake in the
+// warehouse, the wipe of their data and their disposition, with a certificate per step.
+type WarehouseHandler struct {
+	Repo        *repository.Queries
+	RecoverySvc *service.RecoveryService
+}
+
+// renderWarehouse renders the warehouse dashboard, optionally with an error from one of
+// its forms.
+func (h *WarehouseHandler) renderWarehouse(c echo.Context, statusCode int, errorMsg string) error {
+	ctx := c.Request().Context()
+	awaiting, err := h.Repo.ListDevicesAwaitingIntake(ctx)
+	if err != nil {
+		log.Printf("Error listing devices awaiting intake: %v", err)
+		return c.String(http.StatusInternalServerError, "No se pudo cargar el almacén.")
+	}
+	open, err := h.Repo.ListOpenRecoveries(ctx)
+	if err != nil {
+		log.Printf("Error listing open recoveries: %v", err)
+		return c.String(http.StatusInternalServerError, "No se pudo cargar el almacén.")
+	}
+	counts, err := h.Repo.CountRecoveriesByStage(ctx)
+	if err != nil {
+		log.Printf("Error counting recoveries: %v", err)
+		return c.String(http.StatusInternalServerError, "No se pudo cargar el almacén.")
+	}
+
+	updated, _ := strconv.Atoi(c.QueryParam("updated"))
+	return render(c, statusCode, view.WarehousePage(view.WarehousePageProps{
+		AwaitingIntake: awaiting,
+		Recoveries:     open,
+		Counts:         counts,
+		WipeMethods:    service.WipeMethods,
+		Updated:        int32(updated),
+		ErrorMsg:       errorMsg,
+	}))
+}
+
+// ShowWarehouse lists the devices waiting for intake, for a wipe and for a disposition.
+func (h *WarehouseHandler) ShowWarehouse(c echo.Context) error {
+	return h.renderWarehouse(c, http.StatusOK, "")
+}
+
+// warehouseURL is the dashboard highlighting the recovery that was just updated.
+func warehouseURL(recoveryID int32) string {
+	return fmt.Sprintf("/warehouse?updated=%d", recoveryID)
+}
+
+func (h *WarehouseHandler) HandleReceiveDevice(c echo.Context) error {
+	user, ok := c.Get("user").(model.AuthenticatedUser)
+	if !ok {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+
+	code := c.FormValue("device_code")
+	recovery, err := h.RecoverySvc.Receive(c.Request().Context(), code, c.FormValue("location"), c.FormValue("notes"), user.ID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return h.renderWarehouse(c, http.StatusBadRequest, fmt.Sprintf("El equipo %q no está en custodia del almacén.", code))
+		}
+		if isUniqueViolation(err) {
+			return h.renderWarehouse(c, http.StatusConflict, fmt.Sprintf("El ingreso del equipo %q ya fue registrado.", code))
+		}
+		log.Printf("Error registering intake of device %s: %v", code, err)
+		return h.renderWarehouse(c, http.StatusInternalServerError, "No se pudo registrar el ingreso.")
+	}
+	return c.Redirect(http.StatusFound, warehouseURL(recovery.RecoveryID))
+}
+
+// recoveryID parses the :id route parameter.
+func recoveryID(c echo.Context) (int32, error) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 32)
+	return int32(id), err
+}
+
+func (h *WarehouseHandler) HandleRecordWipe(c echo.Context) error {
+	user, ok := c.Get("user").(model.AuthenticatedUser)
+	if !ok {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+	id, err := recoveryID(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "ID de recuperación inválido.")
+	}
+
+	recovery, err := h.RecoverySvc.RecordWipe(c.Request().Context(), service.RecordWipeParams{
+		RecoveryID: id,
+		Method:     c.FormValue("method"),
+		Tool:       c.FormValue("tool"),
+		Operator:   c.FormValue("operator"),
+		Notes:      c.FormValue("notes"),
+		WipedBy:    user.ID,
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrRecoveryStep) {
+			return h.renderWarehouse(c, http.StatusConflict, "El borrado de este equipo ya fue registrado.")
+		}
+		return h.renderWarehouse(c, http.StatusBadRequest, "No se pudo registrar el borrado: "+err.Error())
+	}
+	return c.Redirect(http.StatusFound, warehouseURL(recovery.RecoveryID))
+}
+
+func (h *WarehouseHandler) HandleRecordDisposition(c echo.Context) error {
+	user, ok := c.Get("user").(model.AuthenticatedUser)
+	if !ok {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+	id, err := recoveryID(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "ID de recuperación inválido.")
+	}
+
+	recovery, err := h.RecoverySvc.RecordDisposition(c.Request().Context(), service.RecordDispositionParams{
+		RecoveryID:  id,
+		Disposition: repository.RecoveryDisposition(c.FormValue("disposition")),
+		Reference:   c.FormValue("reference"),
+		Notes:       c.FormValue("notes"),
+		DisposedBy:  user.ID,
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrRecoveryStep) {
+			return h.renderWarehouse(c, http.StatusConflict, "El equipo debe tener el borrado registrado y no tener destino aún.")
+		}
+		log.Printf("Error recording disposition of recovery %d: %v", id, err)
+		return h.renderWarehouse(c, http.StatusBadRequest, "No se pudo registrar el destino: "+err.Error())
+	}
+	return c.Redirect(http.StatusFound, warehouseURL(recovery.RecoveryID))
+}
+
+// ShowRecoveryCertificate renders the certificate of a step of a recovery: intake, wipe or
+// disposition. Steps not reached yet have no certificate.
+func (h *WarehouseHandler) ShowRecoveryCertificate(c echo.Context) error {
+	id, err := recoveryID(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "ID de recuperación inválido.")
+	}
+	recovery, err := h.Repo.GetRecoveryDetails(c.Request().Context(), id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return c.String(http.StatusNotFound, "Recuperación no encontrada.")
+		}
+		log.Printf("Error fetching recovery %d: %v", id, err)
+		return c.String(http.StatusInternalServerError, "No se pudo cargar el certificado.")
+	}
+
+	step := view.RecoveryStep(c.Param("step"))
+	switch step {
+	case view.RecoveryStepIntake:
+	case view.RecoveryStepWipe:
+		if !recovery.WipedAt.Valid {
+			return c.String(http.StatusNotFound, "El borrado de este equipo aún no fue registrado.")
+		}
+	case view.RecoveryStepDisposition:
+		if !recovery.Disposition.Valid {
+			return c.String(http.StatusNotFound, "El destino de este equipo aún no fue registrado.")
+		}
+	default:
+		return c.String(http.StatusNotFound, "Certificado no encontrado.")
+	}
+	return render(c, http.StatusOK, view.RecoveryCertificatePage(recovery, step))
+}
@@ -0,0 +1,29 @@
+package model
+
+// APIScope limits what a personal access token can read through the JSON API.
+type APIScope string
+
+const (
+	APIScopeCertificates APIScope = "certificates:read"
+	APIScopeMachineUsers APIScope = "machine_users:read"
+	APIScopeInventory    APIScope = "inventory:read"
+	APIScopeCatalogs     APIScope = "catalogs:read"
+)
+
+// APIScopes lists every scope a token can be granted, in display order.
+var APIScopes = []APIScope{
+	APIScopeCertificates,
+	APIScopeMachineUsers,
+	APIScopeInventory,
+	APIScopeCatalogs,
+}
+
+// ValidAPIScope reports whether s is a known scope.
+func ValidAPIScope(s string) bool {
+	for _, scope := range APIScopes {
+		if string(scope) == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package model
+
+import "alc/repository"
+
+// Permission is an action a role is allowed to perform.
+type Permission string
+
+const (
+	PermManageUsers        Permission = "manage_users"
+	PermManageData         Permission = "manage_data"
+	PermViewReports        Permission = "view_reports"
+	PermViewStats          Permission = "view_stats"
+	PermBrowseCertificates Permission = "browse_certificates"
+	PermCreateCertificates Permission = "create_certificates"
+)
+
+var rolePermissions = map[repository.UserRole][]Permission{
+	repository.UserRoleADMIN: {
+		PermManageUsers,
+		PermManageData,
+		PermViewReports,
+		PermViewStats,
+		PermBrowseCertificates,
+		PermCreateCertificates,
+	},
+	repository.UserRoleSUPERVISOR: {
+		PermViewReports,
+		PermViewStats,
+		PermBrowseCertificates,
+	},
+	repository.UserRoleTECNICO: {
+		PermCreateCertificates,
+	},
+}
+
+// RoleCan reports whether the role grants the permission.
+func RoleCan(role repository.UserRole, p Permission) bool {
+	for _, granted := range rolePermissions[role] {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Can reports whether the user's role grants the permission.
+func (u AuthenticatedUser) Can(p Permission) bool {
+	return RoleCan(u.Role, p)
+}
+
+// IsScoped reports whether what the user sees is limited to their app_user_scopes.
+func (u AuthenticatedUser) IsScoped() bool {
+	return u.Role == repository.UserRoleSUPERVISOR
+}
@@ -0,0 +1,265 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+
+	"alc/repository"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const minPasswordLength = 10
+
+var (
+	ErrInvalidCurrentPassword = errors.New("la contraseña actual es incorrecta")
+	ErrInvalidResetToken      = errors.New("el enlace de recuperación es inválido o ha expirado")
+	// ErrExternalAccount is returned when resetting the password of a user created by LDAP
+	// or OpenID Connect, who has no local password.
+	ErrExternalAccount = errors.New("the user signs in through the directory or single sign-on and has no local password")
+)
+
+type AccountService struct {
+	DBPool   *pgxpool.Pool
+	Repo     *repository.Queries
+	EmailSvc *EmailService
+}
+
+func NewAccountService(db *pgxpool.Pool, r *repository.Queries, emailSvc *EmailService) *AccountService {
+	return &AccountService{
+		DBPool:   db,
+		Repo:     r,
+		EmailSvc: emailSvc,
+	}
+}
+
+// ValidatePassword enforces the password-strength policy for app users.
+func ValidatePassword(password, email string) error {
+	if len([]rune(password)) < minPasswordLength {
+		return fmt.Errorf("la contraseña debe tener al menos %d caracteres", minPasswordLength)
+	}
+
+	var hasUpper, hasLower, hasDigit bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		}
+	}
+	if !hasUpper || !hasLower || !hasDigit {
+		return errors.New("la contraseña debe incluir mayúsculas, minúsculas y números")
+	}
+
+	localPart := strings.ToLower(strings.Split(email, "@")[0])
+	if len(localPart) >= 4 && strings.Contains(strings.ToLower(password), localPart) {
+		return errors.New("la contraseña no puede contener el correo del usuario")
+	}
+	return nil
+}
+
+// HashPassword validates the password against the policy and returns its bcrypt hash.
+func HashPassword(password, email string) (string, error) {
+	if err := ValidatePassword(password, email); err != nil {
+		return "", err
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hashed), nil
+}
+
+// unusablePasswordHash returns the hash of a random value nobody knows.
+func unusablePasswordHash() (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(uuid.NewString()), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hashed), nil
+}
+
+// setPassword stores the new hash and invalidates every session and pending reset token of the user.
+// When resetToken is given, it is consumed in the same transaction.
+func (s *AccountService) setPassword(ctx context.Context, userID pgtype.UUID, hashedPassword string, resetToken *pgtype.UUID) error {
+	tx, err := s.DBPool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := s.Repo.WithTx(tx)
+
+	if resetToken != nil {
+		if _, err := qtx.ConsumePasswordResetToken(ctx, *resetToken); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrInvalidResetToken
+			}
+			return fmt.Errorf("failed to consume reset token: %w", err)
+		}
+	}
+
+	if err := qtx.UpdateAppUserPassword(ctx, repository.UpdateAppUserPasswordParams{
+		UserID:         userID,
+		HashedPassword: hashedPassword,
+	}); err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+	if err := qtx.DeleteAppSessionsByUser(ctx, userID); err != nil {
+		return fmt.Errorf("failed to delete sessions: %w", err)
+	}
+	if err := qtx.InvalidatePasswordResetTokens(ctx, userID); err != nil {
+		return fmt.Errorf("failed to invalidate reset tokens: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ChangePassword verifies the current password, applies the new one and
+// returns a fresh session, since all previous sessions are invalidated.
+func (s *AccountService) ChangePassword(ctx context.Context, userID uuid.UUID, currentPassword, newPassword string) (repository.AppSession, error) {
+	pgxUserID := pgtype.UUID{Bytes: userID, Valid: true}
+
+	user, err := s.Repo.GetAppUserByID(ctx, pgxUserID)
+	if err != nil {
+		return repository.AppSession{}, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.HashedPassword), []byte(currentPassword)); err != nil {
+		return repository.AppSession{}, ErrInvalidCurrentPassword
+	}
+	if currentPassword == newPassword {
+		return repository.AppSession{}, errors.New("la nueva contraseña debe ser diferente a la actual")
+	}
+
+	hashed, err := HashPassword(newPassword, user.Email)
+	if err != nil {
+		return repository.AppSession{}, err
+	}
+
+	if err := s.setPassword(ctx, pgxUserID, hashed, nil); err != nil {
+		return repository.AppSession{}, err
+	}
+
+	return s.Repo.CreateAppSession(ctx, pgxUserID)
+}
+
+// RequestPasswordReset emails a single-use reset link if the email belongs to an app user
+// with a local password. Other emails are ignored so the caller cannot tell which accounts
+// exist.
+func (s *AccountService) RequestPasswordReset(ctx context.Context, email string) error {
+	user, err := s.Repo.GetAppUserByEmail(ctx, strings.ToLower(strings.TrimSpace(email)))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("Password reset requested for unknown email: %s", email)
+			return nil
+		}
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	if !user.IsActive {
+		log.Printf("Password reset requested for disabled user: %s", email)
+		return nil
+	}
+	if user.ExternalAuth {
+		log.Printf("Password reset requested for external user: %s", email)
+		return nil
+	}
+	return s.sendResetLink(ctx, user)
+}
+
+// ForcePasswordReset is the admin action: the current password stops working,
+// every session is closed and the user receives a reset link. It returns
+// ErrExternalAccount for users created by LDAP or OpenID Connect, and pgx.ErrNoRows for
+// deleted ones.
+func (s *AccountService) ForcePasswordReset(ctx context.Context, userID uuid.UUID) error {
+	pgxUserID := pgtype.UUID{Bytes: userID, Valid: true}
+
+	user, err := s.Repo.GetAppUserByID(ctx, pgxUserID)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	if user.DeletedAt.Valid {
+		return fmt.Errorf("user %s was deleted: %w", userID, pgx.ErrNoRows)
+	}
+	if user.ExternalAuth {
+		return ErrExternalAccount
+	}
+
+	randomHash, err := unusablePasswordHash()
+	if err != nil {
+		return err
+	}
+	if err := s.setPassword(ctx, pgxUserID, randomHash, nil); err != nil {
+		return err
+	}
+
+	return s.sendResetLink(ctx, user)
+}
+
+func (s *AccountService) sendResetLink(ctx context.Context, user repository.AppUser) error {
+	token, err := s.Repo.CreatePasswordResetToken(ctx, user.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to create reset token: %w", err)
+	}
+
+	go func() {
+		if err := s.EmailSvc.SendPasswordResetEmail(context.Background(), user, token); err != nil {
+			log.Printf("ERROR: Failed to send password reset email to %s: %v", user.Email, err)
+		}
+	}()
+
+	return nil
+}
+
+// CheckResetToken reports whether the token can still be used.
+func (s *AccountService) CheckResetToken(ctx context.Context, token uuid.UUID) error {
+	_, err := s.Repo.GetValidPasswordResetToken(ctx, pgtype.UUID{Bytes: token, Valid: true})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrInvalidResetToken
+		}
+		return fmt.Errorf("failed to get reset token: %w", err)
+	}
+	return nil
+}
+
+// ResetPassword consumes the token and sets the new password.
+func (s *AccountService) ResetPassword(ctx context.Context, token uuid.UUID, newPassword string) error {
+	pgxToken := pgtype.UUID{Bytes: token, Valid: true}
+
+	resetToken, err := s.Repo.GetValidPasswordResetToken(ctx, pgxToken)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrInvalidResetToken
+		}
+		return fmt.Errorf("failed to get reset token: %w", err)
+	}
+
+	user, err := s.Repo.GetAppUserByID(ctx, resetToken.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	// Links sent before the user was created by a provider
+	if user.ExternalAuth {
+		return ErrInvalidResetToken
+	}
+
+	// Validate before consuming so a weak password does not burn the link
+	hashed, err := HashPassword(newPassword, user.Email)
+	if err != nil {
+		return err
+	}
+
+	return s.setPassword(ctx, user.UserID, hashed, &pgxToken)
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"alc/model"
+	"alc/repository"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// apiTokenPrefix marks the personal access tokens of this app, which helps secret scanners.
+const apiTokenPrefix = "alc_"
+
+var ErrInvalidAPIToken = errors.New("invalid or expired API token")
+
+// APITokenService issues and checks the personal access tokens of the JSON API.
+// Only a SHA-256 hash of each token is stored, so a token is shown once when created.
+type APITokenService struct {
+	Repo *repository.Queries
+}
+
+func NewAPITokenService(r *repository.Queries) *APITokenService {
+	return &APITokenService{Repo: r}
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create issues a token for the user. expiresIn is zero for tokens that do not expire.
+// The plain token is returned only here.
+func (s *APITokenService) Create(ctx context.Context, userID uuid.UUID, name string, scopes []string, expiresIn time.Duration) (string, repository.ApiToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", repository.ApiToken{}, errors.New("the token name is required")
+	}
+	if len(scopes) == 0 {
+		return "", repository.ApiToken{}, errors.New("select at least one scope")
+	}
+	for _, scope := range scopes {
+		if !model.ValidAPIScope(scope) {
+			return "", repository.ApiToken{}, fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", repository.ApiToken{}, fmt.Errorf("failed to generate token: %w", err)
+	}
+	plain := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	var expiresAt pgtype.Timestamptz
+	if expiresIn > 0 {
+		expiresAt = pgtype.Timestamptz{Time: time.Now().Add(expiresIn), Valid: true}
+	}
+
+	token, err := s.Repo.CreateAPIToken(ctx, repository.CreateAPITokenParams{
+		UserID:      pgtype.UUID{Bytes: userID, Valid: true},
+		Name:        name,
+		TokenPrefix: plain[:len(apiTokenPrefix)+6],
+		TokenHash:   hashAPIToken(plain),
+		Scopes:      scopes,
+		ExpiresAt:   expiresAt,
+	})
+	if err != nil {
+		return "", repository.ApiToken{}, fmt.Errorf("failed to create token: %w", err)
+	}
+	return plain, token, nil
+}
+
+// Authenticate resolves a plain token to its owner and scopes and records its use.
+func (s *APITokenService) Authenticate(ctx context.Context, plain string) (repository.GetAPITokenUserByHashRow, error) {
+	if !strings.HasPrefix(plain, apiTokenPrefix) {
+		return repository.GetAPITokenUserByHashRow{}, ErrInvalidAPIToken
+	}
+
+	token, err := s.Repo.GetAPITokenUserByHash(ctx, hashAPIToken(plain))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return repository.GetAPITokenUserByHashRow{}, ErrInvalidAPIToken
+		}
+		return repository.GetAPITokenUserByHashRow{}, fmt.Errorf("failed to get token: %w", err)
+	}
+
+	if err := s.Repo.TouchAPIToken(ctx, token.TokenID); err != nil {
+		// Not critical, the request can go on
+		log.Printf("Error updating last use of API token %s: %v", token.TokenID.String(), err)
+	}
+	return token, nil
+}
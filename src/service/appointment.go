@@ -0,0 +1,206 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"alc/repository"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var (
+	// ErrAppointmentOverlap is returned when the technician already has a scheduled visit
+	// in the requested time window.
+	ErrAppointmentOverlap = errors.New("the technician already has a visit at that time")
+	// ErrAppointmentClosed is returned when a visit that is already done or cancelled is
+	// closed again.
+	ErrAppointmentClosed = errors.New("the appointment is already done or cancelled")
+)
+
+// AppointmentService books visits with machine users and sends their calendar
+// invitations.
+type AppointmentService struct {
+	Repo  *repository.Queries
+	Email *EmailService
+}
+
+func NewAppointmentService(r *repository.Queries, emailSvc *EmailService) *AppointmentService {
+	return &AppointmentService{Repo: r, Email: emailSvc}
+}
+
+// BookAppointmentParams describes a visit to book. An empty Location defaults to the site
+// and floor of the machine user.
+type BookAppointmentParams struct {
+	MachineUser  repository.MachineUser
+	TechnicianID uuid.UUID
+	StartsAt     time.Time
+	EndsAt       time.Time
+	Location     string
+	Notes        string
+	CreatedBy    uuid.UUID
+}
+
+// MachineUserLocation describes where a machine user works, e.g. "Sede Central - Piso 3".
+func MachineUserLocation(user repository.MachineUser) string {
+	if user.FloorName == "" {
+		return user.Site
+	}
+	return fmt.Sprintf("%s - Piso %s", user.Site, user.FloorName)
+}
+
+// Book schedules a visit and emails the invitation to the machine user and the
+// technician. The email is sent in the background; failures are only logged.
+func (s *AppointmentService) Book(ctx context.Context, p BookAppointmentParams) (repository.Appointment, error) {
+	if !p.EndsAt.After(p.StartsAt) {
+		return repository.Appointment{}, errors.New("the visit must end after it starts")
+	}
+	technicianID := pgtype.UUID{Bytes: p.TechnicianID, Valid: true}
+	startsAt := pgtype.Timestamptz{Time: p.StartsAt, Valid: true}
+	endsAt := pgtype.Timestamptz{Time: p.EndsAt, Valid: true}
+
+	overlapping, err := s.Repo.CountOverlappingAppointments(ctx, repository.CountOverlappingAppointmentsParams{
+		TechnicianID: technicianID,
+		StartsAt:     startsAt,
+		EndsAt:       endsAt,
+	})
+	if err != nil {
+		return repository.Appointment{}, err
+	}
+	if overlapping > 0 {
+		return repository.Appointment{}, ErrAppointmentOverlap
+	}
+
+	location := strings.TrimSpace(p.Location)
+	if location == "" {
+		location = MachineUserLocation(p.MachineUser)
+	}
+	appt, err := s.Repo.CreateAppointment(ctx, repository.CreateAppointmentParams{
+		MachineUserDni: p.MachineUser.Dni,
+		TechnicianID:   technicianID,
+		StartsAt:       startsAt,
+		EndsAt:         endsAt,
+		Location:       location,
+		Notes:          strings.TrimSpace(p.Notes),
+		CreatedBy:      pgtype.UUID{Bytes: p.CreatedBy, Valid: true},
+	})
+	if err != nil {
+		return appt, err
+	}
+
+	s.sendInvitation(appt.AppointmentID)
+	return appt, nil
+}
+
+// Close marks a scheduled visit as DONE or CANCELLED. A cancelled visit is sent to the
+// machine user and the technician as a cancellation so the event leaves their calendars; a
+// done one stays there as it happened.
+func (s *AppointmentService) Close(ctx context.Context, appointmentID int32, status repository.AppointmentStatus) (repository.Appointment, error) {
+	appt, err := s.Repo.SetAppointmentStatus(ctx, repository.SetAppointmentStatusParams{
+		AppointmentID: appointmentID,
+		Status:        status,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return appt, ErrAppointmentClosed
+		}
+		return appt, err
+	}
+
+	if status == repository.AppointmentStatusCANCELLED {
+		s.sendInvitation(appt.AppointmentID)
+	}
+	return appt, nil
+}
+
+// sendInvitation emails the current state of an appointment in the background.
+func (s *AppointmentService) sendInvitation(appointmentID int32) {
+	go func() {
+		ctx := context.Background()
+		appt, err := s.Repo.GetAppointmentDetails(ctx, appointmentID)
+		if err != nil {
+			log.Printf("ERROR: Failed to load appointment %d for its invitation: %v", appointmentID, err)
+			return
+		}
+		if err := s.Email.SendAppointmentEmail(ctx, appt); err != nil {
+			log.Printf("ERROR: Failed to send invitation of appointment %d: %v", appointmentID, err)
+		}
+	}()
+}
+
+// icsEscape escapes a TEXT value of an iCalendar property (RFC 5545, 3.3.11).
+func icsEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// icsFold folds a content line longer than 75 octets, without splitting UTF-8 sequences.
+func icsFold(line string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range line {
+		size := len(string(r))
+		if n+size > 75 {
+			b.WriteString("\r\n ")
+			n = 1
+		}
+		b.WriteRune(r)
+		n += size
+	}
+	b.WriteString("\r\n")
+	return b.String()
+}
+
+// AppointmentICS builds the calendar invitation of an appointment: a REQUEST while it is
+// scheduled and a CANCEL once it is cancelled. uidDomain makes the event UID unique to this
+// installation, and organizer is the address the invitation is sent from.
+func AppointmentICS(appt repository.GetAppointmentDetailsRow, uidDomain, organizer string, now time.Time) []byte {
+	const stamp = "20060102T150405Z"
+	method, status := "REQUEST", "CONFIRMED"
+	if appt.Status == repository.AppointmentStatusCANCELLED {
+		method, status = "CANCEL", "CANCELLED"
+	}
+
+	description := fmt.Sprintf("Visita del técnico %s para la renovación del equipo de %s.", appt.TechnicianName, appt.MachineUserName)
+	if appt.Notes != "" {
+		description += "\n" + appt.Notes
+	}
+
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//Renovacion Tecnologica//Visitas//ES",
+		"METHOD:" + method,
+		"BEGIN:VEVENT",
+		fmt.Sprintf("UID:appointment-%d@%s", appt.AppointmentID, uidDomain),
+		fmt.Sprintf("SEQUENCE:%d", appt.Sequence),
+		"DTSTAMP:" + now.UTC().Format(stamp),
+		"DTSTART:" + appt.StartsAt.Time.UTC().Format(stamp),
+		"DTEND:" + appt.EndsAt.Time.UTC().Format(stamp),
+		"SUMMARY:" + icsEscape("Renovación de equipo - "+appt.MachineUserName),
+		"LOCATION:" + icsEscape(appt.Location),
+		"DESCRIPTION:" + icsEscape(description),
+		"STATUS:" + status,
+		"ORGANIZER:mailto:" + organizer,
+		fmt.Sprintf("ATTENDEE;CN=%s;ROLE=REQ-PARTICIPANT;RSVP=TRUE:mailto:%s", icsParam(appt.MachineUserName), appt.MachineUserEmail),
+		fmt.Sprintf("ATTENDEE;CN=%s;ROLE=REQ-PARTICIPANT:mailto:%s", icsParam(appt.TechnicianName), appt.TechnicianEmail),
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}
+
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(icsFold(line))
+	}
+	return []byte(b.String())
+}
+
+// icsParam quotes a parameter value, which cannot contain double quotes.
+func icsParam(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "'") + `"`
+}
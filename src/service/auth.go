@@ -0,0 +1,220 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"alc/config"
+	"alc/repository"
+
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrUserDisabled       = errors.New("your account is disabled, contact an administrator")
+)
+
+// Authenticator checks a login and password and returns the matching app user.
+// It returns ErrInvalidCredentials when it cannot vouch for the user, so the
+// next authenticator of a chain gets a chance.
+type Authenticator interface {
+	Authenticate(ctx context.Context, login, password string) (repository.AppUser, error)
+}
+
+// NewAuthenticator builds the authenticator chain configured in AUTH_CHAIN.
+func NewAuthenticator(cfg *config.Config, r *repository.Queries) (Authenticator, error) {
+	var chain ChainAuthenticator
+	for _, name := range cfg.AuthChain {
+		switch name {
+		case "local":
+			chain = append(chain, &LocalAuthenticator{Repo: r})
+		case "ldap":
+			if cfg.LDAPURL == "" || cfg.LDAPBaseDN == "" {
+				return nil, errors.New("ldap authentication requires LDAP_URL and LDAP_BASE_DN")
+			}
+			// Without a group mapping every account of the directory would get a login
+			if cfg.LDAPAllowJIT && len(cfg.LDAPAdminGroups) == 0 && len(cfg.LDAPSupervisorGroups) == 0 && len(cfg.LDAPTecnicoGroups) == 0 {
+				return nil, errors.New("LDAP_ALLOW_JIT requires LDAP_ADMIN_GROUPS, LDAP_SUPERVISOR_GROUPS or LDAP_TECNICO_GROUPS")
+			}
+			chain = append(chain, NewLDAPAuthenticator(cfg, r))
+		default:
+			return nil, fmt.Errorf("unknown authenticator %q in AUTH_CHAIN", name)
+		}
+	}
+	if len(chain) == 1 {
+		return chain[0], nil
+	}
+	return chain, nil
+}
+
+// ChainAuthenticator tries each authenticator in order until one accepts the credentials.
+type ChainAuthenticator []Authenticator
+
+func (c ChainAuthenticator) Authenticate(ctx context.Context, login, password string) (repository.AppUser, error) {
+	for _, a := range c {
+		user, err := a.Authenticate(ctx, login, password)
+		if errors.Is(err, ErrInvalidCredentials) {
+			continue
+		}
+		return user, err
+	}
+	return repository.AppUser{}, ErrInvalidCredentials
+}
+
+// LocalAuthenticator checks the bcrypt password stored in app_users.
+type LocalAuthenticator struct {
+	Repo *repository.Queries
+}
+
+func (a *LocalAuthenticator) Authenticate(ctx context.Context, login, password string) (repository.AppUser, error) {
+	user, err := a.Repo.GetAppUserByEmail(ctx, strings.ToLower(strings.TrimSpace(login)))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return repository.AppUser{}, ErrInvalidCredentials
+		}
+		return repository.AppUser{}, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	// Users created by a provider are checked by it, even if a local password was set
+	if user.ExternalAuth {
+		return repository.AppUser{}, ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.HashedPassword), []byte(password)); err != nil {
+		return repository.AppUser{}, ErrInvalidCredentials
+	}
+
+	if !user.IsActive {
+		return repository.AppUser{}, ErrUserDisabled
+	}
+	return user, nil
+}
+
+// roleFromGroups maps the groups of an external identity to an app role. ok is false
+// when group mapping is configured but none of the groups match; role is empty when
+// no mapping is configured. Group names are compared case-insensitively.
+// The most privileged matching role wins.
+func roleFromGroups(groups, adminGroups, supervisorGroups, tecnicoGroups []string) (role repository.UserRole, ok bool) {
+	if len(adminGroups) == 0 && len(supervisorGroups) == 0 && len(tecnicoGroups) == 0 {
+		return "", true
+	}
+	if containsFold(adminGroups, groups) {
+		return repository.UserRoleADMIN, true
+	}
+	if containsFold(supervisorGroups, groups) {
+		return repository.UserRoleSUPERVISOR, true
+	}
+	if containsFold(tecnicoGroups, groups) {
+		return repository.UserRoleTECNICO, true
+	}
+	return "", false
+}
+
+func containsFold(allowed, groups []string) bool {
+	for _, g := range groups {
+		for _, a := range allowed {
+			if strings.EqualFold(g, a) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// externalIdentity is a user vouched for by an external identity provider.
+type externalIdentity struct {
+	Email string
+	Name  string
+	Dni   string
+	// Role is empty when the provider does not decide the role
+	Role repository.UserRole
+}
+
+// syncExternalUser finds the app user for an external identity by email and copies
+// the non-empty profile fields and role into it. Unknown users are created when allowCreate
+// is set, except when an admin deleted the user of that email.
+func syncExternalUser(ctx context.Context, r *repository.Queries, id externalIdentity, allowCreate bool) (repository.AppUser, error) {
+	user, err := r.GetAppUserByEmail(ctx, id.Email)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return repository.AppUser{}, fmt.Errorf("failed to get user: %w", err)
+		}
+		if !allowCreate {
+			return repository.AppUser{}, ErrInvalidCredentials
+		}
+		deleted, err := r.IsAppUserEmailDeleted(ctx, id.Email)
+		if err != nil {
+			return repository.AppUser{}, fmt.Errorf("failed to check deleted users: %w", err)
+		}
+		if deleted {
+			return repository.AppUser{}, ErrUserDisabled
+		}
+		return createExternalUser(ctx, r, id)
+	}
+
+	if !user.IsActive {
+		return repository.AppUser{}, ErrUserDisabled
+	}
+
+	params := repository.UpdateAppUserParams{
+		UserID: user.UserID,
+		Name:   user.Name,
+		Email:  user.Email,
+		Dni:    user.Dni,
+		Role:   user.Role,
+	}
+	if id.Name != "" {
+		params.Name = id.Name
+	}
+	if id.Dni != "" {
+		params.Dni = id.Dni
+	}
+	if id.Role != "" {
+		params.Role = id.Role
+	}
+	if params.Name == user.Name && params.Dni == user.Dni && params.Role == user.Role {
+		return user, nil
+	}
+
+	if params.Role != user.Role {
+		log.Printf("Updating role of %s from %s to %s", user.Email, user.Role, params.Role)
+	}
+	user, err = r.UpdateAppUser(ctx, params)
+	if err != nil {
+		return repository.AppUser{}, fmt.Errorf("failed to sync user: %w", err)
+	}
+	return user, nil
+}
+
+func createExternalUser(ctx context.Context, r *repository.Queries, id externalIdentity) (repository.AppUser, error) {
+	if id.Role == "" {
+		id.Role = repository.UserRoleTECNICO
+	}
+	if id.Name == "" {
+		id.Name = id.Email
+	}
+
+	// External users sign in through their provider, so the local password is unusable
+	hashedPassword, err := unusablePasswordHash()
+	if err != nil {
+		return repository.AppUser{}, err
+	}
+
+	user, err := r.CreateExternalAppUser(ctx, repository.CreateExternalAppUserParams{
+		Name:           id.Name,
+		Email:          id.Email,
+		HashedPassword: hashedPassword,
+		Role:           id.Role,
+		Dni:            id.Dni,
+	})
+	if err != nil {
+		return repository.AppUser{}, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	log.Printf("Created external user %s with role %s", user.Email, user.Role)
+	return user, nil
+}
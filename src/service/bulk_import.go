@@ -0,0 +1,491 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"alc/repository"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type ImportKind string
+
+const (
+	ImportKindMachineUsers ImportKind = "machine_users"
+	ImportKindMachines     ImportKind = "machines"
+)
+
+type ImportRowStatus string
+
+const (
+	ImportRowNew       ImportRowStatus = "NEW"
+	ImportRowUpdated   ImportRowStatus = "UPDATED"
+	ImportRowUnchanged ImportRowStatus = "UNCHANGED"
+	ImportRowInvalid   ImportRowStatus = "INVALID"
+)
+
+var ErrImportJobFinished = errors.New("the import was already committed or discarded")
+
+// FieldDiff is a column whose value changes for an existing record.
+type FieldDiff struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// ImportRow is the outcome of one line of an uploaded file. Exactly one of MachineUser
+// and Machine is set for valid rows, depending on the kind of the job.
+type ImportRow struct {
+	Line   int             `json:"line"`
+	Key    string          `json:"key"`
+	Status ImportRowStatus `json:"status"`
+	Errors []string        `json:"errors,omitempty"`
+	Diffs  []FieldDiff     `json:"diffs,omitempty"`
+	// Raw keeps the original cells to write the rejected rows back
+	Raw         []string                            `json:"raw"`
+	MachineUser *repository.UpsertMachineUserParams `json:"machine_user,omitempty"`
+	Machine     *repository.UpsertMachineParams     `json:"machine,omitempty"`
+}
+
+// ImportPlan is the decoded content of an import job.
+type ImportPlan struct {
+	Header  []string      `json:"header"`
+	Mapping ColumnMapping `json:"mapping"`
+	Rows    []ImportRow   `json:"rows"`
+	// Records holds the data rows of a job waiting for its column mapping
+	Records [][]string `json:"records,omitempty"`
+}
+
+// ImportService validates bulk uploads into import jobs that an admin reviews and then
+// commits or discards.
+type ImportService struct {
+	DBPool *pgxpool.Pool
+	Repo   *repository.Queries
+}
+
+func NewImportService(db *pgxpool.Pool, r *repository.Queries) *ImportService {
+	return &ImportService{DBPool: db, Repo: r}
+}
+
+// diffField appends a FieldDiff when the value changes.
+func diffField(diffs []FieldDiff, field, old, new string) []FieldDiff {
+	if old != new {
+		diffs = append(diffs, FieldDiff{Field: field, Old: old, New: new})
+	}
+	return diffs
+}
+
+// Preview matches the header of an uploaded file, the first row, to the fields of the kind.
+// When every column is recognized the rows are validated and compared with the existing
+// records, and the outcome is saved as a job in PREVIEW. Otherwise the job waits in
+// MAPPING for ApplyMapping. Nothing is written to the imported tables until the job is
+// committed.
+func (s *ImportService) Preview(ctx context.Context, kind ImportKind, fileName string, userID uuid.UUID, records [][]string) (repository.ImportJob, error) {
+	if kind.Columns() == nil {
+		return repository.ImportJob{}, fmt.Errorf("unknown import kind %q", kind)
+	}
+	if len(records) < 2 {
+		return repository.ImportJob{}, errors.New("the file is empty or has only a header")
+	}
+
+	profiles, err := s.Repo.ListImportMappingProfiles(ctx, string(kind))
+	if err != nil {
+		return repository.ImportJob{}, fmt.Errorf("failed to load mapping profiles: %w", err)
+	}
+	header := records[0]
+	mapping, unknown := matchColumns(kind, header, bestProfile(profiles, header))
+
+	params := repository.CreateImportJobParams{
+		Kind:      string(kind),
+		FileName:  fileName,
+		CreatedBy: pgtype.UUID{Bytes: userID, Valid: true},
+		Status:    repository.ImportJobStatusPREVIEW,
+	}
+	var plan ImportPlan
+	if len(unknown) > 0 || len(mapping.Missing(kind)) > 0 {
+		params.Status = repository.ImportJobStatusMAPPING
+		plan = ImportPlan{Header: header, Mapping: mapping, Records: records[1:]}
+	} else if plan, err = s.plan(ctx, kind, header, mapping, records[1:]); err != nil {
+		return repository.ImportJob{}, err
+	}
+
+	counts := countRows(plan)
+	params.TotalRows = int32(len(plan.Rows))
+	params.NewRows = counts[ImportRowNew]
+	params.UpdatedRows = counts[ImportRowUpdated]
+	params.UnchangedRows = counts[ImportRowUnchanged]
+	params.InvalidRows = counts[ImportRowInvalid]
+	if params.Rows, err = json.Marshal(plan); err != nil {
+		return repository.ImportJob{}, fmt.Errorf("failed to encode import rows: %w", err)
+	}
+	return s.Repo.CreateImportJob(ctx, params)
+}
+
+// ApplyMapping validates the rows of a job in MAPPING with the columns chosen by the admin,
+// fields holding the field of each column of the file. When profileName is set the mapping
+// is saved under that name and used for the next uploads with the same headers.
+func (s *ImportService) ApplyMapping(ctx context.Context, jobID pgtype.UUID, userID uuid.UUID, fields []string, profileName string) (repository.ImportJob, error) {
+	row, err := s.Repo.GetImportJob(ctx, jobID)
+	if err != nil {
+		return repository.ImportJob{}, err
+	}
+	job := row.ImportJob
+	if job.Status != repository.ImportJobStatusMAPPING {
+		return repository.ImportJob{}, ErrImportJobFinished
+	}
+	pending, err := DecodePlan(job)
+	if err != nil {
+		return repository.ImportJob{}, err
+	}
+
+	kind := ImportKind(job.Kind)
+	mapping, err := mappingFromFields(kind, fields)
+	if err != nil {
+		return repository.ImportJob{}, err
+	}
+	plan, err := s.plan(ctx, kind, pending.Header, mapping, pending.Records)
+	if err != nil {
+		return repository.ImportJob{}, err
+	}
+
+	if profileName = strings.TrimSpace(profileName); profileName != "" {
+		columns, _ := json.Marshal(profileColumns(pending.Header, mapping))
+		if _, err := s.Repo.SaveImportMappingProfile(ctx, repository.SaveImportMappingProfileParams{
+			Kind:      job.Kind,
+			Name:      profileName,
+			Columns:   columns,
+			CreatedBy: pgtype.UUID{Bytes: userID, Valid: true},
+		}); err != nil {
+			return repository.ImportJob{}, fmt.Errorf("failed to save mapping profile: %w", err)
+		}
+	}
+
+	counts := countRows(plan)
+	data, err := json.Marshal(plan)
+	if err != nil {
+		return repository.ImportJob{}, fmt.Errorf("failed to encode import rows: %w", err)
+	}
+	job, err = s.Repo.SetImportJobPlan(ctx, repository.SetImportJobPlanParams{
+		JobID:         jobID,
+		TotalRows:     int32(len(plan.Rows)),
+		NewRows:       counts[ImportRowNew],
+		UpdatedRows:   counts[ImportRowUpdated],
+		UnchangedRows: counts[ImportRowUnchanged],
+		InvalidRows:   counts[ImportRowInvalid],
+		Rows:          data,
+	})
+	if err != nil {
+		return repository.ImportJob{}, ErrImportJobFinished
+	}
+	return job, nil
+}
+
+// plan validates the data rows of a file read with the given mapping.
+func (s *ImportService) plan(ctx context.Context, kind ImportKind, header []string, mapping ColumnMapping, records [][]string) (ImportPlan, error) {
+	plan := ImportPlan{Header: header, Mapping: mapping}
+	var err error
+	switch kind {
+	case ImportKindMachineUsers:
+		plan.Rows, err = s.planMachineUsers(ctx, mapping, records)
+	case ImportKindMachines:
+		plan.Rows, err = s.planMachines(ctx, mapping, records)
+	default:
+		err = fmt.Errorf("unknown import kind %q", kind)
+	}
+	return plan, err
+}
+
+func countRows(plan ImportPlan) map[ImportRowStatus]int32 {
+	counts := map[ImportRowStatus]int32{}
+	for _, row := range plan.Rows {
+		counts[row.Status]++
+	}
+	return counts
+}
+
+// isBlankRecord reports whether every cell is empty, as in the trailing rows of Excel exports.
+func isBlankRecord(record []string) bool {
+	for _, value := range record {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// checkDuplicate marks a row whose key already appeared in the file.
+func checkDuplicate(row *ImportRow, seen map[string]int) {
+	if row.Key == "" {
+		return
+	}
+	if first, ok := seen[row.Key]; ok {
+		row.Errors = append(row.Errors, fmt.Sprintf("duplicate of line %d", first))
+		return
+	}
+	seen[row.Key] = row.Line
+}
+
+func (s *ImportService) planMachineUsers(ctx context.Context, mapping ColumnMapping, records [][]string) ([]ImportRow, error) {
+	rows := make([]ImportRow, 0, len(records))
+	var dnis []string
+	seen := map[string]int{}
+
+	for i, record := range records {
+		if isBlankRecord(record) {
+			continue
+		}
+		row := ImportRow{Line: i + 2, Raw: record}
+		params := repository.UpsertMachineUserParams{
+			Dni:          cell(record, mapping.index("dni")),
+			PersonalCode: cell(record, mapping.index("personal_code")),
+			Name:         cell(record, mapping.index("name")),
+			Email:        strings.ToLower(cell(record, mapping.index("email"))),
+			Society:      cell(record, mapping.index("society")),
+			Site:         cell(record, mapping.index("site")),
+			Area:         cell(record, mapping.index("area")),
+			FloorName:    cell(record, mapping.index("floor")),
+		}
+		row.Key = params.Dni
+		if params.Dni == "" {
+			row.Errors = append(row.Errors, "missing DNI")
+		}
+		if params.Name == "" {
+			row.Errors = append(row.Errors, "missing name")
+		}
+		if _, err := mail.ParseAddress(params.Email); err != nil {
+			row.Errors = append(row.Errors, fmt.Sprintf("invalid email %q", params.Email))
+		}
+		checkDuplicate(&row, seen)
+		row.MachineUser = &params
+
+		if len(row.Errors) > 0 {
+			row.Status = ImportRowInvalid
+			row.MachineUser = nil
+		} else {
+			dnis = append(dnis, row.Key)
+		}
+		rows = append(rows, row)
+	}
+
+	existing, err := s.Repo.ListMachineUsersByDNIs(ctx, dnis)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load existing machine users: %w", err)
+	}
+	byDNI := make(map[string]repository.MachineUser, len(existing))
+	for _, mu := range existing {
+		byDNI[mu.Dni] = mu
+	}
+
+	for i := range rows {
+		row := &rows[i]
+		if row.Status == ImportRowInvalid {
+			continue
+		}
+		old, ok := byDNI[row.Key]
+		if !ok {
+			row.Status = ImportRowNew
+			continue
+		}
+		// Only the columns updated by UpsertMachineUser are compared
+		p := row.MachineUser
+		row.Diffs = diffField(row.Diffs, "name", old.Name, p.Name)
+		row.Diffs = diffField(row.Diffs, "email", old.Email, p.Email)
+		row.Diffs = diffField(row.Diffs, "society", old.Society, p.Society)
+		row.Diffs = diffField(row.Diffs, "site", old.Site, p.Site)
+		row.Diffs = diffField(row.Diffs, "area", old.Area, p.Area)
+		row.Diffs = diffField(row.Diffs, "floor", old.FloorName, p.FloorName)
+		if len(row.Diffs) > 0 {
+			row.Status = ImportRowUpdated
+		} else {
+			row.Status = ImportRowUnchanged
+		}
+	}
+	return rows, nil
+}
+
+// parseMachineProfile accepts the profile names of the machines file, e.g. "ESPECIAL 1".
+func parseMachineProfile(s string) (repository.MachineProfile, bool) {
+	switch strings.ToUpper(strings.TrimSpace(s)) {
+	case "ESPECIAL 1", "ESPECIAL1":
+		return repository.MachineProfileESPECIAL1, true
+	case "ESPECIAL 2", "ESPECIAL2":
+		return repository.MachineProfileESPECIAL2, true
+	case "PROCESAMIENTO":
+		return repository.MachineProfilePROCESAMIENTO, true
+	case "REGULAR":
+		return repository.MachineProfileREGULAR, true
+	}
+	return "", false
+}
+
+func (s *ImportService) planMachines(ctx context.Context, mapping ColumnMapping, records [][]string) ([]ImportRow, error) {
+	rows := make([]ImportRow, 0, len(records))
+	var serials []string
+	seen := map[string]int{}
+
+	for i, record := range records {
+		if isBlankRecord(record) {
+			continue
+		}
+		row := ImportRow{Line: i + 2, Raw: record}
+		params := repository.UpsertMachineParams{
+			SerialNum:  strings.ToUpper(strings.ReplaceAll(cell(record, mapping.index("serial")), " ", "")),
+			Type:       repository.MachineType(strings.ToUpper(cell(record, mapping.index("type")))),
+			Mtm:        cell(record, mapping.index("mtm")),
+			Model:      cell(record, mapping.index("model")),
+			PlateNum:   cell(record, mapping.index("plate")),
+			DiskSize:   cell(record, mapping.index("disk")),
+			MemorySize: cell(record, mapping.index("memory")),
+			Processor:  cell(record, mapping.index("processor")),
+		}
+		row.Key = params.SerialNum
+		if params.SerialNum == "" {
+			row.Errors = append(row.Errors, "missing serial number")
+		}
+		if params.Type != repository.MachineTypePC && params.Type != repository.MachineTypeLAPTOP {
+			row.Errors = append(row.Errors, fmt.Sprintf("invalid type %q, expected PC or LAPTOP", params.Type))
+		}
+		profileCell := cell(record, mapping.index("profile"))
+		profile, ok := parseMachineProfile(profileCell)
+		if !ok {
+			row.Errors = append(row.Errors, fmt.Sprintf("invalid profile %q", profileCell))
+		}
+		params.Profile = profile
+		checkDuplicate(&row, seen)
+		row.Machine = &params
+
+		if len(row.Errors) > 0 {
+			row.Status = ImportRowInvalid
+			row.Machine = nil
+		} else {
+			serials = append(serials, row.Key)
+		}
+		rows = append(rows, row)
+	}
+
+	existing, err := s.Repo.ListMachinesBySerials(ctx, serials)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load existing machines: %w", err)
+	}
+	bySerial := make(map[string]repository.Machine, len(existing))
+	for _, m := range existing {
+		bySerial[m.SerialNum] = m
+	}
+
+	for i := range rows {
+		row := &rows[i]
+		if row.Status == ImportRowInvalid {
+			continue
+		}
+		old, ok := bySerial[row.Key]
+		if !ok {
+			row.Status = ImportRowNew
+			continue
+		}
+		p := row.Machine
+		row.Diffs = diffField(row.Diffs, "type", string(old.Type), string(p.Type))
+		row.Diffs = diffField(row.Diffs, "mtm", old.Mtm, p.Mtm)
+		row.Diffs = diffField(row.Diffs, "model", old.Model, p.Model)
+		row.Diffs = diffField(row.Diffs, "plate", old.PlateNum, p.PlateNum)
+		row.Diffs = diffField(row.Diffs, "disk", old.DiskSize, p.DiskSize)
+		row.Diffs = diffField(row.Diffs, "memory", old.MemorySize, p.MemorySize)
+		row.Diffs = diffField(row.Diffs, "processor", old.Processor, p.Processor)
+		row.Diffs = diffField(row.Diffs, "profile", string(old.Profile), string(p.Profile))
+		if len(row.Diffs) > 0 {
+			row.Status = ImportRowUpdated
+		} else {
+			row.Status = ImportRowUnchanged
+		}
+	}
+	return rows, nil
+}
+
+// DecodePlan returns the rows saved in a job.
+func DecodePlan(job repository.ImportJob) (ImportPlan, error) {
+	var plan ImportPlan
+	if err := json.Unmarshal(job.Rows, &plan); err != nil {
+		return plan, fmt.Errorf("failed to decode import rows: %w", err)
+	}
+	return plan, nil
+}
+
+// Commit writes the new and updated rows of a job in a single transaction.
+func (s *ImportService) Commit(ctx context.Context, jobID pgtype.UUID) (repository.ImportJob, error) {
+	tx, err := s.DBPool.Begin(ctx)
+	if err != nil {
+		return repository.ImportJob{}, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := s.Repo.WithTx(tx)
+
+	// Finishing the job first locks it, so a double submit cannot apply it twice
+	job, err := qtx.CommitImportJob(ctx, jobID)
+	if err != nil {
+		return repository.ImportJob{}, ErrImportJobFinished
+	}
+
+	plan, err := DecodePlan(job)
+	if err != nil {
+		return repository.ImportJob{}, err
+	}
+
+	for _, row := range plan.Rows {
+		if row.Status != ImportRowNew && row.Status != ImportRowUpdated {
+			continue
+		}
+		switch {
+		case row.MachineUser != nil:
+			if _, err := qtx.UpsertMachineUser(ctx, *row.MachineUser); err != nil {
+				return repository.ImportJob{}, fmt.Errorf("failed to save machine user %s (line %d): %w", row.Key, row.Line, err)
+			}
+		case row.Machine != nil:
+			if _, err := qtx.UpsertMachine(ctx, *row.Machine); err != nil {
+				return repository.ImportJob{}, fmt.Errorf("failed to save machine %s (line %d): %w", row.Key, row.Line, err)
+			}
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return repository.ImportJob{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return job, nil
+}
+
+// Discard closes a job without writing its rows.
+func (s *ImportService) Discard(ctx context.Context, jobID pgtype.UUID) error {
+	if _, err := s.Repo.DiscardImportJob(ctx, jobID); err != nil {
+		return ErrImportJobFinished
+	}
+	return nil
+}
+
+// WriteRejectedRows writes the invalid rows of a plan as CSV: the original header and
+// cells, followed by the line number and the reasons.
+func WriteRejectedRows(w io.Writer, plan ImportPlan) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(append(append([]string{}, plan.Header...), "line", "errors")); err != nil {
+		return err
+	}
+	for _, row := range plan.Rows {
+		if row.Status != ImportRowInvalid {
+			continue
+		}
+		record := append(append([]string{}, row.Raw...), strconv.Itoa(row.Line), strings.Join(row.Errors, "; "))
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -13,22 +13,56 @@ import (
 	"alc/model"
 	"alc/repository"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// ErrCertificateVoided is returned when editing a voided certificate.
+var ErrCertificateVoided = errors.New("el certificado fue anulado y ya no puede editarse")
+
 type CertificateService struct {
-	DBPool   *pgxpool.Pool
-	Repo     *repository.Queries
-	EmailSvc *EmailService
+	DBPool     *pgxpool.Pool
+	Repo       *repository.Queries
+	EmailSvc   *EmailService
+	WebhookSvc *WebhookService
+	// Tickets validates ticket numbers; nil when no ITSM system is configured
+	Tickets TicketProvider
 }
 
-func NewCertificateService(db *pgxpool.Pool, r *repository.Queries, emailSvc *EmailService) *CertificateService {
+func NewCertificateService(db *pgxpool.Pool, r *repository.Queries, emailSvc *EmailService, webhookSvc *WebhookService, tickets TicketProvider) *CertificateService {
 	return &CertificateService{
-		DBPool:   db,
-		Repo:     r,
-		EmailSvc: emailSvc,
+		DBPool:     db,
+		Repo:       r,
+		EmailSvc:   emailSvc,
+		WebhookSvc: webhookSvc,
+		Tickets:    tickets,
+	}
+}
+
+// resolveTicket checks the ticket of the form against the ITSM system. When the system
+// cannot be reached the certificate is saved anyway, without a new ticket state and link.
+func (s *CertificateService) resolveTicket(ctx context.Context, form url.Values) (Ticket, error) {
+	if s.Tickets == nil {
+		return Ticket{Number: normalize(form.Get("ticket_name"), false)}, nil
+	}
+
+	number := NormalizeTicketNumber(form.Get("ticket_name"))
+	if number == "" {
+		return Ticket{}, errors.New("el 'Ticket' no puede estar vacío")
 	}
+	ticket, err := s.Tickets.LookupTicket(ctx, number)
+	if err != nil {
+		if errors.Is(err, ErrTicketNotFound) {
+			return Ticket{}, fmt.Errorf("el ticket '%s' no existe en %s", number, s.Tickets.Name())
+		}
+		if errors.Is(err, ErrInvalidTicketNumber) {
+			return Ticket{}, fmt.Errorf("el ticket '%s' no tiene un formato válido", number)
+		}
+		log.Printf("ERROR: Failed to look up ticket %s: %v", number, err)
+		return Ticket{Number: number}, nil
+	}
+	return ticket, nil
 }
 
 // Helper function to normalize strings
@@ -82,6 +116,16 @@ func (s *CertificateService) CreateCertificateFromForm(ctx context.Context, user
 		}
 	}
 
+	loanDueDate, err := parseLoanDueDate(form, pgtype.Date{})
+	if err != nil {
+		return nil, err
+	}
+
+	ticket, err := s.resolveTicket(ctx, form)
+	if err != nil {
+		return nil, err
+	}
+
 	// --- 2. DATABASE TRANSACTION ---
 
 	tx, err := s.DBPool.Begin(ctx)
@@ -92,9 +136,9 @@ func (s *CertificateService) CreateCertificateFromForm(ctx context.Context, user
 
 	qtx := s.Repo.WithTx(tx)
 
-	// --- 3. Upsert Machine User ---
+	// --- 3. Create the Machine User, or propose changes to the one on file ---
 
-	machineUser, err := qtx.UpsertMachineUser(ctx, repository.UpsertMachineUserParams{
+	machineUser, changes, err := syncMachineUser(ctx, qtx, repository.UpsertMachineUserParams{
 		Dni:          userDNI,
 		PersonalCode: normalize(form.Get("machine_user_code"), true),
 		Name:         normalize(form.Get("machine_user_name"), true),
@@ -105,12 +149,19 @@ func (s *CertificateService) CreateCertificateFromForm(ctx context.Context, user
 		FloorName:    normalize(form.Get("machine_user_floor"), true),
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to upsert machine user: %w", err)
+		return nil, err
 	}
 
-	// --- 4. Upsert NEW Machine ---
+	// --- 4. Create the NEW Machine or propose changes, taking it out of any reservation ---
 
-	newMachine, err := qtx.UpsertMachine(ctx, repository.UpsertMachineParams{
+	if err := checkNotRetired(ctx, qtx, newSerial, newDeviceCode); err != nil {
+		return nil, err
+	}
+	if err := claimMachineReservation(ctx, qtx, newSerial, machineUser.Dni); err != nil {
+		return nil, err
+	}
+
+	newMachine, machineChanges, err := syncMachine(ctx, qtx, repository.UpsertMachineParams{
 		SerialNum:  newSerial,
 		Type:       repository.MachineType(normalize(form.Get("new_device_type"), true)),
 		Model:      normalize(form.Get("new_device_model"), false),
@@ -120,8 +171,9 @@ func (s *CertificateService) CreateCertificateFromForm(ctx context.Context, user
 		Profile:    repository.MachineProfile(normalize(form.Get("new_device_profile"), true)),
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to upsert new machine: %w", err)
+		return nil, err
 	}
+	changes = append(changes, machineChanges...)
 
 	// --- 5. Upsert NEW Device ---
 
@@ -137,20 +189,20 @@ func (s *CertificateService) CreateCertificateFromForm(ctx context.Context, user
 		return nil, fmt.Errorf("failed to upsert new device: %w", err)
 	}
 
-	// --- 6. Upsert OLD Machine ---
+	// --- 6. Create the OLD Machine or propose changes ---
 
-	_, err = qtx.UpsertMachine(ctx, repository.UpsertMachineParams{
+	_, machineChanges, err = syncMachine(ctx, qtx, repository.UpsertMachineParams{
 		SerialNum:  oldSerial,
 		Type:       repository.MachineType(normalize(form.Get("old_device_type"), true)),
 		Model:      normalize(form.Get("old_device_model"), false),
 		PlateNum:   oldDeviceCode,
 		DiskSize:   normalize(form.Get("old_device_disk"), false),
 		MemorySize: normalize(form.Get("old_device_memory"), false),
-		Profile:    repository.MachineProfileREGULAR,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to upsert old machine: %w", err)
+		return nil, err
 	}
+	changes = append(changes, machineChanges...)
 
 	// --- 7. Upsert OLD Device ---
 
@@ -237,7 +289,7 @@ func (s *CertificateService) CreateCertificateFromForm(ctx context.Context, user
 	// --- 9. Create the Certificate ---
 
 	cert, err := qtx.CreateCertificate(ctx, repository.CreateCertificateParams{
-		TicketName:     normalize(form.Get("ticket_name"), false),
+		TicketName:     ticket.Number,
 		AppUserID:      pgtype.UUID{Bytes: user.ID, Valid: true},
 		MachineUserDni: machineUser.Dni,
 		NewDeviceCode:  newDeviceCode,
@@ -248,10 +300,28 @@ func (s *CertificateService) CreateCertificateFromForm(ctx context.Context, user
 		PrinterIp:      normalize(form.Get("printer_ip"), false),
 		PrinterTest:    form.Get("printer_test") == "on",
 		Comments:       strings.TrimSpace(form.Get("comments")),
+		TicketState:    ticket.State,
+		TicketUrl:      ticket.URL,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create certificate: %w", err)
 	}
+	if err := proposeMasterDataChanges(ctx, qtx, changes, cert.CertificateID, user.ID); err != nil {
+		return nil, err
+	}
+
+	// --- 10. Record who holds each device now, and until when if lent ---
+
+	if err := recordCertificateCustody(ctx, qtx, cert, machineUser, repository.DeviceStatus(normalize(form.Get("new_device_status"), true))); err != nil {
+		return nil, err
+	}
+	if err := recordCertificateLoan(ctx, qtx, cert, loanDueDate); err != nil {
+		return nil, err
+	}
+
+	if err := s.WebhookSvc.EnqueueCertificateEvent(ctx, qtx, EventCertificateCreated, cert); err != nil {
+		return nil, err
+	}
 
 	// If all operations were successful, commit the transaction
 	if err := tx.Commit(ctx); err != nil {
@@ -302,6 +372,11 @@ func (s *CertificateService) UpdateCertificateFromForm(ctx context.Context, user
 		}
 	}
 
+	ticket, err := s.resolveTicket(ctx, form)
+	if err != nil {
+		return nil, err
+	}
+
 	// --- 2. DATABASE TRANSACTION ---
 
 	tx, err := s.DBPool.Begin(ctx)
@@ -312,9 +387,47 @@ func (s *CertificateService) UpdateCertificateFromForm(ctx context.Context, user
 
 	qtx := s.Repo.WithTx(tx)
 
-	// --- 3. Upsert Machine User ---
+	previous, err := qtx.GetCertificateForUpdate(ctx, repository.GetCertificateForUpdateParams{
+		CertificateID: certID,
+		AppUserID:     pgtype.UUID{Bytes: user.ID, Valid: true},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get certificate %d: %w", certID, err)
+	}
+	if previous.ConfirmationStatus == repository.CertificateStatusVOIDED {
+		return nil, ErrCertificateVoided
+	}
+	// Keep what the ITSM system last said about the same ticket when it cannot be reached
+	if ticket.URL == "" && ticket.Number == previous.TicketName {
+		ticket.State, ticket.URL = previous.TicketState, previous.TicketUrl
+	}
 
-	machineUser, err := qtx.UpsertMachineUser(ctx, repository.UpsertMachineUserParams{
+	previousLoan, err := qtx.GetCertificateLoan(ctx, certID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get loan of certificate %d: %w", certID, err)
+	}
+	loanDueDate, err := parseLoanDueDate(form, previousLoan.DueDate)
+	if err != nil {
+		return nil, err
+	}
+
+	custodies, err := qtx.ListCertificateCustody(ctx, pgtype.Int4{Int32: certID, Valid: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list custody of certificate %d: %w", certID, err)
+	}
+	// A device that moved since the certificate keeps its status
+	handoverStatus := repository.DeviceStatus(normalize(form.Get("new_device_status"), true))
+	newDeviceStatus := handoverStatus
+	if handoverMoved(custodies) && newDeviceCode == previous.NewDeviceCode {
+		newDeviceStatus, err = qtx.GetDeviceStatus(ctx, newDeviceCode)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get device %s: %w", newDeviceCode, err)
+		}
+	}
+
+	// --- 3. Create the Machine User, or propose changes to the one on file ---
+
+	machineUser, changes, err := syncMachineUser(ctx, qtx, repository.UpsertMachineUserParams{
 		Dni:          userDNI,
 		PersonalCode: normalize(form.Get("machine_user_code"), true),
 		Name:         normalize(form.Get("machine_user_name"), true),
@@ -325,12 +438,19 @@ func (s *CertificateService) UpdateCertificateFromForm(ctx context.Context, user
 		FloorName:    normalize(form.Get("machine_user_floor"), true),
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to upsert machine user: %w", err)
+		return nil, err
 	}
 
-	// --- 4. Upsert NEW Machine ---
+	// --- 4. Create the NEW Machine or propose changes, taking it out of any reservation ---
+
+	if err := checkNotRetired(ctx, qtx, newSerial, newDeviceCode); err != nil {
+		return nil, err
+	}
+	if err := claimMachineReservation(ctx, qtx, newSerial, machineUser.Dni); err != nil {
+		return nil, err
+	}
 
-	newMachine, err := qtx.UpsertMachine(ctx, repository.UpsertMachineParams{
+	newMachine, machineChanges, err := syncMachine(ctx, qtx, repository.UpsertMachineParams{
 		SerialNum:  newSerial,
 		Type:       repository.MachineType(normalize(form.Get("new_device_type"), true)),
 		Model:      normalize(form.Get("new_device_model"), false),
@@ -340,8 +460,9 @@ func (s *CertificateService) UpdateCertificateFromForm(ctx context.Context, user
 		Profile:    repository.MachineProfile(normalize(form.Get("new_device_profile"), true)),
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to upsert new machine: %w", err)
+		return nil, err
 	}
+	changes = append(changes, machineChanges...)
 
 	// --- 5. Upsert NEW Device ---
 
@@ -350,28 +471,28 @@ func (s *CertificateService) UpdateCertificateFromForm(ctx context.Context, user
 		MachineSerialNum:   newSerial,
 		Type:               repository.DeviceTypeNEW,
 		Hostname:           normalize(form.Get("new_device_hostname"), true),
-		Status:             repository.DeviceStatus(normalize(form.Get("new_device_status"), true)),
+		Status:             newDeviceStatus,
 		AdditionalSoftware: strings.TrimSpace(form.Get("additional_software")),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to upsert new device: %w", err)
 	}
 
-	// --- 6. Upsert OLD Machine & Device ---
+	// --- 6. Create the OLD Machine or propose changes, and upsert its Device ---
 
 	if oldDeviceCode != "" && oldSerial != "" {
-		_, err = qtx.UpsertMachine(ctx, repository.UpsertMachineParams{
+		_, machineChanges, err = syncMachine(ctx, qtx, repository.UpsertMachineParams{
 			SerialNum:  oldSerial,
 			Type:       repository.MachineType(normalize(form.Get("old_device_type"), true)),
 			Model:      normalize(form.Get("old_device_model"), false),
 			PlateNum:   oldDeviceCode,
 			DiskSize:   normalize(form.Get("old_device_disk"), false),
 			MemorySize: normalize(form.Get("old_device_memory"), false),
-			Profile:    repository.MachineProfileREGULAR,
 		})
 		if err != nil {
-			return nil, fmt.Errorf("failed to upsert old machine: %w", err)
+			return nil, err
 		}
+		changes = append(changes, machineChanges...)
 
 		_, err = qtx.UpsertDevice(ctx, repository.UpsertDeviceParams{
 			DeviceCode:         oldDeviceCode,
@@ -439,7 +560,7 @@ func (s *CertificateService) UpdateCertificateFromForm(ctx context.Context, user
 	cert, err := qtx.UpdateCertificate(ctx, repository.UpdateCertificateParams{
 		CertificateID:  certID,
 		AppUserID:      pgtype.UUID{Bytes: user.ID, Valid: true},
-		TicketName:     normalize(form.Get("ticket_name"), false),
+		TicketName:     ticket.Number,
 		MachineUserDni: machineUser.Dni,
 		NewDeviceCode:  newDeviceCode,
 		OldDeviceCode:  oldDeviceCode,
@@ -449,10 +570,28 @@ func (s *CertificateService) UpdateCertificateFromForm(ctx context.Context, user
 		PrinterIp:      normalize(form.Get("printer_ip"), false),
 		PrinterTest:    form.Get("printer_test") == "on",
 		Comments:       strings.TrimSpace(form.Get("comments")),
+		TicketState:    ticket.State,
+		TicketUrl:      ticket.URL,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to update certificate: %w", err)
 	}
+	if err := proposeMasterDataChanges(ctx, qtx, changes, cert.CertificateID, user.ID); err != nil {
+		return nil, err
+	}
+
+	// --- 9. Record the corrected custody and loan of the devices ---
+
+	if err := updateCertificateCustody(ctx, qtx, custodies, previous, cert, machineUser, handoverStatus); err != nil {
+		return nil, err
+	}
+	if err := recordCertificateLoan(ctx, qtx, cert, loanDueDate); err != nil {
+		return nil, err
+	}
+
+	if err := s.WebhookSvc.EnqueueCertificateEvent(ctx, qtx, EventCertificateUpdated, cert); err != nil {
+		return nil, err
+	}
 
 	// If all operations were successful, commit the transaction
 	if err := tx.Commit(ctx); err != nil {
@@ -468,3 +607,71 @@ func (s *CertificateService) UpdateCertificateFromForm(ctx context.Context, user
 
 	return &cert, nil
 }
+
+// SetCertificateStatus records the machine user's answer to a pending certificate and
+// notifies the webhooks. It returns pgx.ErrNoRows if the certificate is no longer pending.
+func (s *CertificateService) SetCertificateStatus(ctx context.Context, token pgtype.UUID, status repository.CertificateStatus) (repository.Alicorp2025Certificate, error) {
+	var event WebhookEvent
+	switch status {
+	case repository.CertificateStatusCONFIRMED:
+		event = EventCertificateConfirmed
+	case repository.CertificateStatusREJECTED:
+		event = EventCertificateRejected
+	default:
+		return repository.Alicorp2025Certificate{}, fmt.Errorf("invalid certificate status %q", status)
+	}
+
+	tx, err := s.DBPool.Begin(ctx)
+	if err != nil {
+		return repository.Alicorp2025Certificate{}, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := s.Repo.WithTx(tx)
+
+	cert, err := qtx.UpdateCertificateStatus(ctx, repository.UpdateCertificateStatusParams{
+		ConfirmationStatus: status,
+		ConfirmationToken:  token,
+	})
+	if err != nil {
+		return repository.Alicorp2025Certificate{}, err
+	}
+
+	if err := s.WebhookSvc.EnqueueCertificateEvent(ctx, qtx, event, cert); err != nil {
+		return repository.Alicorp2025Certificate{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return repository.Alicorp2025Certificate{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return cert, nil
+}
+
+// VoidCertificate voids a certificate of the user the machine user has not confirmed and
+// notifies the webhooks. It returns pgx.ErrNoRows if the user has no such certificate.
+func (s *CertificateService) VoidCertificate(ctx context.Context, user model.AuthenticatedUser, certID int32) (repository.Alicorp2025Certificate, error) {
+	tx, err := s.DBPool.Begin(ctx)
+	if err != nil {
+		return repository.Alicorp2025Certificate{}, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := s.Repo.WithTx(tx)
+
+	cert, err := qtx.VoidCertificate(ctx, repository.VoidCertificateParams{
+		CertificateID: certID,
+		AppUserID:     pgtype.UUID{Bytes: user.ID, Valid: true},
+	})
+	if err != nil {
+		return repository.Alicorp2025Certificate{}, err
+	}
+
+	if err := s.WebhookSvc.EnqueueCertificateEvent(ctx, qtx, EventCertificateVoided, cert); err != nil {
+		return repository.Alicorp2025Certificate{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return repository.Alicorp2025Certificate{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return cert, nil
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"alc/config"
+	"alc/db/dbtest"
+	"alc/model"
+	"alc/repository"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// newTestTecnico creates a technician to own the certificates of a test.
+func newTestTecnico(t *testing.T, repo *repository.Queries) model.AuthenticatedUser {
+	t.Helper()
+
+	u, err := repo.CreateAppUser(context.Background(), repository.CreateAppUserParams{
+		Name:           "Técnico",
+		Email:          "tecnico@example.com",
+		HashedPassword: "x",
+		Role:           repository.UserRoleTECNICO,
+		Dni:            "00000001",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return model.AuthenticatedUser{ID: uuid.UUID(u.UserID.Bytes), Name: u.Name, Email: u.Email, Role: u.Role}
+}
+
+// testCertificateForm is a certificate form for machine user dni, handing over devices of
+// its own numbered i. The personal code and email, unique among machine users, follow the
+// DNI.
+func testCertificateForm(i int, dni, name string) url.Values {
+	return url.Values{
+		"ticket_name":        {fmt.Sprintf("INC%07d", i)},
+		"machine_user_dni":   {dni},
+		"machine_user_code":  {"P" + dni},
+		"machine_user_name":  {name},
+		"machine_user_email": {"usuario" + dni + "@example.com"},
+		"new_device_code":    {fmt.Sprintf("EQ-NEW-%d", i)},
+		"new_device_serial":  {fmt.Sprintf("SN-NEW-%d", i)},
+		"new_device_type":    {"LAPTOP"},
+		"new_device_status":  {"ASIGNACION"},
+		"old_device_code":    {fmt.Sprintf("EQ-OLD-%d", i)},
+		"old_device_serial":  {fmt.Sprintf("SN-OLD-%d", i)},
+		"old_device_type":    {"PC"},
+		"old_device_status":  {"RECUPERACION"},
+	}
+}
+
+func TestVoidCertificate(t *testing.T) {
+	pool, repo := dbtest.New(t)
+	ctx := context.Background()
+
+	owner := newTestTecnico(t, repo)
+	u, err := repo.CreateAppUser(ctx, repository.CreateAppUserParams{
+		Name:           "Otro Técnico",
+		Email:          "otro@example.com",
+		HashedPassword: "x",
+		Role:           repository.UserRoleTECNICO,
+		Dni:            "00000002",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := model.AuthenticatedUser{ID: uuid.UUID(u.UserID.Bytes), Name: u.Name, Email: u.Email, Role: u.Role}
+
+	endpoint, err := repo.CreateWebhookEndpoint(ctx, repository.CreateWebhookEndpointParams{
+		Url:    "http://localhost:9090/",
+		Secret: "whsec_test",
+		Events: []string{string(EventCertificateVoided)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	emailSvc, err := NewEmailService(&config.Config{SmtpHost: "localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := &CertificateService{DBPool: pool, Repo: repo, EmailSvc: emailSvc, WebhookSvc: NewWebhookService(repo)}
+	form := testCertificateForm(1, "70000001", "Usuario")
+	cert, err := svc.CreateCertificateFromForm(ctx, owner, form)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := svc.VoidCertificate(ctx, other, cert.CertificateID); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("VoidCertificate() by another technician error = %v, want pgx.ErrNoRows", err)
+	}
+	voided, err := svc.VoidCertificate(ctx, owner, cert.CertificateID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if voided.ConfirmationStatus != repository.CertificateStatusVOIDED {
+		t.Errorf("status = %s, want VOIDED", voided.ConfirmationStatus)
+	}
+	if _, err := svc.VoidCertificate(ctx, owner, cert.CertificateID); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("second VoidCertificate() error = %v, want pgx.ErrNoRows", err)
+	}
+	if _, err := svc.SetCertificateStatus(ctx, cert.ConfirmationToken, repository.CertificateStatusCONFIRMED); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("confirming a voided certificate error = %v, want pgx.ErrNoRows", err)
+	}
+	if _, err := svc.UpdateCertificateFromForm(ctx, owner, cert.CertificateID, form); !errors.Is(err, ErrCertificateVoided) {
+		t.Errorf("UpdateCertificateFromForm() error = %v, want ErrCertificateVoided", err)
+	}
+
+	deliveries, err := repo.ListWebhookDeliveries(ctx, endpoint.EndpointID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Event != string(EventCertificateVoided) {
+		t.Errorf("deliveries = %+v, want one certificate.voided", deliveries)
+	}
+}
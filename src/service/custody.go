@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"alc/repository"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// RecordDeviceCustody ends the current custody of the device and opens the given one. q
+// should be bound to the transaction of the change that moved the device.
+func RecordDeviceCustody(ctx context.Context, q *repository.Queries, p repository.CreateDeviceCustodyParams) (repository.DeviceCustody, error) {
+	if err := q.CloseDeviceCustody(ctx, p.DeviceCode); err != nil {
+		return repository.DeviceCustody{}, fmt.Errorf("failed to close custody of device %s: %w", p.DeviceCode, err)
+	}
+	custody, err := q.CreateDeviceCustody(ctx, p)
+	if err != nil {
+		return custody, fmt.Errorf("failed to record custody of device %s: %w", p.DeviceCode, err)
+	}
+	return custody, nil
+}
+
+// handoverEvent is the custody event of a new device handed over with the status.
+func handoverEvent(status repository.DeviceStatus) repository.CustodyEvent {
+	switch status {
+	case repository.DeviceStatusPRESTAMO:
+		return repository.CustodyEventPRESTAMO
+	case repository.DeviceStatusBACKUP:
+		return repository.CustodyEventBACKUP
+	}
+	return repository.CustodyEventASIGNACION
+}
+
+// recordCertificateCustody records the handover of a certificate: the new device goes to
+// the machine user, as an assignment, loan or backup after its status, and the old one is
+// recovered to the warehouse.
+func recordCertificateCustody(ctx context.Context, q *repository.Queries, cert repository.Alicorp2025Certificate, machineUser repository.MachineUser, newStatus repository.DeviceStatus) error {
+	base := repository.CreateDeviceCustodyParams{
+		MachineUserDni: pgtype.Text{String: machineUser.Dni, Valid: true},
+		Location:       machineUser.Site,
+		CertificateID:  pgtype.Int4{Int32: cert.CertificateID, Valid: true},
+		RecordedBy:     cert.AppUserID,
+	}
+
+	assigned := base
+	assigned.DeviceCode = cert.NewDeviceCode
+	assigned.Event = handoverEvent(newStatus)
+	assigned.Custodian = repository.CustodianTypeMACHINEUSER
+	if _, err := RecordDeviceCustody(ctx, q, assigned); err != nil {
+		return err
+	}
+
+	recovered := base
+	recovered.DeviceCode = cert.OldDeviceCode
+	recovered.Event = repository.CustodyEventRECUPERACION
+	recovered.Custodian = repository.CustodianTypeWAREHOUSE
+	_, err := RecordDeviceCustody(ctx, q, recovered)
+	return err
+}
+
+// revertCertificateCustody removes the custodies a certificate opened that are still
+// current, making the previous custody of those devices current again.
+func revertCertificateCustody(ctx context.Context, q *repository.Queries, certificateID int32) error {
+	devices, err := q.DeleteOpenCertificateCustody(ctx, pgtype.Int4{Int32: certificateID, Valid: true})
+	if err != nil {
+		return fmt.Errorf("failed to remove custody of certificate %d: %w", certificateID, err)
+	}
+	for _, code := range devices {
+		if err := q.ReopenLastDeviceCustody(ctx, code); err != nil {
+			return fmt.Errorf("failed to reopen custody of device %s: %w", code, err)
+		}
+	}
+	return nil
+}
+
+// handoverMoved reports whether a later change, e.g. a loan return or a warehouse
+// disposition, ended one of the custodies a certificate opened.
+func handoverMoved(custodies []repository.DeviceCustody) bool {
+	for _, custody := range custodies {
+		if custody.EndedAt.Valid {
+			return true
+		}
+	}
+	return false
+}
+
+// updateCertificateCustody records the handover of an edited certificate again when its
+// devices, machine user or kind of handover changed. custodies are the ones the certificate
+// opened. Once a later change ended one of them the handover is history and can no longer
+// be changed.
+func updateCertificateCustody(ctx context.Context, q *repository.Queries, custodies []repository.DeviceCustody, previous, cert repository.Alicorp2025Certificate, machineUser repository.MachineUser, newStatus repository.DeviceStatus) error {
+	changed := previous.NewDeviceCode != cert.NewDeviceCode ||
+		previous.OldDeviceCode != cert.OldDeviceCode ||
+		previous.MachineUserDni != cert.MachineUserDni
+	for _, custody := range custodies {
+		if custody.Custodian == repository.CustodianTypeMACHINEUSER && custody.Event != handoverEvent(newStatus) {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	if handoverMoved(custodies) {
+		return errors.New("los equipos de este certificado cambiaron de custodia después de él; ya no se pueden cambiar sus equipos, su usuario ni el tipo de entrega")
+	}
+	if err := revertCertificateCustody(ctx, q, cert.CertificateID); err != nil {
+		return err
+	}
+	return recordCertificateCustody(ctx, q, cert, machineUser, newStatus)
+}
@@ -6,6 +6,9 @@ import (
 	"fmt"
 	"html/template"
 	"log"
+	netmail "net/mail"
+	"net/url"
+	"time"
 
 	"alc/config"
 	"alc/repository"
@@ -61,6 +64,121 @@ const finalCertificateTpl = `
 </html>
 `
 
+const passwordResetTpl = `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Restablecer Contraseña</title>
+</head>
+<body style="font-family: Arial, sans-serif;">
+    <h2>Restablecer Contraseña</h2>
+    <p>Hola {{.UserName}},</p>
+    <p>Recibimos una solicitud para restablecer la contraseña de tu cuenta. Haz clic en el siguiente enlace para elegir una nueva:</p>
+    <p><a href="{{.ResetURL}}" style="padding: 10px 15px; background-color: #007bff; color: white; text-decoration: none; border-radius: 5px;">Restablecer Contraseña</a></p>
+    <p>El enlace vence el {{.ExpiresAt}} y solo puede usarse una vez. Si no solicitaste este cambio, puedes ignorar este correo.</p>
+    <p>Gracias,<br>El equipo de Renovación Tecnológica</p>
+</body>
+</html>
+`
+
+const reportTpl = `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>{{.Name}}</title>
+</head>
+<body style="font-family: Arial, sans-serif;">
+    <h2>{{.Name}}</h2>
+    <p>Adjuntamos el reporte de certificados generado el {{.GeneratedAt}}.</p>
+    {{if .Period}}<p>Incluye los certificados creados {{.Period}}.</p>{{end}}
+    <p>Este correo se envía automáticamente. Para dejar de recibirlo, contacte a un administrador.</p>
+    <p>Gracias,<br>El equipo de Renovación Tecnológica</p>
+</body>
+</html>
+`
+
+const appointmentTpl = `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>{{.Title}}</title>
+</head>
+<body style="font-family: Arial, sans-serif;">
+    <h2>{{.Title}}</h2>
+    <p>Hola {{.UserName}},</p>
+    {{if .Cancelled}}
+    <p>La visita para la renovación de tu equipo ha sido cancelada. El técnico se comunicará contigo para coordinar una nueva fecha.</p>
+    {{else}}
+    <p>Hemos programado una visita para la renovación de tu equipo. Adjuntamos la invitación para que la agregues a tu calendario.</p>
+    {{end}}
+    <ul>
+        <li><strong>Fecha:</strong> {{.Date}}</li>
+        <li><strong>Horario:</strong> {{.Window}}</li>
+        <li><strong>Lugar:</strong> {{.Location}}</li>
+        <li><strong>Técnico:</strong> {{.TechnicianName}}</li>
+    </ul>
+    {{if and .Notes (not .Cancelled)}}<p>{{.Notes}}</p>{{end}}
+    <p>Gracias,<br>El equipo de Renovación Tecnológica</p>
+</body>
+</html>
+`
+
+const loanReturnTpl = `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Acta de Devolución del Equipo en Préstamo</title>
+</head>
+<body style="font-family: Arial, sans-serif;">
+    <h2>Acta de Devolución del Equipo en Préstamo</h2>
+    <p>Hola {{.UserName}},</p>
+    <p>Se ha registrado la devolución del equipo que tenías en préstamo. Por favor, revisa los detalles del acta y confirma o rechaza la devolución.</p>
+    <ul>
+        <li><strong>Modelo:</strong> {{.Model}}</li>
+        <li><strong>N/S:</strong> {{.Serial}}</li>
+        <li><strong>Placa:</strong> {{.Plate}}</li>
+        <li><strong>Recibido por:</strong> {{.ReceivedBy}}</li>
+        {{if .Comments}}<li><strong>Observaciones:</strong> {{.Comments}}</li>{{end}}
+    </ul>
+    <p><a href="{{.ViewURL}}" style="padding: 10px 15px; background-color: #007bff; color: white; text-decoration: none; border-radius: 5px;">Ver Acta de Devolución</a></p>
+    <p>Para confirmar la devolución, por favor haz clic en el siguiente enlace:</p>
+    <p><a href="{{.ConfirmURL}}" style="padding: 10px 15px; background-color: #28a745; color: white; text-decoration: none; border-radius: 5px;">Conforme</a></p>
+    <p>Si tienes alguna observación sobre la devolución, haz clic aquí:</p>
+    <p><a href="{{.RejectURL}}" style="padding: 10px 15px; background-color: #dc3545; color: white; text-decoration: none; border-radius: 5px;">No Conforme</a></p>
+    <p>Gracias,<br>El equipo de Renovación Tecnológica</p>
+</body>
+</html>
+`
+
+const loanOverdueTpl = `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Préstamo de Equipo Vencido</title>
+</head>
+<body style="font-family: Arial, sans-serif;">
+    <h2>Préstamo de Equipo Vencido</h2>
+    <p>Hola {{.UserName}},</p>
+    <p>El préstamo del siguiente equipo venció el {{.DueDate}}. Por favor, coordina su devolución con {{.TechnicianName}}, en copia.</p>
+    <ul>
+        <li><strong>Modelo:</strong> {{.Model}}</li>
+        <li><strong>N/S:</strong> {{.Serial}}</li>
+        <li><strong>Placa:</strong> {{.Plate}}</li>
+    </ul>
+    <p>Gracias,<br>El equipo de Renovación Tecnológica</p>
+</body>
+</html>
+`
+
+// limaLocation is used to show dates in emails in local time.
+var limaLocation = func() *time.Location {
+	loc, err := time.LoadLocation("America/Lima")
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}()
+
 type EmailService struct {
 	config *config.Config
 	client *mail.Client
@@ -173,3 +291,268 @@ func (s *EmailService) SendFinalCertificateEmail(ctx context.Context, user repos
 	log.Printf("Final certificate email sent successfully to %s", user.Email)
 	return nil
 }
+
+func (s *EmailService) SendPasswordResetEmail(ctx context.Context, user repository.AppUser, token repository.PasswordResetToken) error {
+	msg := mail.NewMsg()
+	if err := msg.From(s.config.SmtpSender); err != nil {
+		return err
+	}
+	if err := msg.To(user.Email); err != nil {
+		return err
+	}
+	msg.Subject("Restablecer contraseña")
+
+	data := struct {
+		UserName  string
+		ResetURL  string
+		ExpiresAt string
+	}{
+		UserName:  user.Name,
+		ResetURL:  fmt.Sprintf("%s/password/reset/%s", s.config.AppBaseURL, token.Token.String()),
+		ExpiresAt: token.ExpiresAt.Time.In(limaLocation).Format("02/01/2006 15:04"),
+	}
+
+	t, err := template.New("password_reset").Parse(passwordResetTpl)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	if err := t.Execute(&body, data); err != nil {
+		return err
+	}
+	msg.SetBodyString(mail.TypeTextHTML, body.String())
+
+	if err := s.client.DialAndSend(msg); err != nil {
+		return err
+	}
+	log.Printf("Password reset email sent successfully to %s", user.Email)
+	return nil
+}
+
+// ReportEmail is a scheduled certificate report sent as an attachment.
+type ReportEmail struct {
+	Recipients  []string
+	Name        string
+	Period      string
+	FileName    string
+	ContentType string
+	Data        []byte
+	GeneratedAt time.Time
+}
+
+func (s *EmailService) SendReportEmail(ctx context.Context, report ReportEmail) error {
+	msg := mail.NewMsg()
+	if err := msg.From(s.config.SmtpSender); err != nil {
+		return err
+	}
+	if err := msg.To(report.Recipients...); err != nil {
+		return err
+	}
+	msg.Subject(fmt.Sprintf("%s - %s", report.Name, report.GeneratedAt.In(limaLocation).Format("02/01/2006")))
+
+	data := struct {
+		Name        string
+		Period      string
+		GeneratedAt string
+	}{
+		Name:        report.Name,
+		Period:      report.Period,
+		GeneratedAt: report.GeneratedAt.In(limaLocation).Format("02/01/2006 15:04"),
+	}
+
+	t, err := template.New("report").Parse(reportTpl)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	if err := t.Execute(&body, data); err != nil {
+		return err
+	}
+	msg.SetBodyString(mail.TypeTextHTML, body.String())
+
+	if err := msg.AttachReader(report.FileName, bytes.NewReader(report.Data), mail.WithFileContentType(mail.ContentType(report.ContentType))); err != nil {
+		return err
+	}
+
+	if err := s.client.DialAndSend(msg); err != nil {
+		return err
+	}
+	log.Printf("Report email %q sent successfully to %d recipients", report.Name, len(report.Recipients))
+	return nil
+}
+
+// SendAppointmentEmail sends the invitation of an appointment to the machine user, with the
+// technician in copy, or its cancellation once it is cancelled. The event is attached as
+// an .ics file.
+func (s *EmailService) SendAppointmentEmail(ctx context.Context, appt repository.GetAppointmentDetailsRow) error {
+	msg := mail.NewMsg()
+	if err := msg.From(s.config.SmtpSender); err != nil {
+		return err
+	}
+	if err := msg.To(appt.MachineUserEmail); err != nil {
+		return err
+	}
+	if err := msg.Cc(appt.TechnicianEmail); err != nil {
+		return err
+	}
+
+	cancelled := appt.Status == repository.AppointmentStatusCANCELLED
+	title := "Visita programada para la renovación de tu equipo"
+	method := "REQUEST"
+	if cancelled {
+		title = "Visita cancelada para la renovación de tu equipo"
+		method = "CANCEL"
+	}
+	startsAt := appt.StartsAt.Time.In(limaLocation)
+	msg.Subject(fmt.Sprintf("%s (%s)", title, startsAt.Format("02/01/2006 15:04")))
+
+	data := struct {
+		Title          string
+		UserName       string
+		Cancelled      bool
+		Date           string
+		Window         string
+		Location       string
+		TechnicianName string
+		Notes          string
+	}{
+		Title:          title,
+		UserName:       appt.MachineUserName,
+		Cancelled:      cancelled,
+		Date:           startsAt.Format("02/01/2006"),
+		Window:         startsAt.Format("15:04") + " - " + appt.EndsAt.Time.In(limaLocation).Format("15:04"),
+		Location:       appt.Location,
+		TechnicianName: appt.TechnicianName,
+		Notes:          appt.Notes,
+	}
+
+	t, err := template.New("appointment").Parse(appointmentTpl)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	if err := t.Execute(&body, data); err != nil {
+		return err
+	}
+	msg.SetBodyString(mail.TypeTextHTML, body.String())
+
+	organizer := s.config.SmtpSender
+	if addr, err := netmail.ParseAddress(organizer); err == nil {
+		organizer = addr.Address
+	}
+	uidDomain := "localhost"
+	if u, err := url.Parse(s.config.AppBaseURL); err == nil && u.Hostname() != "" {
+		uidDomain = u.Hostname()
+	}
+	ics := AppointmentICS(appt, uidDomain, organizer, time.Now())
+	contentType := mail.ContentType("text/calendar; charset=utf-8; method=" + method)
+	if err := msg.AttachReader("invitacion.ics", bytes.NewReader(ics), mail.WithFileContentType(contentType)); err != nil {
+		return err
+	}
+
+	if err := s.client.DialAndSend(msg); err != nil {
+		return err
+	}
+	log.Printf("Appointment email for appointment %d sent successfully to %s", appt.AppointmentID, appt.MachineUserEmail)
+	return nil
+}
+
+// SendLoanReturnEmail sends the return acta of a lent device to the machine user, with the
+// links to confirm or reject it.
+func (s *EmailService) SendLoanReturnEmail(ctx context.Context, loan repository.GetLoanDetailsRow) error {
+	msg := mail.NewMsg()
+	if err := msg.From(s.config.SmtpSender); err != nil {
+		return err
+	}
+	if err := msg.To(loan.MachineUserEmail); err != nil {
+		return err
+	}
+	msg.Subject(fmt.Sprintf("Conformidad por devolución del equipo en préstamo (Código: %s)", loan.DeviceCode))
+
+	token := loan.ReturnToken.String()
+	data := struct {
+		UserName   string
+		Model      string
+		Serial     string
+		Plate      string
+		ReceivedBy string
+		Comments   string
+		ViewURL    string
+		ConfirmURL string
+		RejectURL  string
+	}{
+		UserName:   loan.MachineUserName,
+		Model:      loan.Model,
+		Serial:     loan.SerialNum,
+		Plate:      loan.DeviceCode,
+		ReceivedBy: loan.ReceivedByName,
+		Comments:   loan.ReturnComments,
+		ViewURL:    fmt.Sprintf("%s/loan-return/%s", s.config.AppBaseURL, token),
+		ConfirmURL: fmt.Sprintf("%s/loan-return/%s?choice=confirm", s.config.AppBaseURL, token),
+		RejectURL:  fmt.Sprintf("%s/loan-return/%s?choice=reject", s.config.AppBaseURL, token),
+	}
+
+	t, err := template.New("loan-return").Parse(loanReturnTpl)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	if err := t.Execute(&body, data); err != nil {
+		return err
+	}
+	msg.SetBodyString(mail.TypeTextHTML, body.String())
+
+	if err := s.client.DialAndSend(msg); err != nil {
+		return err
+	}
+	log.Printf("Loan return email for loan %d sent successfully to %s", loan.LoanID, loan.MachineUserEmail)
+	return nil
+}
+
+// SendLoanOverdueEmail reminds the machine user of an overdue loan, with the technician who
+// lent the device in copy.
+func (s *EmailService) SendLoanOverdueEmail(ctx context.Context, loan repository.GetLoanDetailsRow) error {
+	msg := mail.NewMsg()
+	if err := msg.From(s.config.SmtpSender); err != nil {
+		return err
+	}
+	if err := msg.To(loan.MachineUserEmail); err != nil {
+		return err
+	}
+	if err := msg.Cc(loan.TechnicianEmail); err != nil {
+		return err
+	}
+	msg.Subject(fmt.Sprintf("Préstamo vencido del equipo %s", loan.DeviceCode))
+
+	data := struct {
+		UserName       string
+		TechnicianName string
+		DueDate        string
+		Model          string
+		Serial         string
+		Plate          string
+	}{
+		UserName:       loan.MachineUserName,
+		TechnicianName: loan.TechnicianName,
+		DueDate:        loan.DueDate.Time.Format("02/01/2006"),
+		Model:          loan.Model,
+		Serial:         loan.SerialNum,
+		Plate:          loan.DeviceCode,
+	}
+
+	t, err := template.New("loan-overdue").Parse(loanOverdueTpl)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	if err := t.Execute(&body, data); err != nil {
+		return err
+	}
+	msg.SetBodyString(mail.TypeTextHTML, body.String())
+
+	if err := s.client.DialAndSend(msg); err != nil {
+		return err
+	}
+	log.Printf("Overdue loan email for loan %d sent successfully to %s", loan.LoanID, loan.MachineUserEmail)
+	return nil
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"alc/repository"
+)
+
+// ImportColumn is a field of a bulk upload. Every field is required, since the upsert
+// overwrites all of them.
+type ImportColumn struct {
+	Field string
+	Label string
+	// Aliases are the accepted headers, already normalized with headerKey
+	Aliases []string
+}
+
+var importColumns = map[ImportKind][]ImportColumn{
+	ImportKindMachineUsers: {
+		{Field: "society", Label: "Society", Aliases: []string{"sociedad", "society", "empresa", "compania"}},
+		{Field: "personal_code", Label: "Personal code", Aliases: []string{"codpersonal", "codigopersonal", "personalcode", "codempleado", "codigoempleado", "codigo"}},
+		{Field: "dni", Label: "DNI", Aliases: []string{"dni", "documento", "nrodoc", "nrodocumento", "nrodedocumento", "numerodocumento", "numerodedocumento", "doc"}},
+		{Field: "name", Label: "Full name", Aliases: []string{"nombrecompleto", "nombre", "nombres", "apellidosynombres", "name", "fullname"}},
+		{Field: "site", Label: "Site", Aliases: []string{"predio", "sede", "local", "site"}},
+		{Field: "email", Label: "Email", Aliases: []string{"emailcorporativo", "email", "correo", "correocorporativo", "correoelectronico", "mail"}},
+		{Field: "area", Label: "Area", Aliases: []string{"area", "gerencia"}},
+		{Field: "floor", Label: "Floor", Aliases: []string{"piso", "floor"}},
+	},
+	ImportKindMachines: {
+		{Field: "serial", Label: "Serial number", Aliases: []string{"serialnumber", "serial", "serie", "numerodeserie", "serialno", "sn"}},
+		{Field: "type", Label: "Type (PC or LAPTOP)", Aliases: []string{"tipo", "type"}},
+		{Field: "mtm", Label: "MTM", Aliases: []string{"mtm", "machinetypemodel"}},
+		{Field: "model", Label: "Model", Aliases: []string{"modelo", "model"}},
+		{Field: "plate", Label: "Plate", Aliases: []string{"placa", "plate", "platenum", "activofijo"}},
+		{Field: "disk", Label: "Disk", Aliases: []string{"ssd", "disco", "disk", "disksize", "almacenamiento"}},
+		{Field: "memory", Label: "Memory", Aliases: []string{"ram", "memoria", "memory", "memorysize"}},
+		{Field: "processor", Label: "Processor", Aliases: []string{"procesador", "processor", "cpu"}},
+		{Field: "profile", Label: "Profile", Aliases: []string{"perfilalicorp", "perfil", "profile"}},
+	},
+}
+
+// importIgnoredHeaders are columns of the usual files that are not imported, so they do
+// not send every upload to the mapping screen.
+var importIgnoredHeaders = map[ImportKind][]string{
+	ImportKindMachineUsers: {"telefonopersonal", "telefono", "celular", "phone"},
+}
+
+// ErrInvalidMapping is returned when a column mapping leaves a field unassigned or
+// assigns it twice.
+var ErrInvalidMapping = errors.New("invalid column mapping")
+
+// Columns lists the fields of the kind in display order.
+func (k ImportKind) Columns() []ImportColumn {
+	return importColumns[k]
+}
+
+// ColumnMapping maps each field to the index of its column in the file.
+type ColumnMapping map[string]int
+
+// index returns the column of a field, or -1 when it is not mapped.
+func (m ColumnMapping) index(field string) int {
+	if i, ok := m[field]; ok {
+		return i
+	}
+	return -1
+}
+
+// Fields returns the field of each of the n columns of the file, "" for ignored ones.
+func (m ColumnMapping) Fields(n int) []string {
+	fields := make([]string, n)
+	for field, i := range m {
+		if i >= 0 && i < n {
+			fields[i] = field
+		}
+	}
+	return fields
+}
+
+// Missing returns the fields of the kind that have no column.
+func (m ColumnMapping) Missing(kind ImportKind) []ImportColumn {
+	var missing []ImportColumn
+	for _, col := range kind.Columns() {
+		if _, ok := m[col.Field]; !ok {
+			missing = append(missing, col)
+		}
+	}
+	return missing
+}
+
+// decodeProfileColumns reads the columns of a saved profile: normalized header to field.
+func decodeProfileColumns(profile repository.ImportMappingProfile) map[string]string {
+	columns := map[string]string{}
+	if err := json.Unmarshal(profile.Columns, &columns); err != nil {
+		return nil
+	}
+	return columns
+}
+
+// bestProfile returns the columns of the saved profile that knows the most headers of the
+// file, or nil when none knows any.
+func bestProfile(profiles []repository.ImportMappingProfile, header []string) map[string]string {
+	var best map[string]string
+	bestCount := 0
+	for _, profile := range profiles {
+		columns := decodeProfileColumns(profile)
+		count := 0
+		for _, h := range header {
+			if _, ok := columns[headerKey(h)]; ok {
+				count++
+			}
+		}
+		if count > bestCount {
+			best, bestCount = columns, count
+		}
+	}
+	return best
+}
+
+// matchColumns assigns the columns of the header to fields, first by the saved profile
+// and then by the aliases. It returns the mapping and the indexes of the non-empty
+// headers that are neither assigned nor known to be ignored.
+func matchColumns(kind ImportKind, header []string, profile map[string]string) (ColumnMapping, []int) {
+	mapping := ColumnMapping{}
+	var unknown []int
+	for i, h := range header {
+		key := headerKey(h)
+		if key == "" {
+			continue
+		}
+		if field, ok := profile[key]; ok {
+			if field == "" {
+				continue // Ignored when the profile was saved
+			}
+			if _, taken := mapping[field]; !taken {
+				mapping[field] = i
+				continue
+			}
+		}
+		matched := false
+		for _, col := range kind.Columns() {
+			if _, taken := mapping[col.Field]; !taken && slices.Contains(col.Aliases, key) {
+				mapping[col.Field] = i
+				matched = true
+				break
+			}
+		}
+		if !matched && !slices.Contains(importIgnoredHeaders[kind], key) {
+			unknown = append(unknown, i)
+		}
+	}
+	return mapping, unknown
+}
+
+// mappingFromFields builds the mapping chosen in the mapping screen, where fields holds the
+// field of each column of the file and "" for the ignored ones.
+func mappingFromFields(kind ImportKind, fields []string) (ColumnMapping, error) {
+	mapping := ColumnMapping{}
+	for i, field := range fields {
+		if field == "" {
+			continue
+		}
+		if !slices.ContainsFunc(kind.Columns(), func(col ImportColumn) bool { return col.Field == field }) {
+			return nil, fmt.Errorf("%w: unknown field %q", ErrInvalidMapping, field)
+		}
+		if _, taken := mapping[field]; taken {
+			return nil, fmt.Errorf("%w: %s is assigned to more than one column", ErrInvalidMapping, field)
+		}
+		mapping[field] = i
+	}
+	if missing := mapping.Missing(kind); len(missing) > 0 {
+		labels := make([]string, len(missing))
+		for i, col := range missing {
+			labels[i] = col.Label
+		}
+		return nil, fmt.Errorf("%w: no column selected for %s", ErrInvalidMapping, strings.Join(labels, ", "))
+	}
+	return mapping, nil
+}
+
+// profileColumns converts a mapping into the columns of a profile, keyed by the normalized
+// headers of the file. Unassigned headers are saved as ignored.
+func profileColumns(header []string, mapping ColumnMapping) map[string]string {
+	fields := mapping.Fields(len(header))
+	columns := map[string]string{}
+	for i, h := range header {
+		if key := headerKey(h); key != "" {
+			columns[key] = fields[i]
+		}
+	}
+	return columns
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+func TestHeaderKey(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{header: "Serial No.", want: "serialno"},
+		{header: "serial_no", want: "serialno"},
+		{header: "Número de documento", want: "numerodedocumento"},
+		{header: "  CORREO ELECTRÓNICO ", want: "correoelectronico"},
+		{header: "Compañía", want: "compania"},
+		{header: "---", want: ""},
+	}
+	for _, tt := range tests {
+		if got := headerKey(tt.header); got != tt.want {
+			t.Errorf("headerKey(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	latin1, err := charmap.Windows1252.NewEncoder().String("DNI;Nombre;Área\n70000001;Nuñez;Logística\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data string
+		want [][]string
+	}{
+		{
+			name: "comma",
+			data: "DNI,Nombre\n70000001,Ana Torres\n",
+			want: [][]string{{"DNI", "Nombre"}, {"70000001", "Ana Torres"}},
+		},
+		{
+			name: "semicolon",
+			data: "DNI;Nombre;Área\n70000001;Torres, Ana;Logística\n",
+			want: [][]string{{"DNI", "Nombre", "Área"}, {"70000001", "Torres, Ana", "Logística"}},
+		},
+		{
+			name: "byte order mark",
+			data: "\xEF\xBB\xBFDNI,Nombre\n70000001,Ana Torres\n",
+			want: [][]string{{"DNI", "Nombre"}, {"70000001", "Ana Torres"}},
+		},
+		{
+			name: "Latin-1",
+			data: latin1,
+			want: [][]string{{"DNI", "Nombre", "Área"}, {"70000001", "Nuñez", "Logística"}},
+		},
+		{
+			name: "ragged rows and leading spaces",
+			data: "DNI, Nombre\n70000001\n",
+			want: [][]string{{"DNI", "Nombre"}, {"70000001"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadSpreadsheet("usuarios.csv", strings.NewReader(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadSpreadsheet() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ReadSpreadsheet("usuarios.pdf", strings.NewReader("")); err == nil {
+		t.Error("ReadSpreadsheet() of a PDF succeeded")
+	}
+}
+
+func TestMatchColumns(t *testing.T) {
+	tests := []struct {
+		name        string
+		kind        ImportKind
+		header      []string
+		profile     map[string]string
+		want        ColumnMapping
+		wantUnknown []int
+	}{
+		{
+			name:   "aliases",
+			kind:   ImportKindMachineUsers,
+			header: []string{"Sociedad", "Cod. Personal", "Nro. Documento", "Apellidos y Nombres", "Sede", "Correo Electrónico", "Gerencia", "Piso"},
+			want:   ColumnMapping{"society": 0, "personal_code": 1, "dni": 2, "name": 3, "site": 4, "email": 5, "area": 6, "floor": 7},
+		},
+		{
+			name:        "ignored and unknown headers",
+			kind:        ImportKindMachineUsers,
+			header:      []string{"DNI", "Teléfono", "Cargo", ""},
+			want:        ColumnMapping{"dni": 0},
+			wantUnknown: []int{2},
+		},
+		{
+			name:        "an alias maps a single column",
+			kind:        ImportKindMachines,
+			header:      []string{"Serie", "Serial Number", "Tipo"},
+			want:        ColumnMapping{"serial": 0, "type": 2},
+			wantUnknown: []int{1},
+		},
+		{
+			name:    "the profile comes before the aliases",
+			kind:    ImportKindMachines,
+			header:  []string{"Serie", "Código de activo", "Placa"},
+			profile: map[string]string{"codigodeactivo": "plate", "placa": ""},
+			want:    ColumnMapping{"serial": 0, "plate": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, unknown := matchColumns(tt.kind, tt.header, tt.profile)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matchColumns() mapping = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(unknown, tt.wantUnknown) {
+				t.Errorf("matchColumns() unknown = %v, want %v", unknown, tt.wantUnknown)
+			}
+		})
+	}
+}
+
+func TestMappingFromFields(t *testing.T) {
+	fields := []string{"society", "personal_code", "dni", "name", "", "site", "email", "area", "floor"}
+	mapping, err := mappingFromFields(ImportKindMachineUsers, fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mapping["site"] != 5 || mapping.index("phone") != -1 {
+		t.Errorf("mappingFromFields() = %v", mapping)
+	}
+	if got := mapping.Fields(len(fields)); !reflect.DeepEqual(got, fields) {
+		t.Errorf("Fields() = %q, want %q", got, fields)
+	}
+
+	for _, fields := range [][]string{
+		{"society", "personal_code", "dni", "name", "site", "email", "area"},
+		{"society", "personal_code", "dni", "name", "site", "email", "area", "floor", "dni"},
+		{"society", "personal_code", "dni", "name", "site", "email", "area", "floor", "phone"},
+	} {
+		if _, err := mappingFromFields(ImportKindMachineUsers, fields); !errors.Is(err, ErrInvalidMapping) {
+			t.Errorf("mappingFromFields(%q) error = %v, want ErrInvalidMapping", fields, err)
+		}
+	}
+}
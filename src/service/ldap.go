@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"alc/config"
+	"alc/repository"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// LDAPAuthenticator authenticates against LDAP or Active Directory: it searches the
+// user with a service account, binds as the user to check the password and reads
+// the group membership to decide the role. Name, email and DNI are synced into app_users.
+type LDAPAuthenticator struct {
+	config *config.Config
+	repo   *repository.Queries
+}
+
+func NewLDAPAuthenticator(cfg *config.Config, r *repository.Queries) *LDAPAuthenticator {
+	return &LDAPAuthenticator{config: cfg, repo: r}
+}
+
+func (a *LDAPAuthenticator) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(a.config.LDAPURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to LDAP: %w", err)
+	}
+	if a.config.LDAPStartTLS {
+		host := strings.TrimPrefix(a.config.LDAPURL, "ldap://")
+		host, _, _ = strings.Cut(host, ":")
+		if err := conn.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+func (a *LDAPAuthenticator) Authenticate(ctx context.Context, login, password string) (repository.AppUser, error) {
+	identity, err := a.lookup(login, password)
+	if err != nil {
+		return repository.AppUser{}, err
+	}
+
+	user, err := syncExternalUser(ctx, a.repo, identity, a.config.LDAPAllowJIT && identity.Role != "")
+	if err != nil && !errors.Is(err, ErrUserDisabled) {
+		return repository.AppUser{}, fmt.Errorf("failed to sync LDAP user %s: %w", identity.Email, err)
+	}
+	return user, err
+}
+
+// lookup checks the password against the directory and returns the identity of the
+// user, with the role its groups map to.
+func (a *LDAPAuthenticator) lookup(login, password string) (externalIdentity, error) {
+	login = strings.TrimSpace(login)
+	// An empty password would be an unauthenticated bind, which many servers accept
+	if login == "" || password == "" {
+		return externalIdentity{}, ErrInvalidCredentials
+	}
+
+	conn, err := a.dial()
+	if err != nil {
+		return externalIdentity{}, err
+	}
+	defer conn.Close()
+
+	if a.config.LDAPBindDN != "" {
+		if err := conn.Bind(a.config.LDAPBindDN, a.config.LDAPBindPassword); err != nil {
+			return externalIdentity{}, fmt.Errorf("failed to bind service account: %w", err)
+		}
+	}
+
+	filter := strings.ReplaceAll(a.config.LDAPUserFilter, "%s", ldap.EscapeFilter(login))
+	result, err := conn.Search(ldap.NewSearchRequest(
+		a.config.LDAPBaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		filter,
+		[]string{a.config.LDAPNameAttr, a.config.LDAPEmailAttr, a.config.LDAPDniAttr, a.config.LDAPGroupAttr},
+		nil,
+	))
+	if err != nil {
+		return externalIdentity{}, fmt.Errorf("failed to search LDAP user: %w", err)
+	}
+	if len(result.Entries) != 1 {
+		return externalIdentity{}, ErrInvalidCredentials
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return externalIdentity{}, ErrInvalidCredentials
+		}
+		return externalIdentity{}, fmt.Errorf("failed to bind LDAP user: %w", err)
+	}
+
+	email := strings.ToLower(strings.TrimSpace(entry.GetAttributeValue(a.config.LDAPEmailAttr)))
+	if email == "" {
+		log.Printf("LDAP user %s has no %s attribute", entry.DN, a.config.LDAPEmailAttr)
+		return externalIdentity{}, ErrInvalidCredentials
+	}
+
+	// With no group mapping role is empty, and unknown users are never created
+	role, ok := roleFromGroups(entry.GetAttributeValues(a.config.LDAPGroupAttr), a.config.LDAPAdminGroups, a.config.LDAPSupervisorGroups, a.config.LDAPTecnicoGroups)
+	if !ok {
+		log.Printf("LDAP user %s is not in any allowed group", entry.DN)
+		return externalIdentity{}, ErrInvalidCredentials
+	}
+
+	return externalIdentity{
+		Email: email,
+		Name:  strings.TrimSpace(entry.GetAttributeValue(a.config.LDAPNameAttr)),
+		Dni:   strings.TrimSpace(entry.GetAttributeValue(a.config.LDAPDniAttr)),
+		Role:  role,
+	}, nil
+}
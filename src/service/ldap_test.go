@@ -0,0 +1,281 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"alc/config"
+	"alc/db/dbtest"
+	"alc/repository"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/jimlambrt/gldap"
+)
+
+const (
+	testLDAPBaseDN      = "dc=example,dc=org"
+	testLDAPServiceDN   = "cn=alc,ou=services,dc=example,dc=org"
+	testLDAPServicePass = "service-secret"
+	testLDAPAdmins      = "cn=alc-admins,ou=groups,dc=example,dc=org"
+	testLDAPTecnicos    = "cn=alc-tecnicos,ou=groups,dc=example,dc=org"
+)
+
+// testLDAPEntry is a user of the test directory.
+type testLDAPEntry struct {
+	dn       string
+	password string
+	attrs    map[string][]string
+}
+
+// testLDAPUsers are the users every test directory holds.
+var testLDAPUsers = []testLDAPEntry{
+	{
+		dn:       "uid=ana,ou=people,dc=example,dc=org",
+		password: "ana-secret",
+		attrs: map[string][]string{
+			"uid": {"ana"}, "mail": {"Ana@Example.com"}, "displayName": {"Ana Torres"}, "employeeID": {"40000001"},
+			"memberOf": {testLDAPAdmins, testLDAPTecnicos},
+		},
+	},
+	{
+		dn:       "uid=luis,ou=people,dc=example,dc=org",
+		password: "luis-secret",
+		attrs: map[string][]string{
+			"uid": {"luis"}, "mail": {"luis@example.com"}, "displayName": {"Luis Rojas"}, "employeeID": {"40000002"},
+			"memberOf": {testLDAPTecnicos},
+		},
+	},
+	{
+		dn:       "uid=vera,ou=people,dc=example,dc=org",
+		password: "vera-secret",
+		attrs: map[string][]string{
+			"uid": {"vera"}, "mail": {"vera@example.com"}, "displayName": {"Vera Paz"},
+			"memberOf": {"cn=ventas,ou=groups,dc=example,dc=org"},
+		},
+	},
+	{
+		dn:       "uid=nomail,ou=people,dc=example,dc=org",
+		password: "nomail-secret",
+		attrs:    map[string][]string{"uid": {"nomail"}, "displayName": {"Sin Correo"}, "memberOf": {testLDAPTecnicos}},
+	},
+}
+
+// startTestLDAP runs an in-process directory with testLDAPUsers and a service account.
+// Searches support the equality filters LDAP_USER_FILTER is built from.
+func startTestLDAP(t *testing.T) string {
+	t.Helper()
+
+	mux, err := gldap.NewMux()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux.Bind(func(w *gldap.ResponseWriter, r *gldap.Request) {
+		resp := r.NewBindResponse(gldap.WithResponseCode(gldap.ResultInvalidCredentials))
+		defer w.Write(resp)
+
+		m, err := r.GetSimpleBindMessage()
+		if err != nil {
+			return
+		}
+		if m.UserName == testLDAPServiceDN && string(m.Password) == testLDAPServicePass {
+			resp.SetResultCode(gldap.ResultSuccess)
+			return
+		}
+		for _, u := range testLDAPUsers {
+			if strings.EqualFold(m.UserName, u.dn) && string(m.Password) == u.password {
+				resp.SetResultCode(gldap.ResultSuccess)
+				return
+			}
+		}
+	})
+	mux.Search(func(w *gldap.ResponseWriter, r *gldap.Request) {
+		resp := r.NewSearchDoneResponse(gldap.WithResponseCode(gldap.ResultSuccess))
+		defer w.Write(resp)
+
+		m, err := r.GetSearchMessage()
+		if err != nil {
+			resp.SetResultCode(gldap.ResultOperationsError)
+			return
+		}
+		for _, u := range testLDAPUsers {
+			if !strings.HasSuffix(u.dn, ","+m.BaseDN) || !testLDAPMatches(u, m.Filter) {
+				continue
+			}
+			entry := r.NewSearchResponseEntry(u.dn)
+			for _, name := range m.Attributes {
+				if values, ok := u.attrs[name]; ok {
+					entry.AddAttribute(name, values)
+				}
+			}
+			w.Write(entry)
+		}
+	})
+
+	srv, err := gldap.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Router(mux); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	go srv.Run(addr)
+	t.Cleanup(func() { srv.Stop() })
+	for deadline := time.Now().Add(5 * time.Second); !srv.Ready(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("test directory did not start")
+		}
+	}
+	return "ldap://" + addr
+}
+
+// testLDAPMatches reports whether the entry matches an equality filter such as (mail=x).
+func testLDAPMatches(u testLDAPEntry, filter string) bool {
+	for name, values := range u.attrs {
+		for _, v := range values {
+			if strings.EqualFold(filter, fmt.Sprintf("(%s=%s)", name, ldap.EscapeFilter(v))) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func newTestLDAPConfig(t *testing.T) config.Config {
+	return config.Config{
+		LDAPURL:           startTestLDAP(t),
+		LDAPBindDN:        testLDAPServiceDN,
+		LDAPBindPassword:  testLDAPServicePass,
+		LDAPBaseDN:        testLDAPBaseDN,
+		LDAPUserFilter:    "(mail=%s)",
+		LDAPNameAttr:      "displayName",
+		LDAPEmailAttr:     "mail",
+		LDAPDniAttr:       "employeeID",
+		LDAPGroupAttr:     "memberOf",
+		LDAPAdminGroups:   []string{testLDAPAdmins},
+		LDAPTecnicoGroups: []string{testLDAPTecnicos},
+	}
+}
+
+func TestLDAPLookup(t *testing.T) {
+	cfg := newTestLDAPConfig(t)
+	a := NewLDAPAuthenticator(&cfg, nil)
+
+	tests := []struct {
+		name     string
+		login    string
+		password string
+		want     externalIdentity
+		wantErr  error
+	}{
+		{
+			name: "admin by its groups", login: "ana@example.com", password: "ana-secret",
+			want: externalIdentity{Email: "ana@example.com", Name: "Ana Torres", Dni: "40000001", Role: repository.UserRoleADMIN},
+		},
+		{
+			name: "tecnico", login: " luis@example.com ", password: "luis-secret",
+			want: externalIdentity{Email: "luis@example.com", Name: "Luis Rojas", Dni: "40000002", Role: repository.UserRoleTECNICO},
+		},
+		{name: "wrong password", login: "luis@example.com", password: "ana-secret", wantErr: ErrInvalidCredentials},
+		{name: "empty password", login: "luis@example.com", password: "", wantErr: ErrInvalidCredentials},
+		{name: "unknown user", login: "nadie@example.com", password: "luis-secret", wantErr: ErrInvalidCredentials},
+		{name: "filter injection", login: "*", password: "luis-secret", wantErr: ErrInvalidCredentials},
+		{name: "outside the groups", login: "vera@example.com", password: "vera-secret", wantErr: ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := a.lookup(tt.login, tt.password)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("lookup() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("lookup() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("no email attribute", func(t *testing.T) {
+		cfg := cfg
+		cfg.LDAPUserFilter = "(uid=%s)"
+		if _, err := NewLDAPAuthenticator(&cfg, nil).lookup("nomail", "nomail-secret"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("lookup() error = %v, want ErrInvalidCredentials", err)
+		}
+	})
+
+	t.Run("wrong service account", func(t *testing.T) {
+		cfg := cfg
+		cfg.LDAPBindPassword = "wrong"
+		if _, err := NewLDAPAuthenticator(&cfg, nil).lookup("luis@example.com", "luis-secret"); err == nil || errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("lookup() error = %v, want a service bind error", err)
+		}
+	})
+
+	t.Run("no group mapping", func(t *testing.T) {
+		cfg := cfg
+		cfg.LDAPAdminGroups, cfg.LDAPTecnicoGroups = nil, nil
+		got, err := NewLDAPAuthenticator(&cfg, nil).lookup("vera@example.com", "vera-secret")
+		if err != nil || got.Role != "" {
+			t.Errorf("lookup() = %+v, %v, want no role and no error", got, err)
+		}
+	})
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	_, repo := dbtest.New(t)
+	ctx := context.Background()
+	cfg := newTestLDAPConfig(t)
+
+	t.Run("JIT off", func(t *testing.T) {
+		if _, err := NewLDAPAuthenticator(&cfg, repo).Authenticate(ctx, "luis@example.com", "luis-secret"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("Authenticate() error = %v, want ErrInvalidCredentials", err)
+		}
+	})
+
+	t.Run("JIT without a group mapping", func(t *testing.T) {
+		cfg := cfg
+		cfg.LDAPAllowJIT = true
+		cfg.LDAPAdminGroups, cfg.LDAPTecnicoGroups = nil, nil
+		if _, err := NewLDAPAuthenticator(&cfg, repo).Authenticate(ctx, "luis@example.com", "luis-secret"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("Authenticate() error = %v, want ErrInvalidCredentials", err)
+		}
+	})
+
+	jit := cfg
+	jit.LDAPAllowJIT = true
+	a := NewLDAPAuthenticator(&jit, repo)
+
+	t.Run("JIT on", func(t *testing.T) {
+		user, err := a.Authenticate(ctx, "luis@example.com", "luis-secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Role != repository.UserRoleTECNICO || user.Dni != "40000002" || !user.ExternalAuth {
+			t.Errorf("Authenticate() = role %s, dni %s, external %v", user.Role, user.Dni, user.ExternalAuth)
+		}
+	})
+
+	t.Run("deleted users are not created again", func(t *testing.T) {
+		user, err := a.Authenticate(ctx, "ana@example.com", "ana-secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.SoftDeleteAppUser(ctx, user.UserID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := a.Authenticate(ctx, "ana@example.com", "ana-secret"); !errors.Is(err, ErrUserDisabled) {
+			t.Fatalf("Authenticate() error = %v, want ErrUserDisabled", err)
+		}
+	})
+}
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"alc/repository"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const loanReminderPollInterval = time.Hour
+
+// ErrLoanReturnNotPending is returned when the machine user answers a return that is no
+// longer waiting for them.
+var ErrLoanReturnNotPending = errors.New("the loan return is not pending")
+
+// LoanService tracks devices lent with a certificate: their return acta, confirmed by the
+// machine user by email, and the reminders once they are overdue.
+type LoanService struct {
+	DBPool *pgxpool.Pool
+	Repo   *repository.Queries
+	Email  *EmailService
+}
+
+func NewLoanService(db *pgxpool.Pool, r *repository.Queries, emailSvc *EmailService) *LoanService {
+	return &LoanService{DBPool: db, Repo: r, Email: emailSvc}
+}
+
+// LimaToday is the current date in Lima, the one due dates are compared to.
+func LimaToday() pgtype.Date {
+	now := time.Now().In(limaLocation)
+	return pgtype.Date{Time: time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC), Valid: true}
+}
+
+// parseLoanDueDate reads the due date of a loan from the certificate form. It is only
+// required, and only read, when the new device is lent. current is the due date on file
+// for an edited certificate, which may be kept once it is past.
+func parseLoanDueDate(form url.Values, current pgtype.Date) (pgtype.Date, error) {
+	if repository.DeviceStatus(normalize(form.Get("new_device_status"), true)) != repository.DeviceStatusPRESTAMO {
+		return pgtype.Date{}, nil
+	}
+	value := strings.TrimSpace(form.Get("loan_due_date"))
+	if value == "" {
+		return pgtype.Date{}, errors.New("la 'Fecha de Devolución' es obligatoria para un préstamo")
+	}
+	due, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return pgtype.Date{}, fmt.Errorf("la 'Fecha de Devolución' '%s' no es válida", value)
+	}
+	if due.Before(LimaToday().Time) && !(current.Valid && due.Equal(current.Time)) {
+		return pgtype.Date{}, errors.New("la 'Fecha de Devolución' no puede ser anterior a hoy")
+	}
+	return pgtype.Date{Time: due, Valid: true}, nil
+}
+
+// recordCertificateLoan keeps the loan of a certificate in step with it: recorded with its
+// due date when the new device is lent, dropped otherwise.
+func recordCertificateLoan(ctx context.Context, q *repository.Queries, cert repository.Alicorp2025Certificate, dueDate pgtype.Date) error {
+	if !dueDate.Valid {
+		if err := q.DeleteCertificateLoan(ctx, cert.CertificateID); err != nil {
+			return fmt.Errorf("failed to remove loan of certificate %d: %w", cert.CertificateID, err)
+		}
+		return nil
+	}
+	err := q.UpsertCertificateLoan(ctx, repository.UpsertCertificateLoanParams{
+		CertificateID:  cert.CertificateID,
+		DeviceCode:     cert.NewDeviceCode,
+		MachineUserDni: cert.MachineUserDni,
+		TechnicianID:   cert.AppUserID,
+		DueDate:        dueDate,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record loan of certificate %d: %w", cert.CertificateID, err)
+	}
+	return nil
+}
+
+// RequestReturn registers that the technician received a lent device back and emails the
+// return acta to the machine user for confirmation. The email is sent in the background.
+func (s *LoanService) RequestReturn(ctx context.Context, loanID int32, receivedBy uuid.UUID, comments string) (repository.DeviceLoan, error) {
+	loan, err := s.Repo.RequestLoanReturn(ctx, repository.RequestLoanReturnParams{
+		LoanID:           loanID,
+		ReturnComments:   strings.TrimSpace(comments),
+		ReturnReceivedBy: pgtype.UUID{Bytes: receivedBy, Valid: true},
+	})
+	if err != nil {
+		return loan, err
+	}
+
+	go func() {
+		ctx := context.Background()
+		details, err := s.Repo.GetLoanDetails(ctx, loan.LoanID)
+		if err != nil {
+			log.Printf("ERROR: Failed to load loan %d for its return email: %v", loan.LoanID, err)
+			return
+		}
+		if err := s.Email.SendLoanReturnEmail(ctx, details); err != nil {
+			log.Printf("ERROR: Failed to send return acta of loan %d: %v", loan.LoanID, err)
+		}
+	}()
+	return loan, nil
+}
+
+// SetReturnStatus records the machine user's answer to the return acta. A confirmed return
+// sends the device back to the warehouse in its custody history.
+func (s *LoanService) SetReturnStatus(ctx context.Context, token pgtype.UUID, status repository.CertificateStatus) (repository.DeviceLoan, error) {
+	tx, err := s.DBPool.Begin(ctx)
+	if err != nil {
+		return repository.DeviceLoan{}, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := s.Repo.WithTx(tx)
+
+	loan, err := qtx.SetLoanReturnStatus(ctx, repository.SetLoanReturnStatusParams{
+		ReturnStatus: status,
+		ReturnToken:  token,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return loan, ErrLoanReturnNotPending
+		}
+		return loan, err
+	}
+
+	if status == repository.CertificateStatusCONFIRMED {
+		machineUser, err := qtx.GetMachineUserByDNI(ctx, loan.MachineUserDni)
+		if err != nil {
+			return loan, fmt.Errorf("failed to get machine user %s: %w", loan.MachineUserDni, err)
+		}
+		_, err = RecordDeviceCustody(ctx, qtx, repository.CreateDeviceCustodyParams{
+			DeviceCode:     loan.DeviceCode,
+			Event:          repository.CustodyEventDEVOLUCION,
+			Custodian:      repository.CustodianTypeWAREHOUSE,
+			MachineUserDni: pgtype.Text{String: loan.MachineUserDni, Valid: true},
+			Location:       machineUser.Site,
+			CertificateID:  pgtype.Int4{Int32: loan.CertificateID, Valid: true},
+			RecordedBy:     loan.ReturnReceivedBy,
+			Notes:          loan.ReturnComments,
+		})
+		if err != nil {
+			return loan, err
+		}
+		// Back in the warehouse, the device is handled like any other recovered one
+		if err := qtx.UpdateDeviceStatus(ctx, repository.UpdateDeviceStatusParams{
+			DeviceCode: loan.DeviceCode,
+			Status:     repository.DeviceStatusRECUPERACION,
+		}); err != nil {
+			return loan, fmt.Errorf("failed to update status of device %s: %w", loan.DeviceCode, err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return loan, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return loan, nil
+}
+
+// Run reminds the machine user and the technician of overdue loans, once a week per loan,
+// until ctx is cancelled.
+func (s *LoanService) Run(ctx context.Context) {
+	ticker := time.NewTicker(loanReminderPollInterval)
+	defer ticker.Stop()
+	for {
+		s.notifyOverdue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *LoanService) notifyOverdue(ctx context.Context) {
+	ids, err := s.Repo.ListOverdueLoansToNotify(ctx, LimaToday())
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("ERROR: Failed to list overdue loans: %v", err)
+		}
+		return
+	}
+	for _, id := range ids {
+		loan, err := s.Repo.GetLoanDetails(ctx, id)
+		if err != nil {
+			log.Printf("ERROR: Failed to load overdue loan %d: %v", id, err)
+			continue
+		}
+		if err := s.Email.SendLoanOverdueEmail(ctx, loan); err != nil {
+			log.Printf("ERROR: Failed to send overdue reminder of loan %d: %v", id, err)
+			continue
+		}
+		if err := s.Repo.MarkLoanOverdueNotified(ctx, id); err != nil {
+			log.Printf("ERROR: Failed to mark loan %d as notified: %v", id, err)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"alc/repository"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrPlateInUse is returned when saving a machine with the plate of another machine.
+var ErrPlateInUse = errors.New("the plate is already used by another machine")
+
+// AssetInUseError is returned when deleting a machine or device that certificates still
+// reference. Its message explains what holds it, for the admin to retire it instead.
+type AssetInUseError struct {
+	Reason string
+}
+
+func (e *AssetInUseError) Error() string {
+	return e.Reason
+}
+
+// MachineService maintains the machines and devices from the admin panel: edits, serial
+// number renames, retirement and deletion.
+type MachineService struct {
+	DBPool *pgxpool.Pool
+	Repo   *repository.Queries
+}
+
+func NewMachineService(db *pgxpool.Pool, r *repository.Queries) *MachineService {
+	return &MachineService{DBPool: db, Repo: r}
+}
+
+// certificateTickets lists the tickets of the certificates of a device, for the messages
+// of a blocked deletion.
+func certificateTickets(certs []repository.ListDeviceCertificatesRow) string {
+	tickets := make([]string, len(certs))
+	for i, cert := range certs {
+		tickets[i] = cert.TicketName
+	}
+	return strings.Join(tickets, ", ")
+}
+
+// UpdateMachine saves a machine, renaming it when p.SerialNum differs from
+// p.OldSerialNum. Its device and reservation follow the rename. It returns pgx.ErrNoRows
+// when the machine does not exist, ErrPlateInUse when another machine has the plate and a
+// unique violation when another machine has the new serial number.
+func (s *MachineService) UpdateMachine(ctx context.Context, p repository.UpdateMachineParams) (repository.Machine, error) {
+	tx, err := s.DBPool.Begin(ctx)
+	if err != nil {
+		return repository.Machine{}, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := s.Repo.WithTx(tx)
+
+	if p.PlateNum != "" {
+		others, err := qtx.CountMachinesWithPlate(ctx, repository.CountMachinesWithPlateParams{
+			PlateNum:  p.PlateNum,
+			SerialNum: p.OldSerialNum,
+		})
+		if err != nil {
+			return repository.Machine{}, fmt.Errorf("failed to check plate %s: %w", p.PlateNum, err)
+		}
+		if others > 0 {
+			return repository.Machine{}, ErrPlateInUse
+		}
+	}
+
+	machine, err := qtx.UpdateMachine(ctx, p)
+	if err != nil {
+		return machine, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return machine, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return machine, nil
+}
+
+// SetMachineRetired retires a machine, taking it out of stock and releasing its
+// reservation, or brings it back.
+func (s *MachineService) SetMachineRetired(ctx context.Context, serial string, retired bool) error {
+	tx, err := s.DBPool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := s.Repo.WithTx(tx)
+
+	if err := qtx.SetMachineRetired(ctx, repository.SetMachineRetiredParams{
+		SerialNum: serial,
+		Retired:   retired,
+	}); err != nil {
+		return fmt.Errorf("failed to retire machine %s: %w", serial, err)
+	}
+	if retired {
+		if _, err := qtx.ReleaseMachineReservation(ctx, serial); err != nil {
+			return fmt.Errorf("failed to release reservation of machine %s: %w", serial, err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// DeleteMachine deletes a machine without a device. A machine with a device returns an
+// AssetInUseError naming the device and its certificates.
+func (s *MachineService) DeleteMachine(ctx context.Context, serial string) error {
+	device, err := s.Repo.GetDeviceByMachine(ctx, serial)
+	if err == nil {
+		certs, err := s.Repo.ListDeviceCertificates(ctx, device.DeviceCode)
+		if err != nil {
+			return fmt.Errorf("failed to list certificates of device %s: %w", device.DeviceCode, err)
+		}
+		if len(certs) > 0 {
+			return &AssetInUseError{Reason: fmt.Sprintf(
+				"Machine %s cannot be deleted: its device %s is referenced by the certificates %s. Retire it instead.",
+				serial, device.DeviceCode, certificateTickets(certs))}
+		}
+		return &AssetInUseError{Reason: fmt.Sprintf(
+			"Machine %s cannot be deleted while it has the device %s. Delete the device first.",
+			serial, device.DeviceCode)}
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("failed to get device of machine %s: %w", serial, err)
+	}
+
+	deleted, err := s.Repo.DeleteMachine(ctx, serial)
+	if err != nil {
+		return fmt.Errorf("failed to delete machine %s: %w", serial, err)
+	}
+	if deleted == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteDevice deletes a device, with its custody history, unless certificates
+// reference it, which returns an AssetInUseError naming them.
+func (s *MachineService) DeleteDevice(ctx context.Context, deviceCode string) error {
+	certs, err := s.Repo.ListDeviceCertificates(ctx, deviceCode)
+	if err != nil {
+		return fmt.Errorf("failed to list certificates of device %s: %w", deviceCode, err)
+	}
+	if len(certs) > 0 {
+		return &AssetInUseError{Reason: fmt.Sprintf(
+			"Device %s cannot be deleted: it is referenced by the certificates %s. Retire it instead.",
+			deviceCode, certificateTickets(certs))}
+	}
+
+	deleted, err := s.Repo.DeleteDevice(ctx, deviceCode)
+	if err != nil {
+		return fmt.Errorf("failed to delete device %s: %w", deviceCode, err)
+	}
+	if deleted == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// checkNotRetired refuses a certificate handing over a retired machine or device.
+func checkNotRetired(ctx context.Context, q *repository.Queries, serial, deviceCode string) error {
+	retired, err := q.GetRetiredCertificateAssets(ctx, repository.GetRetiredCertificateAssetsParams{
+		SerialNum:  serial,
+		DeviceCode: deviceCode,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to check retirement of machine %s: %w", serial, err)
+	}
+	if retired.MachineRetired {
+		return fmt.Errorf("el equipo con N/S '%s' está dado de baja", serial)
+	}
+	if retired.DeviceRetired {
+		return fmt.Errorf("el equipo con código '%s' está dado de baja", deviceCode)
+	}
+	return nil
+}
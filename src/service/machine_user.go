@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"alc/repository"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	// ErrMachineUserInactive is returned when reserving a machine for a machine user who
+	// left the company.
+	ErrMachineUserInactive = errors.New("the machine user is inactive")
+	// ErrMergeSameMachineUser is returned when merging a machine user into itself.
+	ErrMergeSameMachineUser = errors.New("cannot merge a machine user into itself")
+)
+
+// machineUserFields are the editable fields of a machine user, by column name.
+func machineUserFields(mu repository.MachineUser) map[string]string {
+	return map[string]string{
+		"personal_code": mu.PersonalCode,
+		"name":          mu.Name,
+		"email":         mu.Email,
+		"society":       mu.Society,
+		"site":          mu.Site,
+		"area":          mu.Area,
+		"floor_name":    mu.FloorName,
+	}
+}
+
+// recordMachineUserChanges writes to the history of a machine user the fields that differ
+// between two versions of it.
+func recordMachineUserChanges(ctx context.Context, q *repository.Queries, before, after repository.MachineUser, source repository.MachineUserChangeSource, changedBy uuid.UUID) error {
+	old := machineUserFields(before)
+	for field, value := range machineUserFields(after) {
+		if old[field] == value {
+			continue
+		}
+		if err := q.CreateMachineUserHistory(ctx, repository.CreateMachineUserHistoryParams{
+			MachineUserDni: after.Dni,
+			Field:          field,
+			OldValue:       old[field],
+			NewValue:       value,
+			Source:         source,
+			ChangedBy:      pgtype.UUID{Bytes: changedBy, Valid: true},
+		}); err != nil {
+			return fmt.Errorf("failed to record change of %s of machine user %s: %w", field, after.Dni, err)
+		}
+	}
+	return nil
+}
+
+// MachineUserService maintains the directory of machine users: edits, deactivation and
+// merges of duplicates, each recorded in their history.
+type MachineUserService struct {
+	DBPool *pgxpool.Pool
+	Repo   *repository.Queries
+}
+
+func NewMachineUserService(db *pgxpool.Pool, r *repository.Queries) *MachineUserService {
+	return &MachineUserService{DBPool: db, Repo: r}
+}
+
+// Update saves a machine user and records the fields that changed. It returns
+// pgx.ErrNoRows when the machine user does not exist and a unique violation when another
+// one has the email or personal code.
+func (s *MachineUserService) Update(ctx context.Context, p repository.UpdateMachineUserParams, changedBy uuid.UUID) (repository.MachineUser, error) {
+	tx, err := s.DBPool.Begin(ctx)
+	if err != nil {
+		return repository.MachineUser{}, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := s.Repo.WithTx(tx)
+
+	before, err := qtx.GetMachineUserByDNI(ctx, p.Dni)
+	if err != nil {
+		return before, err
+	}
+	after, err := qtx.UpdateMachineUser(ctx, p)
+	if err != nil {
+		return after, err
+	}
+	if err := recordMachineUserChanges(ctx, qtx, before, after, repository.MachineUserChangeSourceDIRECTORY, changedBy); err != nil {
+		return after, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return after, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return after, nil
+}
+
+// SetActive deactivates a machine user who left the company, or reactivates them.
+func (s *MachineUserService) SetActive(ctx context.Context, dni string, active bool, changedBy uuid.UUID) (repository.MachineUser, error) {
+	tx, err := s.DBPool.Begin(ctx)
+	if err != nil {
+		return repository.MachineUser{}, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := s.Repo.WithTx(tx)
+
+	before, err := qtx.GetMachineUserByDNI(ctx, dni)
+	if err != nil {
+		return before, err
+	}
+	after, err := qtx.SetMachineUserActive(ctx, repository.SetMachineUserActiveParams{Dni: dni, Active: active})
+	if err != nil {
+		return after, err
+	}
+	if before.Active != after.Active {
+		if err := qtx.CreateMachineUserHistory(ctx, repository.CreateMachineUserHistoryParams{
+			MachineUserDni: dni,
+			Field:          "active",
+			OldValue:       strconv.FormatBool(before.Active),
+			NewValue:       strconv.FormatBool(after.Active),
+			Source:         repository.MachineUserChangeSourceDIRECTORY,
+			ChangedBy:      pgtype.UUID{Bytes: changedBy, Valid: true},
+		}); err != nil {
+			return after, fmt.Errorf("failed to record activation of machine user %s: %w", dni, err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return after, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return after, nil
+}
+
+// Merge moves the certificates, devices, loans, visits, reservation, wave membership and
+// history of a duplicate machine user to the one kept, then deletes the duplicate. The
+// kept record wins where both have a reservation or wave membership. It returns
+// pgx.ErrNoRows when either machine user does not exist.
+func (s *MachineUserService) Merge(ctx context.Context, fromDNI, intoDNI string, changedBy uuid.UUID) error {
+	if fromDNI == intoDNI {
+		return ErrMergeSameMachineUser
+	}
+
+	tx, err := s.DBPool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := s.Repo.WithTx(tx)
+
+	from, err := qtx.GetMachineUserByDNI(ctx, fromDNI)
+	if err != nil {
+		return err
+	}
+	if _, err := qtx.GetMachineUserByDNI(ctx, intoDNI); err != nil {
+		return err
+	}
+
+	if err := qtx.DropMergedMachineUserWaveMembership(ctx, repository.DropMergedMachineUserWaveMembershipParams{FromDni: fromDNI, IntoDni: intoDNI}); err != nil {
+		return fmt.Errorf("failed to drop wave membership of %s: %w", fromDNI, err)
+	}
+	if err := qtx.DropMergedMachineUserReservation(ctx, repository.DropMergedMachineUserReservationParams{FromDni: fromDNI, IntoDni: intoDNI}); err != nil {
+		return fmt.Errorf("failed to drop reservation of %s: %w", fromDNI, err)
+	}
+	if err := qtx.MergeMachineUserCertificates(ctx, repository.MergeMachineUserCertificatesParams{FromDni: fromDNI, IntoDni: intoDNI}); err != nil {
+		return fmt.Errorf("failed to move certificates of %s: %w", fromDNI, err)
+	}
+	if err := qtx.MergeMachineUserWaveMembership(ctx, repository.MergeMachineUserWaveMembershipParams{FromDni: fromDNI, IntoDni: intoDNI}); err != nil {
+		return fmt.Errorf("failed to move wave membership of %s: %w", fromDNI, err)
+	}
+	if err := qtx.MergeMachineUserReservation(ctx, repository.MergeMachineUserReservationParams{FromDni: fromDNI, IntoDni: intoDNI}); err != nil {
+		return fmt.Errorf("failed to move reservation of %s: %w", fromDNI, err)
+	}
+	if err := qtx.MergeMachineUserAppointments(ctx, repository.MergeMachineUserAppointmentsParams{FromDni: fromDNI, IntoDni: intoDNI}); err != nil {
+		return fmt.Errorf("failed to move appointments of %s: %w", fromDNI, err)
+	}
+	if err := qtx.MergeMachineUserCustody(ctx, repository.MergeMachineUserCustodyParams{FromDni: fromDNI, IntoDni: intoDNI}); err != nil {
+		return fmt.Errorf("failed to move custody of %s: %w", fromDNI, err)
+	}
+	if err := qtx.MergeMachineUserLoans(ctx, repository.MergeMachineUserLoansParams{FromDni: fromDNI, IntoDni: intoDNI}); err != nil {
+		return fmt.Errorf("failed to move loans of %s: %w", fromDNI, err)
+	}
+	if err := qtx.MergeMachineUserRecoveries(ctx, repository.MergeMachineUserRecoveriesParams{FromDni: fromDNI, IntoDni: intoDNI}); err != nil {
+		return fmt.Errorf("failed to move recoveries of %s: %w", fromDNI, err)
+	}
+	if err := qtx.MergeMachineUserHistory(ctx, repository.MergeMachineUserHistoryParams{FromDni: fromDNI, IntoDni: intoDNI}); err != nil {
+		return fmt.Errorf("failed to move history of %s: %w", fromDNI, err)
+	}
+
+	if err := qtx.DiscardMachineUserPendingChanges(ctx, fromDNI); err != nil {
+		return fmt.Errorf("failed to discard pending changes of %s: %w", fromDNI, err)
+	}
+	if err := qtx.CreateMachineUserHistory(ctx, repository.CreateMachineUserHistoryParams{
+		MachineUserDni: intoDNI,
+		Field:          "merged",
+		OldValue:       fmt.Sprintf("%s (%s, %s)", from.Dni, from.Name, from.Email),
+		NewValue:       intoDNI,
+		Source:         repository.MachineUserChangeSourceMERGE,
+		ChangedBy:      pgtype.UUID{Bytes: changedBy, Valid: true},
+	}); err != nil {
+		return fmt.Errorf("failed to record merge of %s: %w", fromDNI, err)
+	}
+	if err := qtx.DeleteMachineUser(ctx, fromDNI); err != nil {
+		return fmt.Errorf("failed to delete merged machine user %s: %w", fromDNI, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
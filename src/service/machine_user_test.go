@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"alc/config"
+	"alc/db/dbtest"
+	"alc/repository"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestMachineUserMerge(t *testing.T) {
+	pool, repo := dbtest.New(t)
+	ctx := context.Background()
+	user := newTestTecnico(t, repo)
+
+	emailSvc, err := NewEmailService(&config.Config{SmtpHost: "localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	certSvc := NewCertificateService(pool, repo, emailSvc, NewWebhookService(repo), nil)
+	// The duplicate 70000009 has two certificates and the kept 70000001 one
+	for i, dni := range []string{"70000009", "70000009", "70000001"} {
+		if _, err := certSvc.CreateCertificateFromForm(ctx, user, testCertificateForm(i+1, dni, "Ana Torres")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.ProposeMasterDataChange(ctx, repository.ProposeMasterDataChangeParams{
+		Entity:        repository.MasterDataEntityMACHINEUSER,
+		EntityKey:     "70000009",
+		Field:         "site",
+		ProposedValue: "Lima",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	svc := NewMachineUserService(pool, repo)
+	if err := svc.Merge(ctx, "70000001", "70000001", user.ID); !errors.Is(err, ErrMergeSameMachineUser) {
+		t.Errorf("Merge() into itself error = %v, want ErrMergeSameMachineUser", err)
+	}
+	if err := svc.Merge(ctx, "70000008", "70000001", user.ID); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("Merge() of an unknown machine user error = %v, want pgx.ErrNoRows", err)
+	}
+
+	if err := svc.Merge(ctx, "70000009", "70000001", user.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.GetMachineUserByDNI(ctx, "70000009"); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("merged machine user still exists: %v", err)
+	}
+	certs, err := repo.ListMachineUserCertificates(ctx, "70000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 3 {
+		t.Errorf("kept machine user has %d certificates, want 3", len(certs))
+	}
+	devices, err := repo.ListMachineUserDevices(ctx, "70000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 3 {
+		t.Errorf("kept machine user has held %d devices, want 3", len(devices))
+	}
+	changes, err := repo.ListPendingMasterDataChanges(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("pending changes of the merged machine user were kept: %+v", changes)
+	}
+	history, err := repo.ListMachineUserHistory(ctx, "70000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) == 0 || history[0].Field != "merged" || history[0].Source != repository.MachineUserChangeSourceMERGE {
+		t.Errorf("history = %+v, want the merge first", history)
+	}
+}
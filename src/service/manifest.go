@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"alc/repository"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// manifestColumns lists the header aliases of each column of the vendor's shipment
+// manifests, already normalized with headerKey.
+var manifestColumns = map[string][]string{
+	"serial": {"serial", "serialnumber", "serialno", "sn", "sno"},
+	"mtm":    {"mtm", "machinetypemodel", "typemodel", "productid", "productnumber", "partnumber"},
+	"model":  {"model", "modelname", "description", "productdescription"},
+	"pallet": {"pallet", "palletid", "palletnumber", "palletno"},
+	"po":     {"po", "ponumber", "pono", "purchaseorder", "customerpo"},
+	"site":   {"site", "sede", "shipto", "shiptosite", "deliverysite", "destination"},
+}
+
+// manifestHeaderSearchRows is how many rows may precede the header, since the vendor
+// puts the shipment details above the table.
+const manifestHeaderSearchRows = 20
+
+// ManifestRowError explains why a row of a manifest was not imported. Line is 1-based.
+type ManifestRowError struct {
+	Line    int
+	Serial  string
+	Message string
+}
+
+type ManifestResult struct {
+	// Received counts new machines, Updated machines that were already registered
+	Received int
+	Updated  int
+	Errors   []ManifestRowError
+	// UnknownMTMs are the MTMs missing from the catalog, to be added before importing again
+	UnknownMTMs []string
+}
+
+type ManifestService struct {
+	DBPool *pgxpool.Pool
+	Repo   *repository.Queries
+}
+
+func NewManifestService(db *pgxpool.Pool, r *repository.Queries) *ManifestService {
+	return &ManifestService{DBPool: db, Repo: r}
+}
+
+// findManifestHeader locates the header row and returns its index and the position of
+// each known column, -1 for the missing ones. Serial and MTM are required.
+func findManifestHeader(rows [][]string) (int, map[string]int, error) {
+	for i := 0; i < len(rows) && i < manifestHeaderSearchRows; i++ {
+		columns := map[string]int{}
+		for name := range manifestColumns {
+			columns[name] = -1
+		}
+		for j, header := range rows[i] {
+			key := headerKey(header)
+			for name, aliases := range manifestColumns {
+				if columns[name] == -1 && slices.Contains(aliases, key) {
+					columns[name] = j
+				}
+			}
+		}
+		if columns["serial"] >= 0 && columns["mtm"] >= 0 {
+			return i, columns, nil
+		}
+	}
+	return 0, nil, errors.New("no header row with serial number and MTM columns was found")
+}
+
+// lookupMTM finds the catalog entry of a full MTM such as "21JK0032LM", falling back to
+// its four-character machine type ("21JK") so one entry can cover every configuration.
+func lookupMTM(catalog map[string]repository.MtmCatalog, mtm string) (repository.MtmCatalog, bool) {
+	if entry, ok := catalog[mtm]; ok {
+		return entry, true
+	}
+	if len(mtm) > 4 {
+		entry, ok := catalog[mtm[:4]]
+		return entry, ok
+	}
+	return repository.MtmCatalog{}, false
+}
+
+// Import registers the machines of a shipment manifest as received and not assigned.
+// Invalid rows are skipped and reported; the valid ones are saved in a single transaction.
+func (s *ManifestService) Import(ctx context.Context, rows [][]string) (ManifestResult, error) {
+	var result ManifestResult
+
+	headerRow, columns, err := findManifestHeader(rows)
+	if err != nil {
+		return result, err
+	}
+
+	entries, err := s.Repo.ListMTMCatalog(ctx)
+	if err != nil {
+		return result, fmt.Errorf("failed to load the MTM catalog: %w", err)
+	}
+	catalog := make(map[string]repository.MtmCatalog, len(entries))
+	for _, entry := range entries {
+		catalog[entry.Mtm] = entry
+	}
+
+	tx, err := s.DBPool.Begin(ctx)
+	if err != nil {
+		return result, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := s.Repo.WithTx(tx)
+	seen := map[string]int{}
+
+	for i, row := range rows[headerRow+1:] {
+		line := headerRow + i + 2
+		serial := strings.ToUpper(strings.ReplaceAll(cell(row, columns["serial"]), " ", ""))
+		mtm := strings.ToUpper(strings.ReplaceAll(cell(row, columns["mtm"]), " ", ""))
+		if serial == "" && mtm == "" {
+			continue // Blank or totals row
+		}
+		if serial == "" {
+			result.Errors = append(result.Errors, ManifestRowError{Line: line, Message: "missing serial number"})
+			continue
+		}
+		if first, ok := seen[serial]; ok {
+			result.Errors = append(result.Errors, ManifestRowError{Line: line, Serial: serial, Message: fmt.Sprintf("duplicate of line %d", first)})
+			continue
+		}
+		seen[serial] = line
+
+		entry, ok := lookupMTM(catalog, mtm)
+		if !ok {
+			result.Errors = append(result.Errors, ManifestRowError{Line: line, Serial: serial, Message: fmt.Sprintf("MTM %q is not in the catalog", mtm)})
+			if mtm != "" && !slices.Contains(result.UnknownMTMs, mtm) {
+				result.UnknownMTMs = append(result.UnknownMTMs, mtm)
+			}
+			continue
+		}
+
+		model := entry.Model
+		if model == "" {
+			model = cell(row, columns["model"])
+		}
+
+		inserted, err := qtx.ReceiveMachine(ctx, repository.ReceiveMachineParams{
+			SerialNum:     serial,
+			Type:          entry.Type,
+			Mtm:           mtm,
+			Model:         model,
+			DiskSize:      entry.DiskSize,
+			MemorySize:    entry.MemorySize,
+			Processor:     entry.Processor,
+			Profile:       entry.Profile,
+			Pallet:        cell(row, columns["pallet"]),
+			PurchaseOrder: cell(row, columns["po"]),
+			Site:          normalize(cell(row, columns["site"]), true),
+		})
+		if err != nil {
+			return result, fmt.Errorf("failed to register machine %s (line %d): %w", serial, line, err)
+		}
+		if inserted {
+			result.Received++
+		} else {
+			result.Updated++
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return result, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return result, nil
+}
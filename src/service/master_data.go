@@ -0,0 +1,297 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"alc/repository"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	// ErrChangeNotPending is returned when reviewing a change already approved or rejected.
+	ErrChangeNotPending = errors.New("the change is not pending")
+	// ErrMasterRecordGone is returned when approving a change of a machine user or machine
+	// that no longer exists, e.g. after a rename.
+	ErrMasterRecordGone = errors.New("the record of the change no longer exists")
+	// ErrPersonalCodeInUse is returned when giving a machine user the personal code of
+	// another one.
+	ErrPersonalCodeInUse = errors.New("the personal code is already used by another machine user")
+	// ErrEmailInUse is returned when giving a machine user the email of another one.
+	ErrEmailInUse = errors.New("the email is already used by another machine user")
+)
+
+// masterDataChange is a field a certificate form typed for a record on file. It proposes a
+// change when it differs from the record, and settles any pending change of the field when
+// it agrees.
+type masterDataChange struct {
+	Entity   repository.MasterDataEntity
+	Key      string
+	Field    string
+	Current  string
+	Proposed string
+}
+
+// agrees reports whether the typed value matches the record on file beyond letter case.
+func (ch masterDataChange) agrees() bool {
+	return strings.EqualFold(ch.Current, ch.Proposed)
+}
+
+// proposeField adds the typed value of a field when it is not empty.
+func proposeField(changes []masterDataChange, entity repository.MasterDataEntity, key, field, current, proposed string) []masterDataChange {
+	if proposed == "" {
+		return changes
+	}
+	return append(changes, masterDataChange{Entity: entity, Key: key, Field: field, Current: current, Proposed: proposed})
+}
+
+// syncMachineUser returns the machine user of a certificate, creating it when it is new.
+// HR owns an existing one: only its empty fields are filled in, and the other differences
+// are returned as changes to review.
+func syncMachineUser(ctx context.Context, q *repository.Queries, p repository.UpsertMachineUserParams) (repository.MachineUser, []masterDataChange, error) {
+	machineUser, err := q.GetMachineUserByDNI(ctx, p.Dni)
+	if errors.Is(err, pgx.ErrNoRows) {
+		machineUser, err = q.UpsertMachineUser(ctx, p)
+		if err != nil {
+			return machineUser, nil, fmt.Errorf("failed to create machine user: %w", err)
+		}
+		return machineUser, nil, nil
+	} else if err != nil {
+		return machineUser, nil, fmt.Errorf("failed to get machine user: %w", err)
+	}
+	if !machineUser.Active {
+		return machineUser, nil, fmt.Errorf("el usuario con DNI %s está dado de baja", machineUser.Dni)
+	}
+
+	machineUser, err = q.FillMachineUserBlanks(ctx, repository.FillMachineUserBlanksParams{
+		Dni:       p.Dni,
+		Name:      p.Name,
+		Society:   p.Society,
+		Site:      p.Site,
+		Area:      p.Area,
+		FloorName: p.FloorName,
+	})
+	if err != nil {
+		return machineUser, nil, fmt.Errorf("failed to fill in machine user: %w", err)
+	}
+
+	entity, key := repository.MasterDataEntityMACHINEUSER, machineUser.Dni
+	var changes []masterDataChange
+	changes = proposeField(changes, entity, key, "personal_code", machineUser.PersonalCode, p.PersonalCode)
+	changes = proposeField(changes, entity, key, "name", machineUser.Name, p.Name)
+	changes = proposeField(changes, entity, key, "email", machineUser.Email, p.Email)
+	changes = proposeField(changes, entity, key, "society", machineUser.Society, p.Society)
+	changes = proposeField(changes, entity, key, "site", machineUser.Site, p.Site)
+	changes = proposeField(changes, entity, key, "area", machineUser.Area, p.Area)
+	changes = proposeField(changes, entity, key, "floor_name", machineUser.FloorName, p.FloorName)
+	return machineUser, changes, nil
+}
+
+// syncMachine returns a machine of a certificate, creating it when it is new. The
+// manifest and admins own an existing one: only its empty fields are filled in, and the
+// other differences are returned as changes to review. An empty profile, as for the
+// replaced machine whose profile the form does not ask, is REGULAR for a new machine.
+func syncMachine(ctx context.Context, q *repository.Queries, p repository.UpsertMachineParams) (repository.Machine, []masterDataChange, error) {
+	machine, err := q.GetMachineBySerial(ctx, p.SerialNum)
+	if errors.Is(err, pgx.ErrNoRows) {
+		if p.Profile == "" {
+			p.Profile = repository.MachineProfileREGULAR
+		}
+		machine, err = q.UpsertMachine(ctx, p)
+		if err != nil {
+			return machine, nil, fmt.Errorf("failed to create machine %s: %w", p.SerialNum, err)
+		}
+		return machine, nil, nil
+	} else if err != nil {
+		return machine, nil, fmt.Errorf("failed to get machine %s: %w", p.SerialNum, err)
+	}
+
+	machine, err = q.FillMachineBlanks(ctx, repository.FillMachineBlanksParams{
+		SerialNum:  p.SerialNum,
+		Model:      p.Model,
+		PlateNum:   p.PlateNum,
+		DiskSize:   p.DiskSize,
+		MemorySize: p.MemorySize,
+	})
+	if err != nil {
+		return machine, nil, fmt.Errorf("failed to fill in machine %s: %w", p.SerialNum, err)
+	}
+
+	entity, key := repository.MasterDataEntityMACHINE, machine.SerialNum
+	var changes []masterDataChange
+	if slices.Contains(repository.AllMachineTypeValues(), p.Type) {
+		changes = proposeField(changes, entity, key, "type", string(machine.Type), string(p.Type))
+	}
+	changes = proposeField(changes, entity, key, "model", machine.Model, p.Model)
+	changes = proposeField(changes, entity, key, "plate_num", machine.PlateNum, p.PlateNum)
+	changes = proposeField(changes, entity, key, "disk_size", machine.DiskSize, p.DiskSize)
+	changes = proposeField(changes, entity, key, "memory_size", machine.MemorySize, p.MemorySize)
+	if slices.Contains(repository.AllMachineProfileValues(), p.Profile) {
+		changes = proposeField(changes, entity, key, "profile", string(machine.Profile), string(p.Profile))
+	}
+	return machine, changes, nil
+}
+
+// proposeMasterDataChanges records the changes found in a certificate for an admin to
+// review, and withdraws the pending ones of fields the certificate agrees with the record.
+func proposeMasterDataChanges(ctx context.Context, q *repository.Queries, changes []masterDataChange, certID int32, proposedBy uuid.UUID) error {
+	for _, ch := range changes {
+		if ch.agrees() {
+			if err := q.WithdrawMasterDataChange(ctx, repository.WithdrawMasterDataChangeParams{
+				Entity:    ch.Entity,
+				EntityKey: ch.Key,
+				Field:     ch.Field,
+			}); err != nil {
+				return fmt.Errorf("failed to withdraw change of %s %s: %w", ch.Key, ch.Field, err)
+			}
+			continue
+		}
+		if err := q.ProposeMasterDataChange(ctx, repository.ProposeMasterDataChangeParams{
+			Entity:        ch.Entity,
+			EntityKey:     ch.Key,
+			Field:         ch.Field,
+			CurrentValue:  ch.Current,
+			ProposedValue: ch.Proposed,
+			CertificateID: pgtype.Int4{Int32: certID, Valid: true},
+			ProposedBy:    pgtype.UUID{Bytes: proposedBy, Valid: true},
+		}); err != nil {
+			return fmt.Errorf("failed to propose change of %s %s: %w", ch.Key, ch.Field, err)
+		}
+	}
+	return nil
+}
+
+// MasterDataService applies or discards the changes to machine users and machines that
+// technicians typed in certificates.
+type MasterDataService struct {
+	DBPool *pgxpool.Pool
+	Repo   *repository.Queries
+}
+
+func NewMasterDataService(db *pgxpool.Pool, r *repository.Queries) *MasterDataService {
+	return &MasterDataService{DBPool: db, Repo: r}
+}
+
+// Approve applies a pending change to its machine user or machine, recording it in the
+// history of a machine user. It returns ErrPlateInUse when another machine has the
+// proposed plate, and ErrPersonalCodeInUse or ErrEmailInUse when another machine user has
+// the proposed personal code or email.
+func (s *MasterDataService) Approve(ctx context.Context, changeID int32, reviewedBy uuid.UUID) (repository.MasterDataChange, error) {
+	tx, err := s.DBPool.Begin(ctx)
+	if err != nil {
+		return repository.MasterDataChange{}, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := s.Repo.WithTx(tx)
+
+	change, err := qtx.ReviewMasterDataChange(ctx, repository.ReviewMasterDataChangeParams{
+		ChangeID:   changeID,
+		Status:     repository.MasterDataChangeStatusAPPROVED,
+		ReviewedBy: pgtype.UUID{Bytes: reviewedBy, Valid: true},
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return change, ErrChangeNotPending
+		}
+		return change, fmt.Errorf("failed to approve change %d: %w", changeID, err)
+	}
+
+	var applied int64
+	switch change.Entity {
+	case repository.MasterDataEntityMACHINEUSER:
+		var before repository.MachineUser
+		before, err = qtx.GetMachineUserByDNI(ctx, change.EntityKey)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return change, ErrMasterRecordGone
+		} else if err != nil {
+			return change, fmt.Errorf("failed to get machine user %s: %w", change.EntityKey, err)
+		}
+		var others int64
+		switch change.Field {
+		case "personal_code":
+			others, err = qtx.CountMachineUsersWithPersonalCode(ctx, repository.CountMachineUsersWithPersonalCodeParams{
+				PersonalCode: change.ProposedValue,
+				Dni:          change.EntityKey,
+			})
+			if err == nil && others > 0 {
+				return change, ErrPersonalCodeInUse
+			}
+		case "email":
+			others, err = qtx.CountMachineUsersWithEmail(ctx, repository.CountMachineUsersWithEmailParams{
+				Email: change.ProposedValue,
+				Dni:   change.EntityKey,
+			})
+			if err == nil && others > 0 {
+				return change, ErrEmailInUse
+			}
+		}
+		if err != nil {
+			return change, fmt.Errorf("failed to check %s %s: %w", change.Field, change.ProposedValue, err)
+		}
+		applied, err = qtx.ApplyMachineUserChange(ctx, repository.ApplyMachineUserChangeParams{
+			Field:     change.Field,
+			Value:     change.ProposedValue,
+			EntityKey: change.EntityKey,
+		})
+		if err == nil {
+			err = qtx.CreateMachineUserHistory(ctx, repository.CreateMachineUserHistoryParams{
+				MachineUserDni: change.EntityKey,
+				Field:          change.Field,
+				OldValue:       machineUserFields(before)[change.Field],
+				NewValue:       change.ProposedValue,
+				Source:         repository.MachineUserChangeSourceREVIEW,
+				ChangedBy:      pgtype.UUID{Bytes: reviewedBy, Valid: true},
+			})
+		}
+	case repository.MasterDataEntityMACHINE:
+		if change.Field == "plate_num" {
+			others, err := qtx.CountMachinesWithPlate(ctx, repository.CountMachinesWithPlateParams{
+				PlateNum:  change.ProposedValue,
+				SerialNum: change.EntityKey,
+			})
+			if err != nil {
+				return change, fmt.Errorf("failed to check plate %s: %w", change.ProposedValue, err)
+			}
+			if others > 0 {
+				return change, ErrPlateInUse
+			}
+		}
+		applied, err = qtx.ApplyMachineChange(ctx, repository.ApplyMachineChangeParams{
+			Field:     change.Field,
+			Value:     change.ProposedValue,
+			EntityKey: change.EntityKey,
+		})
+	}
+	if err != nil {
+		return change, fmt.Errorf("failed to apply change %d: %w", changeID, err)
+	}
+	if applied == 0 {
+		return change, ErrMasterRecordGone
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return change, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return change, nil
+}
+
+// Reject discards a pending change.
+func (s *MasterDataService) Reject(ctx context.Context, changeID int32, reviewedBy uuid.UUID) (repository.MasterDataChange, error) {
+	change, err := s.Repo.ReviewMasterDataChange(ctx, repository.ReviewMasterDataChangeParams{
+		ChangeID:   changeID,
+		Status:     repository.MasterDataChangeStatusREJECTED,
+		ReviewedBy: pgtype.UUID{Bytes: reviewedBy, Valid: true},
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return change, ErrChangeNotPending
+	}
+	return change, err
+}
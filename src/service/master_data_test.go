@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"alc/config"
+	"alc/db/dbtest"
+	"alc/repository"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestMasterDataChangeAgrees(t *testing.T) {
+	tests := []struct {
+		current, proposed string
+		want              bool
+	}{
+		{current: "Ana Torres", proposed: "Ana Torres", want: true},
+		{current: "Ana Torres", proposed: "ANA TORRES", want: true},
+		{current: "Ana Torres", proposed: "Ana Torres Paz", want: false},
+		{current: "", proposed: "Ana", want: false},
+	}
+	for _, tt := range tests {
+		ch := masterDataChange{Current: tt.current, Proposed: tt.proposed}
+		if got := ch.agrees(); got != tt.want {
+			t.Errorf("agrees() of %q and %q = %v, want %v", tt.current, tt.proposed, got, tt.want)
+		}
+	}
+
+	changes := proposeField(nil, repository.MasterDataEntityMACHINEUSER, "70000001", "name", "Ana", "")
+	changes = proposeField(changes, repository.MasterDataEntityMACHINEUSER, "70000001", "site", "Lima", "LIMA")
+	if len(changes) != 1 || changes[0].Field != "site" {
+		t.Errorf("proposeField() = %+v, want only the typed site", changes)
+	}
+}
+
+func TestProposeMasterDataChanges(t *testing.T) {
+	pool, repo := dbtest.New(t)
+	ctx := context.Background()
+	user := newTestTecnico(t, repo)
+
+	emailSvc, err := NewEmailService(&config.Config{SmtpHost: "localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := NewCertificateService(pool, repo, emailSvc, NewWebhookService(repo), nil)
+
+	pending := func() []repository.ListPendingMasterDataChangesRow {
+		t.Helper()
+		changes, err := repo.ListPendingMasterDataChanges(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return changes
+	}
+
+	if _, err := svc.CreateCertificateFromForm(ctx, user, testCertificateForm(1, "70000001", "Ana Torres")); err != nil {
+		t.Fatal(err)
+	}
+	if changes := pending(); len(changes) != 0 {
+		t.Fatalf("a new machine user proposed %+v", changes)
+	}
+
+	if _, err := svc.CreateCertificateFromForm(ctx, user, testCertificateForm(2, "70000001", "Ana Torres Paz")); err != nil {
+		t.Fatal(err)
+	}
+	// Certificates store names upper-cased
+	changes := pending()
+	if len(changes) != 1 || changes[0].Field != "name" || changes[0].CurrentValue != "ANA TORRES" || changes[0].ProposedValue != "ANA TORRES PAZ" {
+		t.Fatalf("pending changes = %+v, want the name", changes)
+	}
+	if mu, err := repo.GetMachineUserByDNI(ctx, "70000001"); err != nil || mu.Name != "ANA TORRES" {
+		t.Fatalf("machine user name = %q, %v, want it unchanged until approved", mu.Name, err)
+	}
+
+	if _, err := svc.CreateCertificateFromForm(ctx, user, testCertificateForm(3, "70000001", "ana torres")); err != nil {
+		t.Fatal(err)
+	}
+	if changes := pending(); len(changes) != 0 {
+		t.Errorf("pending changes = %+v, want the name withdrawn by a certificate agreeing with the record", changes)
+	}
+}
+
+func TestMasterDataReview(t *testing.T) {
+	pool, repo := dbtest.New(t)
+	ctx := context.Background()
+	svc := NewMasterDataService(pool, repo)
+	reviewer := newTestTecnico(t, repo).ID
+
+	for _, p := range []repository.UpsertMachineUserParams{
+		{Dni: "70000001", PersonalCode: "P1", Name: "Ana Torres", Email: "ana@example.com"},
+		{Dni: "70000002", PersonalCode: "P2", Name: "Luis Rojas", Email: "luis@example.com"},
+	} {
+		if _, err := repo.UpsertMachineUser(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// propose records a pending change of the machine user dni and returns its id.
+	propose := func(dni, field, current, proposed string) int32 {
+		t.Helper()
+		if err := repo.ProposeMasterDataChange(ctx, repository.ProposeMasterDataChangeParams{
+			Entity:        repository.MasterDataEntityMACHINEUSER,
+			EntityKey:     dni,
+			Field:         field,
+			CurrentValue:  current,
+			ProposedValue: proposed,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		changes, err := repo.ListPendingMasterDataChanges(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, ch := range changes {
+			if ch.EntityKey == dni && ch.Field == field {
+				return ch.ChangeID
+			}
+		}
+		t.Fatalf("no pending change of %s %s", dni, field)
+		return 0
+	}
+
+	tests := []struct {
+		name     string
+		field    string
+		proposed string
+		wantErr  error
+	}{
+		{name: "personal code in use", field: "personal_code", proposed: "P1", wantErr: ErrPersonalCodeInUse},
+		{name: "email in use", field: "email", proposed: "ana@example.com", wantErr: ErrEmailInUse},
+		{name: "free personal code", field: "personal_code", proposed: "P3"},
+		{name: "name", field: "name", proposed: "Luis Rojas Paz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := propose("70000002", tt.field, "", tt.proposed)
+			_, err := svc.Approve(ctx, id, reviewer)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Approve() error = %v, want %v", err, tt.wantErr)
+			}
+
+			mu, err := repo.GetMachineUserByDNI(ctx, "70000002")
+			if err != nil {
+				t.Fatal(err)
+			}
+			applied := machineUserFields(mu)[tt.field] == tt.proposed
+			if applied != (tt.wantErr == nil) {
+				t.Errorf("%s = %q after Approve()", tt.field, machineUserFields(mu)[tt.field])
+			}
+			if tt.wantErr != nil {
+				// The refused change stays pending for the admin to reject
+				if _, err := svc.Reject(ctx, id, reviewer); err != nil {
+					t.Errorf("Reject() error = %v", err)
+				}
+				return
+			}
+
+			history, err := repo.ListMachineUserHistory(ctx, "70000002")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(history) == 0 || history[0].Field != tt.field || history[0].NewValue != tt.proposed || history[0].Source != repository.MachineUserChangeSourceREVIEW {
+				t.Errorf("history = %+v, want the approved %s first", history, tt.field)
+			}
+			if _, err := svc.Approve(ctx, id, reviewer); !errors.Is(err, ErrChangeNotPending) {
+				t.Errorf("second Approve() error = %v, want ErrChangeNotPending", err)
+			}
+		})
+	}
+
+	t.Run("record gone", func(t *testing.T) {
+		id := propose("70000009", "name", "", "Nadie")
+		if _, err := svc.Approve(ctx, id, reviewer); !errors.Is(err, ErrMasterRecordGone) {
+			t.Errorf("Approve() error = %v, want ErrMasterRecordGone", err)
+		}
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		id := propose("70000001", "site", "", "Arequipa")
+		change, err := svc.Reject(ctx, id, reviewer)
+		if err != nil || change.Status != repository.MasterDataChangeStatusREJECTED || change.ReviewedBy != (pgtype.UUID{Bytes: reviewer, Valid: true}) {
+			t.Fatalf("Reject() = %+v, %v", change, err)
+		}
+		if _, err := svc.Reject(ctx, id, reviewer); !errors.Is(err, ErrChangeNotPending) {
+			t.Errorf("second Reject() error = %v, want ErrChangeNotPending", err)
+		}
+	})
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"alc/config"
+	"alc/repository"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var ErrOIDCUserNotAllowed = errors.New("your account is not allowed to access this application")
+
+// OIDCClaims holds the ID token claims used to map an identity to an app user.
+type OIDCClaims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Dni           string
+	Groups        []string
+}
+
+// OIDCService implements the authorization code flow with PKCE against an
+// OpenID Connect provider and maps the resulting identity to app_users.
+type OIDCService struct {
+	config   *config.Config
+	repo     *repository.Queries
+	oauth2   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// NewOIDCService discovers the provider configuration from the issuer URL.
+// The HTTP client used to reach the provider can be set in ctx with oidc.ClientContext,
+// which allows pointing the service to a local mock provider.
+func NewOIDCService(ctx context.Context, cfg *config.Config, r *repository.Queries) (*OIDCService, error) {
+	// Without a group mapping every identity of the provider would get a login
+	if cfg.OIDCAllowJIT && len(cfg.OIDCAdminGroups) == 0 && len(cfg.OIDCSupervisorGroups) == 0 && len(cfg.OIDCTecnicoGroups) == 0 {
+		return nil, errors.New("OIDC_ALLOW_JIT requires OIDC_ADMIN_GROUPS, OIDC_SUPERVISOR_GROUPS or OIDC_TECNICO_GROUPS")
+	}
+
+	provider, err := oidc.NewProvider(ctx, cfg.OIDCIssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover OIDC provider: %w", err)
+	}
+
+	return &OIDCService{
+		config: cfg,
+		repo:   r,
+		oauth2: oauth2.Config{
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			RedirectURL:  cfg.OIDCRedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: cfg.OIDCClientID}),
+	}, nil
+}
+
+// ProviderName is the label shown on the login button.
+func (s *OIDCService) ProviderName() string {
+	return s.config.OIDCProviderName
+}
+
+// AuthCodeURL returns the provider URL the user is redirected to.
+// The PKCE verifier and the nonce must be kept until the callback.
+func (s *OIDCService) AuthCodeURL(state, nonce, pkceVerifier string) string {
+	return s.oauth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(pkceVerifier))
+}
+
+// Exchange trades the authorization code for tokens and verifies the ID token.
+func (s *OIDCService) Exchange(ctx context.Context, code, nonce, pkceVerifier string) (OIDCClaims, error) {
+	token, err := s.oauth2.Exchange(ctx, code, oauth2.VerifierOption(pkceVerifier))
+	if err != nil {
+		return OIDCClaims{}, fmt.Errorf("failed to exchange code: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return OIDCClaims{}, errors.New("no id_token in token response")
+	}
+
+	idToken, err := s.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return OIDCClaims{}, fmt.Errorf("failed to verify id_token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return OIDCClaims{}, errors.New("id_token nonce does not match")
+	}
+
+	var raw map[string]any
+	if err := idToken.Claims(&raw); err != nil {
+		return OIDCClaims{}, fmt.Errorf("failed to parse claims: %w", err)
+	}
+
+	claims := OIDCClaims{
+		Subject: idToken.Subject,
+		Email:   strings.ToLower(strings.TrimSpace(stringClaim(raw, "email"))),
+		Name:    strings.TrimSpace(stringClaim(raw, "name")),
+		Groups:  listClaim(raw, s.config.OIDCGroupsClaim),
+	}
+	if v, ok := raw["email_verified"].(bool); ok {
+		claims.EmailVerified = v
+	} else {
+		claims.EmailVerified = s.config.OIDCAssumeEmailVerified
+	}
+	if s.config.OIDCDniClaim != "" {
+		claims.Dni = strings.TrimSpace(stringClaim(raw, s.config.OIDCDniClaim))
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return OIDCClaims{}, errors.New("the identity has no verified email")
+	}
+
+	return claims, nil
+}
+
+// ResolveUser finds the app user for the claims by email, creating it just-in-time
+// when allowed and the groups map to a role. Name, DNI and role are kept in sync with the claims.
+func (s *OIDCService) ResolveUser(ctx context.Context, claims OIDCClaims) (repository.AppUser, error) {
+	role, ok := roleFromGroups(claims.Groups, s.config.OIDCAdminGroups, s.config.OIDCSupervisorGroups, s.config.OIDCTecnicoGroups)
+	if !ok {
+		return repository.AppUser{}, ErrOIDCUserNotAllowed
+	}
+
+	user, err := syncExternalUser(ctx, s.repo, externalIdentity{
+		Email: claims.Email,
+		Name:  claims.Name,
+		Dni:   claims.Dni,
+		Role:  role,
+	}, s.config.OIDCAllowJIT && role != "")
+	if errors.Is(err, ErrInvalidCredentials) {
+		return repository.AppUser{}, ErrOIDCUserNotAllowed
+	}
+	return user, err
+}
+
+func stringClaim(claims map[string]any, key string) string {
+	v, _ := claims[key].(string)
+	return v
+}
+
+// listClaim accepts both a JSON array and a single string, as providers differ.
+func listClaim(claims map[string]any, key string) []string {
+	switch v := claims[key].(type) {
+	case []any:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	case string:
+		return []string{v}
+	}
+	return nil
+}
@@ -0,0 +1,315 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"alc/config"
+	"alc/db/dbtest"
+	"alc/repository"
+
+	"github.com/go-jose/go-jose/v4"
+	"golang.org/x/oauth2"
+)
+
+// mockIssuer is an OpenID Connect provider that hands out an ID token with the claims of
+// the next authorization, after checking the PKCE verifier against its challenge.
+type mockIssuer struct {
+	*httptest.Server
+	signer jose.Signer
+
+	mu     sync.Mutex
+	grants map[string]mockGrant
+}
+
+type mockGrant struct {
+	challenge string
+	claims    map[string]any
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key}, (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &mockIssuer{signer: signer, grants: map[string]mockGrant{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                m.URL,
+			"authorization_endpoint":                m.URL + "/authorize",
+			"token_endpoint":                        m.URL + "/token",
+			"jwks_uri":                              m.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("GET /keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("POST /token", m.handleToken)
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// authorize plays the user signing in at authURL: it returns the code the provider would
+// redirect back with, granting the claims. The nonce of authURL is added unless the claims
+// carry one.
+func (m *mockIssuer) authorize(t *testing.T, authURL string, claims map[string]any) string {
+	t.Helper()
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("code_challenge_method = %q, want S256", q.Get("code_challenge_method"))
+	}
+	if _, ok := claims["nonce"]; !ok {
+		claims["nonce"] = q.Get("nonce")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code := oauth2.GenerateVerifier()
+	m.grants[code] = mockGrant{challenge: q.Get("code_challenge"), claims: claims}
+	return code
+}
+
+func (m *mockIssuer) handleToken(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	grant, ok := m.grants[r.FormValue("code")]
+	delete(m.grants, r.FormValue("code"))
+	m.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := map[string]any{
+		"iss": m.URL,
+		"aud": "alc-test",
+		"sub": "user-1",
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range grant.claims {
+		claims[k] = v
+	}
+	payload, _ := json.Marshal(claims)
+	jws, err := m.signer.Sign(payload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	idToken, _ := jws.CompactSerialize()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func newTestOIDCService(t *testing.T, issuer *mockIssuer, cfg config.Config, repo *repository.Queries) *OIDCService {
+	t.Helper()
+
+	cfg.OIDCIssuerURL = issuer.URL
+	cfg.OIDCClientID = "alc-test"
+	cfg.OIDCRedirectURL = "https://alc.example.com/login/oidc/callback"
+	cfg.OIDCGroupsClaim = "groups"
+	svc, err := NewOIDCService(context.Background(), &cfg, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return svc
+}
+
+func TestOIDCExchange(t *testing.T) {
+	issuer := newMockIssuer(t)
+	verified := map[string]any{"email": "Ana@Example.com", "email_verified": true, "name": "Ana", "groups": []string{"alc-tecnicos"}}
+
+	tests := []struct {
+		name           string
+		assumeVerified bool
+		claims         map[string]any
+		// badVerifier sends another PKCE verifier than the one of the challenge
+		badVerifier bool
+		wantErr     bool
+	}{
+		{name: "verified email", claims: verified},
+		{name: "wrong PKCE verifier", claims: verified, badVerifier: true, wantErr: true},
+		{name: "nonce mismatch", claims: map[string]any{"email": "ana@example.com", "email_verified": true, "nonce": "other"}, wantErr: true},
+		{name: "unverified email", claims: map[string]any{"email": "ana@example.com", "email_verified": false}, wantErr: true},
+		{name: "email_verified missing", claims: map[string]any{"email": "ana@example.com"}, wantErr: true},
+		{name: "email_verified missing and assumed", assumeVerified: true, claims: map[string]any{"email": "ana@example.com"}},
+		{name: "no email", claims: map[string]any{"email_verified": true}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := newTestOIDCService(t, issuer, config.Config{OIDCAssumeEmailVerified: tt.assumeVerified}, nil)
+
+			verifier, nonce := oauth2.GenerateVerifier(), "nonce-1"
+			code := issuer.authorize(t, svc.AuthCodeURL("state", nonce, verifier), maps.Clone(tt.claims))
+			if tt.badVerifier {
+				verifier = oauth2.GenerateVerifier()
+			}
+
+			claims, err := svc.Exchange(context.Background(), code, nonce, verifier)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Exchange() = %+v, want an error", claims)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Exchange() error = %v", err)
+			}
+			if claims.Email != "ana@example.com" || !claims.EmailVerified || claims.Subject != "user-1" {
+				t.Errorf("Exchange() = %+v", claims)
+			}
+		})
+	}
+
+	t.Run("groups", func(t *testing.T) {
+		svc := newTestOIDCService(t, issuer, config.Config{}, nil)
+		verifier := oauth2.GenerateVerifier()
+		code := issuer.authorize(t, svc.AuthCodeURL("state", "n", verifier), maps.Clone(verified))
+		claims, err := svc.Exchange(context.Background(), code, "n", verifier)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(claims.Groups, []string{"alc-tecnicos"}) || claims.Name != "Ana" {
+			t.Errorf("Exchange() = %+v", claims)
+		}
+	})
+}
+
+func TestNewOIDCServiceJITRequiresGroups(t *testing.T) {
+	issuer := newMockIssuer(t)
+	cfg := config.Config{OIDCIssuerURL: issuer.URL, OIDCClientID: "alc-test", OIDCAllowJIT: true}
+	if _, err := NewOIDCService(context.Background(), &cfg, nil); err == nil {
+		t.Fatal("NewOIDCService() with OIDC_ALLOW_JIT and no group lists succeeded")
+	}
+
+	cfg.OIDCTecnicoGroups = []string{"alc-tecnicos"}
+	if _, err := NewOIDCService(context.Background(), &cfg, nil); err != nil {
+		t.Fatalf("NewOIDCService() error = %v", err)
+	}
+}
+
+func TestRoleFromGroups(t *testing.T) {
+	admins, supervisors, tecnicos := []string{"alc-admins"}, []string{"alc-supervisores"}, []string{"alc-tecnicos"}
+
+	tests := []struct {
+		name     string
+		groups   []string
+		admins   []string
+		wantRole repository.UserRole
+		wantOK   bool
+	}{
+		{name: "admin wins", groups: []string{"alc-tecnicos", "ALC-Admins"}, admins: admins, wantRole: repository.UserRoleADMIN, wantOK: true},
+		{name: "supervisor", groups: []string{"alc-supervisores"}, admins: admins, wantRole: repository.UserRoleSUPERVISOR, wantOK: true},
+		{name: "tecnico", groups: []string{"alc-tecnicos"}, admins: admins, wantRole: repository.UserRoleTECNICO, wantOK: true},
+		{name: "outside every group", groups: []string{"ventas"}, admins: admins, wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			role, ok := roleFromGroups(tt.groups, tt.admins, supervisors, tecnicos)
+			if role != tt.wantRole || ok != tt.wantOK {
+				t.Errorf("roleFromGroups() = %q, %v, want %q, %v", role, ok, tt.wantRole, tt.wantOK)
+			}
+		})
+	}
+
+	if role, ok := roleFromGroups([]string{"ventas"}, nil, nil, nil); role != "" || !ok {
+		t.Errorf("roleFromGroups() without group lists = %q, %v, want no role and allowed", role, ok)
+	}
+}
+
+func TestOIDCResolveUser(t *testing.T) {
+	_, repo := dbtest.New(t)
+	issuer := newMockIssuer(t)
+	ctx := context.Background()
+	groups := config.Config{
+		OIDCAdminGroups:   []string{"alc-admins"},
+		OIDCTecnicoGroups: []string{"alc-tecnicos"},
+	}
+
+	t.Run("JIT without group mappings", func(t *testing.T) {
+		// NewOIDCService refuses this configuration, so the service is built by hand
+		svc := &OIDCService{config: &config.Config{OIDCAllowJIT: true}, repo: repo}
+		_, err := svc.ResolveUser(ctx, OIDCClaims{Email: "nuevo@example.com", Groups: []string{"alc-tecnicos"}})
+		if !errors.Is(err, ErrOIDCUserNotAllowed) {
+			t.Fatalf("ResolveUser() error = %v, want ErrOIDCUserNotAllowed", err)
+		}
+	})
+
+	t.Run("JIT off", func(t *testing.T) {
+		svc := newTestOIDCService(t, issuer, groups, repo)
+		_, err := svc.ResolveUser(ctx, OIDCClaims{Email: "nuevo@example.com", Groups: []string{"alc-tecnicos"}})
+		if !errors.Is(err, ErrOIDCUserNotAllowed) {
+			t.Fatalf("ResolveUser() error = %v, want ErrOIDCUserNotAllowed", err)
+		}
+	})
+
+	cfg := groups
+	cfg.OIDCAllowJIT = true
+	svc := newTestOIDCService(t, issuer, cfg, repo)
+
+	t.Run("JIT on", func(t *testing.T) {
+		user, err := svc.ResolveUser(ctx, OIDCClaims{Email: "nuevo@example.com", Name: "Nuevo", Groups: []string{"alc-tecnicos"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Role != repository.UserRoleTECNICO || !user.ExternalAuth {
+			t.Errorf("ResolveUser() = role %s, external %v", user.Role, user.ExternalAuth)
+		}
+	})
+
+	t.Run("role follows the groups", func(t *testing.T) {
+		user, err := svc.ResolveUser(ctx, OIDCClaims{Email: "nuevo@example.com", Groups: []string{"alc-admins"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Role != repository.UserRoleADMIN {
+			t.Errorf("ResolveUser() role = %s, want ADMIN", user.Role)
+		}
+	})
+
+	t.Run("outside the groups", func(t *testing.T) {
+		_, err := svc.ResolveUser(ctx, OIDCClaims{Email: "otro@example.com", Groups: []string{"ventas"}})
+		if !errors.Is(err, ErrOIDCUserNotAllowed) {
+			t.Fatalf("ResolveUser() error = %v, want ErrOIDCUserNotAllowed", err)
+		}
+	})
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"alc/repository"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// WipeMethods are the sanitization methods of NIST SP 800-88 a wipe can be recorded with.
+var WipeMethods = []string{
+	"Clear (sobrescritura)",
+	"Purge (borrado criptográfico / Secure Erase)",
+	"Destroy (destrucción física del disco)",
+}
+
+// ErrRecoveryStep is returned when a step of the recovery pipeline is recorded out of
+// order: a wipe of a device already wiped, or a disposition before the wipe.
+var ErrRecoveryStep = errors.New("the recovery is not at that step")
+
+// RecoveryService moves recovered devices through the warehouse: intake, data wipe and
+// disposition, each recorded for its certificate.
+type RecoveryService struct {
+	DBPool *pgxpool.Pool
+	Repo   *repository.Queries
+}
+
+func NewRecoveryService(db *pgxpool.Pool, r *repository.Queries) *RecoveryService {
+	return &RecoveryService{DBPool: db, Repo: r}
+}
+
+// Receive registers the intake of a device held by the warehouse. It returns
+// pgx.ErrNoRows when the warehouse does not hold the device.
+func (s *RecoveryService) Receive(ctx context.Context, deviceCode, location, notes string, receivedBy uuid.UUID) (repository.DeviceRecovery, error) {
+	return s.Repo.CreateDeviceRecovery(ctx, repository.CreateDeviceRecoveryParams{
+		DeviceCode:  strings.TrimSpace(deviceCode),
+		Location:    strings.TrimSpace(location),
+		IntakeNotes: strings.TrimSpace(notes),
+		ReceivedBy:  pgtype.UUID{Bytes: receivedBy, Valid: true},
+	})
+}
+
+// RecordWipeParams describes the data wipe of a received device. Operator is who ran the
+// wipe, which may be a vendor rather than an app user.
+type RecordWipeParams struct {
+	RecoveryID int32
+	Method     string
+	Tool       string
+	Operator   string
+	Notes      string
+	WipedBy    uuid.UUID
+}
+
+func (s *RecoveryService) RecordWipe(ctx context.Context, p RecordWipeParams) (repository.DeviceRecovery, error) {
+	if !slices.Contains(WipeMethods, p.Method) {
+		return repository.DeviceRecovery{}, fmt.Errorf("el método de borrado '%s' no es válido", p.Method)
+	}
+	tool := strings.TrimSpace(p.Tool)
+	operator := strings.TrimSpace(p.Operator)
+	if tool == "" || operator == "" {
+		return repository.DeviceRecovery{}, errors.New("la herramienta y el operador del borrado son obligatorios")
+	}
+
+	recovery, err := s.Repo.RecordRecoveryWipe(ctx, repository.RecordRecoveryWipeParams{
+		RecoveryID:   p.RecoveryID,
+		WipeMethod:   p.Method,
+		WipeTool:     tool,
+		WipeOperator: operator,
+		WipeNotes:    strings.TrimSpace(p.Notes),
+		WipedBy:      pgtype.UUID{Bytes: p.WipedBy, Valid: true},
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return recovery, ErrRecoveryStep
+	}
+	return recovery, err
+}
+
+// RecordDispositionParams describes where a wiped device goes. Reference is the buyer,
+// the scrap vendor or the reason of the redeployment.
+type RecordDispositionParams struct {
+	RecoveryID  int32
+	Disposition repository.RecoveryDisposition
+	Reference   string
+	Notes       string
+	DisposedBy  uuid.UUID
+}
+
+// RecordDisposition closes the recovery of a wiped device. A redeployed device stays in
+// the warehouse, ready to be assigned; a sold or scrapped one leaves it in its custody
+// history.
+func (s *RecoveryService) RecordDisposition(ctx context.Context, p RecordDispositionParams) (repository.DeviceRecovery, error) {
+	if !slices.Contains(repository.AllRecoveryDispositionValues(), p.Disposition) {
+		return repository.DeviceRecovery{}, fmt.Errorf("el destino '%s' no es válido", p.Disposition)
+	}
+	reference := strings.TrimSpace(p.Reference)
+	if reference == "" && p.Disposition != repository.RecoveryDispositionREDESPLIEGUE {
+		return repository.DeviceRecovery{}, errors.New("el comprador o proveedor de la baja es obligatorio")
+	}
+
+	tx, err := s.DBPool.Begin(ctx)
+	if err != nil {
+		return repository.DeviceRecovery{}, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := s.Repo.WithTx(tx)
+
+	disposedBy := pgtype.UUID{Bytes: p.DisposedBy, Valid: true}
+	recovery, err := qtx.RecordRecoveryDisposition(ctx, repository.RecordRecoveryDispositionParams{
+		RecoveryID:           p.RecoveryID,
+		Disposition:          repository.NullRecoveryDisposition{RecoveryDisposition: p.Disposition, Valid: true},
+		DispositionReference: reference,
+		DispositionNotes:     strings.TrimSpace(p.Notes),
+		DisposedBy:           disposedBy,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return recovery, ErrRecoveryStep
+		}
+		return recovery, err
+	}
+
+	custody := repository.CreateDeviceCustodyParams{
+		DeviceCode:     recovery.DeviceCode,
+		Event:          repository.CustodyEvent(p.Disposition),
+		Custodian:      repository.CustodianTypeEXTERNAL,
+		MachineUserDni: recovery.MachineUserDni,
+		Location:       reference,
+		RecordedBy:     disposedBy,
+		Notes:          recovery.DispositionNotes,
+	}
+	if p.Disposition == repository.RecoveryDispositionREDESPLIEGUE {
+		custody.Custodian = repository.CustodianTypeWAREHOUSE
+		custody.Location = recovery.Location
+		custody.Notes = reference
+	}
+	if _, err := RecordDeviceCustody(ctx, qtx, custody); err != nil {
+		return recovery, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return recovery, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return recovery, nil
+}
@@ -0,0 +1,340 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"alc/repository"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/xuri/excelize/v2"
+)
+
+// ReportColumn is a column of the certificate report that can be selected.
+type ReportColumn struct {
+	Key   string
+	Label string
+}
+
+// CertificateReportColumns lists every column of the report in order. The values of a
+// row are produced by certificateReportValues in the same order.
+var CertificateReportColumns = []ReportColumn{
+	{"id", "ID Certificado"}, {"ticket", "Ticket"}, {"status", "Estado"},
+	{"created_at", "Fecha Creación"}, {"confirmed_at", "Fecha Confirmación"},
+	{"technician", "Técnico"}, {"technician_email", "Email Técnico"},
+	{"user_dni", "DNI Usuario"}, {"user_code", "Cod. Personal Usuario"}, {"user_name", "Nombre Usuario"}, {"user_email", "Email Usuario"},
+	{"society", "Sociedad"}, {"site", "Sede"}, {"area", "Área"}, {"floor", "Piso"},
+	{"new_device", "Cod. Equipo Nuevo"}, {"new_hostname", "Hostname Nuevo"}, {"new_device_status", "Estado Nuevo"},
+	{"new_serial", "Serial Nuevo"}, {"new_type", "Tipo Nuevo"}, {"new_model", "Modelo Nuevo"},
+	{"new_disk", "Disco Nuevo"}, {"new_memory", "RAM Nueva"}, {"new_profile", "Perfil Nuevo"},
+	{"old_device", "Cod. Equipo Antiguo"}, {"old_hostname", "Hostname Antiguo"}, {"old_serial", "Serial Antiguo"},
+	{"old_type", "Tipo Antiguo"}, {"old_model", "Modelo Antiguo"},
+	{"software", "Software"}, {"configuration", "Configuración"}, {"peripherals", "Periféricos"},
+	{"disk_c", "Tamaño Disco C"}, {"disk_d", "Tamaño Disco D"}, {"printer", "Impresora"}, {"printer_ip", "IP Impresora"},
+	{"printer_test", "Test Impresión OK"}, {"comments", "Comentarios"},
+}
+
+// CertificateReport streams the certificates matching Params with the selected columns,
+// given as indexes into CertificateReportColumns. Every column is included when Columns
+// is empty.
+type CertificateReport struct {
+	DBPool  *pgxpool.Pool
+	Params  CertificateReportParams
+	Columns []int
+}
+
+// SelectReportColumns returns the indexes of the columns with the given keys, in report
+// order. Unknown keys are ignored.
+func SelectReportColumns(keys []string) []int {
+	var columns []int
+	for i, col := range CertificateReportColumns {
+		if slices.Contains(keys, col.Key) {
+			columns = append(columns, i)
+		}
+	}
+	return columns
+}
+
+func (r CertificateReport) columns() []int {
+	if len(r.Columns) > 0 {
+		return r.Columns
+	}
+	columns := make([]int, len(CertificateReportColumns))
+	for i := range columns {
+		columns[i] = i
+	}
+	return columns
+}
+
+func (r CertificateReport) header() []string {
+	columns := r.columns()
+	header := make([]string, len(columns))
+	for i, col := range columns {
+		header[i] = CertificateReportColumns[col].Label
+	}
+	return header
+}
+
+// values returns the selected cells of a row.
+func (r CertificateReport) values(row certificateReportRow) []any {
+	all := certificateReportValues(row)
+	columns := r.columns()
+	values := make([]any, len(columns))
+	for i, col := range columns {
+		values[i] = all[col]
+	}
+	return values
+}
+
+// reportTime returns the time in Lima, or nil for an empty cell.
+func reportTime(t pgtype.Timestamptz) any {
+	if !t.Valid {
+		return nil
+	}
+	return t.Time.In(limaLocation)
+}
+
+// certificateReportValues returns the cells of a report row, typed for the spreadsheet:
+// dates as time.Time (nil when empty), the printer test as bool and the ID as int32.
+func certificateReportValues(row certificateReportRow) []any {
+	return []any{
+		row.CertificateID,
+		row.TicketName,
+		string(row.ConfirmationStatus),
+		reportTime(row.CertificateCreatedAt),
+		reportTime(row.ConfirmedAt),
+		row.TechnicianName,
+		row.TechnicianEmail,
+		row.UserDni,
+		row.UserPersonalCode,
+		row.UserName,
+		row.UserEmail,
+		row.UserSociety,
+		row.UserSite,
+		row.UserArea,
+		row.UserFloor,
+		row.NewDeviceCode,
+		row.NewDeviceHostname,
+		string(row.NewDeviceStatus),
+		row.NewMachineSerial,
+		string(row.NewMachineType),
+		row.NewMachineModel,
+		row.NewMachineDisk,
+		row.NewMachineMemory,
+		string(row.NewMachineProfile),
+		row.OldDeviceCode.String,
+		row.OldDeviceHostname.String,
+		row.OldMachineSerial.String,
+		string(row.OldMachineType.MachineType),
+		row.OldMachineModel.String,
+		row.SoftwareList,
+		row.ConfigItemList,
+		row.PeripheralList,
+		row.DiskCSize,
+		row.DiskDSize,
+		row.PrinterName,
+		row.PrinterIp,
+		row.PrinterTest,
+		row.Comments,
+	}
+}
+
+// WriteCSV streams the report as CSV, with dates in RFC 3339.
+func (r CertificateReport) WriteCSV(ctx context.Context, w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(r.header()); err != nil {
+		return err
+	}
+
+	record := make([]string, len(r.columns()))
+	err := streamCertificateReport(ctx, r.DBPool, r.Params, func(row certificateReportRow) error {
+		for i, value := range r.values(row) {
+			switch v := value.(type) {
+			case nil:
+				record[i] = ""
+			case time.Time:
+				record[i] = v.Format(time.RFC3339)
+			default:
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		return writer.Write(record)
+	})
+	if err != nil {
+		return err
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+const (
+	reportSheet        = "Certificados"
+	reportSummarySheet = "Resumen"
+)
+
+// WriteXLSX writes the report as an Excel workbook: the certificates with typed cells, a
+// frozen header and filters, and a summary sheet with the number of certificates per site
+// and status. The rows are streamed from the database into the sheet, which excelize
+// spills to a temporary file once it grows, and the summary is counted along the way; the
+// workbook is then zipped straight into w.
+func (r CertificateReport) WriteXLSX(ctx context.Context, w io.Writer) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	if err := f.SetSheetName(f.GetSheetName(0), reportSheet); err != nil {
+		return err
+	}
+	headerStyle, err := f.NewStyle(&excelize.Style{
+		Font: &excelize.Font{Bold: true, Color: "FFFFFF"},
+		Fill: excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"1F4E78"}},
+	})
+	if err != nil {
+		return err
+	}
+	dateStyle, err := f.NewStyle(&excelize.Style{CustomNumFmt: ptr("dd/mm/yyyy hh:mm")})
+	if err != nil {
+		return err
+	}
+
+	sw, err := f.NewStreamWriter(reportSheet)
+	if err != nil {
+		return err
+	}
+	if err := sw.SetPanes(&excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"}); err != nil {
+		return err
+	}
+	columns := r.header()
+	if err := sw.SetColWidth(1, len(columns), 18); err != nil {
+		return err
+	}
+
+	header := make([]any, len(columns))
+	for i, name := range columns {
+		header[i] = excelize.Cell{StyleID: headerStyle, Value: name}
+	}
+	if err := sw.SetRow("A1", header); err != nil {
+		return err
+	}
+
+	summary := reportSummary{}
+	rowNum := 1
+	err = streamCertificateReport(ctx, r.DBPool, r.Params, func(row certificateReportRow) error {
+		summary.add(row)
+		values := r.values(row)
+		for j, value := range values {
+			if t, ok := value.(time.Time); ok {
+				values[j] = excelize.Cell{StyleID: dateStyle, Value: t}
+			}
+		}
+		rowNum++
+		cell, _ := excelize.CoordinatesToCellName(1, rowNum)
+		return sw.SetRow(cell, values)
+	})
+	if err != nil {
+		return err
+	}
+	// A table brings the filters without reading the streamed sheet back, as
+	// File.AutoFilter would after Flush.
+	lastCell, _ := excelize.CoordinatesToCellName(len(columns), rowNum)
+	if err := sw.AddTable(&excelize.Table{Range: "A1:" + lastCell, Name: "Reporte", ShowRowStripes: ptr(false)}); err != nil {
+		return err
+	}
+	if err := sw.Flush(); err != nil {
+		return err
+	}
+
+	if err := summary.write(f, headerStyle); err != nil {
+		return err
+	}
+
+	_, err = f.WriteTo(w)
+	return err
+}
+
+// reportSummary counts the certificates per site and status.
+type reportSummary map[string]map[repository.CertificateStatus]int
+
+func (counts reportSummary) add(row certificateReportRow) {
+	site := strings.TrimSpace(row.UserSite)
+	if site == "" {
+		site = "(sin sede)"
+	}
+	if counts[site] == nil {
+		counts[site] = map[repository.CertificateStatus]int{}
+	}
+	counts[site][row.ConfirmationStatus]++
+}
+
+// write adds a sheet with one row per site and one column per status.
+func (counts reportSummary) write(f *excelize.File, headerStyle int) error {
+	if _, err := f.NewSheet(reportSummarySheet); err != nil {
+		return err
+	}
+
+	statuses := repository.AllCertificateStatusValues()
+	sites := make([]string, 0, len(counts))
+	for site := range counts {
+		sites = append(sites, site)
+	}
+	slices.Sort(sites)
+
+	header := []any{"Sede"}
+	for _, status := range statuses {
+		header = append(header, string(status))
+	}
+	header = append(header, "Total")
+	if err := f.SetSheetRow(reportSummarySheet, "A1", &header); err != nil {
+		return err
+	}
+
+	totals := make([]int, len(statuses)+1)
+	for i, site := range sites {
+		values := []any{site}
+		siteTotal := 0
+		for j, status := range statuses {
+			n := counts[site][status]
+			values = append(values, n)
+			totals[j] += n
+			siteTotal += n
+		}
+		values = append(values, siteTotal)
+		totals[len(statuses)] += siteTotal
+		if err := f.SetSheetRow(reportSummarySheet, "A"+strconv.Itoa(i+2), &values); err != nil {
+			return err
+		}
+	}
+
+	totalRow := []any{"Total"}
+	for _, n := range totals {
+		totalRow = append(totalRow, n)
+	}
+	totalCell := "A" + strconv.Itoa(len(sites)+2)
+	if err := f.SetSheetRow(reportSummarySheet, totalCell, &totalRow); err != nil {
+		return err
+	}
+
+	lastCol, _ := excelize.ColumnNumberToName(len(header))
+	if err := f.SetCellStyle(reportSummarySheet, "A1", lastCol+"1", headerStyle); err != nil {
+		return err
+	}
+	totalStyle, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return err
+	}
+	if err := f.SetCellStyle(reportSummarySheet, totalCell, lastCol+strconv.Itoa(len(sites)+2), totalStyle); err != nil {
+		return err
+	}
+	return f.SetColWidth(reportSummarySheet, "A", "A", 30)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
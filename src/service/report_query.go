@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+
+	"alc/repository"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// CertificateReportParams filters the certificates of the report. Scoped limits them to the
+// app_user_scopes of ViewerID; the other filters apply when set.
+type CertificateReportParams struct {
+	Scoped       bool
+	ViewerID     pgtype.UUID
+	CreatedFrom  pgtype.Timestamptz
+	CreatedTo    pgtype.Timestamptz
+	Status       repository.NullCertificateStatus
+	TechnicianID pgtype.UUID
+	Society      string
+	Site         string
+}
+
+// certificateReportRow is a row of certificateReportQuery, scanned by column name.
+type certificateReportRow struct {
+	CertificateID        int32                        `db:"certificate_id"`
+	TicketName           string                       `db:"ticket_name"`
+	ConfirmationStatus   repository.CertificateStatus `db:"confirmation_status"`
+	CertificateCreatedAt pgtype.Timestamptz           `db:"certificate_created_at"`
+	ConfirmedAt          pgtype.Timestamptz           `db:"confirmed_at"`
+	TechnicianName       string                       `db:"technician_name"`
+	TechnicianEmail      string                       `db:"technician_email"`
+	UserDni              string                       `db:"user_dni"`
+	UserPersonalCode     string                       `db:"user_personal_code"`
+	UserName             string                       `db:"user_name"`
+	UserEmail            string                       `db:"user_email"`
+	UserSociety          string                       `db:"user_society"`
+	UserSite             string                       `db:"user_site"`
+	UserArea             string                       `db:"user_area"`
+	UserFloor            string                       `db:"user_floor"`
+	NewDeviceCode        string                       `db:"new_device_code"`
+	NewDeviceHostname    string                       `db:"new_device_hostname"`
+	NewDeviceStatus      repository.DeviceStatus      `db:"new_device_status"`
+	NewMachineSerial     string                       `db:"new_machine_serial"`
+	NewMachineType       repository.MachineType       `db:"new_machine_type"`
+	NewMachineModel      string                       `db:"new_machine_model"`
+	NewMachineDisk       string                       `db:"new_machine_disk"`
+	NewMachineMemory     string                       `db:"new_machine_memory"`
+	NewMachineProfile    repository.MachineProfile    `db:"new_machine_profile"`
+	OldDeviceCode        pgtype.Text                  `db:"old_device_code"`
+	OldDeviceHostname    pgtype.Text                  `db:"old_device_hostname"`
+	OldMachineSerial     pgtype.Text                  `db:"old_machine_serial"`
+	OldMachineType       repository.NullMachineType   `db:"old_machine_type"`
+	OldMachineModel      pgtype.Text                  `db:"old_machine_model"`
+	SoftwareList         string                       `db:"software_list"`
+	ConfigItemList       string                       `db:"config_item_list"`
+	PeripheralList       string                       `db:"peripheral_list"`
+	DiskCSize            string                       `db:"disk_c_size"`
+	DiskDSize            string                       `db:"disk_d_size"`
+	PrinterName          string                       `db:"printer_name"`
+	PrinterIp            string                       `db:"printer_ip"`
+	PrinterTest          bool                         `db:"printer_test"`
+	Comments             string                       `db:"comments"`
+}
+
+// certificateReportQuery lists the certificates of the report, newest first, with the
+// parameters of CertificateReportParams in order. sqlc cannot stream rows, so the query
+// lives here rather than in db/query. The lists of the new device are aggregated in
+// subqueries rather than joined, so each certificate is a single row without GROUP BY
+// and rows can be streamed as they are read.
+const certificateReportQuery = `
+SELECT
+    c.certificate_id,
+    c.ticket_name,
+    c.confirmation_status,
+    c.created_at AS certificate_created_at,
+    c.confirmed_at,
+    au.name AS technician_name,
+    au.email AS technician_email,
+    mu.dni AS user_dni,
+    mu.personal_code AS user_personal_code,
+    mu.name AS user_name,
+    mu.email AS user_email,
+    mu.society AS user_society,
+    mu.site AS user_site,
+    mu.area AS user_area,
+    mu.floor_name AS user_floor,
+    -- New Device Info
+    nd.device_code AS new_device_code,
+    nd.hostname AS new_device_hostname,
+    nd.status AS new_device_status,
+    nm.serial_num AS new_machine_serial,
+    nm.type AS new_machine_type,
+    nm.model AS new_machine_model,
+    nm.disk_size AS new_machine_disk,
+    nm.memory_size AS new_machine_memory,
+    nm.profile AS new_machine_profile,
+    -- Old Device Info
+    od.device_code AS old_device_code,
+    od.hostname AS old_device_hostname,
+    om.serial_num AS old_machine_serial,
+    om.type AS old_machine_type,
+    om.model AS old_machine_model,
+    -- Aggregated Data
+    COALESCE((
+        SELECT STRING_AGG(DISTINCT s.name, ', ')
+        FROM device_software ds
+        JOIN software s ON ds.software_id = s.software_id
+        WHERE ds.device_code = nd.device_code
+    ), '')::text AS software_list,
+    COALESCE((
+        SELECT STRING_AGG(DISTINCT ci.name, ', ')
+        FROM device_configuration dc
+        JOIN configuration_items ci ON dc.item_id = ci.item_id
+        WHERE dc.device_code = nd.device_code
+    ), '')::text AS config_item_list,
+    COALESCE((
+        SELECT STRING_AGG(DISTINCT p.name || ' (Placa: ' || dp.plate_num || ', S/N: ' || dp.serial_num || ')', '; ')
+        FROM device_peripherals dp
+        JOIN peripherals p ON dp.peripheral_id = p.peripheral_id
+        WHERE dp.device_code = nd.device_code
+    ), '')::text AS peripheral_list,
+    -- Other Certificate Data
+    c.disk_c_size,
+    c.disk_d_size,
+    c.printer_name,
+    c.printer_ip,
+    c.printer_test,
+    c.comments
+FROM
+    alicorp_2025_certificates c
+JOIN app_users au ON c.app_user_id = au.user_id
+JOIN machine_users mu ON c.machine_user_dni = mu.dni
+-- Joins for New Device
+JOIN devices nd ON c.new_device_code = nd.device_code
+JOIN machines nm ON nd.machine_serial_num = nm.serial_num
+-- Joins for Old Device
+LEFT JOIN devices od ON c.old_device_code = od.device_code
+LEFT JOIN machines om ON od.machine_serial_num = om.serial_num
+WHERE (NOT $1::boolean OR EXISTS (
+        SELECT 1 FROM app_user_scopes sc
+        WHERE sc.user_id = $2::uuid
+            AND UPPER(sc.society) = UPPER(mu.society)
+            AND (sc.site = '' OR UPPER(sc.site) = UPPER(mu.site))
+    ))
+    AND ($3::timestamptz IS NULL OR c.created_at >= $3::timestamptz)
+    AND ($4::timestamptz IS NULL OR c.created_at < $4::timestamptz)
+    AND ($5::certificate_status IS NULL OR c.confirmation_status = $5::certificate_status)
+    AND ($6::uuid IS NULL OR c.app_user_id = $6::uuid)
+    AND ($7::text = '' OR UPPER(mu.society) = UPPER($7::text))
+    AND ($8::text = '' OR UPPER(mu.site) = UPPER($8::text))
+ORDER BY
+    c.created_at DESC
+`
+
+// streamCertificateReport runs certificateReportQuery and calls fn with each row as it is
+// read from the connection, so the report is never held in memory. Returning an error from
+// fn stops the query.
+func streamCertificateReport(ctx context.Context, db *pgxpool.Pool, arg CertificateReportParams, fn func(certificateReportRow) error) error {
+	rows, err := db.Query(ctx, certificateReportQuery,
+		arg.Scoped,
+		arg.ViewerID,
+		arg.CreatedFrom,
+		arg.CreatedTo,
+		arg.Status,
+		arg.TechnicianID,
+		arg.Society,
+		arg.Site,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		row, err := pgx.RowToStructByName[certificateReportRow](rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
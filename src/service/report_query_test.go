@@ -0,0 +1,107 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"alc/config"
+	"alc/db/dbtest"
+)
+
+// certificateReportQueryColumns returns the names of the columns certificateReportQuery
+// selects, in order: the alias of each item of its top-level select list, or the column.
+func certificateReportQueryColumns(t *testing.T) []string {
+	t.Helper()
+
+	comments := regexp.MustCompile(`--[^\n]*`)
+	query := comments.ReplaceAllString(certificateReportQuery, "")
+	start := strings.Index(query, "SELECT")
+	end := strings.Index(query, "\nFROM\n")
+	if start < 0 || end < 0 {
+		t.Fatal("no top-level SELECT ... FROM in certificateReportQuery")
+	}
+
+	var columns []string
+	depth, itemStart := 0, start+len("SELECT")
+	for i := itemStart; i <= end; i++ {
+		if i < end {
+			switch query[i] {
+			case '(':
+				depth++
+				continue
+			case ')':
+				depth--
+				continue
+			case ',':
+				if depth > 0 {
+					continue
+				}
+			default:
+				continue
+			}
+		}
+		item := strings.Fields(query[itemStart:i])
+		itemStart = i + 1
+		name := item[len(item)-1]
+		if len(item) < 3 || !strings.EqualFold(item[len(item)-2], "AS") {
+			name = name[strings.LastIndex(name, ".")+1:]
+		}
+		columns = append(columns, name)
+	}
+	return columns
+}
+
+func TestCertificateReportRowColumns(t *testing.T) {
+	columns := certificateReportQueryColumns(t)
+
+	rowType := reflect.TypeFor[certificateReportRow]()
+	var tags []string
+	for i := range rowType.NumField() {
+		tags = append(tags, rowType.Field(i).Tag.Get("db"))
+	}
+	if !reflect.DeepEqual(columns, tags) {
+		t.Errorf("certificateReportQuery selects\n%v\nbut certificateReportRow scans\n%v", columns, tags)
+	}
+	if n := len(certificateReportValues(certificateReportRow{})); n != len(CertificateReportColumns) {
+		t.Errorf("certificateReportValues() returns %d cells for %d columns", n, len(CertificateReportColumns))
+	}
+}
+
+func TestCertificateReportCSV(t *testing.T) {
+	pool, repo := dbtest.New(t)
+	ctx := context.Background()
+	user := newTestTecnico(t, repo)
+
+	emailSvc, err := NewEmailService(&config.Config{SmtpHost: "localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	certSvc := &CertificateService{DBPool: pool, Repo: repo, EmailSvc: emailSvc, WebhookSvc: NewWebhookService(repo)}
+	cert, err := certSvc.CreateCertificateFromForm(ctx, user, testCertificateForm(1, "70000001", "Ana Torres"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	report := CertificateReport{DBPool: pool, Columns: SelectReportColumns([]string{"id", "user_name", "new_device", "old_device"})}
+	if err := report.WriteCSV(ctx, &buf); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"ID Certificado", "Nombre Usuario", "Cod. Equipo Nuevo", "Cod. Equipo Antiguo"},
+		{strconv.Itoa(int(cert.CertificateID)), "ANA TORRES", "EQ-NEW-1", "EQ-OLD-1"},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("WriteCSV() = %v, want %v", records, want)
+	}
+}
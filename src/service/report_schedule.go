@@ -0,0 +1,180 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"alc/repository"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const reportSchedulePollInterval = time.Minute
+
+const (
+	ReportFormatXLSX = "xlsx"
+	ReportFormatCSV  = "csv"
+)
+
+// ReportScheduleService emails the certificate report to the active subscriptions when
+// they are due and records every attempt in report_runs.
+type ReportScheduleService struct {
+	DBPool *pgxpool.Pool
+	Repo   *repository.Queries
+	Email  *EmailService
+}
+
+func NewReportScheduleService(db *pgxpool.Pool, r *repository.Queries, emailSvc *EmailService) *ReportScheduleService {
+	return &ReportScheduleService{DBPool: db, Repo: r, Email: emailSvc}
+}
+
+// NextReportRun returns the first time after after at which a subscription with the given
+// schedule is due. The schedule is in Lima time.
+func NextReportRun(frequency repository.ReportFrequency, hour, weekday, monthDay int, after time.Time) time.Time {
+	t := after.In(limaLocation)
+	next := time.Date(t.Year(), t.Month(), t.Day(), hour, 0, 0, 0, limaLocation)
+	switch frequency {
+	case repository.ReportFrequencyWEEKLY:
+		next = next.AddDate(0, 0, (weekday-int(next.Weekday())+7)%7)
+		if !next.After(t) {
+			next = next.AddDate(0, 0, 7)
+		}
+	case repository.ReportFrequencyMONTHLY:
+		next = time.Date(t.Year(), t.Month(), monthDay, hour, 0, 0, 0, limaLocation)
+		if !next.After(t) {
+			next = next.AddDate(0, 1, 0)
+		}
+	default:
+		if !next.After(t) {
+			next = next.AddDate(0, 0, 1)
+		}
+	}
+	return next
+}
+
+// SubscriptionNextRun is NextReportRun for a saved subscription.
+func SubscriptionNextRun(sub repository.ReportSubscription, after time.Time) time.Time {
+	return NextReportRun(sub.Frequency, int(sub.SendHour), int(sub.Weekday), int(sub.MonthDay), after)
+}
+
+// subscriptionReport builds the report of a subscription as of now. Subscriptions are set
+// up by admins, so the report is not limited to any scope.
+func (s *ReportScheduleService) subscriptionReport(sub repository.ReportSubscription, now time.Time) CertificateReport {
+	params := CertificateReportParams{
+		Status:       sub.Status,
+		TechnicianID: sub.TechnicianID,
+		Society:      sub.Society,
+		Site:         sub.Site,
+	}
+	if sub.PeriodDays > 0 {
+		today := now.In(limaLocation)
+		from := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, limaLocation).AddDate(0, 0, -int(sub.PeriodDays))
+		params.CreatedFrom = pgtype.Timestamptz{Time: from, Valid: true}
+	}
+	return CertificateReport{
+		DBPool:  s.DBPool,
+		Params:  params,
+		Columns: SelectReportColumns(sub.Columns),
+	}
+}
+
+// Send generates the report of a subscription, emails it and records the run. The run is
+// returned even when sending failed, with the error.
+func (s *ReportScheduleService) Send(ctx context.Context, sub repository.ReportSubscription) (repository.ReportRun, error) {
+	startedAt := time.Now()
+	report := s.subscriptionReport(sub, startedAt)
+
+	var buf bytes.Buffer
+	var err error
+	fileName := fmt.Sprintf("reporte_certificados_%s.%s", startedAt.In(limaLocation).Format("20060102"), sub.Format)
+	contentType := "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	if sub.Format == ReportFormatCSV {
+		contentType = "text/csv"
+		err = report.WriteCSV(ctx, &buf)
+	} else {
+		err = report.WriteXLSX(ctx, &buf)
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to generate report: %w", err)
+	} else {
+		var period string
+		if sub.PeriodDays > 0 {
+			period = fmt.Sprintf("desde el %s", report.Params.CreatedFrom.Time.In(limaLocation).Format("02/01/2006"))
+		}
+		err = s.Email.SendReportEmail(ctx, ReportEmail{
+			Recipients:  sub.Recipients,
+			Name:        sub.Name,
+			Period:      period,
+			FileName:    fileName,
+			ContentType: contentType,
+			Data:        buf.Bytes(),
+			GeneratedAt: startedAt,
+		})
+		if err != nil {
+			err = fmt.Errorf("failed to send email: %w", err)
+		}
+	}
+
+	params := repository.CreateReportRunParams{
+		SubscriptionID: sub.SubscriptionID,
+		Status:         repository.ReportRunStatusSUCCEEDED,
+		FileName:       fileName,
+		SizeBytes:      int32(buf.Len()),
+		StartedAt:      pgtype.Timestamptz{Time: startedAt, Valid: true},
+	}
+	if err != nil {
+		params.Status = repository.ReportRunStatusFAILED
+		params.Error = err.Error()
+	}
+	run, logErr := s.Repo.CreateReportRun(ctx, params)
+	if logErr != nil {
+		log.Printf("ERROR: Failed to record run of report subscription %d: %v", sub.SubscriptionID, logErr)
+	}
+	return run, err
+}
+
+// Run sends the due subscriptions until ctx is cancelled.
+func (s *ReportScheduleService) Run(ctx context.Context) {
+	ticker := time.NewTicker(reportSchedulePollInterval)
+	defer ticker.Stop()
+	for {
+		s.sendDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *ReportScheduleService) sendDue(ctx context.Context) {
+	subs, err := s.Repo.ListDueReportSubscriptions(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("ERROR: Failed to list due report subscriptions: %v", err)
+		}
+		return
+	}
+	for _, sub := range subs {
+		// A run missed while the server was down is sent once, then the schedule resumes
+		claimed, err := s.Repo.ClaimReportSubscriptionRun(ctx, repository.ClaimReportSubscriptionRunParams{
+			SubscriptionID: sub.SubscriptionID,
+			DueAt:          sub.NextRunAt,
+			NextRunAt:      pgtype.Timestamptz{Time: SubscriptionNextRun(sub, time.Now()), Valid: true},
+		})
+		if err != nil {
+			log.Printf("ERROR: Failed to claim report subscription %d: %v", sub.SubscriptionID, err)
+			continue
+		}
+		if claimed == 0 {
+			continue // Sent by another instance, or changed meanwhile
+		}
+		if _, err := s.Send(ctx, sub); err != nil {
+			log.Printf("ERROR: Report subscription %d (%s) failed: %v", sub.SubscriptionID, sub.Name, err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// ReadSpreadsheet returns the rows of a CSV or XLSX file, chosen by the extension of
+// filename. Only the first sheet of a workbook is read. CSV files may use commas or
+// semicolons, as exported by Excel in Spanish locales.
+func ReadSpreadsheet(filename string, r io.Reader) ([][]string, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".xlsx":
+		return readXLSX(r)
+	case ".csv", ".txt":
+		return readCSV(r)
+	default:
+		return nil, fmt.Errorf("unsupported file type %q, use CSV or XLSX", filepath.Ext(filename))
+	}
+}
+
+func readXLSX(r io.Reader) ([][]string, error) {
+	f, err := excelize.OpenReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open workbook: %w", err)
+	}
+	defer f.Close()
+
+	sheets := f.GetSheetList()
+	if len(sheets) == 0 {
+		return nil, errors.New("the workbook has no sheets")
+	}
+	rows, err := f.GetRows(sheets[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sheet %s: %w", sheets[0], err)
+	}
+	return rows, nil
+}
+
+// readCSV also accepts the Windows-1252 (Latin-1) files saved by Excel as "CSV (delimited)",
+// which is detected when the content is not valid UTF-8.
+func readCSV(r io.Reader) ([][]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	// Excel prefixes UTF-8 CSV files with a byte order mark
+	data = bytes.TrimPrefix(data, []byte{0xEF, 0xBB, 0xBF})
+	if !utf8.Valid(data) {
+		if data, err = charmap.Windows1252.NewDecoder().Bytes(data); err != nil {
+			return nil, fmt.Errorf("failed to decode file: %w", err)
+		}
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	if first := data[:min(len(data), 4096)]; bytes.Count(first, []byte{';'}) > bytes.Count(first, []byte{','}) {
+		reader.Comma = ';'
+	}
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CSV: %w", err)
+	}
+	return rows, nil
+}
+
+var accentFolder = strings.NewReplacer("á", "a", "é", "e", "í", "i", "ó", "o", "ú", "u", "ü", "u", "ñ", "n")
+
+// headerKey normalizes a column header for matching: "Serial No." and "serial_no" are both
+// "serialno", and "Número de documento" is "numerodedocumento".
+func headerKey(header string) string {
+	var b strings.Builder
+	for _, r := range accentFolder.Replace(strings.ToLower(header)) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// cell returns the trimmed value of column i, or "" when the row is shorter or i is negative.
+func cell(row []string, i int) string {
+	if i < 0 || i >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[i])
+}
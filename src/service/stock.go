@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"alc/repository"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var (
+	// ErrMachineNotInStock is returned when reserving a machine that does not exist or
+	// already has a device.
+	ErrMachineNotInStock = errors.New("the machine is not in stock")
+	// ErrUserHasReservation is returned when the machine user already has a machine
+	// reserved.
+	ErrUserHasReservation = errors.New("the machine user already has a reservation")
+)
+
+// StockService reserves machines in stock for machine users ahead of their visit.
+type StockService struct {
+	Repo *repository.Queries
+}
+
+func NewStockService(r *repository.Queries) *StockService {
+	return &StockService{Repo: r}
+}
+
+// StockAlert is a profile with fewer available machines than its alert level.
+type StockAlert struct {
+	Profile      repository.MachineProfile
+	Available    int64
+	MinAvailable int32
+}
+
+// LowStockAlerts compares the available machines of each profile, across sites, with
+// their alert levels.
+func LowStockAlerts(summary []repository.SummarizeMachineStockRow, levels []repository.StockAlertLevel) []StockAlert {
+	available := map[repository.MachineProfile]int64{}
+	for _, row := range summary {
+		available[row.Profile] += row.Available
+	}
+	var alerts []StockAlert
+	for _, level := range levels {
+		if available[level.Profile] < int64(level.MinAvailable) {
+			alerts = append(alerts, StockAlert{
+				Profile:      level.Profile,
+				Available:    available[level.Profile],
+				MinAvailable: level.MinAvailable,
+			})
+		}
+	}
+	return alerts
+}
+
+// Reserve sets a machine in stock aside for an active machine user. It returns
+// pgx.ErrNoRows when the machine user does not exist, and a unique violation when the
+// machine is already reserved.
+func (s *StockService) Reserve(ctx context.Context, serial, dni, notes string, reservedBy uuid.UUID) (repository.MachineReservation, error) {
+	serial = normalize(serial, true)
+	dni = strings.ReplaceAll(dni, " ", "")
+
+	machineUser, err := s.Repo.GetMachineUserByDNI(ctx, dni)
+	if err != nil {
+		return repository.MachineReservation{}, err
+	}
+	if !machineUser.Active {
+		return repository.MachineReservation{}, ErrMachineUserInactive
+	}
+	if _, err := s.Repo.GetMachineReservationByUser(ctx, dni); err == nil {
+		return repository.MachineReservation{}, ErrUserHasReservation
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return repository.MachineReservation{}, err
+	}
+
+	reservation, err := s.Repo.ReserveMachine(ctx, repository.ReserveMachineParams{
+		MachineSerialNum: serial,
+		MachineUserDni:   dni,
+		ReservedBy:       pgtype.UUID{Bytes: reservedBy, Valid: true},
+		Notes:            strings.TrimSpace(notes),
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return reservation, ErrMachineNotInStock
+	}
+	return reservation, err
+}
+
+// claimMachineReservation removes the reservation of a machine handed over in a
+// certificate, refusing a machine reserved for another machine user.
+func claimMachineReservation(ctx context.Context, q *repository.Queries, serial, dni string) error {
+	reservedFor, err := q.ClaimMachineReservation(ctx, serial)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return fmt.Errorf("failed to claim reservation of machine %s: %w", serial, err)
+	}
+	if reservedFor != dni {
+		return fmt.Errorf("el equipo con N/S '%s' está reservado para el usuario con DNI %s", serial, reservedFor)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"alc/config"
+)
+
+var (
+	ErrTicketNotFound = errors.New("ticket not found")
+	// ErrInvalidTicketNumber is returned for a number that is not a prefix followed by
+	// digits, such as INC0012345.
+	ErrInvalidTicketNumber = errors.New("invalid ticket number")
+)
+
+// Ticket is the part of an ITSM ticket the certificate form uses.
+type Ticket struct {
+	Number string
+	// State is the display state of the ticket in the ITSM system, e.g. "In Progress"
+	State string
+	// URL opens the ticket in the ITSM system
+	URL string
+	// RequesterDNI and DeviceCode are empty when the ticket does not reference them
+	RequesterDNI string
+	DeviceCode   string
+	Summary      string
+}
+
+// TicketProvider looks up tickets in an ITSM system. It returns ErrTicketNotFound
+// when the number does not exist and ErrInvalidTicketNumber when it is malformed.
+type TicketProvider interface {
+	Name() string
+	LookupTicket(ctx context.Context, number string) (Ticket, error)
+}
+
+// NormalizeTicketNumber trims and upper-cases a ticket number, e.g. " inc0012345" -> "INC0012345".
+func NormalizeTicketNumber(number string) string {
+	return strings.ToUpper(strings.ReplaceAll(number, " ", ""))
+}
+
+// NewTicketProvider builds the provider configured in TICKET_PROVIDER. It returns
+// nil when ticket validation is disabled.
+func NewTicketProvider(cfg *config.Config) (TicketProvider, error) {
+	switch cfg.TicketProvider {
+	case "":
+		return nil, nil
+	case "servicenow":
+		if cfg.ServiceNowURL == "" {
+			return nil, errors.New("the servicenow ticket provider requires SERVICENOW_URL")
+		}
+		return NewServiceNowTicketProvider(cfg), nil
+	case "fake":
+		return NewFakeTicketProvider(cfg.TicketFakeFile)
+	default:
+		return nil, fmt.Errorf("unknown ticket provider %q in TICKET_PROVIDER", cfg.TicketProvider)
+	}
+}
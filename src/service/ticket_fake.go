@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// FakeTicketProvider serves tickets from a local JSON file, for development and demos
+// without an ITSM system. Without a file every ticket number exists in state "Open".
+type FakeTicketProvider struct {
+	tickets map[string]Ticket
+}
+
+// NewFakeTicketProvider loads the tickets of path, a JSON array of objects with the
+// fields number, state, url, requester_dni, device_code and summary.
+func NewFakeTicketProvider(path string) (*FakeTicketProvider, error) {
+	if path == "" {
+		return &FakeTicketProvider{}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fake tickets: %w", err)
+	}
+	var records []struct {
+		Number       string `json:"number"`
+		State        string `json:"state"`
+		URL          string `json:"url"`
+		RequesterDNI string `json:"requester_dni"`
+		DeviceCode   string `json:"device_code"`
+		Summary      string `json:"summary"`
+	}
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("failed to parse fake tickets %s: %w", path, err)
+	}
+
+	tickets := make(map[string]Ticket, len(records))
+	for _, r := range records {
+		number := NormalizeTicketNumber(r.Number)
+		tickets[number] = Ticket{
+			Number:       number,
+			State:        r.State,
+			URL:          r.URL,
+			RequesterDNI: r.RequesterDNI,
+			DeviceCode:   r.DeviceCode,
+			Summary:      r.Summary,
+		}
+	}
+	return &FakeTicketProvider{tickets: tickets}, nil
+}
+
+func (p *FakeTicketProvider) Name() string {
+	return "Fake"
+}
+
+func (p *FakeTicketProvider) LookupTicket(ctx context.Context, number string) (Ticket, error) {
+	if p.tickets == nil {
+		return Ticket{Number: number, State: "Open"}, nil
+	}
+	ticket, ok := p.tickets[number]
+	if !ok {
+		return Ticket{}, ErrTicketNotFound
+	}
+	return ticket, nil
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"alc/config"
+)
+
+// ServiceNowTicketProvider reads tickets with the ServiceNow Table API. The requester's
+// DNI and the device code are read from configurable dot-walked fields of the ticket.
+type ServiceNowTicketProvider struct {
+	config *config.Config
+	client *http.Client
+}
+
+func NewServiceNowTicketProvider(cfg *config.Config) *ServiceNowTicketProvider {
+	return &ServiceNowTicketProvider{
+		config: cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *ServiceNowTicketProvider) Name() string {
+	return "ServiceNow"
+}
+
+// serviceNowNumber matches ticket numbers such as INC0012345 or RITM0001234. Anything
+// else could add conditions to the encoded query, e.g. "INC1^ORnumberISNOTEMPTY".
+var serviceNowNumber = regexp.MustCompile(`^[A-Z]+[0-9]+$`)
+
+func (p *ServiceNowTicketProvider) LookupTicket(ctx context.Context, number string) (Ticket, error) {
+	if !serviceNowNumber.MatchString(number) {
+		return Ticket{}, ErrInvalidTicketNumber
+	}
+
+	query := url.Values{}
+	query.Set("sysparm_query", "number="+number)
+	query.Set("sysparm_limit", "1")
+	// Display values turn choice fields such as state into readable labels
+	query.Set("sysparm_display_value", "true")
+	query.Set("sysparm_exclude_reference_link", "true")
+	query.Set("sysparm_fields", strings.Join([]string{
+		"sys_id", "number", "state", "short_description",
+		p.config.ServiceNowDniField, p.config.ServiceNowDeviceField,
+	}, ","))
+	endpoint := fmt.Sprintf("%s/api/now/table/%s?%s", p.config.ServiceNowURL, url.PathEscape(p.config.ServiceNowTable), query.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return Ticket{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(p.config.ServiceNowUser, p.config.ServiceNowPassword)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return Ticket{}, fmt.Errorf("failed to query ServiceNow: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Ticket{}, fmt.Errorf("unexpected ServiceNow response: %s", resp.Status)
+	}
+
+	var body struct {
+		Result []map[string]string `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return Ticket{}, fmt.Errorf("failed to decode ServiceNow response: %w", err)
+	}
+	if len(body.Result) == 0 {
+		return Ticket{}, ErrTicketNotFound
+	}
+
+	record := body.Result[0]
+	return Ticket{
+		Number:       record["number"],
+		State:        record["state"],
+		URL:          fmt.Sprintf("%s/nav_to.do?uri=%s", p.config.ServiceNowURL, url.QueryEscape(p.config.ServiceNowTable+".do?sys_id="+record["sys_id"])),
+		RequesterDNI: strings.TrimSpace(record[p.config.ServiceNowDniField]),
+		DeviceCode:   strings.ToUpper(strings.TrimSpace(record[p.config.ServiceNowDeviceField])),
+		Summary:      record["short_description"],
+	}, nil
+}
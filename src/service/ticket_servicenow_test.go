@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"alc/config"
+	"alc/db/dbtest"
+	"alc/model"
+	"alc/repository"
+
+	"github.com/google/uuid"
+)
+
+// mockServiceNow serves the Table API of one incident, INC0012345. While down is set it
+// answers 503.
+type mockServiceNow struct {
+	*httptest.Server
+	requests atomic.Int32
+	down     atomic.Bool
+}
+
+func newMockServiceNow(t *testing.T) *mockServiceNow {
+	t.Helper()
+
+	m := &mockServiceNow{}
+	m.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.requests.Add(1)
+		if m.down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if user, password, ok := r.BasicAuth(); !ok || user != "alc" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/api/now/table/incident" {
+			t.Errorf("request to %s, want the incident table", r.URL.Path)
+		}
+
+		q := r.URL.Query()
+		result := []map[string]string{}
+		if q.Get("sysparm_query") == "number=INC0012345" {
+			result = append(result, map[string]string{
+				"sys_id":                    "abc123",
+				"number":                    "INC0012345",
+				"state":                     "In Progress",
+				"short_description":         "Cambio de equipo",
+				"caller_id.employee_number": " 70000001 ",
+				"cmdb_ci.asset_tag":         "eq-1001",
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"result": result})
+	}))
+	t.Cleanup(m.Close)
+	return m
+}
+
+func newTestServiceNowProvider(m *mockServiceNow) *ServiceNowTicketProvider {
+	return NewServiceNowTicketProvider(&config.Config{
+		ServiceNowURL:         m.URL,
+		ServiceNowUser:        "alc",
+		ServiceNowPassword:    "secret",
+		ServiceNowTable:       "incident",
+		ServiceNowDniField:    "caller_id.employee_number",
+		ServiceNowDeviceField: "cmdb_ci.asset_tag",
+	})
+}
+
+func TestServiceNowLookupTicket(t *testing.T) {
+	m := newMockServiceNow(t)
+	p := newTestServiceNowProvider(m)
+	ctx := context.Background()
+
+	ticket, err := p.LookupTicket(ctx, "INC0012345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Ticket{
+		Number:       "INC0012345",
+		State:        "In Progress",
+		URL:          m.URL + "/nav_to.do?uri=" + url.QueryEscape("incident.do?sys_id=abc123"),
+		RequesterDNI: "70000001",
+		DeviceCode:   "EQ-1001",
+		Summary:      "Cambio de equipo",
+	}
+	if ticket != want {
+		t.Errorf("LookupTicket() = %+v, want %+v", ticket, want)
+	}
+
+	if _, err := p.LookupTicket(ctx, "INC0099999"); !errors.Is(err, ErrTicketNotFound) {
+		t.Errorf("LookupTicket() of a missing ticket error = %v, want ErrTicketNotFound", err)
+	}
+
+	t.Run("invalid numbers are not sent", func(t *testing.T) {
+		before := m.requests.Load()
+		for _, number := range []string{"", "INC", "12345", "inc0012345", "INC1^ORnumberISNOTEMPTY", "INC1&sysparm_limit=100"} {
+			if _, err := p.LookupTicket(ctx, number); !errors.Is(err, ErrInvalidTicketNumber) {
+				t.Errorf("LookupTicket(%q) error = %v, want ErrInvalidTicketNumber", number, err)
+			}
+		}
+		if n := m.requests.Load() - before; n != 0 {
+			t.Errorf("%d invalid numbers reached ServiceNow", n)
+		}
+	})
+
+	t.Run("unavailable", func(t *testing.T) {
+		m.down.Store(true)
+		defer m.down.Store(false)
+		_, err := p.LookupTicket(ctx, "INC0012345")
+		if err == nil || errors.Is(err, ErrTicketNotFound) {
+			t.Errorf("LookupTicket() error = %v, want an unexpected response error", err)
+		}
+	})
+}
+
+func TestResolveTicket(t *testing.T) {
+	m := newMockServiceNow(t)
+	s := &CertificateService{Tickets: newTestServiceNowProvider(m)}
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		number  string
+		down    bool
+		want    Ticket
+		wantErr bool
+	}{
+		{name: "normalized", number: " inc 0012345", want: Ticket{Number: "INC0012345", State: "In Progress"}},
+		{name: "missing", number: "INC0099999", wantErr: true},
+		{name: "invalid", number: "INC1^ORnumberISNOTEMPTY", wantErr: true},
+		{name: "empty", number: " ", wantErr: true},
+		{name: "unreachable keeps the number", number: "INC0012345", down: true, want: Ticket{Number: "INC0012345"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m.down.Store(tt.down)
+			defer m.down.Store(false)
+
+			got, err := s.resolveTicket(ctx, url.Values{"ticket_name": {tt.number}})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveTicket() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.Number != tt.want.Number || got.State != tt.want.State {
+				t.Errorf("resolveTicket() = %+v, want number %q and state %q", got, tt.want.Number, tt.want.State)
+			}
+		})
+	}
+}
+
+func TestUpdateCertificateKeepsTicketState(t *testing.T) {
+	pool, repo := dbtest.New(t)
+	ctx := context.Background()
+	m := newMockServiceNow(t)
+
+	tecnico, err := repo.CreateAppUser(ctx, repository.CreateAppUserParams{
+		Name:           "Técnico",
+		Email:          "tecnico@example.com",
+		HashedPassword: "x",
+		Role:           repository.UserRoleTECNICO,
+		Dni:            "00000001",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := model.AuthenticatedUser{ID: uuid.UUID(tecnico.UserID.Bytes), Name: tecnico.Name, Email: tecnico.Email, Role: tecnico.Role}
+
+	emailSvc, err := NewEmailService(&config.Config{SmtpHost: "localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := NewCertificateService(pool, repo, emailSvc, NewWebhookService(repo), newTestServiceNowProvider(m))
+	form := url.Values{
+		"ticket_name":        {"INC0012345"},
+		"machine_user_dni":   {"70000001"},
+		"machine_user_code":  {"P1"},
+		"machine_user_name":  {"Usuario"},
+		"machine_user_email": {"usuario@example.com"},
+		"new_device_code":    {"EQ-1001"},
+		"new_device_serial":  {"SN-NEW"},
+		"new_device_type":    {"LAPTOP"},
+		"new_device_status":  {"ASIGNACION"},
+		"old_device_code":    {"EQ-0001"},
+		"old_device_serial":  {"SN-OLD"},
+		"old_device_type":    {"PC"},
+		"old_device_status":  {"RECUPERACION"},
+	}
+	cert, err := svc.CreateCertificateFromForm(ctx, user, form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.TicketState != "In Progress" || cert.TicketUrl == "" {
+		t.Fatalf("created certificate has ticket state %q and URL %q", cert.TicketState, cert.TicketUrl)
+	}
+
+	m.down.Store(true)
+	form.Set("machine_user_name", "Usuario Editado")
+	updated, err := svc.UpdateCertificateFromForm(ctx, user, cert.CertificateID, form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.TicketState != cert.TicketState || updated.TicketUrl != cert.TicketUrl {
+		t.Errorf("ticket state %q and URL %q after a failed lookup, want %q and %q", updated.TicketState, updated.TicketUrl, cert.TicketState, cert.TicketUrl)
+	}
+}
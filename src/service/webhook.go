@@ -0,0 +1,289 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"alc/repository"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type WebhookEvent string
+
+const (
+	EventCertificateCreated   WebhookEvent = "certificate.created"
+	EventCertificateUpdated   WebhookEvent = "certificate.updated"
+	EventCertificateConfirmed WebhookEvent = "certificate.confirmed"
+	EventCertificateRejected  WebhookEvent = "certificate.rejected"
+	EventCertificateVoided    WebhookEvent = "certificate.voided"
+	// EventPing is only sent by the "Send test" action of an endpoint
+	EventPing WebhookEvent = "ping"
+)
+
+// WebhookEvents lists the events an endpoint can subscribe to, in display order.
+var WebhookEvents = []WebhookEvent{
+	EventCertificateCreated,
+	EventCertificateUpdated,
+	EventCertificateConfirmed,
+	EventCertificateRejected,
+	EventCertificateVoided,
+}
+
+const (
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+
+	webhookBatchSize    = 20
+	webhookPollInterval = 5 * time.Second
+	webhookTimeout      = 10 * time.Second
+)
+
+// webhookRetryDelays are the waits after each failed attempt. A delivery is marked
+// FAILED after len(webhookRetryDelays)+1 attempts.
+var webhookRetryDelays = []time.Duration{
+	30 * time.Second,
+	2 * time.Minute,
+	10 * time.Minute,
+	time.Hour,
+	6 * time.Hour,
+}
+
+// WebhookPayload is the JSON body of every delivery.
+type WebhookPayload struct {
+	// ID identifies the event; it is the same for every endpoint and retry
+	ID         string       `json:"id"`
+	Event      WebhookEvent `json:"event"`
+	OccurredAt time.Time    `json:"occurred_at"`
+	Data       any          `json:"data"`
+}
+
+// WebhookCertificate is the data of the certificate.* events. TicketName is the ITSM ticket.
+type WebhookCertificate struct {
+	ID             int32                        `json:"id"`
+	TicketName     string                       `json:"ticket_name"`
+	Status         repository.CertificateStatus `json:"status"`
+	MachineUserDni string                       `json:"machine_user_dni"`
+	NewDeviceCode  string                       `json:"new_device_code"`
+	OldDeviceCode  string                       `json:"old_device_code"`
+	TechnicianID   string                       `json:"technician_id"`
+	Comments       string                       `json:"comments"`
+	ConfirmedAt    *time.Time                   `json:"confirmed_at"`
+	CreatedAt      time.Time                    `json:"created_at"`
+	UpdatedAt      time.Time                    `json:"updated_at"`
+}
+
+func newWebhookCertificate(cert repository.Alicorp2025Certificate) WebhookCertificate {
+	data := WebhookCertificate{
+		ID:             cert.CertificateID,
+		TicketName:     cert.TicketName,
+		Status:         cert.ConfirmationStatus,
+		MachineUserDni: cert.MachineUserDni,
+		NewDeviceCode:  cert.NewDeviceCode,
+		OldDeviceCode:  cert.OldDeviceCode,
+		TechnicianID:   cert.AppUserID.String(),
+		Comments:       cert.Comments,
+		CreatedAt:      cert.CreatedAt.Time,
+		UpdatedAt:      cert.UpdatedAt.Time,
+	}
+	if cert.ConfirmedAt.Valid {
+		data.ConfirmedAt = &cert.ConfirmedAt.Time
+	}
+	return data
+}
+
+// WebhookSignature signs "<timestamp>.<body>" with HMAC-SHA256 and returns the
+// header value "t=<timestamp>,v1=<hex signature>".
+func WebhookSignature(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// VerifyWebhookSignature checks a signature header produced by WebhookSignature.
+// Signatures older than tolerance are rejected to prevent replays.
+func VerifyWebhookSignature(secret, header string, body []byte, tolerance time.Duration) error {
+	var timestamp int64
+	var signature string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp, _ = strconv.ParseInt(value, 10, 64)
+		case "v1":
+			signature = value
+		}
+	}
+	if timestamp == 0 || signature == "" {
+		return errors.New("malformed signature header")
+	}
+	if age := time.Since(time.Unix(timestamp, 0)); age > tolerance || age < -tolerance {
+		return errors.New("signature timestamp outside the tolerance")
+	}
+
+	expected := WebhookSignature(secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(fmt.Sprintf("t=%d,v1=%s", timestamp, signature))) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// NewWebhookSecret returns a random secret for a new endpoint.
+func NewWebhookSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// WebhookService queues certificate lifecycle events for the configured endpoints
+// and delivers them in the background with HMAC signatures and retries.
+type WebhookService struct {
+	Repo   *repository.Queries
+	client *http.Client
+}
+
+func NewWebhookService(r *repository.Queries) *WebhookService {
+	return &WebhookService{
+		Repo:   r,
+		client: &http.Client{Timeout: webhookTimeout},
+	}
+}
+
+func newWebhookPayload(event WebhookEvent, data any) ([]byte, error) {
+	return json.Marshal(WebhookPayload{
+		ID:         uuid.NewString(),
+		Event:      event,
+		OccurredAt: time.Now().UTC(),
+		Data:       data,
+	})
+}
+
+// EnqueueCertificateEvent queues the event for every subscribed endpoint. q may be bound
+// to a transaction, so the deliveries only exist if the change that caused them is committed.
+func (s *WebhookService) EnqueueCertificateEvent(ctx context.Context, q *repository.Queries, event WebhookEvent, cert repository.Alicorp2025Certificate) error {
+	payload, err := newWebhookPayload(event, newWebhookCertificate(cert))
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+	if _, err := q.EnqueueWebhookEvent(ctx, repository.EnqueueWebhookEventParams{
+		Event:   string(event),
+		Payload: payload,
+	}); err != nil {
+		return fmt.Errorf("failed to enqueue %s webhooks: %w", event, err)
+	}
+	return nil
+}
+
+// Ping queues a test delivery to a single endpoint, whatever its subscriptions.
+func (s *WebhookService) Ping(ctx context.Context, endpointID int32) error {
+	payload, err := newWebhookPayload(EventPing, map[string]string{"message": "Test delivery"})
+	if err != nil {
+		return err
+	}
+	_, err = s.Repo.EnqueueWebhookDelivery(ctx, repository.EnqueueWebhookDeliveryParams{
+		EndpointID: endpointID,
+		Event:      string(EventPing),
+		Payload:    payload,
+	})
+	return err
+}
+
+// Run delivers due webhooks until ctx is cancelled.
+func (s *WebhookService) Run(ctx context.Context) {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+	for {
+		s.deliverDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *WebhookService) deliverDue(ctx context.Context) {
+	deliveries, err := s.Repo.ClaimDueWebhookDeliveries(ctx, webhookBatchSize)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("ERROR: Failed to claim webhook deliveries: %v", err)
+		}
+		return
+	}
+	for _, d := range deliveries {
+		s.deliver(ctx, d)
+	}
+}
+
+// deliver makes one attempt and records its outcome. Any 2xx response is a success.
+func (s *WebhookService) deliver(ctx context.Context, d repository.ClaimDueWebhookDeliveriesRow) {
+	statusCode, err := s.send(ctx, d)
+	if err == nil {
+		if err := s.Repo.MarkWebhookDeliverySucceeded(ctx, repository.MarkWebhookDeliverySucceededParams{
+			DeliveryID:     d.DeliveryID,
+			LastStatusCode: pgtype.Int4{Int32: int32(statusCode), Valid: true},
+		}); err != nil {
+			log.Printf("ERROR: Failed to record webhook delivery %s: %v", d.DeliveryID.String(), err)
+		}
+		return
+	}
+
+	params := repository.MarkWebhookDeliveryAttemptFailedParams{
+		DeliveryID:     d.DeliveryID,
+		Status:         repository.WebhookDeliveryStatusPENDING,
+		LastStatusCode: pgtype.Int4{Int32: int32(statusCode), Valid: statusCode != 0},
+		LastError:      err.Error(),
+		NextAttemptAt:  pgtype.Timestamptz{Time: time.Now(), Valid: true},
+	}
+	if attempt := int(d.Attempts); attempt < len(webhookRetryDelays) {
+		params.NextAttemptAt.Time = time.Now().Add(webhookRetryDelays[attempt])
+	} else {
+		params.Status = repository.WebhookDeliveryStatusFAILED
+		log.Printf("Webhook delivery %s (%s) to %s failed after %d attempts: %v", d.DeliveryID.String(), d.Event, d.Url, attempt+1, err)
+	}
+	if err := s.Repo.MarkWebhookDeliveryAttemptFailed(ctx, params); err != nil {
+		log.Printf("ERROR: Failed to record webhook delivery %s: %v", d.DeliveryID.String(), err)
+	}
+}
+
+func (s *WebhookService) send(ctx context.Context, d repository.ClaimDueWebhookDeliveriesRow) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.Url, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("invalid request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "alc-webhooks/1")
+	req.Header.Set(WebhookEventHeader, d.Event)
+	req.Header.Set(WebhookDeliveryHeader, d.DeliveryID.String())
+	req.Header.Set(WebhookSignatureHeader, WebhookSignature(d.Secret, time.Now().Unix(), d.Payload))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return resp.StatusCode, fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,204 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"alc/db/dbtest"
+	"alc/repository"
+)
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	body := []byte(`{"event":"ping"}`)
+	now := time.Now().Unix()
+	header := WebhookSignature("whsec_test", now, body)
+
+	tests := []struct {
+		name    string
+		secret  string
+		header  string
+		body    []byte
+		wantErr bool
+	}{
+		{name: "valid", secret: "whsec_test", header: header, body: body},
+		{name: "other secret", secret: "whsec_other", header: header, body: body, wantErr: true},
+		{name: "tampered body", secret: "whsec_test", header: header, body: []byte(`{"event":"pong"}`), wantErr: true},
+		{name: "replayed", secret: "whsec_test", header: WebhookSignature("whsec_test", now-600, body), body: body, wantErr: true},
+		{name: "malformed", secret: "whsec_test", header: "v1=abc", body: body, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyWebhookSignature(tt.secret, tt.header, tt.body, 5*time.Minute)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyWebhookSignature() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// webhookReceiver records the deliveries it gets and answers each with the next status,
+// repeating the last one.
+type webhookReceiver struct {
+	*httptest.Server
+	t        *testing.T
+	secret   string
+	statuses []int
+
+	mu       sync.Mutex
+	received []*http.Request
+}
+
+func newWebhookReceiver(t *testing.T, secret string, statuses ...int) *webhookReceiver {
+	r := &webhookReceiver{t: t, secret: secret, statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *webhookReceiver) serve(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	if err := VerifyWebhookSignature(r.secret, req.Header.Get(WebhookSignatureHeader), body, time.Minute); err != nil {
+		r.t.Errorf("delivery with a bad signature: %v", err)
+	}
+	var payload WebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil || string(payload.Event) != req.Header.Get(WebhookEventHeader) {
+		r.t.Errorf("delivery body %s does not match its %s header %q", body, WebhookEventHeader, req.Header.Get(WebhookEventHeader))
+	}
+
+	r.mu.Lock()
+	r.received = append(r.received, req)
+	status := r.statuses[min(len(r.received), len(r.statuses))-1]
+	r.mu.Unlock()
+	w.WriteHeader(status)
+}
+
+func (r *webhookReceiver) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.received)
+}
+
+func TestWebhookSend(t *testing.T) {
+	receiver := newWebhookReceiver(t, "whsec_test", http.StatusNoContent, http.StatusServiceUnavailable)
+	svc := NewWebhookService(nil)
+	payload, err := newWebhookPayload(EventPing, map[string]string{"message": "Test delivery"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := repository.ClaimDueWebhookDeliveriesRow{
+		Event:   string(EventPing),
+		Payload: payload,
+		Url:     receiver.URL,
+		Secret:  "whsec_test",
+	}
+
+	if status, err := svc.send(context.Background(), d); err != nil || status != http.StatusNoContent {
+		t.Errorf("send() = %d, %v, want 204 and no error", status, err)
+	}
+	if status, err := svc.send(context.Background(), d); err == nil || status != http.StatusServiceUnavailable {
+		t.Errorf("send() = %d, %v, want 503 and an error", status, err)
+	}
+	if receiver.count() != 2 {
+		t.Errorf("receiver got %d deliveries, want 2", receiver.count())
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	pool, repo := dbtest.New(t)
+	ctx := context.Background()
+
+	receiver := newWebhookReceiver(t, "whsec_test", http.StatusInternalServerError, http.StatusInternalServerError, http.StatusOK)
+	endpoint, err := repo.CreateWebhookEndpoint(ctx, repository.CreateWebhookEndpointParams{
+		Url:    receiver.URL,
+		Secret: "whsec_test",
+		Events: []string{string(EventCertificateCreated)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := NewWebhookService(repo)
+	cert := repository.Alicorp2025Certificate{CertificateID: 1, TicketName: "INC0001"}
+	if err := svc.EnqueueCertificateEvent(ctx, repo, EventCertificateCreated, cert); err != nil {
+		t.Fatal(err)
+	}
+	// Not subscribed, so not queued
+	if err := svc.EnqueueCertificateEvent(ctx, repo, EventCertificateRejected, cert); err != nil {
+		t.Fatal(err)
+	}
+
+	delivery := func() repository.ListWebhookDeliveriesRow {
+		t.Helper()
+		deliveries, err := repo.ListWebhookDeliveries(ctx, endpoint.EndpointID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deliveries) != 1 {
+			t.Fatalf("got %d deliveries, want 1", len(deliveries))
+		}
+		return deliveries[0]
+	}
+	makeDue := func() {
+		t.Helper()
+		if _, err := pool.Exec(ctx, "UPDATE webhook_deliveries SET next_attempt_at = NOW()"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for attempt := range 2 {
+		start := time.Now()
+		svc.deliverDue(ctx)
+		d := delivery()
+		if d.Status != repository.WebhookDeliveryStatusPENDING || d.Attempts != int32(attempt+1) || d.LastStatusCode.Int32 != http.StatusInternalServerError {
+			t.Fatalf("after failed attempt %d: %+v", attempt+1, d)
+		}
+		if wait := d.NextAttemptAt.Time.Sub(start); wait < webhookRetryDelays[attempt] || wait > webhookRetryDelays[attempt]+time.Minute {
+			t.Errorf("attempt %d retries after %s, want %s", attempt+1, wait, webhookRetryDelays[attempt])
+		}
+
+		// Not due yet
+		svc.deliverDue(ctx)
+		if receiver.count() != attempt+1 {
+			t.Fatalf("receiver got %d deliveries, want %d", receiver.count(), attempt+1)
+		}
+		makeDue()
+	}
+
+	svc.deliverDue(ctx)
+	if d := delivery(); d.Status != repository.WebhookDeliveryStatusSUCCEEDED || d.Attempts != 3 || !d.DeliveredAt.Valid {
+		t.Fatalf("after the successful attempt: %+v", d)
+	}
+
+	t.Run("gives up after the last retry", func(t *testing.T) {
+		failing := newWebhookReceiver(t, "whsec_test", http.StatusBadGateway)
+		d, err := repo.EnqueueWebhookDelivery(ctx, repository.EnqueueWebhookDeliveryParams{
+			EndpointID: endpoint.EndpointID,
+			Event:      string(EventPing),
+			Payload:    []byte(`{"event":"ping"}`),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pool.Exec(ctx, "UPDATE webhook_endpoints SET url = $1", failing.URL); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pool.Exec(ctx, "UPDATE webhook_deliveries SET attempts = $1 WHERE delivery_id = $2", len(webhookRetryDelays), d.DeliveryID); err != nil {
+			t.Fatal(err)
+		}
+
+		svc.deliverDue(ctx)
+		var status repository.WebhookDeliveryStatus
+		var attempts int32
+		if err := pool.QueryRow(ctx, "SELECT status, attempts FROM webhook_deliveries WHERE delivery_id = $1", d.DeliveryID).Scan(&status, &attempts); err != nil {
+			t.Fatal(err)
+		}
+		if status != repository.WebhookDeliveryStatusFAILED || attempts != int32(len(webhookRetryDelays)+1) {
+			t.Errorf("delivery is %s after %d attempts, want FAILED after %d", status, attempts, len(webhookRetryDelays)+1)
+		}
+	})
+}
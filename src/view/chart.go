@@ -0,0 +1,195 @@
+package view
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// The charts are drawn as SVG on the server, in a fixed coordinate system that the browser
+// scales to the width of the page.
+const (
+	chartWidth  = 720.0
+	chartHeight = 240.0
+	chartLeft   = 40.0 // Room for the value axis labels
+	chartBottom = 22.0 // Room for the category labels
+	chartTop    = 8.0
+
+	barRowHeight  = 26.0
+	barLabelWidth = 180.0
+	barValueWidth = 60.0
+)
+
+// ChartSeries is one series of a column chart. The series of a chart are stacked in order,
+// the first one at the bottom.
+type ChartSeries struct {
+	Name   string
+	Color  string
+	Values []int64
+}
+
+// ColumnChart is a stacked column chart with one column per label.
+type ColumnChart struct {
+	Labels []string
+	Series []ChartSeries
+}
+
+// BarItem is a row of a horizontal bar chart.
+type BarItem struct {
+	Label string
+	Value int64
+	Color string
+}
+
+type chartRect struct {
+	X, Y, W, H float64
+	Fill       string
+	Title      string
+}
+
+type chartTick struct {
+	X, Y  float64
+	Label string
+}
+
+// svgNum formats a coordinate for an SVG attribute.
+func svgNum(v float64) string {
+	return strconv.FormatFloat(v, 'f', 1, 64)
+}
+
+// niceCeil rounds v up to 1, 2 or 5 times a power of ten, so the axis has round ticks.
+func niceCeil(v int64) int64 {
+	if v <= 1 {
+		return 1
+	}
+	p := int64(math.Pow(10, math.Floor(math.Log10(float64(v)))))
+	for _, m := range []int64{1, 2, 5, 10} {
+		if v <= m*p {
+			return m * p
+		}
+	}
+	return 10 * p
+}
+
+func (ch ColumnChart) total(i int) int64 {
+	var sum int64
+	for _, s := range ch.Series {
+		if i < len(s.Values) {
+			sum += s.Values[i]
+		}
+	}
+	return sum
+}
+
+func (ch ColumnChart) top() int64 {
+	var top int64
+	for i := range ch.Labels {
+		top = max(top, ch.total(i))
+	}
+	return niceCeil(top)
+}
+
+func (ch ColumnChart) plotHeight() float64 {
+	return chartHeight - chartTop - chartBottom
+}
+
+func (ch ColumnChart) slot() float64 {
+	if len(ch.Labels) == 0 {
+		return 0
+	}
+	return (chartWidth - chartLeft) / float64(len(ch.Labels))
+}
+
+// rects returns the stacked segments of every column.
+func (ch ColumnChart) rects() []chartRect {
+	scale := ch.plotHeight() / float64(ch.top())
+	slot := ch.slot()
+	gap := math.Min(slot*0.2, 8)
+	var rects []chartRect
+	for i, label := range ch.Labels {
+		base := chartHeight - chartBottom
+		for _, s := range ch.Series {
+			if i >= len(s.Values) || s.Values[i] == 0 {
+				continue
+			}
+			h := float64(s.Values[i]) * scale
+			base -= h
+			rects = append(rects, chartRect{
+				X:     chartLeft + float64(i)*slot + gap/2,
+				Y:     base,
+				W:     slot - gap,
+				H:     h,
+				Fill:  s.Color,
+				Title: fmt.Sprintf("%s: %d %s", label, s.Values[i], s.Name),
+			})
+		}
+	}
+	return rects
+}
+
+// yTicks returns the gridlines of the value axis.
+func (ch ColumnChart) yTicks() []chartTick {
+	top := ch.top()
+	// top is 1, 2 or 5 times a power of ten, so one of these gives round steps
+	steps := top
+	if top%4 == 0 {
+		steps = 4
+	} else if top%5 == 0 {
+		steps = 5
+	}
+	ticks := make([]chartTick, 0, steps+1)
+	for i := int64(0); i <= steps; i++ {
+		v := top * i / steps
+		ticks = append(ticks, chartTick{
+			X:     chartLeft,
+			Y:     chartHeight - chartBottom - float64(v)/float64(top)*ch.plotHeight(),
+			Label: strconv.FormatInt(v, 10),
+		})
+	}
+	return ticks
+}
+
+// xTicks returns the category labels, skipping some when there are too many to fit.
+func (ch ColumnChart) xTicks() []chartTick {
+	const maxLabels = 12
+	every := (len(ch.Labels) + maxLabels - 1) / maxLabels
+	slot := ch.slot()
+	var ticks []chartTick
+	for i := 0; i < len(ch.Labels); i += max(every, 1) {
+		ticks = append(ticks, chartTick{
+			X:     chartLeft + (float64(i)+0.5)*slot,
+			Y:     chartHeight - 6,
+			Label: ch.Labels[i],
+		})
+	}
+	return ticks
+}
+
+// barChartHeight is the height of a horizontal bar chart with n rows.
+func barChartHeight(n int) float64 {
+	return float64(max(n, 1)) * barRowHeight
+}
+
+// barWidth is the length of a bar of a horizontal bar chart whose largest value is top.
+func barWidth(v, top int64) float64 {
+	if top <= 0 {
+		return 0
+	}
+	return float64(v) / float64(top) * (chartWidth - barLabelWidth - barValueWidth)
+}
+
+func barTop(items []BarItem) int64 {
+	var top int64
+	for _, item := range items {
+		top = max(top, item.Value)
+	}
+	return top
+}
+
+// percent returns part as a percentage of whole, 0 when whole is 0.
+func percent(part, whole int64) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return float64(part) / float64(whole) * 100
+}
@@ -23,3 +23,8 @@ func FormatInLima(t pgtype.Timestamptz, layout string) string {
 	}
 	return t.Time.In(LimaLocation).Format(layout)
 }
+
+// now is the current time, used by templates comparing expirations.
+func now() time.Time {
+	return time.Now()
+}